@@ -1,41 +1,165 @@
 package main
 
 import (
+	"bufio"
+	"errors"
+	"fenix/src/config"
 	"fenix/src/database"
+	"fenix/src/server"
 	"fenix/src/server/runner"
 	"fenix/src/utils"
-	"log"
+	"flag"
+	"fmt"
+	"io"
 	"os"
-	"strconv"
+	"strings"
 )
 
-func getMongoDB() database.Database {
-	var db database.Database
-	mongoAddr := os.Getenv("mongo_addr")
-	dbName := os.Getenv("db_name")
+const commands = `Usage: fenix [command] [flags]
 
-	if mongoAddr == "" || dbName == "" {
-		log.Panicf("Couldn't get database env -  mongoAddr: %q   dbName: %q", mongoAddr, dbName)
-	} else {
-		db = database.NewMongoDatabase(mongoAddr, dbName)
-		err := db.ClearDB()
-		if err != nil {
-			panic(err)
-		}
+Commands:
+  serve    serve Fenix, if the database is migrated.  The default
+  migrate  create the database's collections and indexes, and update stored users
+  reset    drop everything in the database, after typing its name to confirm`
+
+func getDatabase(cfg *config.Config) database.Database {
+	if cfg.Database.Backend == config.BackendMemory {
+		return database.NewInMemoryDatabase()
 	}
-	return db
+	return database.NewMongoDatabase(cfg.Database.MongoAddr, cfg.Database.Name)
 }
 
 func main() {
+	command, args := "serve", os.Args[1:]
+	if len(args) != 0 && !strings.HasPrefix(args[0], "-") {
+		command, args = args[0], args[1:]
+	}
+
+	cfg, err := config.Load(args, os.LookupEnv)
+	if errors.Is(err, flag.ErrHelp) {
+		fmt.Fprintln(os.Stderr, "\n"+commands)
+		return
+	}
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(2)
+	}
+	utils.InitLogger(utils.LogLevel(cfg.Log.Level), cfg.Log.File)
+
+	switch command {
+	case "serve":
+		serve(cfg)
+	case "migrate":
+		err = server.Migrate(getDatabase(cfg), server.NewAccountPolicy())
+	case "reset":
+		err = reset(cfg, os.Stdin, os.Stdout)
+	default:
+		fmt.Fprintf(os.Stderr, "Unknown command %q\n\n%v\n", command, commands)
+		os.Exit(2)
+	}
+	if err != nil {
+		utils.ErrorLogger.Fatalf("Error running %v: %v", command, err)
+	}
+}
+
+// Drops the database once the name typed into in matches it, so it's never done by accident.
+// The confirmation can't come from the config, so it can't be left set and wipe every restart.
+func reset(cfg *config.Config, in io.Reader, out io.Writer) error {
+	if cfg.Database.Backend == config.BackendMemory {
+		return fmt.Errorf("the memory backend has nothing to reset")
+	}
+
+	fmt.Fprintf(out, "This deletes every user, message and yodel in %q on %v.\nType the database's name to confirm: ", cfg.Database.Name, cfg.Database.MongoAddr)
+	typed, err := bufio.NewReader(in).ReadString('\n')
+	if err != nil && err != io.EOF {
+		return err
+	}
+	if strings.TrimSpace(typed) != cfg.Database.Name {
+		return fmt.Errorf("the name didn't match, so nothing was deleted")
+	}
+
+	db := getDatabase(cfg)
+	err = db.ClearDB()
+	if err != nil {
+		return err
+	}
+	utils.InfoLogger.Printf("Dropped %q", cfg.Database.Name)
+	return server.Migrate(db, server.NewAccountPolicy())
+}
+
+func serve(cfg *config.Config) {
 	wg := utils.NewWaitGroupCounter()
-	level := os.Getenv("log_level")
-	i, err := strconv.ParseInt(level, 10, 8)
+	db := getDatabase(cfg)
+	hub := runner.NewHub(wg, db)
+	// The memory backend starts empty every time, so it's always migrated
+	if cfg.Database.Backend == config.BackendMemory {
+		utils.InfoLogger.Printf("Using the memory backend, nothing will survive a restart")
+		err := server.Migrate(db, hub.Accounts)
+		if err != nil {
+			panic(err)
+		}
+	}
+	err := server.CheckSchema(db)
 	if err != nil {
-		panic(err)
+		utils.ErrorLogger.Fatalf("Not serving: %v", err)
+	}
+
+	// Keep tickets in the database, so servers behind a load balancer can redeem each other's tickets
+	if cfg.Accounts.TicketStore == config.TicketStoreDatabase {
+		hub.Tickets = server.NewDatabaseTicketStore(db)
+	}
+	if cfg.Accounts.NotifyFile != "" {
+		f, err := os.OpenFile(cfg.Accounts.NotifyFile, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0600)
+		if err != nil {
+			panic(err)
+		}
+		defer f.Close()
+		hub.Notifier = server.NewLogNotifier(f)
+	}
+	// Validated by config.Load
+	if key, _ := cfg.Accounts.DecodeSecretKey(); key != nil {
+		hub.SecretKey = key
+	} else {
+		utils.WarningLogger.Printf("No secret_key set, so users can't enable 2FA")
+	}
+	if cfg.Accounts.BreachedPasswords != "" {
+		err := hub.Accounts.LoadBreachedPasswords(cfg.Accounts.BreachedPasswords)
+		if err != nil {
+			panic(err)
+		}
+	}
+	if cfg.OIDC.Issuer != "" {
+		hub.OIDC = server.NewOIDCProvider(cfg.OIDC.Issuer, cfg.OIDC.ClientID, cfg.OIDC.ClientSecret, cfg.OIDC.RedirectURL)
+		hub.OIDC.Match = cfg.OIDC.Match
+	}
+	hub.CORS = &server.CORSPolicy{AllowedOrigins: cfg.CORS.Origins}
+	hub.Sizes = &server.SizeLimits{
+		FrameBytes:      cfg.Limits.FrameBytes,
+		MessageLength:   cfg.Limits.MessageLength,
+		YodelNameLength: cfg.Limits.YodelNameLength,
+		HistoryPage:     cfg.Limits.HistoryPage,
+	}
+	if !cfg.Limits.RateLimits {
+		hub.RateLimits = nil
+	}
+	for _, admin := range cfg.Accounts.Admins {
+		err := hub.MakeAdmin(admin)
+		if err != nil {
+			utils.ErrorLogger.Printf("Couldn't make %q an admin: %q", admin, err)
+		}
 	}
 
-	utils.InitLogger(utils.LogLevel(i), "main.log")
-	hub := runner.NewHub(wg, getMongoDB())
-	hub.Serve("0.0.0.0:8080")
+	if cfg.TLS.Enabled() {
+		certs, err := server.NewCertReloader(cfg.TLS.Cert, cfg.TLS.Key)
+		if err != nil {
+			panic(err)
+		}
+		if cfg.TLS.Redirect {
+			go hub.ServeRedirect(cfg.Listen, cfg.TLS.Port())
+		}
+		hub.ServeTLS(cfg.TLS.Addr, certs)
+	} else {
+		hub.Serve(cfg.Listen)
+	}
 	wg.Wait()
 }
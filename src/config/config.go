@@ -0,0 +1,212 @@
+package config
+
+import (
+	"encoding/base64"
+	"fmt"
+	"net"
+	"strings"
+)
+
+// Database backends.
+const (
+	BackendMongo  = "mongo"
+	BackendMemory = "memory"
+)
+
+// Where upgrade tickets are kept.
+const (
+	TicketStoreDatabase = "database"
+	TicketStoreMemory   = "memory"
+)
+
+// Settings for a Fenix server.  Each can be set in a YAML or TOML file, an environment variable or a flag.
+type Config struct {
+	// Address to serve HTTP on, or redirects to HTTPS from.
+	Listen   string         `yaml:"listen" toml:"listen"`
+	Database DatabaseConfig `yaml:"database" toml:"database"`
+	Log      LogConfig      `yaml:"log" toml:"log"`
+	TLS      TLSConfig      `yaml:"tls" toml:"tls"`
+	CORS     CORSConfig     `yaml:"cors" toml:"cors"`
+	Limits   LimitsConfig   `yaml:"limits" toml:"limits"`
+	Accounts AccountsConfig `yaml:"accounts" toml:"accounts"`
+	OIDC     OIDCConfig     `yaml:"oidc" toml:"oidc"`
+}
+
+type DatabaseConfig struct {
+	// "mongo", or "memory" to keep everything in this process until it stops.
+	Backend   string `yaml:"backend" toml:"backend"`
+	MongoAddr string `yaml:"mongo_addr" toml:"mongo_addr"`
+	Name      string `yaml:"name" toml:"name"`
+}
+
+type LogConfig struct {
+	// 0 logs nothing to stdout, 1 errors, 2 warnings too and 3 everything.
+	Level int `yaml:"level" toml:"level"`
+	// Everything is also logged here, if set.
+	File string `yaml:"file" toml:"file"`
+}
+
+type TLSConfig struct {
+	// PEM files to serve HTTPS with.  HTTP is served instead if they're unset.
+	Cert string `yaml:"cert" toml:"cert"`
+	Key  string `yaml:"key" toml:"key"`
+	Addr string `yaml:"addr" toml:"addr"`
+	// Redirects HTTP on Listen to HTTPS.
+	Redirect bool `yaml:"redirect" toml:"redirect"`
+}
+
+type CORSConfig struct {
+	// Origins browsers can use Fenix from.  "https://*.example.com" allows subdomains, and "*" allows every origin.
+	Origins []string `yaml:"origins" toml:"origins"`
+}
+
+// 0 doesn't limit.
+type LimitsConfig struct {
+	FrameBytes      int64 `yaml:"frame_bytes" toml:"frame_bytes"`
+	MessageLength   int   `yaml:"message_length" toml:"message_length"`
+	YodelNameLength int   `yaml:"yodel_name_length" toml:"yodel_name_length"`
+	HistoryPage     int   `yaml:"history_page" toml:"history_page"`
+	// Rate limits websocket messages.
+	RateLimits bool `yaml:"rate_limits" toml:"rate_limits"`
+}
+
+type AccountsConfig struct {
+	// "database" shares upgrade tickets between servers, "memory" keeps them in this process.
+	TicketStore string `yaml:"ticket_store" toml:"ticket_store"`
+	// Password reset tokens are written here, instead of the log.
+	NotifyFile string `yaml:"notify_file" toml:"notify_file"`
+	// 32 bytes of base64, used to encrypt 2FA secrets.
+	SecretKey string `yaml:"secret_key" toml:"secret_key"`
+	// List of breached passwords new passwords are checked against.
+	BreachedPasswords string `yaml:"breached_passwords" toml:"breached_passwords"`
+	// Usernames of existing users to make admins on startup.
+	Admins []string `yaml:"admins" toml:"admins"`
+}
+
+type OIDCConfig struct {
+	// Single sign-on is off without an issuer.
+	Issuer       string `yaml:"issuer" toml:"issuer"`
+	ClientID     string `yaml:"client_id" toml:"client_id"`
+	ClientSecret string `yaml:"client_secret" toml:"client_secret"`
+	RedirectURL  string `yaml:"redirect_url" toml:"redirect_url"`
+	// "subject" or "email".
+	Match string `yaml:"match" toml:"match"`
+}
+
+func Default() *Config {
+	return &Config{
+		Listen:   "0.0.0.0:8080",
+		Database: DatabaseConfig{Backend: BackendMongo},
+		Log:      LogConfig{Level: 3, File: "main.log"},
+		TLS:      TLSConfig{Addr: "0.0.0.0:8443"},
+		CORS:     CORSConfig{Origins: []string{"*"}},
+		Limits: LimitsConfig{
+			FrameBytes:      64 * 1024,
+			MessageLength:   4000,
+			YodelNameLength: 100,
+			HistoryPage:     100,
+			RateLimits:      true,
+		},
+		Accounts: AccountsConfig{TicketStore: TicketStoreDatabase},
+		OIDC:     OIDCConfig{Match: "subject"},
+	}
+}
+
+// Decodes SecretKey, returning nil if it's unset.
+func (a AccountsConfig) DecodeSecretKey() ([]byte, error) {
+	if a.SecretKey == "" {
+		return nil, nil
+	}
+	key, err := base64.StdEncoding.DecodeString(a.SecretKey)
+	if err != nil || len(key) != 32 {
+		return nil, fmt.Errorf("secret_key must be 32 bytes of base64")
+	}
+	return key, nil
+}
+
+// Whether HTTPS is served.
+func (t TLSConfig) Enabled() bool {
+	return t.Cert != ""
+}
+
+// The port HTTPS is served on, for redirects.
+func (t TLSConfig) Port() string {
+	_, port, _ := net.SplitHostPort(t.Addr)
+	return port
+}
+
+// Every problem with c, joined into one error.
+type ValidationError []string
+
+func (e ValidationError) Error() string {
+	return "invalid config: " + strings.Join(e, "; ")
+}
+
+// Checks c for settings that can't work together, returning a ValidationError listing them.
+func (c *Config) Validate() error {
+	var problems ValidationError
+	problem := func(format string, a ...interface{}) {
+		problems = append(problems, fmt.Sprintf(format, a...))
+	}
+
+	if _, _, err := net.SplitHostPort(c.Listen); err != nil {
+		problem("listen must be a host and port: %v", err)
+	}
+
+	switch c.Database.Backend {
+	case BackendMongo:
+		if c.Database.MongoAddr == "" || c.Database.Name == "" {
+			problem("the mongo backend needs mongo_addr and db_name")
+		}
+	case BackendMemory:
+	default:
+		problem("db_backend must be %q or %q, not %q", BackendMongo, BackendMemory, c.Database.Backend)
+	}
+
+	if c.Log.Level < 0 || c.Log.Level > 3 {
+		problem("log_level must be from 0 to 3, not %v", c.Log.Level)
+	}
+
+	if (c.TLS.Cert == "") != (c.TLS.Key == "") {
+		problem("tls_cert and tls_key must be set together")
+	}
+	if c.TLS.Enabled() {
+		if _, _, err := net.SplitHostPort(c.TLS.Addr); err != nil {
+			problem("tls_addr must be a host and port: %v", err)
+		}
+	} else if c.TLS.Redirect {
+		problem("https_redirect needs tls_cert and tls_key")
+	}
+
+	for _, o := range c.CORS.Origins {
+		if o != "*" && !strings.Contains(o, "://") {
+			problem("cors_origins must be \"*\" or include a scheme, like https://%v", o)
+		}
+	}
+
+	l := c.Limits
+	if l.FrameBytes < 0 || l.MessageLength < 0 || l.YodelNameLength < 0 || l.HistoryPage < 0 {
+		problem("limits can't be negative")
+	}
+
+	switch c.Accounts.TicketStore {
+	case TicketStoreDatabase, TicketStoreMemory:
+	default:
+		problem("ticket_store must be %q or %q, not %q", TicketStoreDatabase, TicketStoreMemory, c.Accounts.TicketStore)
+	}
+	if _, err := c.Accounts.DecodeSecretKey(); err != nil {
+		problem("%v", err)
+	}
+
+	if c.OIDC.Issuer != "" && (c.OIDC.ClientID == "" || c.OIDC.RedirectURL == "") {
+		problem("oidc_issuer needs oidc_client_id and oidc_redirect_url")
+	}
+	if c.OIDC.Match != "subject" && c.OIDC.Match != "email" {
+		problem("oidc_match must be \"subject\" or \"email\", not %q", c.OIDC.Match)
+	}
+
+	if len(problems) != 0 {
+		return problems
+	}
+	return nil
+}
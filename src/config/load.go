@@ -0,0 +1,197 @@
+package config
+
+import (
+	"bytes"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+
+	"github.com/BurntSushi/toml"
+	"github.com/joho/godotenv"
+	"gopkg.in/yaml.v3"
+)
+
+// A setting that can be set by an environment variable and a flag, both called name.
+type option struct {
+	name  string
+	usage string
+	set   func(c *Config, v string) error
+}
+
+func stringOption(name, usage string, field func(c *Config) *string) option {
+	return option{name, usage, func(c *Config, v string) error {
+		*field(c) = v
+		return nil
+	}}
+}
+
+func intOption(name, usage string, field func(c *Config) *int) option {
+	return option{name, usage, func(c *Config, v string) error {
+		i, err := strconv.Atoi(v)
+		*field(c) = i
+		return err
+	}}
+}
+
+func int64Option(name, usage string, field func(c *Config) *int64) option {
+	return option{name, usage, func(c *Config, v string) error {
+		i, err := strconv.ParseInt(v, 10, 64)
+		*field(c) = i
+		return err
+	}}
+}
+
+func boolOption(name, usage string, field func(c *Config) *bool) option {
+	return option{name, usage, func(c *Config, v string) error {
+		b, err := strconv.ParseBool(v)
+		*field(c) = b
+		return err
+	}}
+}
+
+// Lists are comma separated.
+func listOption(name, usage string, field func(c *Config) *[]string) option {
+	return option{name, usage, func(c *Config, v string) error {
+		list := []string{}
+		for _, item := range strings.Split(v, ",") {
+			if item = strings.TrimSpace(item); item != "" {
+				list = append(list, item)
+			}
+		}
+		*field(c) = list
+		return nil
+	}}
+}
+
+var options = []option{
+	stringOption("listen", "address to serve HTTP on, or redirect to HTTPS from", func(c *Config) *string { return &c.Listen }),
+	stringOption("db_backend", `"mongo", or "memory" to keep everything in this process`, func(c *Config) *string { return &c.Database.Backend }),
+	stringOption("mongo_addr", "MongoDB connection string", func(c *Config) *string { return &c.Database.MongoAddr }),
+	stringOption("db_name", "MongoDB database name", func(c *Config) *string { return &c.Database.Name }),
+	intOption("log_level", "0 logs nothing to stdout, 1 errors, 2 warnings too and 3 everything", func(c *Config) *int { return &c.Log.Level }),
+	stringOption("log_file", "file everything is also logged to", func(c *Config) *string { return &c.Log.File }),
+	stringOption("tls_cert", "PEM certificate to serve HTTPS with", func(c *Config) *string { return &c.TLS.Cert }),
+	stringOption("tls_key", "PEM key to serve HTTPS with", func(c *Config) *string { return &c.TLS.Key }),
+	stringOption("tls_addr", "address to serve HTTPS on", func(c *Config) *string { return &c.TLS.Addr }),
+	boolOption("https_redirect", "redirect HTTP on listen to HTTPS", func(c *Config) *bool { return &c.TLS.Redirect }),
+	listOption("cors_origins", "comma separated origins browsers can use Fenix from", func(c *Config) *[]string { return &c.CORS.Origins }),
+	int64Option("frame_bytes", "largest websocket frame read, in bytes", func(c *Config) *int64 { return &c.Limits.FrameBytes }),
+	intOption("message_length", "longest chat message, in characters", func(c *Config) *int { return &c.Limits.MessageLength }),
+	intOption("yodel_name_length", "longest yodel name, in characters", func(c *Config) *int { return &c.Limits.YodelNameLength }),
+	intOption("history_page", "most messages one msg_history request can ask for", func(c *Config) *int { return &c.Limits.HistoryPage }),
+	boolOption("rate_limits", "rate limit websocket messages", func(c *Config) *bool { return &c.Limits.RateLimits }),
+	stringOption("ticket_store", `"database" shares upgrade tickets between servers, "memory" keeps them in this process`, func(c *Config) *string { return &c.Accounts.TicketStore }),
+	stringOption("notify_file", "file password reset tokens are written to, instead of the log", func(c *Config) *string { return &c.Accounts.NotifyFile }),
+	stringOption("secret_key", "32 bytes of base64, used to encrypt 2FA secrets", func(c *Config) *string { return &c.Accounts.SecretKey }),
+	stringOption("breached_passwords", "list of breached passwords new passwords are checked against", func(c *Config) *string { return &c.Accounts.BreachedPasswords }),
+	listOption("admins", "comma separated usernames of existing users to make admins", func(c *Config) *[]string { return &c.Accounts.Admins }),
+	stringOption("oidc_issuer", "OpenID Connect provider for single sign-on", func(c *Config) *string { return &c.OIDC.Issuer }),
+	stringOption("oidc_client_id", "OpenID Connect client ID", func(c *Config) *string { return &c.OIDC.ClientID }),
+	stringOption("oidc_client_secret", "OpenID Connect client secret", func(c *Config) *string { return &c.OIDC.ClientSecret }),
+	stringOption("oidc_redirect_url", "URL the provider redirects to after sign in", func(c *Config) *string { return &c.OIDC.RedirectURL }),
+	stringOption("oidc_match", `"subject", or "email" to also match users by verified email`, func(c *Config) *string { return &c.OIDC.Match }),
+}
+
+// Loads a config from, in increasing precedence: defaults, a YAML or TOML file, a .env file,
+// environment variables from lookupEnv, then flags in args.
+// The file is named by the -config flag or the config_file variable.  Empty variables are ignored.
+func Load(args []string, lookupEnv func(string) (string, bool)) (*Config, error) {
+	flags := flag.NewFlagSet("fenix", flag.ContinueOnError)
+	file := flags.String("config", "", "YAML or TOML config file")
+	envFile := flags.String("env_file", ".env", "file of environment variables, which real ones override")
+
+	type setting struct {
+		option *option
+		value  string
+	}
+	var set []setting
+	for i := range options {
+		o := &options[i]
+		flags.Func(o.name, o.usage, func(v string) error {
+			set = append(set, setting{o, v})
+			return nil
+		})
+	}
+	err := flags.Parse(args)
+	if err != nil {
+		return nil, err
+	}
+	if flags.NArg() != 0 {
+		return nil, fmt.Errorf("unexpected arguments %q", flags.Args())
+	}
+
+	dotenv, err := godotenv.Read(*envFile)
+	if err != nil && !errors.Is(err, fs.ErrNotExist) {
+		return nil, fmt.Errorf("reading %v: %w", *envFile, err)
+	}
+	lookup := func(name string) (string, bool) {
+		if v, ok := lookupEnv(name); ok && v != "" {
+			return v, true
+		}
+		v, ok := dotenv[name]
+		return v, ok && v != ""
+	}
+
+	c := Default()
+	if *file == "" {
+		*file, _ = lookup("config_file")
+	}
+	if *file != "" {
+		err = c.loadFile(*file)
+		if err != nil {
+			return nil, fmt.Errorf("reading %v: %w", *file, err)
+		}
+	}
+
+	for i := range options {
+		o := &options[i]
+		if v, ok := lookup(o.name); ok {
+			err = o.set(c, v)
+			if err != nil {
+				return nil, fmt.Errorf("%v: %w", o.name, err)
+			}
+		}
+	}
+	for _, s := range set {
+		err = s.option.set(c, s.value)
+		if err != nil {
+			return nil, fmt.Errorf("-%v: %w", s.option.name, err)
+		}
+	}
+
+	return c, c.Validate()
+}
+
+// Decodes a YAML or TOML file over c, by its extension.  Unknown keys are errors, so typos aren't ignored.
+func (c *Config) loadFile(name string) error {
+	b, err := os.ReadFile(name)
+	if err != nil {
+		return err
+	}
+
+	switch strings.ToLower(filepath.Ext(name)) {
+	case ".yaml", ".yml":
+		decoder := yaml.NewDecoder(bytes.NewReader(b))
+		decoder.KnownFields(true)
+		err = decoder.Decode(c)
+		// Empty files leave the defaults
+		if err == io.EOF {
+			err = nil
+		}
+		return err
+	case ".toml":
+		meta, err := toml.Decode(string(b), c)
+		if err == nil && len(meta.Undecoded()) != 0 {
+			err = fmt.Errorf("unknown keys %v", meta.Undecoded())
+		}
+		return err
+	default:
+		return fmt.Errorf("config files must be .yaml, .yml or .toml")
+	}
+}
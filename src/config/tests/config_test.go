@@ -0,0 +1,112 @@
+package config_test
+
+import (
+	"fenix/src/config"
+	"fenix/src/test_utils"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestConfig(t *testing.T) {
+	// An environment with just vars.
+	env := func(vars map[string]string) func(string) (string, bool) {
+		return func(name string) (string, bool) {
+			v, ok := vars[name]
+			return v, ok
+		}
+	}
+	write := func(t *testing.T, name, contents string) string {
+		t.Helper()
+		path := filepath.Join(t.TempDir(), name)
+		err := os.WriteFile(path, []byte(contents), 0600)
+		if err != nil {
+			t.Fatalf("%q\n", err)
+		}
+		return path
+	}
+	// Flags that keep tests from reading a .env file in the working directory.
+	noEnvFile := []string{"-env_file", "nope.env"}
+
+	t.Run("defaults are valid with a database", func(t *testing.T) {
+		c, err := config.Load(noEnvFile, env(map[string]string{"mongo_addr": "mongodb://db", "db_name": "fenix"}))
+		test_utils.AssertEqual(t, err, nil)
+		test_utils.AssertEqual(t, c.Listen, "0.0.0.0:8080")
+		test_utils.AssertEqual(t, c.Limits.RateLimits, true)
+		test_utils.AssertEqual(t, c.CORS.Origins, []string{"*"})
+	})
+
+	t.Run("yaml files are loaded over the defaults", func(t *testing.T) {
+		path := write(t, "fenix.yaml", `
+listen: 127.0.0.1:9000
+database:
+  backend: memory
+cors:
+  origins: ["https://*.example.com"]
+limits:
+  message_length: 10
+`)
+		c, err := config.Load(append(noEnvFile, "-config", path), env(nil))
+		test_utils.AssertEqual(t, err, nil)
+		test_utils.AssertEqual(t, c.Listen, "127.0.0.1:9000")
+		test_utils.AssertEqual(t, c.Database.Backend, config.BackendMemory)
+		test_utils.AssertEqual(t, c.CORS.Origins, []string{"https://*.example.com"})
+		test_utils.AssertEqual(t, c.Limits.MessageLength, 10)
+		test_utils.AssertEqual(t, c.Limits.HistoryPage, 100)
+	})
+
+	t.Run("toml files are loaded too", func(t *testing.T) {
+		path := write(t, "fenix.toml", `
+listen = "127.0.0.1:9000"
+
+[database]
+backend = "memory"
+
+[accounts]
+admins = ["gopher123"]
+`)
+		c, err := config.Load(noEnvFile, env(map[string]string{"config_file": path}))
+		test_utils.AssertEqual(t, err, nil)
+		test_utils.AssertEqual(t, c.Listen, "127.0.0.1:9000")
+		test_utils.AssertEqual(t, c.Accounts.Admins, []string{"gopher123"})
+	})
+
+	t.Run("environment variables override files, and flags override both", func(t *testing.T) {
+		path := write(t, "fenix.yaml", "listen: 127.0.0.1:9000\nlog:\n  level: 1\ndatabase:\n  backend: memory\n")
+		vars := map[string]string{"listen": "127.0.0.1:9001", "log_level": "2", "cors_origins": "https://a.io, https://b.io", "secret_key": ""}
+		c, err := config.Load(append(noEnvFile, "-config", path, "-listen", "127.0.0.1:9002"), env(vars))
+		test_utils.AssertEqual(t, err, nil)
+		test_utils.AssertEqual(t, c.Listen, "127.0.0.1:9002")
+		test_utils.AssertEqual(t, c.Log.Level, 2)
+		test_utils.AssertEqual(t, c.CORS.Origins, []string{"https://a.io", "https://b.io"})
+	})
+
+	t.Run(".env files are read under real environment variables", func(t *testing.T) {
+		path := write(t, ".env", "export db_backend=\"memory\"\nexport listen=\"127.0.0.1:9000\"\nexport log_level=\"1\"\n")
+		c, err := config.Load([]string{"-env_file", path}, env(map[string]string{"log_level": "2"}))
+		test_utils.AssertEqual(t, err, nil)
+		test_utils.AssertEqual(t, c.Listen, "127.0.0.1:9000")
+		test_utils.AssertEqual(t, c.Log.Level, 2)
+	})
+
+	t.Run("unknown keys and bad values are errors", func(t *testing.T) {
+		path := write(t, "fenix.yaml", "lisen: 127.0.0.1:9000\n")
+		_, err := config.Load(append(noEnvFile, "-config", path), env(nil))
+		test_utils.AssertNotEqual(t, err, nil)
+
+		path = write(t, "fenix.toml", "lisen = \"127.0.0.1:9000\"\n")
+		_, err = config.Load(append(noEnvFile, "-config", path), env(nil))
+		test_utils.AssertNotEqual(t, err, nil)
+
+		_, err = config.Load(noEnvFile, env(map[string]string{"log_level": "loud"}))
+		test_utils.AssertNotEqual(t, err, nil)
+	})
+
+	t.Run("invalid combinations are all reported", func(t *testing.T) {
+		vars := map[string]string{"log_level": "7", "tls_cert": "cert.pem", "oidc_issuer": "https://id.example.com", "secret_key": "short"}
+		_, err := config.Load(noEnvFile, env(vars))
+		problems, ok := err.(config.ValidationError)
+		test_utils.AssertEqual(t, ok, true)
+		test_utils.AssertEqual(t, len(problems), 5)
+	})
+}
@@ -3,6 +3,7 @@ package database
 import (
 	"context"
 	"fenix/src/utils"
+	"regexp"
 	"time"
 
 	"go.mongodb.org/mongo-driver/bson"
@@ -19,14 +20,114 @@ func (e DatabaseError) Error() string {
 
 type Database interface {
 	InsertMessage(*Message) error
+	GetMessage(*Message) error
+	// Gets messages sent outside of any channel.
 	GetMessagesBetween(int64, int64, int64) ([]*Message, error)
+	GetChannelMessagesBetween(channelID primitive.ObjectID, from, to, limit int64) ([]*Message, error)
+	SearchMessages(*SearchQuery) ([]*SearchResult, error)
+	// Deletes a message, and unpins it from its yodel.
+	DeleteMessage(messageID primitive.ObjectID) error
 
 	InsertUser(*User) error
+	// Gets a user by ID, username, canonical name, OIDC subject or email, whichever is set first.
 	GetUser(*User) error
+	// Sets a user's password and salt.
+	UpdatePassword(*User) error
+	// Sets a user's TOTP secret, TOTPEnabled, TOTPLastStep and recovery codes.
+	UpdateTwoFactor(*User) error
+	// Sets a user's Admin and Disabled flags.
+	UpdateUserStatus(*User) error
+	ListUsers(*UserQuery) ([]*User, error)
+	// Deletes a user with their sessions and memberships.  Their messages are kept, credited to DeletedUsername.
+	DeleteUser(*User) error
+
+	InsertSession(*Session) error
+	// Gets a session by its token hash.  Expired sessions are returned too.
+	GetSession(*Session) error
+	DeleteSession(tokenHash string) error
+	// Deletes every session of a user.
+	DeleteSessions(userID primitive.ObjectID) error
+
+	InsertAccessToken(*AccessToken) error
+	// Gets a token by its hash.  Expired tokens are returned too.
+	GetAccessToken(*AccessToken) error
+	GetAccessTokens(userID primitive.ObjectID) ([]*AccessToken, error)
+	DeleteAccessToken(tokenID primitive.ObjectID) error
+	// Gets the bots a user owns.
+	GetBots(ownerID primitive.ObjectID) ([]*User, error)
+
+	InsertPasswordReset(*PasswordReset) error
+	// Atomically gets and deletes a reset, returning DoesNotExist if there is none or it expired.
+	TakePasswordReset(*PasswordReset) error
+
+	// Inserts a ticket, replacing the user's previous ticket.
+	InsertTicket(*Ticket) error
+	// Atomically gets and deletes a user's ticket, returning DoesNotExist if they have none or it expired.
+	TakeTicket(*Ticket) error
 
 	InsertYodel(*Yodel) error
 	GetYodel(*Yodel) error
+	ListYodels(*YodelQuery) ([]*Yodel, error)
+	// Updates a yodel's name, description, icon and topic.
+	UpdateYodel(*Yodel) error
+	// Deletes a yodel along with its messages, members, invites and bans.
+	DeleteYodel(yodelID primitive.ObjectID) error
+	// Changes a yodel's owner, returning DoesNotExist if from no longer owns it.
+	TransferYodel(yodelID primitive.ObjectID, from, to string) error
+	PinMessage(yodelID, messageID primitive.ObjectID) error
+	UnpinMessage(yodelID, messageID primitive.ObjectID) error
+	// Adds a custom role to a yodel, returning AlreadyExists if the name is taken.
+	InsertRole(yodelID primitive.ObjectID, role Role) error
+	// Removes a custom role from a yodel, and makes members who had it RoleMember.
+	DeleteRole(yodelID primitive.ObjectID, name string) error
+
+	// Adds a channel to a yodel, returning AlreadyExists if the name is taken.
+	InsertChannel(*Channel) error
+	GetChannel(*Channel) error
+	// Gets a yodel's channels, oldest first.
+	GetChannels(yodelID primitive.ObjectID) ([]*Channel, error)
+	// Renames a channel, returning AlreadyExists if the name is taken.
+	RenameChannel(channelID primitive.ObjectID, name string) error
+	// Deletes a channel along with its messages, and unpins them.
+	DeleteChannel(channelID primitive.ObjectID) error
+
+	InsertMember(*Member) error
+	GetMember(*Member) error
+	GetMembers(yodelID primitive.ObjectID) ([]*Member, error)
+	GetMembersOf(userID primitive.ObjectID) ([]*Member, error)
+	UpdateMemberRole(*Member) error
+	DeleteMember(*Member) error
+
+	// Inserts a ban, replacing any ban of the same kind for the user in the yodel.
+	InsertBan(*Ban) error
+	// Gets a ban by yodel, user and kind.  Expired bans are returned too.
+	GetBan(*Ban) error
+
+	InsertInvite(*Invite) error
+	GetInvite(*Invite) error
+	// Atomically uses up an invite if userID may redeem it at time now, returning DoesNotExist otherwise.
+	RedeemInvite(code string, userID primitive.ObjectID, now int64) (*Invite, error)
+	RevokeInvite(code string) error
 
+	GetStats() (*Stats, error)
+
+	// Appends to the audit log.  There's deliberately no way to change or delete entries.
+	InsertAuditEntry(*AuditEntry) error
+	GetAuditEntries(*AuditQuery) ([]*AuditEntry, error)
+
+	// Creates collections and the indexes queries rely on.  Safe to call repeatedly.
+	CreateSchema() error
+	// Gets the version the database was last migrated to, or 0 if it never was.
+	GetSchemaVersion() (int, error)
+	SetSchemaVersion(int) error
+	// Gets users from before canonical names, who don't have one.
+	GetUsersWithoutCanonicalName() ([]*User, error)
+	// Sets a user's canonical name, returning AlreadyExists if another user has it.
+	SetCanonicalName(*User) error
+	// Removes every user's canonical name, for when the rules for them change.
+	ClearCanonicalNames() error
+
+	// Drops everything, leaving an empty database with its schema.
 	ClearDB() error
 }
 
@@ -72,122 +173,1226 @@ func (db *MongoDatabase) GetYodel(y *Yodel) error {
 		}},
 	}}
 
-	res := coll.FindOne(ctx, q)
+	return decodeOne(coll.FindOne(ctx, q), y)
+}
+
+func (db *MongoDatabase) ListYodels(yq *YodelQuery) ([]*Yodel, error) {
+	members, err := db.GetMembersOf(yq.Viewer)
+	if err != nil {
+		return nil, err
+	}
+	memberOf := []primitive.ObjectID{}
+	for _, m := range members {
+		memberOf = append(memberOf, m.YodelID)
+	}
+
+	q := bson.D{}
+	if !yq.All {
+		q = append(q, bson.E{"$or", bson.A{
+			bson.D{{"visibility", bson.D{{"$ne", VisibilityPrivate}}}},
+			bson.D{{"_id", bson.D{{"$in", memberOf}}}},
+		}})
+	}
+	if yq.Name != "" {
+		q = append(q, bson.E{"name", primitive.Regex{Pattern: regexp.QuoteMeta(yq.Name), Options: "i"}})
+	}
+	opts := options.Find().SetSort(bson.D{{"name", 1}, {"_id", 1}}).SetSkip(yq.Offset).SetLimit(yq.Limit)
+
+	ctx, cancel := db.makeContext()
+	defer cancel()
+
+	cur, err := db.getDatabase().Collection("yodels").Find(ctx, q, opts)
+	if err != nil {
+		return nil, err
+	}
+
+	var res []*Yodel
+	err = cur.All(context.Background(), &res)
+	return res, err
+}
+
+func (db *MongoDatabase) UpdateYodel(y *Yodel) error {
+	return db.updateYodel(y.YodelID, bson.D{{"$set", bson.D{
+		{"name", y.Name},
+		{"description", y.Description},
+		{"icon", y.Icon},
+		{"topic", y.Topic},
+	}}})
+}
+
+func (db *MongoDatabase) DeleteYodel(yodelID primitive.ObjectID) error {
+	ctx, cancel := db.makeContext()
+	defer cancel()
+
+	res, err := db.getDatabase().Collection("yodels").DeleteOne(ctx, bson.D{{"_id", yodelID}})
+	if err != nil {
+		return err
+	}
+	if res.DeletedCount == 0 {
+		return DoesNotExist{}
+	}
+
+	for _, coll := range []string{"messages", "channels", "members", "invites", "bans"} {
+		_, err = db.getDatabase().Collection(coll).DeleteMany(ctx, bson.D{{"yodel_id", yodelID}})
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (db *MongoDatabase) TransferYodel(yodelID primitive.ObjectID, from, to string) error {
+	coll := db.getDatabase().Collection("yodels")
+
+	ctx, cancel := db.makeContext()
+	defer cancel()
+
+	q := bson.D{{"_id", yodelID}, {"owner", from}}
+	res, err := coll.UpdateOne(ctx, q, bson.D{{"$set", bson.D{{"owner", to}}}})
+	if err != nil {
+		return err
+	}
+	if res.MatchedCount == 0 {
+		return DoesNotExist{}
+	}
+	return nil
+}
+
+func (db *MongoDatabase) PinMessage(yodelID, messageID primitive.ObjectID) error {
+	return db.updateYodel(yodelID, bson.D{{"$addToSet", bson.D{{"pins", messageID}}}})
+}
+
+func (db *MongoDatabase) UnpinMessage(yodelID, messageID primitive.ObjectID) error {
+	return db.updateYodel(yodelID, bson.D{{"$pull", bson.D{{"pins", messageID}}}})
+}
 
-	err := res.Decode(y)
+// Decodes a FindOne result into v, returning DoesNotExist if nothing was found, like the in-memory database.
+func decodeOne(res *mongo.SingleResult, v interface{}) error {
+	err := res.Decode(v)
+	if err == mongo.ErrNoDocuments {
+		return DoesNotExist{}
+	}
 	return err
 }
 
-func (db *MongoDatabase) InsertMessage(m *Message) error {
-	coll := db.getDatabase().Collection("messages")
+func (db *MongoDatabase) updateYodel(yodelID primitive.ObjectID, update bson.D) error {
+	coll := db.getDatabase().Collection("yodels")
 
 	ctx, cancel := db.makeContext()
 	defer cancel()
 
-	res, err := coll.InsertOne(ctx, m)
+	res, err := coll.UpdateByID(ctx, yodelID, update)
 	if err != nil {
-		utils.ErrorLogger.Printf("Got error while inserting message: %q", err)
 		return err
 	}
+	if res.MatchedCount == 0 {
+		return DoesNotExist{}
+	}
+	return nil
+}
 
-	m.MessageID = res.InsertedID.(primitive.ObjectID)
+func (db *MongoDatabase) InsertRole(yodelID primitive.ObjectID, role Role) error {
+	if IsBuiltinRole(role.Name) {
+		return AlreadyExists{}
+	}
+	coll := db.getDatabase().Collection("yodels")
+
+	ctx, cancel := db.makeContext()
+	defer cancel()
+
+	q := bson.D{{"_id", yodelID}, {"roles.name", bson.D{{"$ne", role.Name}}}}
+	res, err := coll.UpdateOne(ctx, q, bson.D{{"$push", bson.D{{"roles", role}}}})
+	if err != nil {
+		return err
+	}
+	if res.MatchedCount == 0 {
+		err = db.GetYodel(&Yodel{YodelID: yodelID})
+		if err != nil {
+			return DoesNotExist{}
+		}
+		return AlreadyExists{}
+	}
+	return nil
+}
+
+func (db *MongoDatabase) DeleteRole(yodelID primitive.ObjectID, name string) error {
+	err := db.updateYodel(yodelID, bson.D{{"$pull", bson.D{{"roles", bson.D{{"name", name}}}}}})
+	if err != nil {
+		return err
+	}
+
+	ctx, cancel := db.makeContext()
+	defer cancel()
+
+	_, err = db.getDatabase().Collection("members").UpdateMany(ctx,
+		bson.D{{"yodel_id", yodelID}, {"role", name}},
+		bson.D{{"$set", bson.D{{"role", RoleMember}}}})
 	return err
 }
 
-func (db *MongoDatabase) GetMessagesBetween(a int64, b int64, limit int64) ([]*Message, error) {
-	coll := db.getDatabase().Collection("messages")
+func (db *MongoDatabase) InsertChannel(ch *Channel) error {
+	coll := db.getDatabase().Collection("channels")
 
-	q := bson.D{{
-		"$and",
-		bson.A{
-			bson.D{{"timestamp", bson.D{{"$gte", a}}}},
-			bson.D{{"timestamp", bson.D{{"$lte", b}}}},
-		},
-	}}
-	opts := options.Find().SetSort(bson.D{{"timestamp", -1}}).SetLimit(limit)
+	ctx, cancel := db.makeContext()
+	defer cancel()
+
+	res, err := coll.InsertOne(ctx, ch)
+	if mongo.IsDuplicateKeyError(err) {
+		return AlreadyExists{}
+	}
+	if err != nil {
+		return err
+	}
+
+	ch.ChannelID = res.InsertedID.(primitive.ObjectID)
+	return nil
+}
+
+func (db *MongoDatabase) GetChannel(ch *Channel) error {
+	coll := db.getDatabase().Collection("channels")
 
 	ctx, cancel := db.makeContext()
 	defer cancel()
 
-	cur, err := coll.Find(ctx, q, opts)
+	return decodeOne(coll.FindOne(ctx, bson.D{{"_id", ch.ChannelID}}), ch)
+}
+
+func (db *MongoDatabase) GetChannels(yodelID primitive.ObjectID) ([]*Channel, error) {
+	coll := db.getDatabase().Collection("channels")
+
+	ctx, cancel := db.makeContext()
+	defer cancel()
+
+	opts := options.Find().SetSort(bson.D{{"created", 1}, {"_id", 1}})
+	cur, err := coll.Find(ctx, bson.D{{"yodel_id", yodelID}}, opts)
 	if err != nil {
 		return nil, err
 	}
-	var res []*Message
 
+	res := []*Channel{}
 	err = cur.All(context.Background(), &res)
+	return res, err
+}
 
-	for i, j := 0, len(res)-1; i < j; i, j = i+1, j-1 {
-		res[i], res[j] = res[j], res[i]
+func (db *MongoDatabase) RenameChannel(channelID primitive.ObjectID, name string) error {
+	coll := db.getDatabase().Collection("channels")
+
+	ctx, cancel := db.makeContext()
+	defer cancel()
+
+	res, err := coll.UpdateByID(ctx, channelID, bson.D{{"$set", bson.D{{"name", name}}}})
+	if mongo.IsDuplicateKeyError(err) {
+		return AlreadyExists{}
 	}
-	
-	return res, err
+	if err != nil {
+		return err
+	}
+	if res.MatchedCount == 0 {
+		return DoesNotExist{}
+	}
+	return nil
 }
 
-func (db *MongoDatabase) InsertUser(u *User) error {
-	coll := db.getDatabase().Collection("users")
+func (db *MongoDatabase) DeleteChannel(channelID primitive.ObjectID) error {
+	ch := &Channel{ChannelID: channelID}
+	err := db.GetChannel(ch)
+	if err != nil {
+		return err
+	}
 
 	ctx, cancel := db.makeContext()
 	defer cancel()
 
-	res, err := coll.InsertOne(ctx, u)
+	messages := db.getDatabase().Collection("messages")
+	q := bson.D{{"channel_id", channelID}}
+	ids, err := messages.Distinct(ctx, "_id", q)
 	if err != nil {
 		return err
 	}
 
-	u.UserID = res.InsertedID.(primitive.ObjectID)
-	return nil
+	if len(ids) != 0 {
+		_, err = db.getDatabase().Collection("yodels").UpdateByID(ctx, ch.YodelID,
+			bson.D{{"$pullAll", bson.D{{"pins", ids}}}})
+		if err != nil {
+			return err
+		}
+	}
+	_, err = messages.DeleteMany(ctx, q)
+	if err != nil {
+		return err
+	}
+	_, err = db.getDatabase().Collection("channels").DeleteOne(ctx, bson.D{{"_id", channelID}})
+	return err
 }
 
-func (db *MongoDatabase) GetUser(u *User) error {
-	coll := db.getDatabase().Collection("users")
-	var q bson.D
-	if u.UserID != primitive.NilObjectID {
-		q = bson.D{{
-			"_id", bson.D{{
-				"$eq", u.UserID,
-			}},
-		}}
-	} else if u.Username != "" {
-		q = bson.D{{
-			"username", bson.D{{
-				"$eq", u.Username,
-			}},
-		}}
-	} else {
-		utils.ErrorLogger.Println("GetUser needs fields in User!")
-		return DatabaseError{}
+func (db *MongoDatabase) UpdateMemberRole(m *Member) error {
+	coll := db.getDatabase().Collection("members")
+
+	ctx, cancel := db.makeContext()
+	defer cancel()
+
+	q := bson.D{{"yodel_id", m.YodelID}, {"user_id", m.UserID}}
+	res, err := coll.UpdateOne(ctx, q, bson.D{{"$set", bson.D{{"role", m.Role}}}})
+	if err != nil {
+		return err
+	}
+	if res.MatchedCount == 0 {
+		return DoesNotExist{}
 	}
+	return nil
+}
+
+func (db *MongoDatabase) DeleteMember(m *Member) error {
+	coll := db.getDatabase().Collection("members")
 
 	ctx, cancel := db.makeContext()
 	defer cancel()
 
-	res := coll.FindOne(ctx, q)
+	_, err := coll.DeleteOne(ctx, bson.D{{"yodel_id", m.YodelID}, {"user_id", m.UserID}})
+	return err
+}
+
+func (db *MongoDatabase) InsertBan(b *Ban) error {
+	coll := db.getDatabase().Collection("bans")
 
-	err := res.Decode(u)
+	ctx, cancel := db.makeContext()
+	defer cancel()
+
+	q := bson.D{{"yodel_id", b.YodelID}, {"user_id", b.UserID}, {"kind", b.Kind}}
+	_, err := coll.ReplaceOne(ctx, q, b, options.Replace().SetUpsert(true))
 	return err
 }
 
-func (db *MongoDatabase) DeleteUser(u *User) error {
-	coll := db.getDatabase().Collection("users")
-	q := bson.D{{
-		"_id", bson.D{{
-			"$eq", u.UserID.Hex(),
-		}},
-	}}
+func (db *MongoDatabase) GetBan(b *Ban) error {
+	coll := db.getDatabase().Collection("bans")
+
 	ctx, cancel := db.makeContext()
 	defer cancel()
 
-	_, err := coll.DeleteOne(ctx, q)
+	q := bson.D{{"yodel_id", b.YodelID}, {"user_id", b.UserID}, {"kind", b.Kind}}
+	return decodeOne(coll.FindOne(ctx, q), b)
+}
+
+// Adds a user to a yodel.  Joining a yodel twice keeps the original membership.
+func (db *MongoDatabase) InsertMember(m *Member) error {
+	coll := db.getDatabase().Collection("members")
 
+	ctx, cancel := db.makeContext()
+	defer cancel()
+
+	q := bson.D{{"yodel_id", m.YodelID}, {"user_id", m.UserID}}
+	opts := options.Update().SetUpsert(true)
+	_, err := coll.UpdateOne(ctx, q, bson.D{{"$setOnInsert", m}}, opts)
 	return err
 }
 
-func (db *MongoDatabase) ClearDB() error {
+func (db *MongoDatabase) GetMember(m *Member) error {
+	coll := db.getDatabase().Collection("members")
+
+	ctx, cancel := db.makeContext()
+	defer cancel()
+
+	q := bson.D{{"yodel_id", m.YodelID}, {"user_id", m.UserID}}
+	return decodeOne(coll.FindOne(ctx, q), m)
+}
+
+func (db *MongoDatabase) GetMembers(yodelID primitive.ObjectID) ([]*Member, error) {
+	coll := db.getDatabase().Collection("members")
+
+	ctx, cancel := db.makeContext()
+	defer cancel()
+
+	cur, err := coll.Find(ctx, bson.D{{"yodel_id", yodelID}})
+	if err != nil {
+		return nil, err
+	}
+
+	var res []*Member
+	err = cur.All(context.Background(), &res)
+	return res, err
+}
+
+func (db *MongoDatabase) GetMembersOf(userID primitive.ObjectID) ([]*Member, error) {
+	coll := db.getDatabase().Collection("members")
+
+	ctx, cancel := db.makeContext()
+	defer cancel()
+
+	cur, err := coll.Find(ctx, bson.D{{"user_id", userID}})
+	if err != nil {
+		return nil, err
+	}
+
+	var res []*Member
+	err = cur.All(context.Background(), &res)
+	return res, err
+}
+
+func (db *MongoDatabase) InsertInvite(i *Invite) error {
+	coll := db.getDatabase().Collection("invites")
+
+	ctx, cancel := db.makeContext()
+	defer cancel()
+
+	_, err := coll.InsertOne(ctx, i)
+	return err
+}
+
+func (db *MongoDatabase) GetInvite(i *Invite) error {
+	coll := db.getDatabase().Collection("invites")
+
+	ctx, cancel := db.makeContext()
+	defer cancel()
+
+	return decodeOne(coll.FindOne(ctx, bson.D{{"_id", i.Code}}), i)
+}
+
+func (db *MongoDatabase) RedeemInvite(code string, userID primitive.ObjectID, now int64) (*Invite, error) {
+	coll := db.getDatabase().Collection("invites")
+
+	q := bson.D{
+		{"_id", code},
+		{"revoked", false},
+		{"$and", bson.A{
+			bson.D{{"$or", bson.A{
+				bson.D{{"expires", 0}},
+				bson.D{{"expires", bson.D{{"$gt", now}}}},
+			}}},
+			bson.D{{"$or", bson.A{
+				bson.D{{"max_uses", 0}},
+				bson.D{{"$expr", bson.D{{"$lt", bson.A{"$uses", "$max_uses"}}}}},
+			}}},
+			bson.D{{"$or", bson.A{
+				bson.D{{"target", bson.D{{"$exists", false}}}},
+				bson.D{{"target", userID}},
+			}}},
+		}},
+	}
+	update := bson.D{{"$inc", bson.D{{"uses", 1}}}}
+	opts := options.FindOneAndUpdate().SetReturnDocument(options.After)
+
+	ctx, cancel := db.makeContext()
+	defer cancel()
+
+	i := &Invite{}
+	err := coll.FindOneAndUpdate(ctx, q, update, opts).Decode(i)
+	if err == mongo.ErrNoDocuments {
+		return nil, DoesNotExist{}
+	}
+	if err != nil {
+		return nil, err
+	}
+	return i, nil
+}
+
+func (db *MongoDatabase) RevokeInvite(code string) error {
+	coll := db.getDatabase().Collection("invites")
+
 	ctx, cancel := db.makeContext()
 	defer cancel()
 
-	return db.getDatabase().Drop(ctx)
+	res, err := coll.UpdateByID(ctx, code, bson.D{{"$set", bson.D{{"revoked", true}}}})
+	if err != nil {
+		return err
+	}
+	if res.MatchedCount == 0 {
+		return DoesNotExist{}
+	}
+	return nil
+}
+
+func (db *MongoDatabase) InsertMessage(m *Message) error {
+	coll := db.getDatabase().Collection("messages")
+
+	ctx, cancel := db.makeContext()
+	defer cancel()
+
+	res, err := coll.InsertOne(ctx, m)
+	if err != nil {
+		utils.ErrorLogger.Printf("Got error while inserting message: %q", err)
+		return err
+	}
+
+	m.MessageID = res.InsertedID.(primitive.ObjectID)
+	return err
+}
+
+func (db *MongoDatabase) GetMessage(m *Message) error {
+	coll := db.getDatabase().Collection("messages")
+
+	ctx, cancel := db.makeContext()
+	defer cancel()
+
+	return decodeOne(coll.FindOne(ctx, bson.D{{"_id", m.MessageID}}), m)
+}
+
+func (db *MongoDatabase) DeleteMessage(messageID primitive.ObjectID) error {
+	ctx, cancel := db.makeContext()
+	defer cancel()
+
+	var m Message
+	err := decodeOne(db.getDatabase().Collection("messages").FindOneAndDelete(ctx, bson.D{{"_id", messageID}}), &m)
+	if err != nil || m.YodelID == primitive.NilObjectID {
+		return err
+	}
+	_, err = db.getDatabase().Collection("yodels").UpdateByID(ctx, m.YodelID,
+		bson.D{{"$pull", bson.D{{"pins", messageID}}}})
+	return err
+}
+
+func (db *MongoDatabase) GetMessagesBetween(a int64, b int64, limit int64) ([]*Message, error) {
+	return db.getMessagesBetween(bson.D{{"$exists", false}}, a, b, limit)
+}
+
+func (db *MongoDatabase) GetChannelMessagesBetween(channelID primitive.ObjectID, from, to, limit int64) ([]*Message, error) {
+	return db.getMessagesBetween(channelID, from, to, limit)
+}
+
+func (db *MongoDatabase) getMessagesBetween(channel interface{}, a int64, b int64, limit int64) ([]*Message, error) {
+	coll := db.getDatabase().Collection("messages")
+
+	q := bson.D{
+		{"channel_id", channel},
+		{"$and", bson.A{
+			bson.D{{"timestamp", bson.D{{"$gte", a}}}},
+			bson.D{{"timestamp", bson.D{{"$lte", b}}}},
+		}},
+	}
+	opts := options.Find().SetSort(bson.D{{"timestamp", -1}}).SetLimit(limit)
+
+	ctx, cancel := db.makeContext()
+	defer cancel()
+
+	cur, err := coll.Find(ctx, q, opts)
+	if err != nil {
+		return nil, err
+	}
+	var res []*Message
+
+	err = cur.All(context.Background(), &res)
+
+	for i, j := 0, len(res)-1; i < j; i, j = i+1, j-1 {
+		res[i], res[j] = res[j], res[i]
+	}
+
+	return res, err
+}
+
+// Ranks messages with mongo's text index, see createIndexes.
+func (db *MongoDatabase) SearchMessages(sq *SearchQuery) ([]*SearchResult, error) {
+	coll := db.getDatabase().Collection("messages")
+
+	q := bson.D{{"$text", bson.D{{"$search", sq.Query}}}}
+	if sq.AuthorID != primitive.NilObjectID {
+		q = append(q, bson.E{"author._id", sq.AuthorID})
+	}
+	if sq.YodelID != primitive.NilObjectID {
+		q = append(q, bson.E{"yodel_id", sq.YodelID})
+	}
+	if sq.ChannelID != primitive.NilObjectID {
+		q = append(q, bson.E{"channel_id", sq.ChannelID})
+	}
+	if sq.YodelIDs != nil {
+		// null matches messages outside yodels, which have no yodel_id
+		yodels := bson.A{nil}
+		for _, id := range sq.YodelIDs {
+			yodels = append(yodels, id)
+		}
+		q = append(q, bson.E{"yodel_id", bson.D{{"$in", yodels}}})
+	}
+	timestamp := bson.D{}
+	if sq.From != 0 {
+		timestamp = append(timestamp, bson.E{"$gte", sq.From})
+	}
+	if sq.To != 0 {
+		timestamp = append(timestamp, bson.E{"$lte", sq.To})
+	}
+	if len(timestamp) != 0 {
+		q = append(q, bson.E{"timestamp", timestamp})
+	}
+
+	score := bson.D{{"score", bson.D{{"$meta", "textScore"}}}}
+	opts := options.Find().SetProjection(score).SetSort(score)
+	if sq.Limit > 0 {
+		opts.SetLimit(sq.Limit)
+	}
+
+	ctx, cancel := db.makeContext()
+	defer cancel()
+
+	cur, err := coll.Find(ctx, q, opts)
+	if err != nil {
+		return nil, err
+	}
+
+	var hits []struct {
+		Message `bson:",inline"`
+		Score   float64 `bson:"score"`
+	}
+	err = cur.All(context.Background(), &hits)
+	if err != nil {
+		return nil, err
+	}
+
+	terms := queryTerms(sq.Query)
+	results := make([]*SearchResult, 0, len(hits))
+	for i := range hits {
+		m := hits[i].Message
+		results = append(results, &SearchResult{
+			Message:    &m,
+			Score:      hits[i].Score,
+			Highlights: highlight(m.Content, terms),
+		})
+	}
+	return results, nil
+}
+
+func (db *MongoDatabase) InsertUser(u *User) error {
+	coll := db.getDatabase().Collection("users")
+
+	ctx, cancel := db.makeContext()
+	defer cancel()
+
+	res, err := coll.InsertOne(ctx, u)
+	if mongo.IsDuplicateKeyError(err) {
+		return AlreadyExists{}
+	}
+	if err != nil {
+		return err
+	}
+
+	u.UserID = res.InsertedID.(primitive.ObjectID)
+	return nil
+}
+
+func (db *MongoDatabase) GetUser(u *User) error {
+	coll := db.getDatabase().Collection("users")
+	var q bson.D
+	if u.UserID != primitive.NilObjectID {
+		q = bson.D{{
+			"_id", bson.D{{
+				"$eq", u.UserID,
+			}},
+		}}
+	} else if u.Username != "" {
+		q = bson.D{{
+			"username", bson.D{{
+				"$eq", u.Username,
+			}},
+		}}
+	} else if u.CanonicalName != "" {
+		q = bson.D{{
+			"canonical_name", bson.D{{
+				"$eq", u.CanonicalName,
+			}},
+		}}
+	} else if u.OIDCSubject != "" {
+		q = bson.D{{
+			"oidc_subject", bson.D{{
+				"$eq", u.OIDCSubject,
+			}},
+		}}
+	} else if u.Email != "" {
+		q = bson.D{{
+			"email", bson.D{{
+				"$eq", u.Email,
+			}},
+		}}
+	} else {
+		utils.ErrorLogger.Println("GetUser needs fields in User!")
+		return DatabaseError{}
+	}
+
+	ctx, cancel := db.makeContext()
+	defer cancel()
+
+	return decodeOne(coll.FindOne(ctx, q), u)
+}
+
+func (db *MongoDatabase) UpdatePassword(u *User) error {
+	coll := db.getDatabase().Collection("users")
+
+	ctx, cancel := db.makeContext()
+	defer cancel()
+
+	update := bson.D{{"$set", bson.D{{"password", u.Password}, {"salt", u.Salt}}}}
+	res, err := coll.UpdateByID(ctx, u.UserID, update)
+	if err != nil {
+		return err
+	}
+	if res.MatchedCount == 0 {
+		return DoesNotExist{}
+	}
+	return nil
+}
+
+func (db *MongoDatabase) UpdateTwoFactor(u *User) error {
+	coll := db.getDatabase().Collection("users")
+
+	ctx, cancel := db.makeContext()
+	defer cancel()
+
+	update := bson.D{{"$set", bson.D{
+		{"totp_secret", u.TOTPSecret},
+		{"totp_enabled", u.TOTPEnabled},
+		{"totp_last_step", u.TOTPLastStep},
+		{"recovery_codes", u.RecoveryCodes},
+	}}}
+	res, err := coll.UpdateByID(ctx, u.UserID, update)
+	if err != nil {
+		return err
+	}
+	if res.MatchedCount == 0 {
+		return DoesNotExist{}
+	}
+	return nil
+}
+
+func (db *MongoDatabase) UpdateUserStatus(u *User) error {
+	coll := db.getDatabase().Collection("users")
+
+	ctx, cancel := db.makeContext()
+	defer cancel()
+
+	update := bson.D{{"$set", bson.D{
+		{"admin", u.Admin},
+		{"disabled", u.Disabled},
+	}}}
+	res, err := coll.UpdateByID(ctx, u.UserID, update)
+	if err != nil {
+		return err
+	}
+	if res.MatchedCount == 0 {
+		return DoesNotExist{}
+	}
+	return nil
+}
+
+func (db *MongoDatabase) ListUsers(uq *UserQuery) ([]*User, error) {
+	coll := db.getDatabase().Collection("users")
+
+	q := bson.D{}
+	if uq.Username != "" {
+		q = append(q, bson.E{"username", primitive.Regex{Pattern: regexp.QuoteMeta(uq.Username), Options: "i"}})
+	}
+	opts := options.Find().SetSort(bson.D{{"username", 1}, {"_id", 1}}).SetSkip(uq.Offset).SetLimit(uq.Limit)
+
+	ctx, cancel := db.makeContext()
+	defer cancel()
+
+	cur, err := coll.Find(ctx, q, opts)
+	if err != nil {
+		return nil, err
+	}
+
+	users := []*User{}
+	err = cur.All(ctx, &users)
+	return users, err
+}
+
+func (db *MongoDatabase) DeleteUser(u *User) error {
+	coll := db.getDatabase().Collection("users")
+	q := bson.D{{
+		"_id", bson.D{{
+			"$eq", u.UserID,
+		}},
+	}}
+	ctx, cancel := db.makeContext()
+	defer cancel()
+
+	res, err := coll.DeleteOne(ctx, q)
+	if err != nil {
+		return err
+	}
+	if res.DeletedCount == 0 {
+		return DoesNotExist{}
+	}
+
+	for _, coll := range []string{"sessions", "access_tokens", "password_resets", "members"} {
+		_, err = db.getDatabase().Collection(coll).DeleteMany(ctx, bson.D{{"user_id", u.UserID}})
+		if err != nil {
+			return err
+		}
+	}
+
+	_, err = db.getDatabase().Collection("messages").UpdateMany(ctx,
+		bson.D{{"author._id", u.UserID}},
+		bson.D{{"$set", bson.D{{"author", bson.D{{"username", DeletedUsername}}}}}})
+	return err
+}
+
+func (db *MongoDatabase) InsertSession(s *Session) error {
+	coll := db.getDatabase().Collection("sessions")
+
+	ctx, cancel := db.makeContext()
+	defer cancel()
+
+	_, err := coll.InsertOne(ctx, s)
+	return err
+}
+
+func (db *MongoDatabase) GetSession(s *Session) error {
+	coll := db.getDatabase().Collection("sessions")
+
+	ctx, cancel := db.makeContext()
+	defer cancel()
+
+	return decodeOne(coll.FindOne(ctx, bson.D{{"_id", s.TokenHash}}), s)
+}
+
+func (db *MongoDatabase) DeleteSession(tokenHash string) error {
+	coll := db.getDatabase().Collection("sessions")
+
+	ctx, cancel := db.makeContext()
+	defer cancel()
+
+	res, err := coll.DeleteOne(ctx, bson.D{{"_id", tokenHash}})
+	if err != nil {
+		return err
+	}
+	if res.DeletedCount == 0 {
+		return DoesNotExist{}
+	}
+	return nil
+}
+
+func (db *MongoDatabase) DeleteSessions(userID primitive.ObjectID) error {
+	coll := db.getDatabase().Collection("sessions")
+
+	ctx, cancel := db.makeContext()
+	defer cancel()
+
+	_, err := coll.DeleteMany(ctx, bson.D{{"user_id", userID}})
+	return err
+}
+
+func (db *MongoDatabase) InsertAccessToken(t *AccessToken) error {
+	coll := db.getDatabase().Collection("access_tokens")
+
+	ctx, cancel := db.makeContext()
+	defer cancel()
+
+	res, err := coll.InsertOne(ctx, t)
+	if err != nil {
+		return err
+	}
+
+	t.TokenID = res.InsertedID.(primitive.ObjectID)
+	return nil
+}
+
+func (db *MongoDatabase) GetAccessToken(t *AccessToken) error {
+	coll := db.getDatabase().Collection("access_tokens")
+
+	ctx, cancel := db.makeContext()
+	defer cancel()
+
+	err := coll.FindOne(ctx, bson.D{{"token_hash", t.TokenHash}}).Decode(t)
+	if err == mongo.ErrNoDocuments {
+		return DoesNotExist{}
+	}
+	return err
+}
+
+func (db *MongoDatabase) GetAccessTokens(userID primitive.ObjectID) ([]*AccessToken, error) {
+	coll := db.getDatabase().Collection("access_tokens")
+
+	ctx, cancel := db.makeContext()
+	defer cancel()
+
+	cur, err := coll.Find(ctx, bson.D{{"user_id", userID}}, options.Find().SetSort(bson.D{{"created", 1}}))
+	if err != nil {
+		return nil, err
+	}
+
+	tokens := []*AccessToken{}
+	err = cur.All(ctx, &tokens)
+	return tokens, err
+}
+
+func (db *MongoDatabase) DeleteAccessToken(tokenID primitive.ObjectID) error {
+	coll := db.getDatabase().Collection("access_tokens")
+
+	ctx, cancel := db.makeContext()
+	defer cancel()
+
+	res, err := coll.DeleteOne(ctx, bson.D{{"_id", tokenID}})
+	if err != nil {
+		return err
+	}
+	if res.DeletedCount == 0 {
+		return DoesNotExist{}
+	}
+	return nil
+}
+
+func (db *MongoDatabase) GetBots(ownerID primitive.ObjectID) ([]*User, error) {
+	coll := db.getDatabase().Collection("users")
+
+	ctx, cancel := db.makeContext()
+	defer cancel()
+
+	cur, err := coll.Find(ctx, bson.D{{"owner_id", ownerID}, {"bot", true}})
+	if err != nil {
+		return nil, err
+	}
+
+	bots := []*User{}
+	err = cur.All(ctx, &bots)
+	return bots, err
+}
+
+func (db *MongoDatabase) InsertPasswordReset(r *PasswordReset) error {
+	coll := db.getDatabase().Collection("password_resets")
+
+	ctx, cancel := db.makeContext()
+	defer cancel()
+
+	_, err := coll.InsertOne(ctx, r)
+	return err
+}
+
+func (db *MongoDatabase) TakePasswordReset(r *PasswordReset) error {
+	coll := db.getDatabase().Collection("password_resets")
+
+	ctx, cancel := db.makeContext()
+	defer cancel()
+
+	q := bson.D{{"_id", r.TokenHash}, {"expires", bson.D{{"$gt", time.Now().UnixNano()}}}}
+	err := coll.FindOneAndDelete(ctx, q).Decode(r)
+	if err == mongo.ErrNoDocuments {
+		return DoesNotExist{}
+	}
+	return err
+}
+
+func (db *MongoDatabase) InsertTicket(t *Ticket) error {
+	coll := db.getDatabase().Collection("tickets")
+
+	ctx, cancel := db.makeContext()
+	defer cancel()
+
+	_, err := coll.ReplaceOne(ctx, bson.D{{"_id", t.UserID}}, t, options.Replace().SetUpsert(true))
+	return err
+}
+
+func (db *MongoDatabase) TakeTicket(t *Ticket) error {
+	coll := db.getDatabase().Collection("tickets")
+
+	ctx, cancel := db.makeContext()
+	defer cancel()
+
+	q := bson.D{{"_id", t.UserID}, {"expires", bson.D{{"$gt", time.Now()}}}}
+	err := coll.FindOneAndDelete(ctx, q).Decode(t)
+	if err == mongo.ErrNoDocuments {
+		return DoesNotExist{}
+	}
+	return err
+}
+
+// Every collection Fenix uses.
+var mongoCollections = []string{
+	"messages", "users", "sessions", "access_tokens", "tickets", "password_resets",
+	"yodels", "channels", "members", "invites", "bans", "audit_log", "schema",
+}
+
+func (db *MongoDatabase) CreateSchema() error {
+	ctx, cancel := db.makeContext()
+	defer cancel()
+
+	names, err := db.getDatabase().ListCollectionNames(ctx, bson.D{})
+	if err != nil {
+		return err
+	}
+	existing := make(map[string]bool)
+	for _, name := range names {
+		existing[name] = true
+	}
+	for _, name := range mongoCollections {
+		if existing[name] {
+			continue
+		}
+		err = db.getDatabase().CreateCollection(ctx, name)
+		if err != nil {
+			return err
+		}
+	}
+	return db.createIndexes()
+}
+
+// Creates the indexes queries rely on.  Safe to call repeatedly.
+func (db *MongoDatabase) createIndexes() error {
+	ctx, cancel := db.makeContext()
+	defer cancel()
+
+	_, err := db.getDatabase().Collection("messages").Indexes().CreateOne(ctx, mongo.IndexModel{
+		Keys: bson.D{{"content", "text"}},
+	})
+	if err != nil {
+		return err
+	}
+
+	_, err = db.getDatabase().Collection("messages").Indexes().CreateMany(ctx, []mongo.IndexModel{
+		{Keys: bson.D{{"yodel_id", 1}}},
+		{Keys: bson.D{{"channel_id", 1}, {"timestamp", 1}}},
+	})
+	if err != nil {
+		return err
+	}
+
+	_, err = db.getDatabase().Collection("channels").Indexes().CreateOne(ctx, mongo.IndexModel{
+		Keys:    bson.D{{"yodel_id", 1}, {"name", 1}},
+		Options: options.Index().SetUnique(true),
+	})
+	if err != nil {
+		return err
+	}
+
+	// Sparse, as users from before canonical names and users who never used OIDC are left out
+	_, err = db.getDatabase().Collection("users").Indexes().CreateMany(ctx, []mongo.IndexModel{
+		{
+			Keys:    bson.D{{"canonical_name", 1}},
+			Options: options.Index().SetUnique(true).SetSparse(true),
+		},
+		{
+			Keys:    bson.D{{"oidc_subject", 1}},
+			Options: options.Index().SetUnique(true).SetSparse(true),
+		},
+		{
+			Keys:    bson.D{{"email", 1}},
+			Options: options.Index().SetSparse(true),
+		},
+	})
+	if err != nil {
+		return err
+	}
+
+	_, err = db.getDatabase().Collection("yodels").Indexes().CreateOne(ctx, mongo.IndexModel{
+		Keys: bson.D{{"name", 1}},
+	})
+	if err != nil {
+		return err
+	}
+
+	_, err = db.getDatabase().Collection("members").Indexes().CreateMany(ctx, []mongo.IndexModel{
+		{
+			Keys:    bson.D{{"yodel_id", 1}, {"user_id", 1}},
+			Options: options.Index().SetUnique(true),
+		},
+		{Keys: bson.D{{"user_id", 1}}},
+	})
+	if err != nil {
+		return err
+	}
+
+	_, err = db.getDatabase().Collection("bans").Indexes().CreateOne(ctx, mongo.IndexModel{
+		Keys:    bson.D{{"yodel_id", 1}, {"user_id", 1}, {"kind", 1}},
+		Options: options.Index().SetUnique(true),
+	})
+	if err != nil {
+		return err
+	}
+
+	_, err = db.getDatabase().Collection("sessions").Indexes().CreateOne(ctx, mongo.IndexModel{
+		Keys: bson.D{{"user_id", 1}},
+	})
+	if err != nil {
+		return err
+	}
+
+	_, err = db.getDatabase().Collection("access_tokens").Indexes().CreateMany(ctx, []mongo.IndexModel{
+		{
+			Keys:    bson.D{{"token_hash", 1}},
+			Options: options.Index().SetUnique(true),
+		},
+		{Keys: bson.D{{"user_id", 1}}},
+	})
+	if err != nil {
+		return err
+	}
+
+	_, err = db.getDatabase().Collection("users").Indexes().CreateOne(ctx, mongo.IndexModel{
+		Keys:    bson.D{{"owner_id", 1}},
+		Options: options.Index().SetSparse(true),
+	})
+	if err != nil {
+		return err
+	}
+
+	_, err = db.getDatabase().Collection("audit_log").Indexes().CreateMany(ctx, []mongo.IndexModel{
+		{Keys: bson.D{{"timestamp", -1}}},
+		{Keys: bson.D{{"actor_id", 1}, {"timestamp", -1}}},
+	})
+	if err != nil {
+		return err
+	}
+
+	// Lets mongo remove tickets that were never redeemed
+	_, err = db.getDatabase().Collection("tickets").Indexes().CreateOne(ctx, mongo.IndexModel{
+		Keys:    bson.D{{"expires", 1}},
+		Options: options.Index().SetExpireAfterSeconds(0),
+	})
+	return err
+}
+
+func (db *MongoDatabase) GetStats() (*Stats, error) {
+	ctx, cancel := db.makeContext()
+	defer cancel()
+
+	stats := &Stats{}
+	counts := map[*int64]struct {
+		coll string
+		q    bson.D
+	}{
+		&stats.Users:    {"users", bson.D{{"bot", bson.D{{"$ne", true}}}}},
+		&stats.Bots:     {"users", bson.D{{"bot", true}}},
+		&stats.Yodels:   {"yodels", bson.D{}},
+		&stats.Messages: {"messages", bson.D{}},
+	}
+	for n, c := range counts {
+		count, err := db.getDatabase().Collection(c.coll).CountDocuments(ctx, c.q)
+		if err != nil {
+			return nil, err
+		}
+		*n = count
+	}
+	return stats, nil
+}
+
+func (db *MongoDatabase) InsertAuditEntry(e *AuditEntry) error {
+	coll := db.getDatabase().Collection("audit_log")
+
+	ctx, cancel := db.makeContext()
+	defer cancel()
+
+	res, err := coll.InsertOne(ctx, e)
+	if err != nil {
+		return err
+	}
+
+	e.EntryID = res.InsertedID.(primitive.ObjectID)
+	return nil
+}
+
+func (db *MongoDatabase) GetAuditEntries(aq *AuditQuery) ([]*AuditEntry, error) {
+	coll := db.getDatabase().Collection("audit_log")
+
+	q := bson.D{}
+	timestamp := bson.D{}
+	if aq.From != 0 {
+		timestamp = append(timestamp, bson.E{"$gte", aq.From})
+	}
+	if aq.To != 0 {
+		timestamp = append(timestamp, bson.E{"$lte", aq.To})
+	}
+	if len(timestamp) != 0 {
+		q = append(q, bson.E{"timestamp", timestamp})
+	}
+	if aq.ActorID != "" {
+		q = append(q, bson.E{"actor_id", aq.ActorID})
+	}
+	if aq.Action != "" {
+		q = append(q, bson.E{"action", aq.Action})
+	}
+	opts := options.Find().SetSort(bson.D{{"timestamp", -1}, {"_id", -1}}).SetSkip(aq.Offset).SetLimit(aq.Limit)
+
+	ctx, cancel := db.makeContext()
+	defer cancel()
+
+	cur, err := coll.Find(ctx, q, opts)
+	if err != nil {
+		return nil, err
+	}
+
+	entries := []*AuditEntry{}
+	err = cur.All(ctx, &entries)
+	return entries, err
+}
+
+func (db *MongoDatabase) GetSchemaVersion() (int, error) {
+	coll := db.getDatabase().Collection("schema")
+
+	ctx, cancel := db.makeContext()
+	defer cancel()
+
+	var doc struct {
+		Version int `bson:"version"`
+	}
+	err := coll.FindOne(ctx, bson.D{{"_id", "version"}}).Decode(&doc)
+	if err == mongo.ErrNoDocuments {
+		return 0, nil
+	}
+	return doc.Version, err
+}
+
+func (db *MongoDatabase) SetSchemaVersion(version int) error {
+	coll := db.getDatabase().Collection("schema")
+
+	ctx, cancel := db.makeContext()
+	defer cancel()
+
+	update := bson.D{{"$set", bson.D{{"version", version}}}}
+	_, err := coll.UpdateByID(ctx, "version", update, options.Update().SetUpsert(true))
+	return err
+}
+
+func (db *MongoDatabase) GetUsersWithoutCanonicalName() ([]*User, error) {
+	coll := db.getDatabase().Collection("users")
+
+	ctx, cancel := db.makeContext()
+	defer cancel()
+
+	// Oldest first, so the first user with a name keeps it
+	opts := options.Find().SetSort(bson.D{{"_id", 1}})
+	cur, err := coll.Find(ctx, bson.D{{"canonical_name", bson.D{{"$exists", false}}}}, opts)
+	if err != nil {
+		return nil, err
+	}
+
+	users := []*User{}
+	err = cur.All(ctx, &users)
+	return users, err
+}
+
+func (db *MongoDatabase) SetCanonicalName(u *User) error {
+	coll := db.getDatabase().Collection("users")
+
+	ctx, cancel := db.makeContext()
+	defer cancel()
+
+	update := bson.D{{"$set", bson.D{{"canonical_name", u.CanonicalName}}}}
+	res, err := coll.UpdateByID(ctx, u.UserID, update)
+	if mongo.IsDuplicateKeyError(err) {
+		return AlreadyExists{}
+	}
+	if err != nil {
+		return err
+	}
+	if res.MatchedCount == 0 {
+		return DoesNotExist{}
+	}
+	return nil
+}
+
+func (db *MongoDatabase) ClearCanonicalNames() error {
+	coll := db.getDatabase().Collection("users")
+
+	ctx, cancel := db.makeContext()
+	defer cancel()
+
+	_, err := coll.UpdateMany(ctx, bson.D{}, bson.D{{"$unset", bson.D{{"canonical_name", ""}}}})
+	return err
+}
+
+func (db *MongoDatabase) ClearDB() error {
+	ctx, cancel := db.makeContext()
+	defer cancel()
+
+	err := db.getDatabase().Drop(ctx)
+	if err != nil {
+		return err
+	}
+	return db.CreateSchema()
 }
 
 func NewMongoDatabase(mongo_addr string, database string) *MongoDatabase {
@@ -203,9 +1408,8 @@ func NewMongoDatabase(mongo_addr string, database string) *MongoDatabase {
 		utils.ErrorLogger.Panicf("Error connecting to mongoDB: %v", err)
 	}
 
-	db := MongoDatabase{
+	return &MongoDatabase{
 		mongo:    c,
 		database: database,
 	}
-	return &db
 }
@@ -1,10 +1,8 @@
 package database
 
 import (
-	"crypto/sha512"
 	"time"
 
-	"github.com/xdg-go/pbkdf2"
 	"go.mongodb.org/mongo-driver/bson/primitive"
 )
 
@@ -13,17 +11,135 @@ type Message struct {
 	Content   string
 	Timestamp int64
 	Author    User
+	YodelID   primitive.ObjectID `bson:"yodel_id,omitempty"`
+	ChannelID primitive.ObjectID `bson:"channel_id,omitempty"`
 }
 
 type User struct {
 	UserID   primitive.ObjectID `bson:"_id,omitempty"`
 	Username string
+	// Username with case, punctuation, and Cyrillic and Greek letters that look like Latin ones folded together,
+	// so they can't be used to impersonate another user.
+	CanonicalName string `json:"-" bson:"canonical_name,omitempty"`
+	// Users who signed in with OpenID Connect have their issuer and subject, as "issuer|subject".
+	OIDCSubject string `json:"-" bson:"oidc_subject,omitempty"`
+	// Only verified emails are stored.
+	Email string `json:"-" bson:"email,omitempty"`
+	// Bots connect with access tokens instead of logging in, and belong to OwnerID.
+	Bot     bool               `json:"bot,omitempty" bson:"bot,omitempty"`
+	OwnerID primitive.ObjectID `json:"-" bson:"owner_id,omitempty"`
+	// Admins can manage every user and yodel through /admin.
+	Admin bool `json:"admin,omitempty" bson:"admin,omitempty"`
+	// Disabled users can't log in or connect, but keep their account.
+	Disabled bool `json:"disabled,omitempty" bson:"disabled,omitempty"`
+	// PHC string, see HashPassword.  Users from before then have a raw PBKDF2 key.
 	Password []byte `json:"-"`
-	Salt     []byte `json:"-"`
+	// Only used by raw PBKDF2 keys.  PHC strings include their salt.
+	Salt []byte `json:"-"`
+
+	// TOTP secret, encrypted by the server.  It's set while enrolling, before TOTPEnabled.
+	TOTPSecret  []byte `json:"-" bson:"totp_secret,omitempty"`
+	TOTPEnabled bool   `json:"-" bson:"totp_enabled,omitempty"`
+	// Last TOTP time step used, so codes can't be replayed.
+	TOTPLastStep int64 `json:"-" bson:"totp_last_step,omitempty"`
+	// Hashes of unused recovery codes.
+	RecoveryCodes []string `json:"-" bson:"recovery_codes,omitempty"`
+}
+
+// Query for listing users, sorted by username.
+type UserQuery struct {
+	// Case insensitive substring of the username.  Empty matches all users.
+	Username string
+	Offset   int64
+	Limit    int64
+}
+
+// Counts of what's stored, for admins.
+type Stats struct {
+	Users    int64 `json:"users"`
+	Bots     int64 `json:"bots"`
+	Yodels   int64 `json:"yodels"`
+	Messages int64 `json:"messages"`
+}
+
+// Record of a security-relevant event.  Entries are never changed or deleted.
+type AuditEntry struct {
+	EntryID primitive.ObjectID `bson:"_id,omitempty" json:"id"`
+	// Unix nanoseconds.
+	Timestamp int64  `bson:"timestamp" json:"timestamp"`
+	Action    string `bson:"action" json:"action"`
+	// ID of the user who did it, if they're known.
+	ActorID string `bson:"actor_id,omitempty" json:"actor_id,omitempty"`
+	// ID of what it was done to, or a username if there's no ID.
+	Target  string `bson:"target,omitempty" json:"target,omitempty"`
+	IP      string `bson:"ip,omitempty" json:"ip,omitempty"`
+	Details string `bson:"details,omitempty" json:"details,omitempty"`
+}
+
+// Query for the audit log, newest first.  Zero values match everything.
+type AuditQuery struct {
+	// Unix nanoseconds, inclusive.
+	From int64
+	To   int64
+	// User ID, as hex.
+	ActorID string
+	Action  string
+	Offset  int64
+	Limit   int64
+}
+
+func (q *AuditQuery) matches(e *AuditEntry) bool {
+	return (q.From == 0 || e.Timestamp >= q.From) &&
+		(q.To == 0 || e.Timestamp <= q.To) &&
+		(q.ActorID == "" || e.ActorID == q.ActorID) &&
+		(q.Action == "" || e.Action == q.Action)
 }
 
-func (u *User) HashPassword() {
-	u.Password = pbkdf2.Key(u.Password, u.Salt, 100000, 32, sha512.New512_256)
+// Username that messages from deleted users are shown with.
+const DeletedUsername = "[deleted]"
+
+// Long-lived login, exchanged for upgrade tickets.  Only a hash of the token is stored.
+type Session struct {
+	TokenHash string             `bson:"_id"`
+	UserID    primitive.ObjectID `bson:"user_id"`
+	Created   int64              `bson:"created"`
+	// Unix nanoseconds.
+	Expires int64 `bson:"expires"`
+}
+
+func (s *Session) Active(now int64) bool {
+	return now < s.Expires
+}
+
+// Lets a user or bot connect without logging in, only doing what Scopes allow.  Only a hash of the token is stored.
+type AccessToken struct {
+	TokenID   primitive.ObjectID `bson:"_id,omitempty" json:"id"`
+	TokenHash string             `bson:"token_hash" json:"-"`
+	UserID    primitive.ObjectID `bson:"user_id" json:"user_id"`
+	Name      string             `bson:"name" json:"name"`
+	Scopes    []string           `bson:"scopes" json:"scopes"`
+	Created   int64              `bson:"created" json:"created"`
+	// Unix nanoseconds, or 0 if the token never expires.
+	Expires int64 `bson:"expires" json:"expires,omitempty"`
+}
+
+func (t *AccessToken) Active(now int64) bool {
+	return t.Expires == 0 || now < t.Expires
+}
+
+// Single-use token for resetting a forgotten password.  Only a hash of the token is stored.
+type PasswordReset struct {
+	TokenHash string             `bson:"_id"`
+	UserID    primitive.ObjectID `bson:"user_id"`
+	// Unix nanoseconds.
+	Expires int64 `bson:"expires"`
+}
+
+// One-shot ticket for upgrading to a websocket.  A user has at most one.
+type Ticket struct {
+	UserID  string    `bson:"_id"`
+	Ticket  string    `bson:"ticket"`
+	Expires time.Time `bson:"expires"`
 }
 
 func NewMessage(user User, content string) *Message {
@@ -32,8 +148,117 @@ func NewMessage(user User, content string) *Message {
 	return &m
 }
 
+// Who can find and join a yodel.
+type Visibility string
+
+const (
+	// Listed, and anyone can join.
+	VisibilityPublic Visibility = "public"
+	// Only visible to its members.
+	VisibilityPrivate Visibility = "private"
+	// Listed, but joining needs an invite.
+	VisibilityInviteOnly Visibility = "invite"
+)
+
+func (v Visibility) Valid() bool {
+	return v == VisibilityPublic || v == VisibilityPrivate || v == VisibilityInviteOnly
+}
+
 type Yodel struct {
-	YodelID primitive.ObjectID `bson:"_id,omitempty"`
-	Name    string             `bson:"name"`
-	Owner   string             `bson:"owner"`
+	YodelID     primitive.ObjectID   `bson:"_id,omitempty"`
+	Name        string               `bson:"name"`
+	Owner       string               `bson:"owner"`
+	Description string               `bson:"description"`
+	Icon        string               `bson:"icon"`
+	Topic       string               `bson:"topic"`
+	Pins        []primitive.ObjectID `bson:"pins"`
+	Visibility  Visibility           `bson:"visibility"`
+	// Custom roles.  See BuiltinRoles for the rest.
+	Roles []Role `bson:"roles"`
+}
+
+// Yodels created before visibility existed are public.
+func (y *Yodel) GetVisibility() Visibility {
+	if y.Visibility == "" {
+		return VisibilityPublic
+	}
+	return y.Visibility
+}
+
+// Name of the channel every yodel is created with.
+const DefaultChannelName = "general"
+
+// Topic channel inside a yodel.  Names are unique within a yodel.
+type Channel struct {
+	ChannelID primitive.ObjectID `bson:"_id,omitempty"`
+	YodelID   primitive.ObjectID `bson:"yodel_id"`
+	Name      string             `bson:"name"`
+	Created   int64              `bson:"created"`
+}
+
+// Filters and pagination for ListYodels.
+type YodelQuery struct {
+	// Case insensitive substring of the yodel's name.  Empty matches all yodels.
+	Name string
+	// Private yodels are only listed if Viewer is a member, or All is set.
+	Viewer primitive.ObjectID
+	All    bool
+	Offset int64
+	Limit  int64
+}
+
+// Membership of a user in a yodel.  Members recieve the yodel's broadcasts.
+type Member struct {
+	YodelID primitive.ObjectID `bson:"yodel_id"`
+	UserID  primitive.ObjectID `bson:"user_id"`
+	Joined  int64              `bson:"joined"`
+	Role    string             `bson:"role"`
+}
+
+// Invite code for joining a yodel.
+type Invite struct {
+	Code    string             `bson:"_id"`
+	YodelID primitive.ObjectID `bson:"yodel_id"`
+	Creator primitive.ObjectID `bson:"creator"`
+	// Only this user may redeem the invite, if set.
+	Target primitive.ObjectID `bson:"target,omitempty"`
+	// Unix nanoseconds.  0 never expires.
+	Expires int64 `bson:"expires"`
+	// 0 allows unlimited uses.
+	MaxUses int64 `bson:"max_uses"`
+	Uses    int64 `bson:"uses"`
+	Revoked bool  `bson:"revoked"`
+}
+
+// Checks whether userID may redeem the invite at time now.
+func (i *Invite) Redeemable(userID primitive.ObjectID, now int64) bool {
+	return !i.Revoked &&
+		(i.Expires == 0 || now < i.Expires) &&
+		(i.MaxUses == 0 || i.Uses < i.MaxUses) &&
+		(i.Target == primitive.NilObjectID || i.Target == userID)
+}
+
+type BanKind string
+
+const (
+	// Removed from the yodel, and can't rejoin.
+	BanKindBan BanKind = "ban"
+	// Can't send messages to the yodel.
+	BanKindMute BanKind = "mute"
+)
+
+// Ban or mute of a user in a yodel.  A user has at most one of each kind per yodel.
+type Ban struct {
+	YodelID primitive.ObjectID `bson:"yodel_id"`
+	UserID  primitive.ObjectID `bson:"user_id"`
+	Kind    BanKind            `bson:"kind"`
+	Issuer  primitive.ObjectID `bson:"issuer"`
+	Reason  string             `bson:"reason"`
+	Created int64              `bson:"created"`
+	// Unix nanoseconds.  0 never expires.
+	Expires int64 `bson:"expires"`
+}
+
+func (b *Ban) Active(now int64) bool {
+	return b.Expires == 0 || now < b.Expires
 }
@@ -2,8 +2,11 @@ package database
 
 import (
 	"fenix/src/utils"
+	"math"
 	"sort"
+	"strings"
 	"sync"
+	"sync/atomic"
 	"time"
 
 	"go.mongodb.org/mongo-driver/bson/primitive"
@@ -35,6 +38,12 @@ func (m messages) Len() int {
 	return len(m.M)
 }
 
+type AlreadyExists struct{}
+
+func (a AlreadyExists) Error() string {
+	return "Already Exists!"
+}
+
 type FakeDatabaseError struct{}
 
 func (f FakeDatabaseError) Error() string {
@@ -43,27 +52,91 @@ func (f FakeDatabaseError) Error() string {
 
 type InMemoryDatabase struct {
 	ShouldErrorOnNext bool
-	messages          []*Message
-	messagesLock      *sync.Mutex
+	// Number of IDs handed out by newID
+	ids int64
+
+	messages     []*Message
+	messagesLock *sync.Mutex
+
+	// Inverted index of search term -> position in messages -> term frequency
+	searchIndex map[string]map[int]int
 
 	users     map[string]*User
 	usersLock *sync.Mutex
 
+	sessions     map[string]*Session
+	sessionsLock *sync.Mutex
+
+	accessTokens     map[string]*AccessToken
+	accessTokensLock *sync.Mutex
+
+	tickets     map[string]*Ticket
+	ticketsLock *sync.Mutex
+
+	resets     map[string]*PasswordReset
+	resetsLock *sync.Mutex
+
 	yodels     map[string]*Yodel
 	yodelsLock *sync.Mutex
+
+	channels     map[string]*Channel
+	channelsLock *sync.Mutex
+
+	members     map[string]*Member
+	membersLock *sync.Mutex
+
+	invites     map[string]*Invite
+	invitesLock *sync.Mutex
+
+	bans     map[string]*Ban
+	bansLock *sync.Mutex
+
+	audit     []*AuditEntry
+	auditLock *sync.Mutex
+
+	schemaVersion int
+	schemaLock    *sync.Mutex
 }
 
 func NewInMemoryDatabase() *InMemoryDatabase {
 	return &InMemoryDatabase{
-		users:        make(map[string]*User),
-		usersLock:    &sync.Mutex{},
-		messagesLock: &sync.Mutex{},
-		yodels:       make(map[string]*Yodel),
-		yodelsLock:   &sync.Mutex{},
+		users:            make(map[string]*User),
+		usersLock:        &sync.Mutex{},
+		sessions:         make(map[string]*Session),
+		sessionsLock:     &sync.Mutex{},
+		accessTokens:     make(map[string]*AccessToken),
+		accessTokensLock: &sync.Mutex{},
+		tickets:          make(map[string]*Ticket),
+		ticketsLock:      &sync.Mutex{},
+		resets:           make(map[string]*PasswordReset),
+		resetsLock:       &sync.Mutex{},
+		messagesLock:     &sync.Mutex{},
+		searchIndex:      make(map[string]map[int]int),
+		yodels:           make(map[string]*Yodel),
+		yodelsLock:       &sync.Mutex{},
+		channels:         make(map[string]*Channel),
+		channelsLock:     &sync.Mutex{},
+		members:          make(map[string]*Member),
+		membersLock:      &sync.Mutex{},
+		invites:          make(map[string]*Invite),
+		invitesLock:      &sync.Mutex{},
+		bans:             make(map[string]*Ban),
+		bansLock:         &sync.Mutex{},
+		auditLock:        &sync.Mutex{},
+		schemaLock:       &sync.Mutex{},
 	}
 }
 
+// Returns a predictable, unique ID.
+func (db *InMemoryDatabase) newID() primitive.ObjectID {
+	return primitive.NewObjectIDFromTimestamp(time.Unix(atomic.AddInt64(&db.ids, 1), 0))
+}
+
 func (db *InMemoryDatabase) GetMessagesBetween(a, b, limit int64) ([]*Message, error) {
+	return db.GetChannelMessagesBetween(primitive.NilObjectID, a, b, limit)
+}
+
+func (db *InMemoryDatabase) GetChannelMessagesBetween(channelID primitive.ObjectID, a, b, limit int64) ([]*Message, error) {
 	db.messagesLock.Lock()
 	defer db.messagesLock.Unlock()
 
@@ -74,7 +147,7 @@ func (db *InMemoryDatabase) GetMessagesBetween(a, b, limit int64) ([]*Message, e
 	partHistory := messages{}
 
 	for _, m := range db.messages {
-		if m.Timestamp >= a && m.Timestamp <= b {
+		if m.ChannelID == channelID && m.Timestamp >= a && m.Timestamp <= b {
 			partHistory.M = append(partHistory.M, m)
 		}
 	}
@@ -94,11 +167,106 @@ func (db *InMemoryDatabase) InsertMessage(m *Message) error {
 		return FakeDatabaseError{}
 	}
 
-	m.MessageID = primitive.NewObjectIDFromTimestamp(time.Unix(int64(len(db.messages)+1), 0))
+	m.MessageID = db.newID()
 	db.messages = append(db.messages, m)
+	db.indexMessage(len(db.messages)-1, m)
 	return nil
 }
 
+// Adds the message at position i to the search index.  Caller must hold messagesLock.
+func (db *InMemoryDatabase) indexMessage(i int, m *Message) {
+	for _, term := range tokenize(m.Content) {
+		postings, ok := db.searchIndex[term]
+		if !ok {
+			postings = make(map[int]int)
+			db.searchIndex[term] = postings
+		}
+		postings[i]++
+	}
+}
+
+func (db *InMemoryDatabase) SearchMessages(q *SearchQuery) ([]*SearchResult, error) {
+	db.messagesLock.Lock()
+	defer db.messagesLock.Unlock()
+
+	if db.ShouldErrorOnNext {
+		return nil, FakeDatabaseError{}
+	}
+
+	terms := queryTerms(q.Query)
+	scores := make(map[int]float64)
+	total := float64(len(db.messages))
+
+	for term := range terms {
+		postings := db.searchIndex[term]
+		if len(postings) == 0 {
+			continue
+		}
+		idf := math.Log(1 + total/float64(len(postings)))
+		for i, tf := range postings {
+			if !q.matches(db.messages[i]) {
+				continue
+			}
+			scores[i] += float64(tf) * idf
+		}
+	}
+
+	results := make([]*SearchResult, 0, len(scores))
+	for i, score := range scores {
+		m := db.messages[i]
+		results = append(results, &SearchResult{
+			Message:    m,
+			Score:      score,
+			Highlights: highlight(m.Content, terms),
+		})
+	}
+
+	sortResults(results)
+	if q.Limit > 0 && int64(len(results)) > q.Limit {
+		results = results[:q.Limit]
+	}
+	return results, nil
+}
+
+func (db *InMemoryDatabase) GetMessage(req *Message) error {
+	db.messagesLock.Lock()
+	defer db.messagesLock.Unlock()
+
+	if db.ShouldErrorOnNext {
+		return FakeDatabaseError{}
+	}
+
+	for _, m := range db.messages {
+		if m.MessageID == req.MessageID {
+			*req = *m
+			return nil
+		}
+	}
+	return DoesNotExist{}
+}
+
+func (db *InMemoryDatabase) DeleteMessage(messageID primitive.ObjectID) error {
+	if db.ShouldErrorOnNext {
+		return FakeDatabaseError{}
+	}
+
+	var yodelID primitive.ObjectID
+	deleted := db.deleteMessages(func(m *Message) bool {
+		if m.MessageID == messageID {
+			yodelID = m.YodelID
+			return true
+		}
+		return false
+	})
+	if len(deleted) == 0 {
+		return DoesNotExist{}
+	}
+	if yodelID == primitive.NilObjectID {
+		return nil
+	}
+	return db.UnpinMessage(yodelID, messageID)
+}
+
 func (db *InMemoryDatabase) InsertUser(u *User) error {
 	db.usersLock.Lock()
 	defer db.usersLock.Unlock()
@@ -106,8 +274,15 @@ func (db *InMemoryDatabase) InsertUser(u *User) error {
 	if db.ShouldErrorOnNext {
 		return FakeDatabaseError{}
 	}
+	if u.CanonicalName != "" {
+		for _, user := range db.users {
+			if user.CanonicalName == u.CanonicalName {
+				return AlreadyExists{}
+			}
+		}
+	}
 
-	u.UserID = primitive.NewObjectIDFromTimestamp(time.Unix(int64(len(db.users)+1), 0))
+	u.UserID = db.newID()
 	db.users[u.UserID.Hex()] = u
 	return nil
 }
@@ -134,6 +309,30 @@ func (db *InMemoryDatabase) GetUser(req *User) error {
 			}
 		}
 		return DoesNotExist{}
+	} else if req.CanonicalName != "" {
+		for _, u := range db.users {
+			if u.CanonicalName == req.CanonicalName {
+				*req = *u
+				return nil
+			}
+		}
+		return DoesNotExist{}
+	} else if req.OIDCSubject != "" {
+		for _, u := range db.users {
+			if u.OIDCSubject == req.OIDCSubject {
+				*req = *u
+				return nil
+			}
+		}
+		return DoesNotExist{}
+	} else if req.Email != "" {
+		for _, u := range db.users {
+			if u.Email == req.Email {
+				*req = *u
+				return nil
+			}
+		}
+		return DoesNotExist{}
 	} else {
 		utils.ErrorLogger.Println("GetUser needs fields in User!")
 		return DatabaseError{}
@@ -142,46 +341,1148 @@ func (db *InMemoryDatabase) GetUser(req *User) error {
 	return nil
 }
 
-func (db *InMemoryDatabase) InsertYodel(y *Yodel) error {
-	db.yodelsLock.Lock()
-	defer db.yodelsLock.Unlock()
+func (db *InMemoryDatabase) UpdatePassword(u *User) error {
+	db.usersLock.Lock()
+	defer db.usersLock.Unlock()
 
 	if db.ShouldErrorOnNext {
 		return FakeDatabaseError{}
 	}
+	user, ok := db.users[u.UserID.Hex()]
+	if !ok {
+		return DoesNotExist{}
+	}
 
-	y.YodelID = primitive.NewObjectIDFromTimestamp(time.Unix(int64(len(db.users)+1), 0))
-	db.yodels[y.YodelID.Hex()] = y
+	user.Password = u.Password
+	user.Salt = u.Salt
 	return nil
 }
 
-func (db *InMemoryDatabase) GetYodel(y *Yodel) error {
-	db.yodelsLock.Lock()
-	defer db.yodelsLock.Unlock()
+func (db *InMemoryDatabase) UpdateTwoFactor(u *User) error {
+	db.usersLock.Lock()
+	defer db.usersLock.Unlock()
 
 	if db.ShouldErrorOnNext {
 		return FakeDatabaseError{}
 	}
-	yodel, ok := db.yodels[y.YodelID.Hex()]
+	user, ok := db.users[u.UserID.Hex()]
 	if !ok {
 		return DoesNotExist{}
 	}
-	*y = *yodel
+
+	user.TOTPSecret = u.TOTPSecret
+	user.TOTPEnabled = u.TOTPEnabled
+	user.TOTPLastStep = u.TOTPLastStep
+	user.RecoveryCodes = append([]string(nil), u.RecoveryCodes...)
 	return nil
 }
 
-func (db *InMemoryDatabase) ClearDB() error {
+func (db *InMemoryDatabase) UpdateUserStatus(u *User) error {
+	db.usersLock.Lock()
+	defer db.usersLock.Unlock()
+
+	if db.ShouldErrorOnNext {
+		return FakeDatabaseError{}
+	}
+	user, ok := db.users[u.UserID.Hex()]
+	if !ok {
+		return DoesNotExist{}
+	}
+
+	user.Admin = u.Admin
+	user.Disabled = u.Disabled
+	return nil
+}
+
+func (db *InMemoryDatabase) ListUsers(q *UserQuery) ([]*User, error) {
+	db.usersLock.Lock()
+	defer db.usersLock.Unlock()
+
+	if db.ShouldErrorOnNext {
+		return nil, FakeDatabaseError{}
+	}
+
+	username := strings.ToLower(q.Username)
+	res := []*User{}
+	for _, u := range db.users {
+		if strings.Contains(strings.ToLower(u.Username), username) {
+			user := *u
+			res = append(res, &user)
+		}
+	}
+
+	sort.Slice(res, func(i, j int) bool {
+		if res[i].Username != res[j].Username {
+			return res[i].Username < res[j].Username
+		}
+		return res[i].UserID.Hex() < res[j].UserID.Hex()
+	})
+
+	if q.Offset >= int64(len(res)) {
+		return []*User{}, nil
+	}
+	res = res[q.Offset:]
+	if q.Limit > 0 && int64(len(res)) > q.Limit {
+		res = res[:q.Limit]
+	}
+	return res, nil
+}
+
+func (db *InMemoryDatabase) DeleteUser(u *User) error {
+	db.usersLock.Lock()
+	_, ok := db.users[u.UserID.Hex()]
+	if ok && !db.ShouldErrorOnNext {
+		delete(db.users, u.UserID.Hex())
+	}
+	db.usersLock.Unlock()
+
+	if db.ShouldErrorOnNext {
+		return FakeDatabaseError{}
+	}
+	if !ok {
+		return DoesNotExist{}
+	}
+
+	db.DeleteSessions(u.UserID)
+
+	db.accessTokensLock.Lock()
+	for key, t := range db.accessTokens {
+		if t.UserID == u.UserID {
+			delete(db.accessTokens, key)
+		}
+	}
+	db.accessTokensLock.Unlock()
+
+	db.resetsLock.Lock()
+	for key, r := range db.resets {
+		if r.UserID == u.UserID {
+			delete(db.resets, key)
+		}
+	}
+	db.resetsLock.Unlock()
+
+	db.membersLock.Lock()
+	for key, m := range db.members {
+		if m.UserID == u.UserID {
+			delete(db.members, key)
+		}
+	}
+	db.membersLock.Unlock()
+
 	db.messagesLock.Lock()
-	db.messages = []*Message{}
+	for i, m := range db.messages {
+		if m.Author.UserID == u.UserID {
+			// Replace rather than modify, since GetMessagesBetween hands out these pointers
+			msg := *m
+			msg.Author = User{Username: DeletedUsername}
+			db.messages[i] = &msg
+		}
+	}
 	db.messagesLock.Unlock()
 
+	return nil
+}
+
+func (db *InMemoryDatabase) InsertSession(s *Session) error {
+	db.sessionsLock.Lock()
+	defer db.sessionsLock.Unlock()
+
+	if db.ShouldErrorOnNext {
+		return FakeDatabaseError{}
+	}
+	if _, ok := db.sessions[s.TokenHash]; ok {
+		return AlreadyExists{}
+	}
+
+	session := *s
+	db.sessions[s.TokenHash] = &session
+	return nil
+}
+
+func (db *InMemoryDatabase) GetSession(s *Session) error {
+	db.sessionsLock.Lock()
+	defer db.sessionsLock.Unlock()
+
+	if db.ShouldErrorOnNext {
+		return FakeDatabaseError{}
+	}
+	session, ok := db.sessions[s.TokenHash]
+	if !ok {
+		return DoesNotExist{}
+	}
+	*s = *session
+	return nil
+}
+
+func (db *InMemoryDatabase) DeleteSession(tokenHash string) error {
+	db.sessionsLock.Lock()
+	defer db.sessionsLock.Unlock()
+
+	if db.ShouldErrorOnNext {
+		return FakeDatabaseError{}
+	}
+	if _, ok := db.sessions[tokenHash]; !ok {
+		return DoesNotExist{}
+	}
+	delete(db.sessions, tokenHash)
+	return nil
+}
+
+func (db *InMemoryDatabase) DeleteSessions(userID primitive.ObjectID) error {
+	db.sessionsLock.Lock()
+	defer db.sessionsLock.Unlock()
+
+	if db.ShouldErrorOnNext {
+		return FakeDatabaseError{}
+	}
+	for key, s := range db.sessions {
+		if s.UserID == userID {
+			delete(db.sessions, key)
+		}
+	}
+	return nil
+}
+
+func (db *InMemoryDatabase) InsertAccessToken(t *AccessToken) error {
+	db.accessTokensLock.Lock()
+	defer db.accessTokensLock.Unlock()
+
+	if db.ShouldErrorOnNext {
+		return FakeDatabaseError{}
+	}
+	for _, token := range db.accessTokens {
+		if token.TokenHash == t.TokenHash {
+			return AlreadyExists{}
+		}
+	}
+
+	t.TokenID = db.newID()
+	token := *t
+	db.accessTokens[t.TokenID.Hex()] = &token
+	return nil
+}
+
+func (db *InMemoryDatabase) GetAccessToken(t *AccessToken) error {
+	db.accessTokensLock.Lock()
+	defer db.accessTokensLock.Unlock()
+
+	if db.ShouldErrorOnNext {
+		return FakeDatabaseError{}
+	}
+	for _, token := range db.accessTokens {
+		if token.TokenHash == t.TokenHash {
+			*t = *token
+			return nil
+		}
+	}
+	return DoesNotExist{}
+}
+
+func (db *InMemoryDatabase) GetAccessTokens(userID primitive.ObjectID) ([]*AccessToken, error) {
+	db.accessTokensLock.Lock()
+	defer db.accessTokensLock.Unlock()
+
+	if db.ShouldErrorOnNext {
+		return nil, FakeDatabaseError{}
+	}
+	tokens := []*AccessToken{}
+	for _, t := range db.accessTokens {
+		if t.UserID == userID {
+			token := *t
+			tokens = append(tokens, &token)
+		}
+	}
+	sort.Slice(tokens, func(i, j int) bool { return tokens[i].Created < tokens[j].Created })
+	return tokens, nil
+}
+
+func (db *InMemoryDatabase) DeleteAccessToken(tokenID primitive.ObjectID) error {
+	db.accessTokensLock.Lock()
+	defer db.accessTokensLock.Unlock()
+
+	if db.ShouldErrorOnNext {
+		return FakeDatabaseError{}
+	}
+	if _, ok := db.accessTokens[tokenID.Hex()]; !ok {
+		return DoesNotExist{}
+	}
+	delete(db.accessTokens, tokenID.Hex())
+	return nil
+}
+
+func (db *InMemoryDatabase) GetBots(ownerID primitive.ObjectID) ([]*User, error) {
 	db.usersLock.Lock()
-	db.users = make(map[string]*User)
-	db.usersLock.Unlock()
+	defer db.usersLock.Unlock()
 
-	db.yodelsLock.Lock()
-	db.yodels = make(map[string]*Yodel)
-	db.yodelsLock.Unlock()
+	if db.ShouldErrorOnNext {
+		return nil, FakeDatabaseError{}
+	}
+	bots := []*User{}
+	for _, u := range db.users {
+		if u.Bot && u.OwnerID == ownerID {
+			bot := *u
+			bots = append(bots, &bot)
+		}
+	}
+	sort.Slice(bots, func(i, j int) bool { return bots[i].Username < bots[j].Username })
+	return bots, nil
+}
+
+func (db *InMemoryDatabase) InsertPasswordReset(r *PasswordReset) error {
+	db.resetsLock.Lock()
+	defer db.resetsLock.Unlock()
+
+	if db.ShouldErrorOnNext {
+		return FakeDatabaseError{}
+	}
+	if _, ok := db.resets[r.TokenHash]; ok {
+		return AlreadyExists{}
+	}
+
+	reset := *r
+	db.resets[r.TokenHash] = &reset
+	return nil
+}
+
+func (db *InMemoryDatabase) TakePasswordReset(r *PasswordReset) error {
+	db.resetsLock.Lock()
+	defer db.resetsLock.Unlock()
+
+	if db.ShouldErrorOnNext {
+		return FakeDatabaseError{}
+	}
+	reset, ok := db.resets[r.TokenHash]
+	if !ok {
+		return DoesNotExist{}
+	}
+	delete(db.resets, r.TokenHash)
+	if reset.Expires <= time.Now().UnixNano() {
+		return DoesNotExist{}
+	}
+	*r = *reset
+	return nil
+}
+
+func (db *InMemoryDatabase) InsertTicket(t *Ticket) error {
+	db.ticketsLock.Lock()
+	defer db.ticketsLock.Unlock()
+
+	if db.ShouldErrorOnNext {
+		return FakeDatabaseError{}
+	}
+
+	ticket := *t
+	db.tickets[t.UserID] = &ticket
+	return nil
+}
+
+func (db *InMemoryDatabase) TakeTicket(t *Ticket) error {
+	db.ticketsLock.Lock()
+	defer db.ticketsLock.Unlock()
+
+	if db.ShouldErrorOnNext {
+		return FakeDatabaseError{}
+	}
+	ticket, ok := db.tickets[t.UserID]
+	if !ok {
+		return DoesNotExist{}
+	}
+	delete(db.tickets, t.UserID)
+	if !time.Now().Before(ticket.Expires) {
+		return DoesNotExist{}
+	}
+	*t = *ticket
+	return nil
+}
+
+func (db *InMemoryDatabase) InsertYodel(y *Yodel) error {
+	db.yodelsLock.Lock()
+	defer db.yodelsLock.Unlock()
+
+	if db.ShouldErrorOnNext {
+		return FakeDatabaseError{}
+	}
+
+	y.YodelID = db.newID()
+	db.yodels[y.YodelID.Hex()] = y
+	return nil
+}
+
+func (db *InMemoryDatabase) GetYodel(y *Yodel) error {
+	db.yodelsLock.Lock()
+	defer db.yodelsLock.Unlock()
+
+	if db.ShouldErrorOnNext {
+		return FakeDatabaseError{}
+	}
+	yodel, ok := db.yodels[y.YodelID.Hex()]
+	if !ok {
+		return DoesNotExist{}
+	}
+	*y = *yodel
+	return nil
+}
+
+func (db *InMemoryDatabase) ListYodels(q *YodelQuery) ([]*Yodel, error) {
+	memberOf, err := db.GetMembersOf(q.Viewer)
+	if err != nil {
+		return nil, err
+	}
+	isMember := make(map[primitive.ObjectID]bool)
+	for _, m := range memberOf {
+		isMember[m.YodelID] = true
+	}
+
+	db.yodelsLock.Lock()
+	defer db.yodelsLock.Unlock()
+
+	name := strings.ToLower(q.Name)
+	res := []*Yodel{}
+	for _, y := range db.yodels {
+		if y.GetVisibility() == VisibilityPrivate && !isMember[y.YodelID] && !q.All {
+			continue
+		}
+		if !strings.Contains(strings.ToLower(y.Name), name) {
+			continue
+		}
+		yodel := *y
+		res = append(res, &yodel)
+	}
+
+	sort.Slice(res, func(i, j int) bool {
+		if res[i].Name != res[j].Name {
+			return res[i].Name < res[j].Name
+		}
+		return res[i].YodelID.Hex() < res[j].YodelID.Hex()
+	})
+
+	if q.Offset >= int64(len(res)) {
+		return []*Yodel{}, nil
+	}
+	res = res[q.Offset:]
+	if q.Limit > 0 && int64(len(res)) > q.Limit {
+		res = res[:q.Limit]
+	}
+	return res, nil
+}
+
+func (db *InMemoryDatabase) UpdateYodel(y *Yodel) error {
+	db.yodelsLock.Lock()
+	defer db.yodelsLock.Unlock()
+
+	if db.ShouldErrorOnNext {
+		return FakeDatabaseError{}
+	}
+	yodel, ok := db.yodels[y.YodelID.Hex()]
+	if !ok {
+		return DoesNotExist{}
+	}
+
+	yodel.Name = y.Name
+	yodel.Description = y.Description
+	yodel.Icon = y.Icon
+	yodel.Topic = y.Topic
+	return nil
+}
+
+func (db *InMemoryDatabase) DeleteYodel(yodelID primitive.ObjectID) error {
+	db.yodelsLock.Lock()
+	_, ok := db.yodels[yodelID.Hex()]
+	if ok && !db.ShouldErrorOnNext {
+		delete(db.yodels, yodelID.Hex())
+	}
+	db.yodelsLock.Unlock()
+
+	if db.ShouldErrorOnNext {
+		return FakeDatabaseError{}
+	}
+	if !ok {
+		return DoesNotExist{}
+	}
+
+	db.deleteMessages(func(m *Message) bool { return m.YodelID == yodelID })
+
+	db.channelsLock.Lock()
+	for key, ch := range db.channels {
+		if ch.YodelID == yodelID {
+			delete(db.channels, key)
+		}
+	}
+	db.channelsLock.Unlock()
+
+	db.membersLock.Lock()
+	for key, m := range db.members {
+		if m.YodelID == yodelID {
+			delete(db.members, key)
+		}
+	}
+	db.membersLock.Unlock()
+
+	db.invitesLock.Lock()
+	for code, i := range db.invites {
+		if i.YodelID == yodelID {
+			delete(db.invites, code)
+		}
+	}
+	db.invitesLock.Unlock()
+
+	db.bansLock.Lock()
+	for key, b := range db.bans {
+		if b.YodelID == yodelID {
+			delete(db.bans, key)
+		}
+	}
+	db.bansLock.Unlock()
+
+	return nil
+}
+
+// Deletes the messages matching del, returning their IDs.
+func (db *InMemoryDatabase) deleteMessages(del func(*Message) bool) []primitive.ObjectID {
+	db.messagesLock.Lock()
+	defer db.messagesLock.Unlock()
+
+	deleted := []primitive.ObjectID{}
+	kept := []*Message{}
+	for _, m := range db.messages {
+		if del(m) {
+			deleted = append(deleted, m.MessageID)
+		} else {
+			kept = append(kept, m)
+		}
+	}
+	// Positions in the search index have changed, so rebuild it
+	db.messages = kept
+	db.searchIndex = make(map[string]map[int]int)
+	for i, m := range db.messages {
+		db.indexMessage(i, m)
+	}
+	return deleted
+}
+
+func (db *InMemoryDatabase) TransferYodel(yodelID primitive.ObjectID, from, to string) error {
+	db.yodelsLock.Lock()
+	defer db.yodelsLock.Unlock()
+
+	if db.ShouldErrorOnNext {
+		return FakeDatabaseError{}
+	}
+	yodel, ok := db.yodels[yodelID.Hex()]
+	if !ok || yodel.Owner != from {
+		return DoesNotExist{}
+	}
+
+	yodel.Owner = to
+	return nil
+}
+
+func (db *InMemoryDatabase) PinMessage(yodelID, messageID primitive.ObjectID) error {
+	db.yodelsLock.Lock()
+	defer db.yodelsLock.Unlock()
+
+	if db.ShouldErrorOnNext {
+		return FakeDatabaseError{}
+	}
+	yodel, ok := db.yodels[yodelID.Hex()]
+	if !ok {
+		return DoesNotExist{}
+	}
+
+	for _, id := range yodel.Pins {
+		if id == messageID {
+			return nil
+		}
+	}
+	// Copy so yodels returned by GetYodel aren't modified
+	pins := make([]primitive.ObjectID, len(yodel.Pins), len(yodel.Pins)+1)
+	copy(pins, yodel.Pins)
+	yodel.Pins = append(pins, messageID)
+	return nil
+}
+
+func (db *InMemoryDatabase) UnpinMessage(yodelID, messageID primitive.ObjectID) error {
+	db.yodelsLock.Lock()
+	defer db.yodelsLock.Unlock()
+
+	if db.ShouldErrorOnNext {
+		return FakeDatabaseError{}
+	}
+	yodel, ok := db.yodels[yodelID.Hex()]
+	if !ok {
+		return DoesNotExist{}
+	}
+
+	pins := []primitive.ObjectID{}
+	for _, id := range yodel.Pins {
+		if id != messageID {
+			pins = append(pins, id)
+		}
+	}
+	yodel.Pins = pins
+	return nil
+}
+
+func (db *InMemoryDatabase) InsertRole(yodelID primitive.ObjectID, role Role) error {
+	db.yodelsLock.Lock()
+	defer db.yodelsLock.Unlock()
+
+	if db.ShouldErrorOnNext {
+		return FakeDatabaseError{}
+	}
+	yodel, ok := db.yodels[yodelID.Hex()]
+	if !ok {
+		return DoesNotExist{}
+	}
+
+	if _, exists := yodel.GetRole(role.Name); exists {
+		return AlreadyExists{}
+	}
+	roles := make([]Role, len(yodel.Roles), len(yodel.Roles)+1)
+	copy(roles, yodel.Roles)
+	yodel.Roles = append(roles, role)
+	return nil
+}
+
+func (db *InMemoryDatabase) DeleteRole(yodelID primitive.ObjectID, name string) error {
+	db.yodelsLock.Lock()
+	yodel, ok := db.yodels[yodelID.Hex()]
+	if !ok || db.ShouldErrorOnNext {
+		db.yodelsLock.Unlock()
+		if !ok {
+			return DoesNotExist{}
+		}
+		return FakeDatabaseError{}
+	}
+	roles := []Role{}
+	for _, r := range yodel.Roles {
+		if r.Name != name {
+			roles = append(roles, r)
+		}
+	}
+	yodel.Roles = roles
+	db.yodelsLock.Unlock()
+
+	db.membersLock.Lock()
+	defer db.membersLock.Unlock()
+	for _, m := range db.members {
+		if m.YodelID == yodelID && m.Role == name {
+			m.Role = RoleMember
+		}
+	}
+	return nil
+}
+
+// Finds a channel named name in yodelID.  Caller must hold channelsLock.
+func (db *InMemoryDatabase) channelNamed(yodelID primitive.ObjectID, name string) *Channel {
+	for _, ch := range db.channels {
+		if ch.YodelID == yodelID && ch.Name == name {
+			return ch
+		}
+	}
+	return nil
+}
+
+func (db *InMemoryDatabase) InsertChannel(ch *Channel) error {
+	db.channelsLock.Lock()
+	defer db.channelsLock.Unlock()
+
+	if db.ShouldErrorOnNext {
+		return FakeDatabaseError{}
+	}
+	if db.channelNamed(ch.YodelID, ch.Name) != nil {
+		return AlreadyExists{}
+	}
+
+	ch.ChannelID = db.newID()
+	channel := *ch
+	db.channels[ch.ChannelID.Hex()] = &channel
+	return nil
+}
+
+func (db *InMemoryDatabase) GetChannel(ch *Channel) error {
+	db.channelsLock.Lock()
+	defer db.channelsLock.Unlock()
+
+	if db.ShouldErrorOnNext {
+		return FakeDatabaseError{}
+	}
+	channel, ok := db.channels[ch.ChannelID.Hex()]
+	if !ok {
+		return DoesNotExist{}
+	}
+	*ch = *channel
+	return nil
+}
+
+func (db *InMemoryDatabase) GetChannels(yodelID primitive.ObjectID) ([]*Channel, error) {
+	db.channelsLock.Lock()
+	defer db.channelsLock.Unlock()
+
+	if db.ShouldErrorOnNext {
+		return nil, FakeDatabaseError{}
+	}
+
+	res := []*Channel{}
+	for _, ch := range db.channels {
+		if ch.YodelID == yodelID {
+			channel := *ch
+			res = append(res, &channel)
+		}
+	}
+	sort.Slice(res, func(i, j int) bool {
+		if res[i].Created != res[j].Created {
+			return res[i].Created < res[j].Created
+		}
+		return res[i].ChannelID.Hex() < res[j].ChannelID.Hex()
+	})
+	return res, nil
+}
+
+func (db *InMemoryDatabase) RenameChannel(channelID primitive.ObjectID, name string) error {
+	db.channelsLock.Lock()
+	defer db.channelsLock.Unlock()
+
+	if db.ShouldErrorOnNext {
+		return FakeDatabaseError{}
+	}
+	channel, ok := db.channels[channelID.Hex()]
+	if !ok {
+		return DoesNotExist{}
+	}
+	if other := db.channelNamed(channel.YodelID, name); other != nil && other != channel {
+		return AlreadyExists{}
+	}
+
+	channel.Name = name
+	return nil
+}
+
+func (db *InMemoryDatabase) DeleteChannel(channelID primitive.ObjectID) error {
+	db.channelsLock.Lock()
+	channel, ok := db.channels[channelID.Hex()]
+	if ok && !db.ShouldErrorOnNext {
+		delete(db.channels, channelID.Hex())
+	}
+	db.channelsLock.Unlock()
+
+	if db.ShouldErrorOnNext {
+		return FakeDatabaseError{}
+	}
+	if !ok {
+		return DoesNotExist{}
+	}
+
+	deleted := db.deleteMessages(func(m *Message) bool { return m.ChannelID == channelID })
+	for _, messageID := range deleted {
+		err := db.UnpinMessage(channel.YodelID, messageID)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (db *InMemoryDatabase) UpdateMemberRole(m *Member) error {
+	db.membersLock.Lock()
+	defer db.membersLock.Unlock()
+
+	if db.ShouldErrorOnNext {
+		return FakeDatabaseError{}
+	}
+
+	member, ok := db.members[memberKey(m.YodelID, m.UserID)]
+	if !ok {
+		return DoesNotExist{}
+	}
+	member.Role = m.Role
+	return nil
+}
+
+func memberKey(yodelID, userID primitive.ObjectID) string {
+	return yodelID.Hex() + userID.Hex()
+}
+
+func (db *InMemoryDatabase) InsertMember(m *Member) error {
+	db.membersLock.Lock()
+	defer db.membersLock.Unlock()
+
+	if db.ShouldErrorOnNext {
+		return FakeDatabaseError{}
+	}
+
+	key := memberKey(m.YodelID, m.UserID)
+	if _, ok := db.members[key]; !ok {
+		member := *m
+		db.members[key] = &member
+	}
+	return nil
+}
+
+func (db *InMemoryDatabase) GetMember(m *Member) error {
+	db.membersLock.Lock()
+	defer db.membersLock.Unlock()
+
+	if db.ShouldErrorOnNext {
+		return FakeDatabaseError{}
+	}
+
+	member, ok := db.members[memberKey(m.YodelID, m.UserID)]
+	if !ok {
+		return DoesNotExist{}
+	}
+	*m = *member
+	return nil
+}
+
+func (db *InMemoryDatabase) GetMembers(yodelID primitive.ObjectID) ([]*Member, error) {
+	db.membersLock.Lock()
+	defer db.membersLock.Unlock()
+
+	if db.ShouldErrorOnNext {
+		return nil, FakeDatabaseError{}
+	}
+
+	var res []*Member
+	for _, m := range db.members {
+		if m.YodelID == yodelID {
+			member := *m
+			res = append(res, &member)
+		}
+	}
+	return res, nil
+}
+
+// Returns every membership of a user.
+func (db *InMemoryDatabase) GetMembersOf(userID primitive.ObjectID) ([]*Member, error) {
+	db.membersLock.Lock()
+	defer db.membersLock.Unlock()
+
+	if db.ShouldErrorOnNext {
+		return nil, FakeDatabaseError{}
+	}
+
+	var res []*Member
+	for _, m := range db.members {
+		if m.UserID == userID {
+			member := *m
+			res = append(res, &member)
+		}
+	}
+	return res, nil
+}
+
+func (db *InMemoryDatabase) DeleteMember(m *Member) error {
+	db.membersLock.Lock()
+	defer db.membersLock.Unlock()
+
+	if db.ShouldErrorOnNext {
+		return FakeDatabaseError{}
+	}
+
+	delete(db.members, memberKey(m.YodelID, m.UserID))
+	return nil
+}
+
+func banKey(b *Ban) string {
+	return memberKey(b.YodelID, b.UserID) + string(b.Kind)
+}
+
+func (db *InMemoryDatabase) InsertBan(b *Ban) error {
+	db.bansLock.Lock()
+	defer db.bansLock.Unlock()
+
+	if db.ShouldErrorOnNext {
+		return FakeDatabaseError{}
+	}
+
+	ban := *b
+	db.bans[banKey(b)] = &ban
+	return nil
+}
+
+func (db *InMemoryDatabase) GetBan(b *Ban) error {
+	db.bansLock.Lock()
+	defer db.bansLock.Unlock()
+
+	if db.ShouldErrorOnNext {
+		return FakeDatabaseError{}
+	}
+
+	ban, ok := db.bans[banKey(b)]
+	if !ok {
+		return DoesNotExist{}
+	}
+	*b = *ban
+	return nil
+}
+
+func (db *InMemoryDatabase) InsertInvite(i *Invite) error {
+	db.invitesLock.Lock()
+	defer db.invitesLock.Unlock()
+
+	if db.ShouldErrorOnNext {
+		return FakeDatabaseError{}
+	}
+
+	invite := *i
+	db.invites[i.Code] = &invite
+	return nil
+}
+
+func (db *InMemoryDatabase) GetInvite(i *Invite) error {
+	db.invitesLock.Lock()
+	defer db.invitesLock.Unlock()
+
+	if db.ShouldErrorOnNext {
+		return FakeDatabaseError{}
+	}
+
+	invite, ok := db.invites[i.Code]
+	if !ok {
+		return DoesNotExist{}
+	}
+	*i = *invite
+	return nil
+}
+
+func (db *InMemoryDatabase) RedeemInvite(code string, userID primitive.ObjectID, now int64) (*Invite, error) {
+	db.invitesLock.Lock()
+	defer db.invitesLock.Unlock()
+
+	if db.ShouldErrorOnNext {
+		return nil, FakeDatabaseError{}
+	}
+
+	invite, ok := db.invites[code]
+	if !ok || !invite.Redeemable(userID, now) {
+		return nil, DoesNotExist{}
+	}
+	invite.Uses++
+
+	res := *invite
+	return &res, nil
+}
+
+func (db *InMemoryDatabase) RevokeInvite(code string) error {
+	db.invitesLock.Lock()
+	defer db.invitesLock.Unlock()
+
+	if db.ShouldErrorOnNext {
+		return FakeDatabaseError{}
+	}
+
+	invite, ok := db.invites[code]
+	if !ok {
+		return DoesNotExist{}
+	}
+	invite.Revoked = true
+	return nil
+}
+
+func (db *InMemoryDatabase) GetStats() (*Stats, error) {
+	if db.ShouldErrorOnNext {
+		return nil, FakeDatabaseError{}
+	}
+	stats := &Stats{}
+
+	db.usersLock.Lock()
+	for _, u := range db.users {
+		if u.Bot {
+			stats.Bots++
+		} else {
+			stats.Users++
+		}
+	}
+	db.usersLock.Unlock()
+
+	db.yodelsLock.Lock()
+	stats.Yodels = int64(len(db.yodels))
+	db.yodelsLock.Unlock()
+
+	db.messagesLock.Lock()
+	stats.Messages = int64(len(db.messages))
+	db.messagesLock.Unlock()
+
+	return stats, nil
+}
+
+func (db *InMemoryDatabase) InsertAuditEntry(e *AuditEntry) error {
+	db.auditLock.Lock()
+	defer db.auditLock.Unlock()
+
+	if db.ShouldErrorOnNext {
+		return FakeDatabaseError{}
+	}
+
+	e.EntryID = db.newID()
+	entry := *e
+	db.audit = append(db.audit, &entry)
+	return nil
+}
+
+func (db *InMemoryDatabase) GetAuditEntries(q *AuditQuery) ([]*AuditEntry, error) {
+	db.auditLock.Lock()
+	defer db.auditLock.Unlock()
+
+	if db.ShouldErrorOnNext {
+		return nil, FakeDatabaseError{}
+	}
+
+	// Entries are appended in order, so walk backwards for newest first
+	res := []*AuditEntry{}
+	skipped := int64(0)
+	for i := len(db.audit) - 1; i >= 0; i-- {
+		if q.Limit > 0 && int64(len(res)) >= q.Limit {
+			break
+		}
+		if !q.matches(db.audit[i]) {
+			continue
+		}
+		if skipped < q.Offset {
+			skipped++
+			continue
+		}
+		entry := *db.audit[i]
+		res = append(res, &entry)
+	}
+	return res, nil
+}
+
+// There's nothing to create, as maps are made when the database is.
+func (db *InMemoryDatabase) CreateSchema() error {
+	if db.ShouldErrorOnNext {
+		return FakeDatabaseError{}
+	}
+	return nil
+}
+
+func (db *InMemoryDatabase) GetSchemaVersion() (int, error) {
+	db.schemaLock.Lock()
+	defer db.schemaLock.Unlock()
+
+	if db.ShouldErrorOnNext {
+		return 0, FakeDatabaseError{}
+	}
+	return db.schemaVersion, nil
+}
+
+func (db *InMemoryDatabase) SetSchemaVersion(version int) error {
+	db.schemaLock.Lock()
+	defer db.schemaLock.Unlock()
+
+	if db.ShouldErrorOnNext {
+		return FakeDatabaseError{}
+	}
+	db.schemaVersion = version
+	return nil
+}
+
+func (db *InMemoryDatabase) GetUsersWithoutCanonicalName() ([]*User, error) {
+	db.usersLock.Lock()
+	defer db.usersLock.Unlock()
+
+	if db.ShouldErrorOnNext {
+		return nil, FakeDatabaseError{}
+	}
+
+	res := []*User{}
+	for _, u := range db.users {
+		if u.CanonicalName == "" {
+			user := *u
+			res = append(res, &user)
+		}
+	}
+	sort.Slice(res, func(i, j int) bool { return res[i].UserID.Hex() < res[j].UserID.Hex() })
+	return res, nil
+}
+
+func (db *InMemoryDatabase) SetCanonicalName(u *User) error {
+	db.usersLock.Lock()
+	defer db.usersLock.Unlock()
+
+	if db.ShouldErrorOnNext {
+		return FakeDatabaseError{}
+	}
+	user, ok := db.users[u.UserID.Hex()]
+	if !ok {
+		return DoesNotExist{}
+	}
+	for id, other := range db.users {
+		if id != u.UserID.Hex() && other.CanonicalName == u.CanonicalName {
+			return AlreadyExists{}
+		}
+	}
+
+	user.CanonicalName = u.CanonicalName
+	return nil
+}
+
+func (db *InMemoryDatabase) ClearCanonicalNames() error {
+	db.usersLock.Lock()
+	defer db.usersLock.Unlock()
+
+	if db.ShouldErrorOnNext {
+		return FakeDatabaseError{}
+	}
+	for _, u := range db.users {
+		u.CanonicalName = ""
+	}
+	return nil
+}
+
+func (db *InMemoryDatabase) ClearDB() error {
+	db.messagesLock.Lock()
+	db.messages = []*Message{}
+	db.searchIndex = make(map[string]map[int]int)
+	db.messagesLock.Unlock()
+
+	db.usersLock.Lock()
+	db.users = make(map[string]*User)
+	db.usersLock.Unlock()
+
+	db.sessionsLock.Lock()
+	db.sessions = make(map[string]*Session)
+	db.sessionsLock.Unlock()
+
+	db.accessTokensLock.Lock()
+	db.accessTokens = make(map[string]*AccessToken)
+	db.accessTokensLock.Unlock()
+
+	db.ticketsLock.Lock()
+	db.tickets = make(map[string]*Ticket)
+	db.ticketsLock.Unlock()
+
+	db.resetsLock.Lock()
+	db.resets = make(map[string]*PasswordReset)
+	db.resetsLock.Unlock()
+
+	db.yodelsLock.Lock()
+	db.yodels = make(map[string]*Yodel)
+	db.yodelsLock.Unlock()
+
+	db.channelsLock.Lock()
+	db.channels = make(map[string]*Channel)
+	db.channelsLock.Unlock()
+
+	db.membersLock.Lock()
+	db.members = make(map[string]*Member)
+	db.membersLock.Unlock()
+
+	db.invitesLock.Lock()
+	db.invites = make(map[string]*Invite)
+	db.invitesLock.Unlock()
+
+	db.bansLock.Lock()
+	db.bans = make(map[string]*Ban)
+	db.bansLock.Unlock()
+
+	db.auditLock.Lock()
+	db.audit = nil
+	db.auditLock.Unlock()
+
+	db.schemaLock.Lock()
+	db.schemaVersion = 0
+	db.schemaLock.Unlock()
 
 	return nil
 }
@@ -0,0 +1,133 @@
+package database
+
+import (
+	"crypto/rand"
+	"crypto/sha512"
+	"crypto/subtle"
+	"encoding/base64"
+	"fmt"
+	"strings"
+
+	"github.com/xdg-go/pbkdf2"
+	"golang.org/x/crypto/argon2"
+)
+
+// Hashes are stored as PHC strings, eg. $argon2id$v=19$m=65536,t=1,p=4$<salt>$<hash>
+// so the algorithm and its parameters can change without breaking existing passwords.
+const (
+	algArgon2id = "argon2id"
+	algPBKDF2   = "pbkdf2-sha512-256"
+)
+
+type Argon2Params struct {
+	Memory  uint32
+	Time    uint32
+	Threads uint8
+	KeyLen  uint32
+	SaltLen uint32
+}
+
+// Parameters new passwords are hashed with.  Passwords hashed differently are rehashed on login.
+var DefaultArgon2Params = Argon2Params{Memory: 64 * 1024, Time: 1, Threads: 4, KeyLen: 32, SaltLen: 16}
+
+// Parameters of the raw PBKDF2 keys stored before PHC strings.
+const legacyPBKDF2Iterations = 100000
+
+var phcEncoding = base64.RawStdEncoding
+
+// A parsed PHC string.
+type passwordHash struct {
+	alg    string
+	argon2 Argon2Params
+	iter   int
+	salt   []byte
+	key    []byte
+}
+
+// Replaces u.Password with a PHC string of its Argon2id hash, using DefaultArgon2Params.
+func (u *User) HashPassword() {
+	p := DefaultArgon2Params
+	salt := make([]byte, p.SaltLen)
+	rand.Read(salt)
+	key := argon2.IDKey(u.Password, salt, p.Time, p.Memory, p.Threads, p.KeyLen)
+
+	u.Password = []byte(fmt.Sprintf("$%v$v=%d$m=%d,t=%d,p=%d$%v$%v", algArgon2id, argon2.Version,
+		p.Memory, p.Time, p.Threads, phcEncoding.EncodeToString(salt), phcEncoding.EncodeToString(key)))
+	u.Salt = nil
+}
+
+// Checks password against u's stored hash.
+func (u *User) CheckPassword(password string) bool {
+	h, err := u.parseHash()
+	if err != nil {
+		return false
+	}
+
+	var key []byte
+	switch h.alg {
+	case algArgon2id:
+		a := h.argon2
+		key = argon2.IDKey([]byte(password), h.salt, a.Time, a.Memory, a.Threads, uint32(len(h.key)))
+	case algPBKDF2:
+		key = pbkdf2.Key([]byte(password), h.salt, h.iter, len(h.key), sha512.New512_256)
+	}
+	return subtle.ConstantTimeCompare(key, h.key) == 1
+}
+
+// Whether u's hash should be replaced with one using the current defaults.
+func (u *User) NeedsRehash() bool {
+	h, err := u.parseHash()
+	if err != nil {
+		return true
+	}
+	p := DefaultArgon2Params
+	return h.alg != algArgon2id || h.argon2 != p || uint32(len(h.salt)) != p.SaltLen
+}
+
+func (u *User) parseHash() (*passwordHash, error) {
+	// Users without a password, like bots, can't log in with one
+	if len(u.Password) == 0 {
+		return nil, fmt.Errorf("user has no password")
+	}
+	// PHC strings keep their salt inline, so only legacy raw keys have one stored beside them.
+	// Checking for "$" alone misreads the raw keys that happen to start with it
+	if len(u.Salt) != 0 || !strings.HasPrefix(string(u.Password), "$") {
+		return &passwordHash{alg: algPBKDF2, iter: legacyPBKDF2Iterations, salt: u.Salt, key: u.Password}, nil
+	}
+
+	parts := strings.Split(string(u.Password), "$")
+	h := &passwordHash{}
+	var err error
+	switch {
+	case len(parts) == 6 && parts[1] == algArgon2id:
+		var version int
+		_, err = fmt.Sscanf(parts[2], "v=%d", &version)
+		if err == nil && version != argon2.Version {
+			err = fmt.Errorf("unsupported argon2 version %v", version)
+		}
+		if err == nil {
+			a := &h.argon2
+			_, err = fmt.Sscanf(parts[3], "m=%d,t=%d,p=%d", &a.Memory, &a.Time, &a.Threads)
+		}
+	case len(parts) == 5 && parts[1] == algPBKDF2:
+		_, err = fmt.Sscanf(parts[2], "i=%d", &h.iter)
+	default:
+		return nil, fmt.Errorf("unknown password hash format")
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	h.alg = parts[1]
+	h.salt, err = phcEncoding.DecodeString(parts[len(parts)-2])
+	if err != nil {
+		return nil, err
+	}
+	h.key, err = phcEncoding.DecodeString(parts[len(parts)-1])
+	if err != nil {
+		return nil, err
+	}
+	h.argon2.KeyLen = uint32(len(h.key))
+	h.argon2.SaltLen = uint32(len(h.salt))
+	return h, nil
+}
@@ -0,0 +1,127 @@
+package database
+
+import "strings"
+
+// Bitmask of actions a member may take in a yodel.
+type Permission uint64
+
+const (
+	PermissionSend Permission = 1 << iota
+	PermissionDeleteOthers
+	PermissionPin
+	PermissionInvite
+	PermissionKick
+	PermissionManageRoles
+	PermissionBan
+	PermissionMute
+	PermissionManageYodel
+
+	PermissionAll = ^Permission(0)
+)
+
+var permissionNames = []struct {
+	p    Permission
+	name string
+}{
+	{PermissionSend, "send"},
+	{PermissionDeleteOthers, "delete-others"},
+	{PermissionPin, "pin"},
+	{PermissionInvite, "invite"},
+	{PermissionKick, "kick"},
+	{PermissionManageRoles, "manage-roles"},
+	{PermissionBan, "ban"},
+	{PermissionMute, "mute"},
+	{PermissionManageYodel, "manage-yodel"},
+}
+
+// Comma separated names of the permissions in p.
+func (p Permission) String() string {
+	if p == PermissionAll {
+		return "all"
+	}
+
+	names := []string{}
+	for _, n := range permissionNames {
+		if p.Has(n.p) {
+			names = append(names, n.name)
+		}
+	}
+	return strings.Join(names, ",")
+}
+
+// Checks that p grants every permission in other.
+func (p Permission) Has(other Permission) bool {
+	return p&other == other
+}
+
+// Named set of permissions.  Members have exactly one role in each yodel.
+type Role struct {
+	Name        string     `bson:"name" json:"name"`
+	Permissions Permission `bson:"permissions" json:"permissions"`
+}
+
+const (
+	RoleOwner     = "owner"
+	RoleAdmin     = "admin"
+	RoleModerator = "moderator"
+	RoleMember    = "member"
+)
+
+// Roles every yodel has.  Custom roles are stored on the yodel.
+var BuiltinRoles = []Role{
+	{Name: RoleOwner, Permissions: PermissionAll},
+	{Name: RoleAdmin, Permissions: PermissionAll},
+	{
+		Name: RoleModerator,
+		Permissions: PermissionSend | PermissionDeleteOthers | PermissionPin |
+			PermissionInvite | PermissionKick | PermissionBan | PermissionMute,
+	},
+	{Name: RoleMember, Permissions: PermissionSend},
+}
+
+func IsBuiltinRole(name string) bool {
+	for _, r := range BuiltinRoles {
+		if r.Name == name {
+			return true
+		}
+	}
+	return false
+}
+
+// Looks up a builtin or custom role by name.
+func (y *Yodel) GetRole(name string) (Role, bool) {
+	for _, r := range BuiltinRoles {
+		if r.Name == name {
+			return r, true
+		}
+	}
+	for _, r := range y.Roles {
+		if r.Name == name {
+			return r, true
+		}
+	}
+	return Role{}, false
+}
+
+// Builtin roles followed by the yodel's custom roles.
+func (y *Yodel) AllRoles() []Role {
+	roles := append([]Role{}, BuiltinRoles...)
+	return append(roles, y.Roles...)
+}
+
+// Role name of a member.  The yodel's owner is always RoleOwner, and members
+// without a role, or with a deleted custom role, are RoleMember.
+func (y *Yodel) MemberRole(m *Member) string {
+	if y.Owner == m.UserID.Hex() {
+		return RoleOwner
+	}
+	if _, ok := y.GetRole(m.Role); !ok || m.Role == RoleOwner {
+		return RoleMember
+	}
+	return m.Role
+}
+
+func (y *Yodel) Permissions(m *Member) Permission {
+	role, _ := y.GetRole(y.MemberRole(m))
+	return role.Permissions
+}
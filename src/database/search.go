@@ -0,0 +1,164 @@
+package database
+
+import (
+	"sort"
+	"strings"
+	"unicode"
+
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+const (
+	snippetRadius  = 30
+	maxSnippets    = 3
+	highlightOpen  = "**"
+	highlightClose = "**"
+)
+
+// Filters and query for SearchMessages.  Zero values disable a filter.
+type SearchQuery struct {
+	Query     string
+	AuthorID  primitive.ObjectID
+	YodelID   primitive.ObjectID
+	ChannelID primitive.ObjectID
+	// Messages in yodels are only found if they're in one of these.  Nil finds messages in every yodel.
+	YodelIDs []primitive.ObjectID
+	From     int64
+	To       int64
+	Limit    int64
+}
+
+// A single ranked hit from SearchMessages.
+type SearchResult struct {
+	Message    *Message `json:"message"`
+	Score      float64  `json:"score"`
+	Highlights []string `json:"highlights"`
+}
+
+// Checks whether a message passes the non-text filters of a query.
+func (q *SearchQuery) matches(m *Message) bool {
+	if q.AuthorID != primitive.NilObjectID && m.Author.UserID != q.AuthorID {
+		return false
+	}
+	if q.YodelID != primitive.NilObjectID && m.YodelID != q.YodelID {
+		return false
+	}
+	if q.ChannelID != primitive.NilObjectID && m.ChannelID != q.ChannelID {
+		return false
+	}
+	if q.YodelIDs != nil && m.YodelID != primitive.NilObjectID && !containsID(q.YodelIDs, m.YodelID) {
+		return false
+	}
+	if q.From != 0 && m.Timestamp < q.From {
+		return false
+	}
+	if q.To != 0 && m.Timestamp > q.To {
+		return false
+	}
+	return true
+}
+
+func containsID(ids []primitive.ObjectID, id primitive.ObjectID) bool {
+	for _, i := range ids {
+		if i == id {
+			return true
+		}
+	}
+	return false
+}
+
+func isWordSeparator(r rune) bool {
+	return !unicode.IsLetter(r) && !unicode.IsNumber(r)
+}
+
+// Splits text into lowercase search terms.
+func tokenize(s string) []string {
+	return strings.FieldsFunc(strings.ToLower(s), isWordSeparator)
+}
+
+// Returns the unique terms in a query.
+func queryTerms(s string) map[string]bool {
+	terms := make(map[string]bool)
+	for _, t := range tokenize(s) {
+		terms[t] = true
+	}
+	return terms
+}
+
+type wordSpan struct {
+	start, end int
+}
+
+// Returns up to maxSnippets excerpts of content around words in terms,
+// with every matching word wrapped in highlight markers.
+func highlight(content string, terms map[string]bool) []string {
+	runes := []rune(content)
+
+	var matches []wordSpan
+	start := -1
+	for i := 0; i <= len(runes); i++ {
+		if i < len(runes) && !isWordSeparator(runes[i]) {
+			if start == -1 {
+				start = i
+			}
+			continue
+		}
+		if start != -1 {
+			if terms[strings.ToLower(string(runes[start:i]))] {
+				matches = append(matches, wordSpan{start, i})
+			}
+			start = -1
+		}
+	}
+
+	snippets := []string{}
+	covered := 0
+	for i := 0; i < len(matches) && len(snippets) < maxSnippets; {
+		if matches[i].start < covered {
+			i++
+			continue
+		}
+		from := matches[i].start - snippetRadius
+		if from < covered {
+			from = covered
+		}
+		if from < 0 {
+			from = 0
+		}
+		to := matches[i].end + snippetRadius
+		if to > len(runes) {
+			to = len(runes)
+		}
+
+		var b strings.Builder
+		if from > 0 {
+			b.WriteString("…")
+		}
+		last := from
+		for ; i < len(matches) && matches[i].end <= to; i++ {
+			b.WriteString(string(runes[last:matches[i].start]))
+			b.WriteString(highlightOpen)
+			b.WriteString(string(runes[matches[i].start:matches[i].end]))
+			b.WriteString(highlightClose)
+			last = matches[i].end
+		}
+		b.WriteString(string(runes[last:to]))
+		if to < len(runes) {
+			b.WriteString("…")
+		}
+
+		snippets = append(snippets, b.String())
+		covered = to
+	}
+	return snippets
+}
+
+// Sorts results by score, most relevant first, then by newest message.
+func sortResults(results []*SearchResult) {
+	sort.SliceStable(results, func(i, j int) bool {
+		if results[i].Score != results[j].Score {
+			return results[i].Score > results[j].Score
+		}
+		return results[i].Message.Timestamp > results[j].Message.Timestamp
+	})
+}
@@ -221,3 +221,455 @@ func TestUsers(t *testing.T) {
 		test_utils.AssertEqual(t, err, database.DoesNotExist{})
 	})
 }
+
+func TestSearchMessages(t *testing.T) {
+	gopher := database.User{UserID: primitive.NewObjectID(), Username: "gopher"}
+	billy := database.User{UserID: primitive.NewObjectID(), Username: "billy"}
+
+	newDB := func() *database.InMemoryDatabase {
+		db := database.NewInMemoryDatabase()
+		db.InsertMessage(database.NewMessage(gopher, "Fenix is awesome"))
+		db.InsertMessage(database.NewMessage(billy, "fenix fenix fenix!"))
+		db.InsertMessage(database.NewMessage(gopher, "Nothing to see here"))
+		return db
+	}
+
+	t.Run("only matching messages are returned", func(t *testing.T) {
+		db := newDB()
+
+		results, err := db.SearchMessages(&database.SearchQuery{Query: "fenix"})
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		got := len(results)
+		expected := 2
+		test_utils.AssertEqual(t, got, expected)
+	})
+
+	t.Run("results are ranked by term frequency", func(t *testing.T) {
+		db := newDB()
+
+		results, _ := db.SearchMessages(&database.SearchQuery{Query: "fenix"})
+
+		got := results[0].Message.Content
+		expected := "fenix fenix fenix!"
+		test_utils.AssertEqual(t, got, expected)
+	})
+
+	t.Run("author filter", func(t *testing.T) {
+		db := newDB()
+
+		results, _ := db.SearchMessages(&database.SearchQuery{Query: "fenix", AuthorID: gopher.UserID})
+
+		got := len(results)
+		expected := 1
+		test_utils.AssertEqual(t, got, expected)
+	})
+
+	t.Run("yodel filter", func(t *testing.T) {
+		db := newDB()
+		yodelID := primitive.NewObjectID()
+		msg := database.NewMessage(gopher, "fenix in a yodel")
+		msg.YodelID = yodelID
+		db.InsertMessage(msg)
+
+		results, _ := db.SearchMessages(&database.SearchQuery{Query: "fenix", YodelID: yodelID})
+
+		got := []string{}
+		for _, r := range results {
+			got = append(got, r.Message.Content)
+		}
+		expected := []string{"fenix in a yodel"}
+		test_utils.AssertEqual(t, got, expected)
+	})
+
+	t.Run("yodel ids filter keeps messages outside yodels", func(t *testing.T) {
+		db := newDB()
+		joined, other := primitive.NewObjectID(), primitive.NewObjectID()
+		for _, yodelID := range []primitive.ObjectID{joined, other} {
+			msg := database.NewMessage(gopher, "fenix in a yodel")
+			msg.YodelID = yodelID
+			db.InsertMessage(msg)
+		}
+
+		results, _ := db.SearchMessages(&database.SearchQuery{Query: "fenix", YodelIDs: []primitive.ObjectID{joined}})
+		got := 0
+		for _, r := range results {
+			test_utils.AssertNotEqual(t, r.Message.YodelID, other)
+			got++
+		}
+		test_utils.AssertEqual(t, got, 3)
+
+		results, _ = db.SearchMessages(&database.SearchQuery{Query: "fenix", YodelIDs: []primitive.ObjectID{}})
+		test_utils.AssertEqual(t, len(results), 2)
+	})
+
+	t.Run("time range filter", func(t *testing.T) {
+		db := database.NewInMemoryDatabase()
+		old := database.NewMessage(gopher, "old fenix")
+		old.Timestamp = 100
+		db.InsertMessage(old)
+		db.InsertMessage(database.NewMessage(gopher, "new fenix"))
+
+		results, _ := db.SearchMessages(&database.SearchQuery{Query: "fenix", From: 50, To: 150})
+
+		got := len(results)
+		expected := 1
+		test_utils.AssertEqual(t, got, expected)
+	})
+
+	t.Run("highlights wrap matching words", func(t *testing.T) {
+		db := newDB()
+
+		results, _ := db.SearchMessages(&database.SearchQuery{Query: "AWESOME", AuthorID: gopher.UserID})
+
+		got := results[0].Highlights
+		expected := []string{"Fenix is **awesome**"}
+		test_utils.AssertEqual(t, got, expected)
+	})
+
+	t.Run("limit", func(t *testing.T) {
+		db := newDB()
+
+		results, _ := db.SearchMessages(&database.SearchQuery{Query: "fenix", Limit: 1})
+
+		got := len(results)
+		expected := 1
+		test_utils.AssertEqual(t, got, expected)
+	})
+}
+
+func TestDeleteMessage(t *testing.T) {
+	t.Run("deleted messages are unpinned and can't be found", func(t *testing.T) {
+		db := database.NewInMemoryDatabase()
+		yodel := &database.Yodel{Name: "Fenixland"}
+		db.InsertYodel(yodel)
+		msg := &database.Message{Content: "fenix", YodelID: yodel.YodelID}
+		db.InsertMessage(msg)
+		db.InsertMessage(&database.Message{Content: "fenix again", YodelID: yodel.YodelID})
+		db.PinMessage(yodel.YodelID, msg.MessageID)
+
+		err := db.DeleteMessage(msg.MessageID)
+		test_utils.AssertEqual(t, err, nil)
+
+		err = db.GetMessage(&database.Message{MessageID: msg.MessageID})
+		test_utils.AssertEqual(t, err, database.DoesNotExist{})
+		results, _ := db.SearchMessages(&database.SearchQuery{Query: "fenix"})
+		test_utils.AssertEqual(t, len(results), 1)
+		db.GetYodel(yodel)
+		test_utils.AssertEqual(t, len(yodel.Pins), 0)
+	})
+
+	t.Run("deleting a missing message returns DoesNotExist", func(t *testing.T) {
+		db := database.NewInMemoryDatabase()
+
+		err := db.DeleteMessage(primitive.NewObjectID())
+		test_utils.AssertEqual(t, err, database.DoesNotExist{})
+	})
+}
+
+func TestInvites(t *testing.T) {
+	user := primitive.NewObjectID()
+
+	t.Run("redeeming uses up invite", func(t *testing.T) {
+		db := database.NewInMemoryDatabase()
+		db.InsertInvite(&database.Invite{Code: "abc", MaxUses: 1})
+
+		_, err := db.RedeemInvite("abc", user, time.Now().UnixNano())
+		test_utils.AssertEqual(t, err, nil)
+
+		_, err = db.RedeemInvite("abc", user, time.Now().UnixNano())
+		test_utils.AssertEqual(t, err, database.DoesNotExist{})
+	})
+
+	t.Run("expired invite cant be redeemed", func(t *testing.T) {
+		db := database.NewInMemoryDatabase()
+		db.InsertInvite(&database.Invite{Code: "abc", Expires: 100})
+
+		_, err := db.RedeemInvite("abc", user, 100)
+		test_utils.AssertEqual(t, err, database.DoesNotExist{})
+	})
+
+	t.Run("targeted invite only works for target", func(t *testing.T) {
+		db := database.NewInMemoryDatabase()
+		db.InsertInvite(&database.Invite{Code: "abc", Target: user})
+
+		_, err := db.RedeemInvite("abc", primitive.NewObjectID(), time.Now().UnixNano())
+		test_utils.AssertEqual(t, err, database.DoesNotExist{})
+
+		invite, err := db.RedeemInvite("abc", user, time.Now().UnixNano())
+		test_utils.AssertEqual(t, err, nil)
+		test_utils.AssertEqual(t, invite.Uses, int64(1))
+	})
+
+	t.Run("revoked invite cant be redeemed", func(t *testing.T) {
+		db := database.NewInMemoryDatabase()
+		db.InsertInvite(&database.Invite{Code: "abc"})
+		db.RevokeInvite("abc")
+
+		_, err := db.RedeemInvite("abc", user, time.Now().UnixNano())
+		test_utils.AssertEqual(t, err, database.DoesNotExist{})
+	})
+}
+
+func TestYodelManagement(t *testing.T) {
+	owner, member := primitive.NewObjectID(), primitive.NewObjectID()
+
+	t.Run("deleting a yodel removes its members and messages", func(t *testing.T) {
+		db := database.NewInMemoryDatabase()
+		yodel := &database.Yodel{Name: "Fenixland", Owner: owner.Hex()}
+		db.InsertYodel(yodel)
+		db.InsertMember(&database.Member{YodelID: yodel.YodelID, UserID: member})
+		db.InsertMessage(&database.Message{Content: "hi", YodelID: yodel.YodelID})
+
+		err := db.DeleteYodel(yodel.YodelID)
+		test_utils.AssertEqual(t, err, nil)
+
+		err = db.GetMember(&database.Member{YodelID: yodel.YodelID, UserID: member})
+		test_utils.AssertEqual(t, err, database.DoesNotExist{})
+
+		results, _ := db.SearchMessages(&database.SearchQuery{Query: "hi"})
+		test_utils.AssertEqual(t, len(results), 0)
+	})
+
+	t.Run("only the current owner can transfer", func(t *testing.T) {
+		db := database.NewInMemoryDatabase()
+		yodel := &database.Yodel{Name: "Fenixland", Owner: owner.Hex()}
+		db.InsertYodel(yodel)
+
+		err := db.TransferYodel(yodel.YodelID, member.Hex(), owner.Hex())
+		test_utils.AssertEqual(t, err, database.DoesNotExist{})
+
+		err = db.TransferYodel(yodel.YodelID, owner.Hex(), member.Hex())
+		test_utils.AssertEqual(t, err, nil)
+
+		db.GetYodel(yodel)
+		test_utils.AssertEqual(t, yodel.Owner, member.Hex())
+	})
+}
+
+func TestChannels(t *testing.T) {
+	yodelID := primitive.NewObjectID()
+
+	t.Run("channel history only has the channel's messages", func(t *testing.T) {
+		db := database.NewInMemoryDatabase()
+		channel := &database.Channel{YodelID: yodelID, Name: "general"}
+		db.InsertChannel(channel)
+		db.InsertMessage(&database.Message{Content: "in channel", Timestamp: 1, YodelID: yodelID, ChannelID: channel.ChannelID})
+		db.InsertMessage(&database.Message{Content: "global", Timestamp: 2})
+
+		history, _ := db.GetChannelMessagesBetween(channel.ChannelID, 0, time.Now().UnixNano(), 50)
+		test_utils.AssertEqual(t, len(history), 1)
+		test_utils.AssertEqual(t, history[0].Content, "in channel")
+
+		history, _ = db.GetMessagesBetween(0, time.Now().UnixNano(), 50)
+		test_utils.AssertEqual(t, len(history), 1)
+		test_utils.AssertEqual(t, history[0].Content, "global")
+	})
+
+	t.Run("names are unique within a yodel", func(t *testing.T) {
+		db := database.NewInMemoryDatabase()
+		db.InsertChannel(&database.Channel{YodelID: yodelID, Name: "general"})
+		random := &database.Channel{YodelID: yodelID, Name: "random"}
+		db.InsertChannel(random)
+
+		err := db.InsertChannel(&database.Channel{YodelID: yodelID, Name: "general"})
+		test_utils.AssertEqual(t, err, database.AlreadyExists{})
+
+		err = db.RenameChannel(random.ChannelID, "general")
+		test_utils.AssertEqual(t, err, database.AlreadyExists{})
+
+		err = db.InsertChannel(&database.Channel{YodelID: primitive.NewObjectID(), Name: "general"})
+		test_utils.AssertEqual(t, err, nil)
+	})
+
+	t.Run("deleting a channel removes and unpins its messages", func(t *testing.T) {
+		db := database.NewInMemoryDatabase()
+		yodel := &database.Yodel{Name: "Fenixland"}
+		db.InsertYodel(yodel)
+		channel := &database.Channel{YodelID: yodel.YodelID, Name: "general"}
+		db.InsertChannel(channel)
+		msg := &database.Message{Content: "pinned", YodelID: yodel.YodelID, ChannelID: channel.ChannelID}
+		db.InsertMessage(msg)
+		db.PinMessage(yodel.YodelID, msg.MessageID)
+
+		err := db.DeleteChannel(channel.ChannelID)
+		test_utils.AssertEqual(t, err, nil)
+
+		err = db.GetMessage(&database.Message{MessageID: msg.MessageID})
+		test_utils.AssertEqual(t, err, database.DoesNotExist{})
+
+		db.GetYodel(yodel)
+		test_utils.AssertEqual(t, len(yodel.Pins), 0)
+	})
+}
+
+func TestDeleteUser(t *testing.T) {
+	t.Run("messages are kept without their author", func(t *testing.T) {
+		db := database.NewInMemoryDatabase()
+		user := &database.User{Username: "gopher123"}
+		db.InsertUser(user)
+		db.InsertMember(&database.Member{YodelID: primitive.NewObjectID(), UserID: user.UserID})
+		msg := &database.Message{Content: "hi", Author: *user, Timestamp: 1}
+		db.InsertMessage(msg)
+
+		err := db.DeleteUser(user)
+		test_utils.AssertEqual(t, err, nil)
+
+		err = db.GetUser(&database.User{UserID: user.UserID})
+		test_utils.AssertEqual(t, err, database.DoesNotExist{})
+
+		got := &database.Message{MessageID: msg.MessageID}
+		db.GetMessage(got)
+		test_utils.AssertEqual(t, got.Author, database.User{Username: database.DeletedUsername})
+
+		members, _ := db.GetMembersOf(user.UserID)
+		test_utils.AssertEqual(t, len(members), 0)
+	})
+}
+
+func TestAccessTokens(t *testing.T) {
+	t.Run("tokens are found by their hash", func(t *testing.T) {
+		db := database.NewInMemoryDatabase()
+		token := &database.AccessToken{TokenHash: "hash", UserID: primitive.NewObjectID(), Scopes: []string{"read"}}
+		err := db.InsertAccessToken(token)
+		test_utils.AssertEqual(t, err, nil)
+		test_utils.AssertNotEqual(t, token.TokenID, primitive.NilObjectID)
+
+		got := &database.AccessToken{TokenHash: "hash"}
+		err = db.GetAccessToken(got)
+		test_utils.AssertEqual(t, err, nil)
+		test_utils.AssertEqual(t, got, token)
+
+		err = db.DeleteAccessToken(token.TokenID)
+		test_utils.AssertEqual(t, err, nil)
+		err = db.GetAccessToken(&database.AccessToken{TokenHash: "hash"})
+		test_utils.AssertEqual(t, err, database.DoesNotExist{})
+	})
+
+	t.Run("deleting a user deletes their tokens", func(t *testing.T) {
+		db := database.NewInMemoryDatabase()
+		owner := &database.User{Username: "gopher123"}
+		db.InsertUser(owner)
+		bot := &database.User{Username: "gopherbot", Bot: true, OwnerID: owner.UserID}
+		db.InsertUser(bot)
+		db.InsertAccessToken(&database.AccessToken{TokenHash: "hash", UserID: bot.UserID})
+
+		bots, err := db.GetBots(owner.UserID)
+		test_utils.AssertEqual(t, err, nil)
+		test_utils.AssertEqual(t, len(bots), 1)
+		test_utils.AssertEqual(t, bots[0].UserID, bot.UserID)
+
+		db.DeleteUser(bot)
+		tokens, _ := db.GetAccessTokens(bot.UserID)
+		test_utils.AssertEqual(t, len(tokens), 0)
+	})
+}
+
+func TestAdminQueries(t *testing.T) {
+	t.Run("users are listed by username and their status can change", func(t *testing.T) {
+		db := database.NewInMemoryDatabase()
+		for _, name := range []string{"gopher2", "gopher1", "billy"} {
+			db.InsertUser(&database.User{Username: name})
+		}
+
+		users, err := db.ListUsers(&database.UserQuery{Username: "GOPHER"})
+		test_utils.AssertEqual(t, err, nil)
+		test_utils.AssertEqual(t, len(users), 2)
+		test_utils.AssertEqual(t, users[0].Username, "gopher1")
+
+		users[0].Disabled = true
+		err = db.UpdateUserStatus(users[0])
+		test_utils.AssertEqual(t, err, nil)
+		got := &database.User{Username: "gopher1"}
+		db.GetUser(got)
+		test_utils.AssertEqual(t, got.Disabled, true)
+
+		users, _ = db.ListUsers(&database.UserQuery{Offset: 1, Limit: 1})
+		test_utils.AssertEqual(t, users[0].Username, "gopher1")
+	})
+
+	t.Run("stats count users, bots, yodels and messages", func(t *testing.T) {
+		db := database.NewInMemoryDatabase()
+		owner := &database.User{Username: "gopher123"}
+		db.InsertUser(owner)
+		db.InsertUser(&database.User{Username: "gopherbot", Bot: true, OwnerID: owner.UserID})
+		db.InsertYodel(&database.Yodel{Name: "gophers"})
+		db.InsertMessage(&database.Message{Content: "hello", Author: *owner})
+
+		stats, err := db.GetStats()
+		test_utils.AssertEqual(t, err, nil)
+		test_utils.AssertEqual(t, *stats, database.Stats{Users: 1, Bots: 1, Yodels: 1, Messages: 1})
+	})
+}
+
+func TestAuditLog(t *testing.T) {
+	t.Run("entries are queried newest first by time, actor and action", func(t *testing.T) {
+		db := database.NewInMemoryDatabase()
+		for _, e := range []database.AuditEntry{
+			{Timestamp: 10, Action: "login.success", ActorID: "a"},
+			{Timestamp: 20, Action: "login.failure"},
+			{Timestamp: 30, Action: "login.success", ActorID: "b"},
+			{Timestamp: 40, Action: "password.change", ActorID: "a"},
+		} {
+			e := e
+			err := db.InsertAuditEntry(&e)
+			test_utils.AssertEqual(t, err, nil)
+			test_utils.AssertNotEqual(t, e.EntryID, primitive.NilObjectID)
+		}
+
+		timestamps := func(q *database.AuditQuery) []int64 {
+			entries, err := db.GetAuditEntries(q)
+			test_utils.AssertEqual(t, err, nil)
+			res := []int64{}
+			for _, e := range entries {
+				res = append(res, e.Timestamp)
+			}
+			return res
+		}
+		test_utils.AssertEqual(t, timestamps(&database.AuditQuery{}), []int64{40, 30, 20, 10})
+		test_utils.AssertEqual(t, timestamps(&database.AuditQuery{From: 20, To: 30}), []int64{30, 20})
+		test_utils.AssertEqual(t, timestamps(&database.AuditQuery{ActorID: "a"}), []int64{40, 10})
+		test_utils.AssertEqual(t, timestamps(&database.AuditQuery{Action: "login.success", Limit: 1}), []int64{30})
+		test_utils.AssertEqual(t, timestamps(&database.AuditQuery{Offset: 1, Limit: 2}), []int64{30, 20})
+	})
+}
+
+func TestSchema(t *testing.T) {
+	t.Run("the schema version is kept until the database is cleared", func(t *testing.T) {
+		db := database.NewInMemoryDatabase()
+		version, err := db.GetSchemaVersion()
+		test_utils.AssertEqual(t, err, nil)
+		test_utils.AssertEqual(t, version, 0)
+
+		db.SetSchemaVersion(3)
+		version, _ = db.GetSchemaVersion()
+		test_utils.AssertEqual(t, version, 3)
+
+		db.ClearDB()
+		version, _ = db.GetSchemaVersion()
+		test_utils.AssertEqual(t, version, 0)
+	})
+
+	t.Run("canonical names can't be set to another user's", func(t *testing.T) {
+		db := database.NewInMemoryDatabase()
+		taken := &database.User{Username: "Gopher", CanonicalName: "gopher"}
+		db.InsertUser(taken)
+		u := &database.User{Username: "gopher"}
+		db.InsertUser(u)
+
+		users, err := db.GetUsersWithoutCanonicalName()
+		test_utils.AssertEqual(t, err, nil)
+		test_utils.AssertEqual(t, len(users), 1)
+
+		u.CanonicalName = "gopher"
+		_, ok := db.SetCanonicalName(u).(database.AlreadyExists)
+		test_utils.AssertEqual(t, ok, true)
+		u.CanonicalName = "gopher_2"
+		test_utils.AssertEqual(t, db.SetCanonicalName(u), nil)
+
+		users, _ = db.GetUsersWithoutCanonicalName()
+		test_utils.AssertEqual(t, len(users), 0)
+	})
+}
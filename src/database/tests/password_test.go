@@ -0,0 +1,75 @@
+package database_test
+
+import (
+	"crypto/rand"
+	"crypto/sha512"
+	"fenix/src/database"
+	"fenix/src/test_utils"
+	"strings"
+	"testing"
+
+	"github.com/xdg-go/pbkdf2"
+)
+
+func TestPasswords(t *testing.T) {
+	t.Run("passwords are hashed as argon2id PHC strings", func(t *testing.T) {
+		u := &database.User{Password: []byte("pass")}
+		u.HashPassword()
+
+		test_utils.AssertEqual(t, strings.HasPrefix(string(u.Password), "$argon2id$v=19$m=65536,t=1,p=4$"), true)
+		test_utils.AssertEqual(t, u.CheckPassword("pass"), true)
+		test_utils.AssertEqual(t, u.CheckPassword("wrong"), false)
+		test_utils.AssertEqual(t, u.NeedsRehash(), false)
+	})
+
+	t.Run("hashes are salted", func(t *testing.T) {
+		a := &database.User{Password: []byte("pass")}
+		b := &database.User{Password: []byte("pass")}
+		a.HashPassword()
+		b.HashPassword()
+
+		test_utils.AssertNotEqual(t, string(a.Password), string(b.Password))
+	})
+
+	t.Run("legacy pbkdf2 keys still verify but need rehashing", func(t *testing.T) {
+		u := &database.User{Salt: make([]byte, 16)}
+		rand.Read(u.Salt)
+		u.Password = pbkdf2.Key([]byte("pass"), u.Salt, 100000, 32, sha512.New512_256)
+
+		test_utils.AssertEqual(t, u.CheckPassword("pass"), true)
+		test_utils.AssertEqual(t, u.CheckPassword("wrong"), false)
+		test_utils.AssertEqual(t, u.NeedsRehash(), true)
+	})
+
+	t.Run("legacy keys starting with $ aren't mistaken for PHC strings", func(t *testing.T) {
+		if testing.Short() {
+			t.Skip("searching for a key that starts with $ is slow")
+		}
+		u := &database.User{Salt: make([]byte, 16)}
+		for {
+			rand.Read(u.Salt)
+			u.Password = pbkdf2.Key([]byte("pass"), u.Salt, 100000, 32, sha512.New512_256)
+			if u.Password[0] == '$' {
+				break
+			}
+		}
+
+		test_utils.AssertEqual(t, u.CheckPassword("pass"), true)
+	})
+
+	t.Run("outdated argon2id parameters need rehashing", func(t *testing.T) {
+		u := &database.User{Password: []byte("pass")}
+		u.HashPassword()
+		u.Password = []byte(strings.Replace(string(u.Password), "t=1", "t=2", 1))
+
+		test_utils.AssertEqual(t, u.NeedsRehash(), true)
+	})
+
+	t.Run("malformed hashes never verify", func(t *testing.T) {
+		for _, hash := range []string{"$argon2id$v=19$m=1", "$bcrypt$", "$argon2id$v=18$m=65536,t=1,p=4$AAAA$AAAA"} {
+			u := &database.User{Password: []byte(hash)}
+			test_utils.AssertEqual(t, u.CheckPassword("pass"), false)
+			test_utils.AssertEqual(t, u.NeedsRehash(), true)
+		}
+	})
+}
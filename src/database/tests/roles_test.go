@@ -0,0 +1,74 @@
+package database_test
+
+import (
+	"fenix/src/database"
+	"fenix/src/test_utils"
+	"testing"
+
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+func TestRoles(t *testing.T) {
+	owner := primitive.NewObjectID()
+	yodel := &database.Yodel{
+		Owner: owner.Hex(),
+		Roles: []database.Role{{Name: "pinner", Permissions: database.PermissionPin}},
+	}
+
+	t.Run("owner has every permission", func(t *testing.T) {
+		member := &database.Member{UserID: owner, Role: database.RoleMember}
+
+		got := yodel.Permissions(member)
+		expected := database.PermissionAll
+		test_utils.AssertEqual(t, got, expected)
+	})
+
+	t.Run("owner role cant be held by non owners", func(t *testing.T) {
+		member := &database.Member{UserID: primitive.NewObjectID(), Role: database.RoleOwner}
+
+		got := yodel.MemberRole(member)
+		expected := database.RoleMember
+		test_utils.AssertEqual(t, got, expected)
+	})
+
+	t.Run("custom roles grant their permissions", func(t *testing.T) {
+		member := &database.Member{UserID: primitive.NewObjectID(), Role: "pinner"}
+
+		got := yodel.Permissions(member)
+		expected := database.PermissionPin
+		test_utils.AssertEqual(t, got, expected)
+	})
+
+	t.Run("members without a role are members", func(t *testing.T) {
+		member := &database.Member{UserID: primitive.NewObjectID()}
+
+		got := yodel.Permissions(member)
+		expected := database.PermissionSend
+		test_utils.AssertEqual(t, got, expected)
+	})
+
+	t.Run("deleting a role resets members", func(t *testing.T) {
+		db := database.NewInMemoryDatabase()
+		y := &database.Yodel{Name: "Fenixland"}
+		db.InsertYodel(y)
+		db.InsertRole(y.YodelID, database.Role{Name: "pinner", Permissions: database.PermissionPin})
+		member := &database.Member{YodelID: y.YodelID, UserID: primitive.NewObjectID(), Role: "pinner"}
+		db.InsertMember(member)
+
+		db.DeleteRole(y.YodelID, "pinner")
+		db.GetMember(member)
+
+		got := member.Role
+		expected := database.RoleMember
+		test_utils.AssertEqual(t, got, expected)
+	})
+
+	t.Run("builtin role names cant be reused", func(t *testing.T) {
+		db := database.NewInMemoryDatabase()
+		y := &database.Yodel{Name: "Fenixland"}
+		db.InsertYodel(y)
+
+		err := db.InsertRole(y.YodelID, database.Role{Name: database.RoleAdmin})
+		test_utils.AssertEqual(t, err, database.AlreadyExists{})
+	})
+}
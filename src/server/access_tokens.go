@@ -0,0 +1,371 @@
+package server
+
+import (
+	"encoding/json"
+	"fenix/src/database"
+	"fenix/src/utils"
+	"fenix/src/websocket_models"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// Scopes of access tokens.  Connections made with a ticket can do anything.
+const (
+	// Read yodels, channels and messages.
+	ScopeRead = "read"
+	// Send and pin messages, and join yodels.
+	ScopeWrite = "write"
+	// Everything else, like creating yodels, managing roles and moderating.
+	ScopeManage = "manage"
+)
+
+// Access tokens are prefixed, so leaked ones are easy to search for.
+const accessTokenPrefix = "fnx_"
+
+var readMessages = map[string]bool{
+	"whoami":                              true,
+	websocket_models.MsgHistory{}.Type():  true,
+	websocket_models.MsgSearch{}.Type():   true,
+	websocket_models.YodelGet{}.Type():    true,
+	websocket_models.YodelList{}.Type():   true,
+	websocket_models.ChannelList{}.Type(): true,
+}
+
+var writeMessages = map[string]bool{
+	websocket_models.MsgSend{}.Type():      true,
+	websocket_models.MsgPin{}.Type():       true,
+	websocket_models.MsgUnpin{}.Type():     true,
+	websocket_models.YodelJoin{}.Type():    true,
+	websocket_models.InviteRedeem{}.Type(): true,
+}
+
+// Scope needed to send a type of message.
+func messageScope(messageType string) string {
+	if readMessages[messageType] {
+		return ScopeRead
+	}
+	if writeMessages[messageType] {
+		return ScopeWrite
+	}
+	return ScopeManage
+}
+
+// Parses space separated scopes, like OAuth.  Returns false if any are unknown.
+func parseScopes(s string) ([]string, bool) {
+	scopes := strings.Fields(s)
+	for _, scope := range scopes {
+		if scope != ScopeRead && scope != ScopeWrite && scope != ScopeManage {
+			return nil, false
+		}
+	}
+	return scopes, len(scopes) > 0
+}
+
+func hasScope(scopes []string, scope string) bool {
+	for _, s := range scopes {
+		if s == scope {
+			return true
+		}
+	}
+	return false
+}
+
+// Gets the access token from an "Authorization: Bearer" header.
+func bearerToken(r *http.Request) (string, bool) {
+	auth := r.Header.Get("Authorization")
+	if !strings.HasPrefix(auth, "Bearer ") {
+		return "", false
+	}
+	return strings.TrimPrefix(auth, "Bearer "), true
+}
+
+// Gets an active access token.
+func (hub *ServerHub) checkAccessToken(token string) (*database.AccessToken, bool) {
+	if !strings.HasPrefix(token, accessTokenPrefix) {
+		return nil, false
+	}
+
+	t := &database.AccessToken{TokenHash: hashToken(token)}
+	err := hub.Database.GetAccessToken(t)
+	if err != nil || !t.Active(time.Now().UnixNano()) {
+		return nil, false
+	}
+	return t, true
+}
+
+// Checks whether a username, or one that looks like it, is in use.
+func (hub *ServerHub) usernameTaken(username, canonical string) bool {
+	return hub.Database.GetUser(&database.User{Username: username}) == nil ||
+		hub.Database.GetUser(&database.User{CanonicalName: canonical}) == nil
+}
+
+// Gets a user, or a bot they own, by ID.  Writes 403 and returns false if it's neither.
+func (hub *ServerHub) ownUserOrBot(w http.ResponseWriter, u *database.User, hex string) (*database.User, bool) {
+	if hex == "" || hex == u.UserID.Hex() {
+		return u, true
+	}
+
+	id, err := primitive.ObjectIDFromHex(hex)
+	if err != nil {
+		w.WriteHeader(http.StatusBadRequest)
+		return nil, false
+	}
+	bot := &database.User{UserID: id}
+	err = hub.Database.GetUser(bot)
+	if err != nil || !bot.Bot || bot.OwnerID != u.UserID {
+		w.WriteHeader(http.StatusForbidden)
+		return nil, false
+	}
+	return bot, true
+}
+
+func writeJSON(w http.ResponseWriter, v interface{}) {
+	b, err := json.Marshal(v)
+	if err != nil {
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+	setCORSHeaders(w)
+	w.Header().Set("Content-Type", "application/json")
+	w.Write(b)
+}
+
+// HTTP method to make a bot owned by the user of a session.
+func (hub *ServerHub) CreateBot(w http.ResponseWriter, r *http.Request) {
+	if r.Method == "OPTIONS" {
+		writeCORSPreflight(w)
+		return
+	}
+
+	body, ok := readJSONBody(w, r, "token", "username")
+	if !ok {
+		return
+	}
+
+	owner, ok := hub.sessionUser(w, body["token"])
+	if !ok {
+		return
+	}
+
+	username, canonical, verr := hub.Accounts.CheckUsername(body["username"])
+	if verr != nil {
+		writeJSONError(w, http.StatusBadRequest, verr)
+		return
+	}
+	taken := &ValidationError{"UsernameTaken", "That username, or one that looks like it, is taken!"}
+	if hub.usernameTaken(username, canonical) {
+		writeJSONError(w, http.StatusConflict, taken)
+		return
+	}
+
+	bot := &database.User{Username: username, CanonicalName: canonical, Bot: true, OwnerID: owner.UserID}
+	err := hub.Database.InsertUser(bot)
+	if _, ok := err.(database.AlreadyExists); ok {
+		writeJSONError(w, http.StatusConflict, taken)
+		return
+	}
+	if err != nil {
+		utils.ErrorLogger.Printf("Error inserting bot %q of %q: %q", username, owner.Username, err)
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+
+	hub.audit(r, AuditCreateBot, owner, bot.UserID.Hex(), bot.Username)
+	writeJSON(w, map[string]string{"userID": bot.UserID.Hex(), "username": bot.Username})
+}
+
+// HTTP method to list the bots of the user of a session.
+func (hub *ServerHub) ListBots(w http.ResponseWriter, r *http.Request) {
+	if r.Method == "OPTIONS" {
+		writeCORSPreflight(w)
+		return
+	}
+
+	body, ok := readJSONBody(w, r, "token")
+	if !ok {
+		return
+	}
+
+	owner, ok := hub.sessionUser(w, body["token"])
+	if !ok {
+		return
+	}
+
+	bots, err := hub.Database.GetBots(owner.UserID)
+	if err != nil {
+		utils.ErrorLogger.Printf("Error getting bots of %q: %q", owner.Username, err)
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+
+	res := make([]map[string]string, 0, len(bots))
+	for _, bot := range bots {
+		res = append(res, map[string]string{"userID": bot.UserID.Hex(), "username": bot.Username})
+	}
+	writeJSON(w, map[string]interface{}{"bots": res})
+}
+
+// HTTP method to make an access token for the user of a session, or one of their bots.
+// The token is only sent this once.
+func (hub *ServerHub) CreateAccessToken(w http.ResponseWriter, r *http.Request) {
+	if r.Method == "OPTIONS" {
+		writeCORSPreflight(w)
+		return
+	}
+
+	body, ok := readJSONBody(w, r, "token", "name", "scopes")
+	if !ok {
+		return
+	}
+
+	scopes, ok := parseScopes(body["scopes"])
+	if !ok {
+		w.WriteHeader(http.StatusBadRequest)
+		return
+	}
+	var expires int64
+	if days := body["expires_in_days"]; days != "" {
+		n, err := strconv.Atoi(days)
+		if err != nil || n <= 0 {
+			w.WriteHeader(http.StatusBadRequest)
+			return
+		}
+		expires = time.Now().Add(time.Duration(n) * 24 * time.Hour).UnixNano()
+	}
+
+	caller, ok := hub.sessionUser(w, body["token"])
+	if !ok {
+		return
+	}
+	u, ok := hub.ownUserOrBot(w, caller, body["user_id"])
+	if !ok {
+		return
+	}
+
+	secret, err := newToken()
+	if err != nil {
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+	token := accessTokenPrefix + secret
+
+	t := &database.AccessToken{
+		TokenHash: hashToken(token),
+		UserID:    u.UserID,
+		Name:      body["name"],
+		Scopes:    scopes,
+		Created:   time.Now().UnixNano(),
+		Expires:   expires,
+	}
+	err = hub.Database.InsertAccessToken(t)
+	if err != nil {
+		utils.ErrorLogger.Printf("Error inserting access token for %q: %q", u.Username, err)
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+
+	hub.audit(r, AuditCreateToken, caller, t.TokenID.Hex(), strings.Join(scopes, " "))
+	writeJSON(w, struct {
+		*database.AccessToken
+		Token string `json:"token"`
+	}{t, token})
+}
+
+// HTTP method to list the access tokens of the user of a session and their bots.
+func (hub *ServerHub) ListAccessTokens(w http.ResponseWriter, r *http.Request) {
+	if r.Method == "OPTIONS" {
+		writeCORSPreflight(w)
+		return
+	}
+
+	body, ok := readJSONBody(w, r, "token")
+	if !ok {
+		return
+	}
+
+	u, ok := hub.sessionUser(w, body["token"])
+	if !ok {
+		return
+	}
+
+	tokens, err := hub.accessTokensOf(u)
+	if err != nil {
+		utils.ErrorLogger.Printf("Error getting access tokens of %q: %q", u.Username, err)
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+	writeJSON(w, map[string]interface{}{"tokens": tokens})
+}
+
+func (hub *ServerHub) accessTokensOf(u *database.User) ([]*database.AccessToken, error) {
+	tokens, err := hub.Database.GetAccessTokens(u.UserID)
+	if err != nil {
+		return nil, err
+	}
+
+	bots, err := hub.Database.GetBots(u.UserID)
+	if err != nil {
+		return nil, err
+	}
+	for _, bot := range bots {
+		botTokens, err := hub.Database.GetAccessTokens(bot.UserID)
+		if err != nil {
+			return nil, err
+		}
+		tokens = append(tokens, botTokens...)
+	}
+	return tokens, nil
+}
+
+// HTTP method to revoke an access token of the user of a session, or one of their bots.
+// Disconnects the client using it.
+func (hub *ServerHub) RevokeAccessToken(w http.ResponseWriter, r *http.Request) {
+	if r.Method == "OPTIONS" {
+		writeCORSPreflight(w)
+		return
+	}
+
+	body, ok := readJSONBody(w, r, "token", "id")
+	if !ok {
+		return
+	}
+
+	u, ok := hub.sessionUser(w, body["token"])
+	if !ok {
+		return
+	}
+
+	tokens, err := hub.accessTokensOf(u)
+	if err != nil {
+		utils.ErrorLogger.Printf("Error getting access tokens of %q: %q", u.Username, err)
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+	for _, t := range tokens {
+		if t.TokenID.Hex() != body["id"] {
+			continue
+		}
+
+		err = hub.Database.DeleteAccessToken(t.TokenID)
+		if err != nil {
+			utils.ErrorLogger.Printf("Error revoking access token of %q: %q", u.Username, err)
+			w.WriteHeader(http.StatusInternalServerError)
+			return
+		}
+		hub.audit(r, AuditRevokeToken, u, t.TokenID.Hex(), "")
+		if value, ok := hub.Clients.Load(t.UserID.Hex()); ok {
+			if c := value.(*Client); c.AccessToken != nil && c.AccessToken.TokenID == t.TokenID {
+				c.Close("")
+			}
+		}
+		setCORSHeaders(w)
+		w.WriteHeader(http.StatusNoContent)
+		return
+	}
+
+	w.WriteHeader(http.StatusNotFound)
+}
@@ -0,0 +1,253 @@
+package server
+
+import (
+	"fenix/src/database"
+	"fenix/src/utils"
+	"net/http"
+	"time"
+)
+
+// How long a password reset token can be used for.
+const resetLifetime = time.Hour
+
+// Checks a username and password, returning the user if they match.
+func (hub *ServerHub) checkPassword(username, password string) (*database.User, bool) {
+	u := &database.User{Username: normalizeUsername(username)}
+	err := hub.Database.GetUser(u)
+	if err != nil {
+		return nil, false
+	}
+
+	return u, u.CheckPassword(password)
+}
+
+// Rehashes a user's correct password if it was hashed with outdated parameters.
+// Failing to is only logged, as the old hash still works.
+func (hub *ServerHub) upgradePassword(u *database.User, password string) {
+	if !u.NeedsRehash() {
+		return
+	}
+
+	u.Password = []byte(password)
+	u.HashPassword()
+	err := hub.Database.UpdatePassword(u)
+	if err != nil {
+		utils.ErrorLogger.Printf("Error rehashing password of %q: %q", u.Username, err)
+	}
+}
+
+// Sets a user's password, then logs them out everywhere.
+func (hub *ServerHub) setPassword(u *database.User, password string) error {
+	u.Password = []byte(password)
+	u.HashPassword()
+
+	err := hub.Database.UpdatePassword(u)
+	if err != nil {
+		return err
+	}
+	return hub.Database.DeleteSessions(u.UserID)
+}
+
+// HTTP method to change a user's password.  Needs the current password.
+func (hub *ServerHub) ChangePassword(w http.ResponseWriter, r *http.Request) {
+	if r.Method == "OPTIONS" {
+		writeCORSPreflight(w)
+		return
+	}
+
+	body, ok := readJSONBody(w, r, "username", "password", "new_password")
+	if !ok {
+		return
+	}
+
+	u, ok := hub.authenticate(w, r, body["username"], body["password"])
+	if !ok {
+		return
+	}
+	if verr := hub.Accounts.CheckPassword(u.Username, body["new_password"]); verr != nil {
+		writeJSONError(w, http.StatusBadRequest, verr)
+		return
+	}
+
+	err := hub.setPassword(u, body["new_password"])
+	if err != nil {
+		utils.ErrorLogger.Printf("Error changing password of %q: %q", u.Username, err)
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+	hub.audit(r, AuditPasswordChange, u, u.UserID.Hex(), "")
+
+	setCORSHeaders(w)
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// HTTP method to send a password reset token through the hub's Notifier.
+// Responds the same whether or not the user exists, so it can't be used to find usernames.
+func (hub *ServerHub) RequestPasswordReset(w http.ResponseWriter, r *http.Request) {
+	if r.Method == "OPTIONS" {
+		writeCORSPreflight(w)
+		return
+	}
+
+	body, ok := readJSONBody(w, r, "username")
+	if !ok {
+		return
+	}
+
+	u := &database.User{Username: normalizeUsername(body["username"])}
+	err := hub.Database.GetUser(u)
+	if err == nil {
+		err = hub.sendPasswordReset(u)
+		if err != nil {
+			utils.ErrorLogger.Printf("Error sending password reset to %q: %q", u.Username, err)
+			w.WriteHeader(http.StatusInternalServerError)
+			return
+		}
+	}
+
+	setCORSHeaders(w)
+	w.WriteHeader(http.StatusNoContent)
+}
+
+func (hub *ServerHub) sendPasswordReset(u *database.User) error {
+	token, err := newToken()
+	if err != nil {
+		return err
+	}
+
+	err = hub.Database.InsertPasswordReset(&database.PasswordReset{
+		TokenHash: hashToken(token),
+		UserID:    u.UserID,
+		Expires:   time.Now().Add(resetLifetime).UnixNano(),
+	})
+	if err != nil {
+		return err
+	}
+
+	return hub.Notifier.Notify(u, "Reset your Fenix password",
+		"Use this token at /password/reset within an hour to choose a new password: "+token)
+}
+
+// HTTP method to set a new password with a reset token.  Tokens can only be used once.
+func (hub *ServerHub) ResetPassword(w http.ResponseWriter, r *http.Request) {
+	if r.Method == "OPTIONS" {
+		writeCORSPreflight(w)
+		return
+	}
+
+	body, ok := readJSONBody(w, r, "token", "new_password")
+	if !ok {
+		return
+	}
+	// Checked without the username, so bad passwords don't use up the token
+	if verr := hub.Accounts.CheckPassword("", body["new_password"]); verr != nil {
+		writeJSONError(w, http.StatusBadRequest, verr)
+		return
+	}
+
+	reset := &database.PasswordReset{TokenHash: hashToken(body["token"])}
+	err := hub.Database.TakePasswordReset(reset)
+	if err != nil {
+		w.WriteHeader(http.StatusForbidden)
+		return
+	}
+
+	u := &database.User{UserID: reset.UserID}
+	err = hub.Database.GetUser(u)
+	if err != nil {
+		w.WriteHeader(http.StatusForbidden)
+		return
+	}
+
+	err = hub.setPassword(u, body["new_password"])
+	if err != nil {
+		utils.ErrorLogger.Printf("Error resetting password of %q: %q", u.Username, err)
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+	hub.audit(r, AuditPasswordReset, u, u.UserID.Hex(), "")
+
+	setCORSHeaders(w)
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// HTTP method to delete a user and their bots.  Their messages are kept, but no longer show who sent them.
+// Users who own yodels, or whose bots do, must transfer or delete them first.
+func (hub *ServerHub) DeleteAccount(w http.ResponseWriter, r *http.Request) {
+	if r.Method == "OPTIONS" {
+		writeCORSPreflight(w)
+		return
+	}
+
+	body, ok := readJSONBody(w, r, "username", "password")
+	if !ok {
+		return
+	}
+
+	u, ok := hub.authenticate(w, r, body["username"], body["password"])
+	if !ok {
+		return
+	}
+
+	if !hub.deleteUser(w, u) {
+		return
+	}
+
+	hub.audit(r, AuditDeleteAccount, u, u.UserID.Hex(), u.Username)
+	setCORSHeaders(w)
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// Deletes a user and their bots, and disconnects them.  Writes an error and returns false if they,
+// or their bots, own yodels.
+func (hub *ServerHub) deleteUser(w http.ResponseWriter, u *database.User) bool {
+	bots, err := hub.Database.GetBots(u.UserID)
+	if err != nil {
+		utils.ErrorLogger.Printf("Error finding bots of %q: %q", u.Username, err)
+		w.WriteHeader(http.StatusInternalServerError)
+		return false
+	}
+	// Bots go first, so a failure part way leaves the owner able to try again
+	users := append(bots, u)
+
+	for _, user := range users {
+		owns, err := hub.ownsYodels(user)
+		if err != nil {
+			utils.ErrorLogger.Printf("Error finding yodels of %q: %q", user.Username, err)
+			w.WriteHeader(http.StatusInternalServerError)
+			return false
+		}
+		if owns {
+			w.WriteHeader(http.StatusConflict)
+			return false
+		}
+	}
+
+	for _, user := range users {
+		err = hub.Database.DeleteUser(user)
+		if err != nil {
+			utils.ErrorLogger.Printf("Error deleting user %q: %q", user.Username, err)
+			w.WriteHeader(http.StatusInternalServerError)
+			return false
+		}
+
+		hub.disconnect(user.UserID)
+	}
+	return true
+}
+
+func (hub *ServerHub) ownsYodels(u *database.User) (bool, error) {
+	members, err := hub.Database.GetMembersOf(u.UserID)
+	if err != nil {
+		return false, err
+	}
+
+	for _, m := range members {
+		yodel := &database.Yodel{YodelID: m.YodelID}
+		err = hub.Database.GetYodel(yodel)
+		if err == nil && yodel.Owner == u.UserID.Hex() {
+			return true, nil
+		}
+	}
+	return false, nil
+}
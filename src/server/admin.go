@@ -0,0 +1,434 @@
+package server
+
+import (
+	"fenix/src/database"
+	"fenix/src/utils"
+	"fenix/src/websocket_models"
+	"net/http"
+	"runtime"
+	"strconv"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+const (
+	defaultAdminPageSize = 50
+	maxAdminPageSize     = 200
+)
+
+// A connected client, as shown to admins.
+type adminSession struct {
+	UserID   string `json:"user_id"`
+	Username string `json:"username"`
+	Bot      bool   `json:"bot,omitempty"`
+	Address  string `json:"address"`
+	// Name of the access token the client connected with, if it used one.
+	AccessToken string `json:"access_token,omitempty"`
+}
+
+// A yodel, as shown to admins.
+type adminYodel struct {
+	YodelID    string `json:"y_id"`
+	Name       string `json:"name"`
+	Owner      string `json:"o_id"`
+	Visibility string `json:"visibility"`
+}
+
+// Makes an existing user an admin.  Admins can't be made through the HTTP API,
+// so the first has to be made when starting the server.
+func (hub *ServerHub) MakeAdmin(username string) error {
+	u := &database.User{Username: normalizeUsername(username)}
+	err := hub.Database.GetUser(u)
+	if err != nil {
+		return err
+	}
+	if u.Admin {
+		return nil
+	}
+
+	u.Admin = true
+	err = hub.Database.UpdateUserStatus(u)
+	if err == nil {
+		hub.audit(nil, AuditMakeAdmin, nil, u.UserID.Hex(), "")
+	}
+	return err
+}
+
+// Reads an admin request's body and checks its session token is an admin's.
+// Writes an error and returns false if it isn't.
+func (hub *ServerHub) adminRequest(w http.ResponseWriter, r *http.Request, fields ...string) (*database.User, map[string]string, bool) {
+	body, ok := readJSONBody(w, r, append(fields, "token")...)
+	if !ok {
+		return nil, nil, false
+	}
+
+	u, ok := hub.sessionUser(w, body["token"])
+	if !ok {
+		return nil, nil, false
+	}
+	if !u.Admin {
+		hub.audit(r, AuditAdminDenied, u, "", r.URL.Path)
+		w.WriteHeader(http.StatusForbidden)
+		return nil, nil, false
+	}
+	return u, body, true
+}
+
+// Reads offset and limit from a request body, with the default page size if there's no limit.
+// Writes 400 and returns false if they aren't valid.
+func pageParams(w http.ResponseWriter, body map[string]string) (int64, int64, bool) {
+	offset, limit := int64(0), int64(defaultAdminPageSize)
+	var err error
+	if s := body["offset"]; s != "" {
+		offset, err = strconv.ParseInt(s, 10, 64)
+	}
+	if s := body["limit"]; s != "" && err == nil {
+		limit, err = strconv.ParseInt(s, 10, 64)
+	}
+	if err != nil || offset < 0 || limit <= 0 || limit > maxAdminPageSize {
+		w.WriteHeader(http.StatusBadRequest)
+		return 0, 0, false
+	}
+	return offset, limit, true
+}
+
+// Gets the user a request's user_id is for.  Writes an error and returns false if there isn't one.
+func (hub *ServerHub) targetUser(w http.ResponseWriter, body map[string]string) (*database.User, bool) {
+	id, err := primitive.ObjectIDFromHex(body["user_id"])
+	if err != nil {
+		w.WriteHeader(http.StatusBadRequest)
+		return nil, false
+	}
+
+	u := &database.User{UserID: id}
+	err = hub.Database.GetUser(u)
+	if _, ok := err.(database.DoesNotExist); ok {
+		w.WriteHeader(http.StatusNotFound)
+		return nil, false
+	}
+	if err != nil {
+		utils.ErrorLogger.Printf("Error getting user %q: %q", body["user_id"], err)
+		w.WriteHeader(http.StatusInternalServerError)
+		return nil, false
+	}
+	return u, true
+}
+
+// HTTP method to list users, optionally filtered by part of their username.
+func (hub *ServerHub) AdminListUsers(w http.ResponseWriter, r *http.Request) {
+	if r.Method == "OPTIONS" {
+		writeCORSPreflight(w)
+		return
+	}
+
+	admin, body, ok := hub.adminRequest(w, r)
+	if !ok {
+		return
+	}
+	offset, limit, ok := pageParams(w, body)
+	if !ok {
+		return
+	}
+
+	users, err := hub.Database.ListUsers(&database.UserQuery{Username: body["username"], Offset: offset, Limit: limit})
+	if err != nil {
+		utils.ErrorLogger.Printf("Error listing users: %q", err)
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+
+	hub.audit(r, AuditAdminListUsers, admin, "", body["username"])
+	writeJSON(w, map[string][]*database.User{"users": users})
+}
+
+// HTTP method to stop a user from logging in or connecting, and disconnect them.
+func (hub *ServerHub) AdminDisableUser(w http.ResponseWriter, r *http.Request) {
+	hub.setUserDisabled(w, r, true)
+}
+
+// HTTP method to let a disabled user log in again.
+func (hub *ServerHub) AdminEnableUser(w http.ResponseWriter, r *http.Request) {
+	hub.setUserDisabled(w, r, false)
+}
+
+func (hub *ServerHub) setUserDisabled(w http.ResponseWriter, r *http.Request, disabled bool) {
+	if r.Method == "OPTIONS" {
+		writeCORSPreflight(w)
+		return
+	}
+
+	admin, body, ok := hub.adminRequest(w, r, "user_id")
+	if !ok {
+		return
+	}
+	u, ok := hub.targetUser(w, body)
+	if !ok {
+		return
+	}
+	// Admins can't lock themselves out
+	if u.UserID == admin.UserID {
+		w.WriteHeader(http.StatusConflict)
+		return
+	}
+
+	u.Disabled = disabled
+	err := hub.Database.UpdateUserStatus(u)
+	if err != nil {
+		utils.ErrorLogger.Printf("Error setting disabled of %q: %q", u.Username, err)
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+
+	if disabled {
+		hub.disconnect(u.UserID)
+		hub.audit(r, AuditAdminDisableUser, admin, u.UserID.Hex(), "")
+	} else {
+		hub.audit(r, AuditAdminEnableUser, admin, u.UserID.Hex(), "")
+	}
+	setCORSHeaders(w)
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// HTTP method to delete a user and their bots, like /account/delete.
+func (hub *ServerHub) AdminDeleteUser(w http.ResponseWriter, r *http.Request) {
+	if r.Method == "OPTIONS" {
+		writeCORSPreflight(w)
+		return
+	}
+
+	admin, body, ok := hub.adminRequest(w, r, "user_id")
+	if !ok {
+		return
+	}
+	u, ok := hub.targetUser(w, body)
+	if !ok {
+		return
+	}
+	if u.UserID == admin.UserID {
+		w.WriteHeader(http.StatusConflict)
+		return
+	}
+
+	if !hub.deleteUser(w, u) {
+		return
+	}
+
+	hub.audit(r, AuditAdminDeleteUser, admin, u.UserID.Hex(), u.Username)
+	setCORSHeaders(w)
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// HTTP method to list connected clients.
+func (hub *ServerHub) AdminListSessions(w http.ResponseWriter, r *http.Request) {
+	if r.Method == "OPTIONS" {
+		writeCORSPreflight(w)
+		return
+	}
+
+	admin, _, ok := hub.adminRequest(w, r)
+	if !ok {
+		return
+	}
+
+	sessions := []adminSession{}
+	hub.Clients.Range(func(key, value interface{}) bool {
+		c := value.(*Client)
+		s := adminSession{
+			UserID:   c.User.UserID.Hex(),
+			Username: c.User.Username,
+			Bot:      c.User.Bot,
+			Address:  c.conn.RemoteAddr().String(),
+		}
+		if c.AccessToken != nil {
+			s.AccessToken = c.AccessToken.Name
+		}
+		sessions = append(sessions, s)
+		return true
+	})
+
+	hub.audit(r, AuditAdminListSessions, admin, "", "")
+	writeJSON(w, map[string][]adminSession{"sessions": sessions})
+}
+
+// HTTP method to disconnect a client.  It can connect again unless its user is disabled too.
+func (hub *ServerHub) AdminDisconnectSession(w http.ResponseWriter, r *http.Request) {
+	if r.Method == "OPTIONS" {
+		writeCORSPreflight(w)
+		return
+	}
+
+	admin, body, ok := hub.adminRequest(w, r, "user_id")
+	if !ok {
+		return
+	}
+	id, err := primitive.ObjectIDFromHex(body["user_id"])
+	if err != nil {
+		w.WriteHeader(http.StatusBadRequest)
+		return
+	}
+
+	if !hub.disconnect(id) {
+		w.WriteHeader(http.StatusNotFound)
+		return
+	}
+
+	hub.audit(r, AuditAdminDisconnectSession, admin, id.Hex(), "")
+	setCORSHeaders(w)
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// HTTP method to list yodels, including private ones, optionally filtered by part of their name.
+func (hub *ServerHub) AdminListYodels(w http.ResponseWriter, r *http.Request) {
+	if r.Method == "OPTIONS" {
+		writeCORSPreflight(w)
+		return
+	}
+
+	admin, body, ok := hub.adminRequest(w, r)
+	if !ok {
+		return
+	}
+	offset, limit, ok := pageParams(w, body)
+	if !ok {
+		return
+	}
+
+	yodels, err := hub.Database.ListYodels(&database.YodelQuery{Name: body["name"], All: true, Offset: offset, Limit: limit})
+	if err != nil {
+		utils.ErrorLogger.Printf("Error listing yodels: %q", err)
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+
+	res := []adminYodel{}
+	for _, y := range yodels {
+		res = append(res, adminYodel{y.YodelID.Hex(), y.Name, y.Owner, string(y.GetVisibility())})
+	}
+	hub.audit(r, AuditAdminListYodels, admin, "", body["name"])
+	writeJSON(w, map[string][]adminYodel{"yodels": res})
+}
+
+// HTTP method to delete a yodel, telling its connected members.
+func (hub *ServerHub) AdminDeleteYodel(w http.ResponseWriter, r *http.Request) {
+	if r.Method == "OPTIONS" {
+		writeCORSPreflight(w)
+		return
+	}
+
+	admin, body, ok := hub.adminRequest(w, r, "yodel_id")
+	if !ok {
+		return
+	}
+	id, err := primitive.ObjectIDFromHex(body["yodel_id"])
+	if err != nil {
+		w.WriteHeader(http.StatusBadRequest)
+		return
+	}
+
+	yodel := &database.Yodel{YodelID: id}
+	err = hub.Database.GetYodel(yodel)
+	if _, ok := err.(database.DoesNotExist); ok {
+		w.WriteHeader(http.StatusNotFound)
+		return
+	}
+	// Members are deleted with the yodel, so find who to tell first
+	var members []*database.Member
+	if err == nil {
+		members, err = hub.Database.GetMembers(id)
+	}
+	if err == nil {
+		err = hub.Database.DeleteYodel(id)
+	}
+	if err != nil {
+		utils.ErrorLogger.Printf("Error deleting yodel %q: %q", body["yodel_id"], err)
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+
+	for _, m := range members {
+		hub.SendToUser(m.UserID, websocket_models.YodelDeleted{YodelID: id.Hex()})
+	}
+	hub.audit(r, AuditAdminDeleteYodel, admin, id.Hex(), yodel.Name)
+	setCORSHeaders(w)
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// HTTP method to query the audit log, newest first.  from and to are Unix nanoseconds, and
+// can be left out along with actor_id and action to match everything.
+func (hub *ServerHub) AdminQueryAudit(w http.ResponseWriter, r *http.Request) {
+	if r.Method == "OPTIONS" {
+		writeCORSPreflight(w)
+		return
+	}
+
+	admin, body, ok := hub.adminRequest(w, r)
+	if !ok {
+		return
+	}
+	offset, limit, ok := pageParams(w, body)
+	if !ok {
+		return
+	}
+
+	q := &database.AuditQuery{ActorID: body["actor_id"], Action: body["action"], Offset: offset, Limit: limit}
+	var err error
+	if s := body["from"]; s != "" {
+		q.From, err = strconv.ParseInt(s, 10, 64)
+	}
+	if s := body["to"]; s != "" && err == nil {
+		q.To, err = strconv.ParseInt(s, 10, 64)
+	}
+	if err != nil || q.From < 0 || q.To < 0 || (q.To != 0 && q.To < q.From) {
+		w.WriteHeader(http.StatusBadRequest)
+		return
+	}
+
+	entries, err := hub.Database.GetAuditEntries(q)
+	if err != nil {
+		utils.ErrorLogger.Printf("Error querying audit log: %q", err)
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+
+	// Recorded after querying, so it isn't in its own results
+	hub.audit(r, AuditAdminQueryAudit, admin, body["actor_id"], body["action"])
+	writeJSON(w, map[string][]*database.AuditEntry{"entries": entries})
+}
+
+// HTTP method to show counts of what's stored, and how the server is doing.
+func (hub *ServerHub) AdminStats(w http.ResponseWriter, r *http.Request) {
+	if r.Method == "OPTIONS" {
+		writeCORSPreflight(w)
+		return
+	}
+
+	admin, _, ok := hub.adminRequest(w, r)
+	if !ok {
+		return
+	}
+
+	stats, err := hub.Database.GetStats()
+	if err != nil {
+		utils.ErrorLogger.Printf("Error getting stats: %q", err)
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+	connected := 0
+	hub.Clients.Range(func(key, value interface{}) bool {
+		connected++
+		return true
+	})
+
+	hub.audit(r, AuditAdminStats, admin, "", "")
+	writeJSON(w, map[string]interface{}{
+		"users":          stats.Users,
+		"bots":           stats.Bots,
+		"yodels":         stats.Yodels,
+		"messages":       stats.Messages,
+		"connected":      connected,
+		"goroutines":     runtime.NumGoroutine(),
+		"uptime_seconds": int64(time.Since(hub.Started).Seconds()),
+		"version":        version,
+	})
+}
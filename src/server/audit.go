@@ -0,0 +1,90 @@
+package server
+
+import (
+	"fenix/src/database"
+	"fenix/src/utils"
+	"net"
+	"net/http"
+	"time"
+)
+
+// Actions recorded in the audit log.
+const (
+	AuditRegister       = "user.register"
+	AuditDeleteAccount  = "user.delete"
+	AuditLoginSuccess   = "login.success"
+	AuditLoginFailure   = "login.failure"
+	AuditTicketRedeem   = "ticket.redeem"
+	AuditTicketReject   = "ticket.reject"
+	AuditTokenConnect   = "token.connect"
+	AuditPasswordChange = "password.change"
+	AuditPasswordReset  = "password.reset"
+	AuditEnable2FA      = "2fa.enable"
+	AuditDisable2FA     = "2fa.disable"
+	AuditCreateBot      = "bot.create"
+	AuditCreateToken    = "token.create"
+	AuditRevokeToken    = "token.revoke"
+
+	AuditRateLimitDisconnect = "ratelimit.disconnect"
+
+	AuditYodelTransfer = "yodel.transfer"
+	AuditYodelDelete   = "yodel.delete"
+	AuditMemberKick    = "member.kick"
+	AuditMemberBan     = "member.ban"
+	AuditMemberMute    = "member.mute"
+
+	AuditMakeAdmin              = "admin.make"
+	AuditAdminDenied            = "admin.denied"
+	AuditAdminListUsers         = "admin.users.list"
+	AuditAdminDisableUser       = "admin.users.disable"
+	AuditAdminEnableUser        = "admin.users.enable"
+	AuditAdminDeleteUser        = "admin.users.delete"
+	AuditAdminListSessions      = "admin.sessions.list"
+	AuditAdminDisconnectSession = "admin.sessions.disconnect"
+	AuditAdminListYodels        = "admin.yodels.list"
+	AuditAdminDeleteYodel       = "admin.yodels.delete"
+	AuditAdminStats             = "admin.stats"
+	AuditAdminQueryAudit        = "admin.audit"
+)
+
+// Gets the address a request came from, without its port.
+func clientIP(r *http.Request) string {
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		return r.RemoteAddr
+	}
+	return host
+}
+
+// Records an event in the audit log.  actor and r can be nil if they aren't known.
+func (hub *ServerHub) audit(r *http.Request, action string, actor *database.User, target, details string) {
+	ip := ""
+	if r != nil {
+		ip = clientIP(r)
+	}
+	hub.insertAudit(ip, action, actor, target, details)
+}
+
+// Records an event done by the client's user in the audit log.
+func (c *Client) Audit(action, target, details string) {
+	c.hub.insertAudit(c.ip, action, &c.User, target, details)
+}
+
+// Failing to record an event is only logged, so it doesn't stop what's being recorded.
+func (hub *ServerHub) insertAudit(ip, action string, actor *database.User, target, details string) {
+	e := &database.AuditEntry{
+		Timestamp: time.Now().UnixNano(),
+		Action:    action,
+		Target:    target,
+		IP:        ip,
+		Details:   details,
+	}
+	if actor != nil {
+		e.ActorID = actor.UserID.Hex()
+	}
+
+	err := hub.Database.InsertAuditEntry(e)
+	if err != nil {
+		utils.ErrorLogger.Printf("Error recording %q in the audit log: %q", action, err)
+	}
+}
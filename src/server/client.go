@@ -2,9 +2,11 @@ package server
 
 import (
 	"encoding/json"
+	"errors"
 	"fenix/src/database"
 	"fenix/src/utils"
 	"fenix/src/websocket_models"
+	"time"
 
 	"github.com/gorilla/websocket"
 )
@@ -27,6 +29,11 @@ type Client struct {
 	User                 database.User
 	ClientEventLoop      chan ClientEvent
 	OutgoingPayloadQueue chan websocket_models.JSONModel
+	// Set if the client connected with an access token, limiting it to the token's scopes.
+	AccessToken *database.AccessToken
+	// Address the client connected from, for the audit log.
+	ip     string
+	limits clientLimits
 }
 
 // Can be called multiple times.  Should be deferred at end of functions
@@ -39,7 +46,7 @@ func (c *Client) Close(wg_id string) {
 	}
 
 	c.Closed = true
-	c.hub.Clients.Delete(c.User.UserID)
+	c.hub.Clients.Delete(c.User.UserID.Hex())
 
 	c.conn.Close()
 }
@@ -52,6 +59,9 @@ func (c *Client) New() {
 	c.hub.Database.GetUser(&c.User)
 
 	c.conn.SetCloseHandler(c.OnClose)
+	if c.hub.Sizes.FrameBytes > 0 {
+		c.conn.SetReadLimit(c.hub.Sizes.FrameBytes)
+	}
 
 	go c.listenOnEventLoop()
 	go c.listenOnWebsocket()
@@ -81,6 +91,11 @@ func (c *Client) listenOnWebsocket() {
 		if websocket.IsUnexpectedCloseError(err) {
 			return
 		}
+		// The connection is already closed with CloseMessageTooBig, so there's no one to tell
+		if errors.Is(err, websocket.ErrReadLimit) {
+			utils.InfoLogger.Printf("Disconnecting %v for sending a frame over %v bytes", c.User.Username, c.hub.Sizes.FrameBytes)
+			return
+		}
 
 		if err != nil {
 			utils.InfoLogger.Printf("Error decoding message: %q; %q", err, b)
@@ -96,9 +111,36 @@ func (c *Client) listenOnWebsocket() {
 			return
 		}
 
-		if handler, ok := c.hub.Handlers[t.Type]; ok {
-			go handler(b, c)
+		handler, ok := c.hub.Handlers[t.Type]
+		if !ok {
+			continue
+		}
+		if scope := messageScope(t.Type); c.AccessToken != nil && !hasScope(c.AccessToken.Scopes, scope) {
+			c.OutgoingPayloadQueue <- websocket_models.GenericError{
+				Error:   "MissingScope",
+				Message: "This access token needs the " + scope + " scope to do that!",
+			}
+			continue
+		}
+		if wait := c.takeMessage(t.Type); wait > 0 {
+			if c.strike() {
+				utils.InfoLogger.Printf("Disconnecting %v for sending too many messages", c.User.Username)
+				c.Audit(AuditRateLimitDisconnect, c.User.UserID.Hex(), t.Type)
+				c.OutgoingPayloadQueue <- websocket_models.GenericError{
+					Error:   "RateLimited",
+					Message: "Disconnected for sending too many messages!",
+				}
+				return
+			}
+			// Round up, so clients that wait exactly that long aren't refused again
+			c.OutgoingPayloadQueue <- websocket_models.GenericError{
+				Error:      "RateLimited",
+				Message:    "Too many " + t.Type + " messages, slow down!",
+				RetryAfter: int64((wait + time.Millisecond - 1) / time.Millisecond),
+			}
+			continue
 		}
+		go handler(b, c)
 	}
 }
 
@@ -0,0 +1,36 @@
+package server
+
+import (
+	"strings"
+
+	"golang.org/x/text/unicode/norm"
+)
+
+// Cyrillic and Greek letters that look like Latin ones, from the Unicode confusables data (UTS #39).
+// Only scripts that are easily mixed into Latin names are covered, as names in other scripts
+// can't be mistaken for Latin ones.  Accented letters are covered by decomposing them first.
+var confusables = map[rune]rune{
+	// Cyrillic
+	'А': 'A', 'В': 'B', 'Е': 'E', 'К': 'K', 'М': 'M', 'Н': 'H', 'О': 'O', 'Р': 'P',
+	'С': 'C', 'Т': 'T', 'У': 'Y', 'Х': 'X', 'Ѕ': 'S', 'І': 'I', 'Ј': 'J', 'Ԛ': 'Q', 'Ԝ': 'W',
+	'а': 'a', 'е': 'e', 'о': 'o', 'р': 'p', 'с': 'c', 'у': 'y', 'х': 'x', 'ѕ': 's',
+	'і': 'i', 'ј': 'j', 'ԁ': 'd', 'һ': 'h', 'ԛ': 'q', 'ԝ': 'w', 'ӏ': 'l',
+	// Greek
+	'Α': 'A', 'Β': 'B', 'Ε': 'E', 'Ζ': 'Z', 'Η': 'H', 'Ι': 'I', 'Κ': 'K', 'Μ': 'M',
+	'Ν': 'N', 'Ο': 'O', 'Ρ': 'P', 'Τ': 'T', 'Υ': 'Y', 'Χ': 'X',
+	'α': 'a', 'γ': 'y', 'ι': 'i', 'κ': 'k', 'ν': 'v', 'ο': 'o', 'ρ': 'p', 'υ': 'u', 'χ': 'x',
+	// Latin letters that look like plainer ones
+	'ı': 'i', 'ȷ': 'j', 'ɑ': 'a', 'ɡ': 'g',
+}
+
+// Replaces look-alike letters in s with the Latin letters they look like, so "pаypаl" with
+// Cyrillic "а"s becomes "paypal".  Like a UTS #39 skeleton, but only for the letters above.
+func skeleton(s string) string {
+	s = strings.Map(func(r rune) rune {
+		if latin, ok := confusables[r]; ok {
+			return latin
+		}
+		return r
+	}, norm.NFD.String(s))
+	return norm.NFC.String(s)
+}
@@ -0,0 +1,110 @@
+package server
+
+import (
+	"fenix/src/utils"
+	"net/http"
+	"strings"
+)
+
+// Which browser origins can call the HTTP endpoints and open websockets.
+// Requests without an Origin header, like those from bots and scripts, are always allowed.
+type CORSPolicy struct {
+	// Origins like "https://fenix.example.com".  "https://*.example.com" allows any subdomain
+	// of example.com but not example.com itself, and "*" allows every origin.
+	AllowedOrigins []string
+}
+
+// Allows every origin, like Fenix did before origins could be limited.
+func NewCORSPolicy() *CORSPolicy {
+	return &CORSPolicy{AllowedOrigins: []string{"*"}}
+}
+
+// Makes a policy from a comma separated list of origins.
+func ParseCORSPolicy(origins string) *CORSPolicy {
+	p := &CORSPolicy{}
+	for _, o := range strings.Split(origins, ",") {
+		if o = strings.TrimSpace(o); o != "" {
+			p.AllowedOrigins = append(p.AllowedOrigins, o)
+		}
+	}
+	return p
+}
+
+func (p *CORSPolicy) allowsAny() bool {
+	for _, allowed := range p.AllowedOrigins {
+		if allowed == "*" {
+			return true
+		}
+	}
+	return false
+}
+
+// Whether origin is allowed.  Origins are compared without case, as hosts are.
+func (p *CORSPolicy) Allowed(origin string) bool {
+	origin = strings.ToLower(origin)
+	for _, allowed := range p.AllowedOrigins {
+		allowed = strings.ToLower(allowed)
+		if allowed == "*" || allowed == origin {
+			return true
+		}
+
+		scheme, domain, ok := strings.Cut(allowed, "://*.")
+		if !ok || !strings.HasPrefix(origin, scheme+"://") {
+			continue
+		}
+		host := strings.TrimPrefix(origin, scheme+"://")
+		if !strings.HasSuffix(host, "."+domain) {
+			continue
+		}
+		// The wildcard stands for one or more labels of the host, never its scheme or port
+		sub := strings.TrimSuffix(host, "."+domain)
+		if sub != "" && !strings.ContainsAny(sub, "/:@") {
+			return true
+		}
+	}
+	return false
+}
+
+// Checks the request's Origin against the hub's policy, allowing the origin to read the response.
+// Logs the origin and writes 403 if it isn't allowed.
+func (hub *ServerHub) checkOrigin(w http.ResponseWriter, r *http.Request) bool {
+	origin := r.Header.Get("Origin")
+	if origin == "" {
+		return true
+	}
+	if !hub.CORS.Allowed(origin) {
+		utils.WarningLogger.Printf("Rejected request to %v from origin %q", r.URL.Path, origin)
+		w.WriteHeader(http.StatusForbidden)
+		return false
+	}
+
+	if hub.CORS.allowsAny() {
+		w.Header().Set("Access-Control-Allow-Origin", "*")
+	} else {
+		w.Header().Set("Access-Control-Allow-Origin", origin)
+		w.Header().Add("Vary", "Origin")
+	}
+	return true
+}
+
+// Lets the websocket upgrader check origins with the hub's policy.
+func (hub *ServerHub) upgraderCheckOrigin(r *http.Request) bool {
+	origin := r.Header.Get("Origin")
+	if origin == "" || hub.CORS.Allowed(origin) {
+		return true
+	}
+	utils.WarningLogger.Printf("Rejected websocket upgrade from origin %q", origin)
+	return false
+}
+
+// Allow-Origin is set by checkOrigin, as only it has the request.
+func setCORSHeaders(w http.ResponseWriter) {
+	w.Header().Set("Access-Control-Allow-Methods", "POST")
+	w.Header().Set("Access-Control-Max-Age", "86400")
+	w.Header().Set("Access-Control-Allow-Headers", "Content-Type")
+}
+
+func writeCORSPreflight(w http.ResponseWriter) {
+	setCORSHeaders(w)
+	w.WriteHeader(http.StatusNoContent)
+}
@@ -0,0 +1,219 @@
+package handlers
+
+import (
+	"encoding/json"
+	"fenix/src/database"
+	"fenix/src/server"
+	"fenix/src/utils"
+	"fenix/src/websocket_models"
+	"time"
+)
+
+type ChannelHandler struct {
+	hub *server.ServerHub
+}
+
+func (ch *ChannelHandler) init() {
+	ch.hub.RegisterHandler(websocket_models.ChannelCreate{}.Type(), ch.HandleChannelCreate)
+	ch.hub.RegisterHandler(websocket_models.ChannelList{}.Type(), ch.HandleChannelList)
+	ch.hub.RegisterHandler(websocket_models.ChannelRename{}.Type(), ch.HandleChannelRename)
+	ch.hub.RegisterHandler(websocket_models.ChannelDelete{}.Type(), ch.HandleChannelDelete)
+}
+
+func (ch *ChannelHandler) HandleChannelCreate(b []byte, c *server.Client) {
+	var create websocket_models.ChannelCreate
+	err := json.Unmarshal(b, &create)
+	if err != nil {
+		utils.InfoLogger.Printf("error in decoding channelcreate json: %v", err)
+		c.OutgoingPayloadQueue <- websocket_models.GenericError{Error: "JSONDecodeError"}
+		return
+	}
+
+	if create.Name == "" {
+		c.OutgoingPayloadQueue <- channelNameEmptyError()
+		return
+	}
+
+	yodelID, ok := parseObjectID(create.YodelID, c)
+	if !ok {
+		return
+	}
+	yodel := &database.Yodel{YodelID: yodelID}
+	err = ch.hub.Database.GetYodel(yodel)
+	if err != nil {
+		c.OutgoingPayloadQueue <- websocket_models.GenericError{Error: "YodelDoesntExistError"}
+		return
+	}
+	if _, ok := requirePermission(ch.hub, yodel, c, database.PermissionManageYodel); !ok {
+		return
+	}
+
+	err = ch.hub.Database.InsertChannel(&database.Channel{
+		YodelID: yodel.YodelID,
+		Name:    create.Name,
+		Created: time.Now().UnixNano(),
+	})
+	if _, ok := err.(database.AlreadyExists); ok {
+		c.OutgoingPayloadQueue <- channelAlreadyExistsError()
+		return
+	}
+	if err != nil {
+		c.OutgoingPayloadQueue <- websocket_models.GenericError{Error: "DatabaseError"}
+		return
+	}
+
+	ch.broadcastChannels(yodel, c)
+}
+
+func (ch *ChannelHandler) HandleChannelList(b []byte, c *server.Client) {
+	var list websocket_models.ChannelList
+	err := json.Unmarshal(b, &list)
+	if err != nil {
+		utils.InfoLogger.Printf("error in decoding channellist json: %v", err)
+		c.OutgoingPayloadQueue <- websocket_models.GenericError{Error: "JSONDecodeError"}
+		return
+	}
+
+	yodelID, ok := parseObjectID(list.YodelID, c)
+	if !ok {
+		return
+	}
+	yodel := &database.Yodel{YodelID: yodelID}
+	err = ch.hub.Database.GetYodel(yodel)
+	if err != nil {
+		c.OutgoingPayloadQueue <- websocket_models.GenericError{Error: "YodelDoesntExistError"}
+		return
+	}
+	if _, ok := requirePermission(ch.hub, yodel, c, 0); !ok {
+		return
+	}
+
+	list.Channels, err = ch.channelModels(yodel)
+	if err != nil {
+		c.OutgoingPayloadQueue <- websocket_models.GenericError{Error: "DatabaseError"}
+		return
+	}
+
+	c.OutgoingPayloadQueue <- list
+}
+
+func (ch *ChannelHandler) HandleChannelRename(b []byte, c *server.Client) {
+	var rename websocket_models.ChannelRename
+	err := json.Unmarshal(b, &rename)
+	if err != nil {
+		utils.InfoLogger.Printf("error in decoding channelrename json: %v", err)
+		c.OutgoingPayloadQueue <- websocket_models.GenericError{Error: "JSONDecodeError"}
+		return
+	}
+
+	if rename.Name == "" {
+		c.OutgoingPayloadQueue <- channelNameEmptyError()
+		return
+	}
+
+	channel, yodel, ok := getChannel(ch.hub, rename.ChannelID, c)
+	if !ok {
+		return
+	}
+	if _, ok := requirePermission(ch.hub, yodel, c, database.PermissionManageYodel); !ok {
+		return
+	}
+
+	err = ch.hub.Database.RenameChannel(channel.ChannelID, rename.Name)
+	if _, ok := err.(database.AlreadyExists); ok {
+		c.OutgoingPayloadQueue <- channelAlreadyExistsError()
+		return
+	}
+	if err != nil {
+		c.OutgoingPayloadQueue <- websocket_models.GenericError{Error: "DatabaseError"}
+		return
+	}
+
+	ch.broadcastChannels(yodel, c)
+}
+
+func (ch *ChannelHandler) HandleChannelDelete(b []byte, c *server.Client) {
+	var del websocket_models.ChannelDelete
+	err := json.Unmarshal(b, &del)
+	if err != nil {
+		utils.InfoLogger.Printf("error in decoding channeldelete json: %v", err)
+		c.OutgoingPayloadQueue <- websocket_models.GenericError{Error: "JSONDecodeError"}
+		return
+	}
+
+	channel, yodel, ok := getChannel(ch.hub, del.ChannelID, c)
+	if !ok {
+		return
+	}
+	if _, ok := requirePermission(ch.hub, yodel, c, database.PermissionManageYodel); !ok {
+		return
+	}
+
+	channels, err := ch.hub.Database.GetChannels(yodel.YodelID)
+	if err != nil {
+		c.OutgoingPayloadQueue <- websocket_models.GenericError{Error: "DatabaseError"}
+		return
+	}
+	if len(channels) <= 1 {
+		c.OutgoingPayloadQueue <- websocket_models.GenericError{
+			Error:   "LastChannel",
+			Message: "A yodel needs at least one channel!",
+		}
+		return
+	}
+
+	err = ch.hub.Database.DeleteChannel(channel.ChannelID)
+	if err != nil {
+		c.OutgoingPayloadQueue <- websocket_models.GenericError{Error: "DatabaseError"}
+		return
+	}
+
+	ch.broadcastChannels(yodel, c)
+}
+
+func (ch *ChannelHandler) channelModels(yodel *database.Yodel) ([]websocket_models.Channel, error) {
+	channels, err := ch.hub.Database.GetChannels(yodel.YodelID)
+	if err != nil {
+		return nil, err
+	}
+
+	res := make([]websocket_models.Channel, 0, len(channels))
+	for _, channel := range channels {
+		res = append(res, websocket_models.Channel{
+			ChannelID: channel.ChannelID.Hex(),
+			YodelID:   channel.YodelID.Hex(),
+			Name:      channel.Name,
+		}.SetType().(websocket_models.Channel))
+	}
+	return res, nil
+}
+
+func (ch *ChannelHandler) broadcastChannels(yodel *database.Yodel, c *server.Client) {
+	channels, err := ch.channelModels(yodel)
+	if err != nil {
+		c.OutgoingPayloadQueue <- websocket_models.GenericError{Error: "DatabaseError"}
+		return
+	}
+
+	err = ch.hub.BroadcastToYodel(yodel.YodelID, websocket_models.ChannelsUpdated{
+		YodelID:  yodel.YodelID.Hex(),
+		Channels: channels,
+	})
+	if err != nil {
+		utils.ErrorLogger.Printf("Error broadcasting channels of yodel %v: %q", yodel.YodelID.Hex(), err)
+	}
+}
+
+func channelNameEmptyError() websocket_models.GenericError {
+	return websocket_models.GenericError{Error: "ChannelNameEmpty", Message: "Cannot have a channel with no name!"}
+}
+
+func channelAlreadyExistsError() websocket_models.GenericError {
+	return websocket_models.GenericError{Error: "ChannelAlreadyExists", Message: "There's already a channel with that name!"}
+}
+
+func NewChannelHandler(hub *server.ServerHub) *ChannelHandler {
+	ch := ChannelHandler{hub: hub}
+	ch.init()
+	return &ch
+}
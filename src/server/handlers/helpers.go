@@ -0,0 +1,99 @@
+package handlers
+
+import (
+	"fenix/src/database"
+	"fenix/src/server"
+	"fenix/src/websocket_models"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// Parses an ID sent by a client.  Replies with an error and returns false if it is missing or malformed.
+func parseObjectID(hex string, c *server.Client) (primitive.ObjectID, bool) {
+	if hex == "" {
+		c.OutgoingPayloadQueue <- websocket_models.GenericError{Error: "MissingIDError", Message: "ID field cannot be empty!"}
+		return primitive.NilObjectID, false
+	}
+
+	id, err := primitive.ObjectIDFromHex(hex)
+	if err != nil {
+		c.OutgoingPayloadQueue <- websocket_models.GenericError{Error: "IDFormattingError", Message: "ID field is formatted incorrectly!"}
+		return primitive.NilObjectID, false
+	}
+	return id, true
+}
+
+// Gets a channel and its yodel.  Replies with an error and returns false if either doesn't exist.
+func getChannel(hub *server.ServerHub, channelHex string, c *server.Client) (*database.Channel, *database.Yodel, bool) {
+	channelID, ok := parseObjectID(channelHex, c)
+	if !ok {
+		return nil, nil, false
+	}
+
+	channel := &database.Channel{ChannelID: channelID}
+	err := hub.Database.GetChannel(channel)
+	if err != nil {
+		c.OutgoingPayloadQueue <- websocket_models.GenericError{Error: "ChannelDoesntExistError"}
+		return nil, nil, false
+	}
+
+	yodel := &database.Yodel{YodelID: channel.YodelID}
+	err = hub.Database.GetYodel(yodel)
+	if err != nil {
+		c.OutgoingPayloadQueue <- websocket_models.GenericError{Error: "YodelDoesntExistError"}
+		return nil, nil, false
+	}
+	return channel, yodel, true
+}
+
+func hexIDs(ids []primitive.ObjectID) []string {
+	res := make([]string, 0, len(ids))
+	for _, id := range ids {
+		res = append(res, id.Hex())
+	}
+	return res
+}
+
+// Checks the client is a member of the yodel with perm.  Replies with an error and returns false otherwise.
+func requirePermission(hub *server.ServerHub, yodel *database.Yodel, c *server.Client, perm database.Permission) (*database.Member, bool) {
+	member := &database.Member{YodelID: yodel.YodelID, UserID: c.User.UserID}
+	err := hub.Database.GetMember(member)
+	if err != nil {
+		c.OutgoingPayloadQueue <- websocket_models.GenericError{Error: "NotYodelMember", Message: "You aren't a member of this yodel!"}
+		return nil, false
+	}
+
+	if !yodel.Permissions(member).Has(perm) {
+		c.OutgoingPayloadQueue <- websocket_models.GenericError{
+			Error:   "MissingPermission",
+			Message: "You need the " + perm.String() + " permission to do that!",
+		}
+		return nil, false
+	}
+	return member, true
+}
+
+// Checks the client isn't banned or muted from the yodel.  Replies with an error and returns false if they are.
+func checkNotBanned(hub *server.ServerHub, yodelID primitive.ObjectID, c *server.Client, kind database.BanKind) bool {
+	ban := &database.Ban{YodelID: yodelID, UserID: c.User.UserID, Kind: kind}
+	err := hub.Database.GetBan(ban)
+	if err != nil || !ban.Active(time.Now().UnixNano()) {
+		return true
+	}
+
+	res := websocket_models.GenericError{Error: "BannedFromYodel", Message: "You are banned from this yodel"}
+	if kind == database.BanKindMute {
+		res = websocket_models.GenericError{Error: "MutedInYodel", Message: "You are muted in this yodel"}
+	}
+	if ban.Expires != 0 {
+		res.Message += " until " + time.Unix(0, ban.Expires).UTC().Format(time.RFC3339)
+	}
+	if ban.Reason != "" {
+		res.Message += ": " + ban.Reason
+	}
+	res.Message += "!"
+
+	c.OutgoingPayloadQueue <- res
+	return false
+}
@@ -0,0 +1,245 @@
+package handlers
+
+import (
+	"crypto/rand"
+	"encoding/base64"
+	"encoding/json"
+	"fenix/src/database"
+	"fenix/src/server"
+	"fenix/src/utils"
+	"fenix/src/websocket_models"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// Random bytes in an invite code.  A multiple of 3, so the code has no padding.
+const inviteCodeBytes = 18
+
+type InviteHandler struct {
+	hub *server.ServerHub
+}
+
+func (i *InviteHandler) init() {
+	i.hub.RegisterHandler(websocket_models.InviteCreate{}.Type(), i.HandleInviteCreate)
+	i.hub.RegisterHandler(websocket_models.InviteRedeem{}.Type(), i.HandleInviteRedeem)
+	i.hub.RegisterHandler(websocket_models.InviteRevoke{}.Type(), i.HandleInviteRevoke)
+}
+
+func (i *InviteHandler) HandleInviteCreate(b []byte, c *server.Client) {
+	var create websocket_models.InviteCreate
+	err := json.Unmarshal(b, &create)
+	if err != nil {
+		utils.InfoLogger.Printf("error in decoding invitecreate json: %v", err)
+		c.OutgoingPayloadQueue <- websocket_models.GenericError{Error: "JSONDecodeError"}
+		return
+	}
+
+	yodelID, ok := parseObjectID(create.YodelID, c)
+	if !ok {
+		return
+	}
+
+	yodel := database.Yodel{YodelID: yodelID}
+	err = i.hub.Database.GetYodel(&yodel)
+	if err != nil {
+		c.OutgoingPayloadQueue <- websocket_models.GenericError{Error: "YodelDoesntExistError"}
+		return
+	}
+
+	if _, ok := requirePermission(i.hub, &yodel, c, database.PermissionInvite); !ok {
+		return
+	}
+
+	if create.Expires != 0 && create.Expires <= time.Now().UnixNano() {
+		c.OutgoingPayloadQueue <- websocket_models.GenericError{Error: "InvalidExpiry", Message: "Invites must expire in the future!"}
+		return
+	}
+	if create.MaxUses < 0 {
+		c.OutgoingPayloadQueue <- websocket_models.GenericError{Error: "InvalidMaxUses", Message: "Max uses cannot be negative!"}
+		return
+	}
+
+	invite := &database.Invite{
+		YodelID: yodelID,
+		Creator: c.User.UserID,
+		Expires: create.Expires,
+		MaxUses: create.MaxUses,
+	}
+
+	if create.Target != "" {
+		invite.Target, ok = parseObjectID(create.Target, c)
+		if !ok {
+			return
+		}
+		err = i.hub.Database.GetUser(&database.User{UserID: invite.Target})
+		if err != nil {
+			c.OutgoingPayloadQueue <- websocket_models.GenericError{Error: "UserDoesntExistError"}
+			return
+		}
+	}
+
+	code := make([]byte, inviteCodeBytes)
+	_, err = rand.Read(code)
+	if err != nil {
+		utils.ErrorLogger.Printf("Error generating invite code: %q", err)
+		c.OutgoingPayloadQueue <- websocket_models.GenericError{Error: "InternalError"}
+		return
+	}
+	invite.Code = base64.URLEncoding.EncodeToString(code)
+
+	err = i.hub.Database.InsertInvite(invite)
+	if err != nil {
+		c.OutgoingPayloadQueue <- websocket_models.GenericError{Error: "DatabaseError"}
+		return
+	}
+
+	c.OutgoingPayloadQueue <- inviteModel(invite)
+}
+
+func (i *InviteHandler) HandleInviteRedeem(b []byte, c *server.Client) {
+	var redeem websocket_models.InviteRedeem
+	err := json.Unmarshal(b, &redeem)
+	if err != nil {
+		utils.InfoLogger.Printf("error in decoding inviteredeem json: %v", err)
+		c.OutgoingPayloadQueue <- websocket_models.GenericError{Error: "JSONDecodeError"}
+		return
+	}
+
+	invite, ok := i.getInvite(redeem.Code, c)
+	if !ok {
+		return
+	}
+
+	if !checkNotBanned(i.hub, invite.YodelID, c, database.BanKindBan) {
+		return
+	}
+
+	member := &database.Member{YodelID: invite.YodelID, UserID: c.User.UserID}
+	if i.hub.Database.GetMember(member) != nil {
+		now := time.Now().UnixNano()
+		if !invite.Redeemable(c.User.UserID, now) {
+			c.OutgoingPayloadQueue <- inviteError(invite, c.User.UserID, now)
+			return
+		}
+
+		_, err = i.hub.Database.RedeemInvite(invite.Code, c.User.UserID, now)
+		if _, ok := err.(database.DoesNotExist); ok {
+			// Another client used the last redemption since GetInvite
+			c.OutgoingPayloadQueue <- websocket_models.GenericError{Error: "InviteUsedUp", Message: "This invite has no uses left!"}
+			return
+		}
+		if err == nil {
+			member.Joined = now
+			member.Role = database.RoleMember
+			err = i.hub.Database.InsertMember(member)
+		}
+		if err != nil {
+			c.OutgoingPayloadQueue <- websocket_models.GenericError{Error: "DatabaseError"}
+			return
+		}
+	}
+
+	yodel := database.Yodel{YodelID: invite.YodelID}
+	err = i.hub.Database.GetYodel(&yodel)
+	if err != nil {
+		c.OutgoingPayloadQueue <- websocket_models.GenericError{Error: "YodelDoesntExistError"}
+		return
+	}
+
+	c.OutgoingPayloadQueue <- yodelModel(&yodel)
+}
+
+func (i *InviteHandler) HandleInviteRevoke(b []byte, c *server.Client) {
+	var revoke websocket_models.InviteRevoke
+	err := json.Unmarshal(b, &revoke)
+	if err != nil {
+		utils.InfoLogger.Printf("error in decoding inviterevoke json: %v", err)
+		c.OutgoingPayloadQueue <- websocket_models.GenericError{Error: "JSONDecodeError"}
+		return
+	}
+
+	invite, ok := i.getInvite(revoke.Code, c)
+	if !ok {
+		return
+	}
+
+	yodel := database.Yodel{YodelID: invite.YodelID}
+	err = i.hub.Database.GetYodel(&yodel)
+	if err != nil {
+		c.OutgoingPayloadQueue <- websocket_models.GenericError{Error: "YodelDoesntExistError"}
+		return
+	}
+
+	// Members with the invite permission can revoke anyone's invites, and members their own.
+	// Creators who were kicked or banned can't revoke theirs.
+	if invite.Creator == c.User.UserID {
+		if i.hub.Database.GetMember(&database.Member{YodelID: yodel.YodelID, UserID: c.User.UserID}) != nil {
+			c.OutgoingPayloadQueue <- websocket_models.GenericError{Error: "NotYodelMember", Message: "You aren't a member of this yodel!"}
+			return
+		}
+	} else if _, ok := requirePermission(i.hub, &yodel, c, database.PermissionInvite); !ok {
+		return
+	}
+
+	err = i.hub.Database.RevokeInvite(invite.Code)
+	if err != nil {
+		c.OutgoingPayloadQueue <- websocket_models.GenericError{Error: "DatabaseError"}
+		return
+	}
+
+	invite.Revoked = true
+	c.OutgoingPayloadQueue <- inviteModel(invite)
+}
+
+// Looks up an invite by code.  Replies with an error and returns false if it doesn't exist.
+func (i *InviteHandler) getInvite(code string, c *server.Client) (*database.Invite, bool) {
+	if code == "" {
+		c.OutgoingPayloadQueue <- websocket_models.GenericError{Error: "MissingCodeError", Message: "Code field cannot be empty!"}
+		return nil, false
+	}
+
+	invite := &database.Invite{Code: code}
+	err := i.hub.Database.GetInvite(invite)
+	if err != nil {
+		c.OutgoingPayloadQueue <- websocket_models.GenericError{Error: "InviteDoesntExistError"}
+		return nil, false
+	}
+	return invite, true
+}
+
+// Explains why an invite can't be redeemed.
+func inviteError(invite *database.Invite, userID primitive.ObjectID, now int64) websocket_models.GenericError {
+	switch {
+	case invite.Revoked:
+		return websocket_models.GenericError{Error: "InviteRevoked", Message: "This invite has been revoked!"}
+	case invite.Expires != 0 && now >= invite.Expires:
+		return websocket_models.GenericError{Error: "InviteExpired", Message: "This invite has expired!"}
+	case invite.Target != primitive.NilObjectID && invite.Target != userID:
+		return websocket_models.GenericError{Error: "InviteNotForUser", Message: "This invite is for someone else!"}
+	default:
+		return websocket_models.GenericError{Error: "InviteUsedUp", Message: "This invite has no uses left!"}
+	}
+}
+
+func inviteModel(invite *database.Invite) websocket_models.Invite {
+	res := websocket_models.Invite{
+		Code:    invite.Code,
+		YodelID: invite.YodelID.Hex(),
+		Creator: invite.Creator.Hex(),
+		Expires: invite.Expires,
+		MaxUses: invite.MaxUses,
+		Uses:    invite.Uses,
+		Revoked: invite.Revoked,
+	}
+	if invite.Target != primitive.NilObjectID {
+		res.Target = invite.Target.Hex()
+	}
+	return res
+}
+
+func NewInviteHandler(hub *server.ServerHub) *InviteHandler {
+	i := InviteHandler{hub: hub}
+	i.init()
+	return &i
+}
@@ -6,9 +6,17 @@ import (
 	"fenix/src/server"
 	"fenix/src/utils"
 	"fenix/src/websocket_models"
+	"fmt"
 	"time"
+
+	"go.mongodb.org/mongo-driver/bson/primitive"
 )
 
+const maxSearchResults = 50
+
+// Messages sent for msg_history requests without a limit.
+const defaultHistoryPage = 50
+
 type MessageHandler struct {
 	hub *server.ServerHub
 }
@@ -16,6 +24,10 @@ type MessageHandler struct {
 func (m *MessageHandler) init() {
 	m.hub.RegisterHandler("msg_send", m.HandleSendMessage)
 	m.hub.RegisterHandler("msg_history", m.HandleMessageHistory)
+	m.hub.RegisterHandler(websocket_models.MsgSearch{}.Type(), m.HandleMessageSearch)
+	m.hub.RegisterHandler(websocket_models.MsgPin{}.Type(), m.HandleMessagePin)
+	m.hub.RegisterHandler(websocket_models.MsgUnpin{}.Type(), m.HandleMessageUnpin)
+	m.hub.RegisterHandler(websocket_models.MsgDelete{}.Type(), m.HandleMessageDelete)
 }
 
 func (m *MessageHandler) HandleSendMessage(b []byte, c *server.Client) {
@@ -34,12 +46,41 @@ func (m *MessageHandler) HandleSendMessage(b []byte, c *server.Client) {
 		}
 		return
 	}
+	if server.TooLong(msg.Message, m.hub.Sizes.MessageLength) {
+		c.OutgoingPayloadQueue <- websocket_models.GenericError{
+			Error:   "MessageTooLong",
+			Message: fmt.Sprintf("Messages can't be longer than %v characters!", m.hub.Sizes.MessageLength),
+		}
+		return
+	}
+
+	// Messages outside a channel have no yodel either
+	channel := &database.Channel{}
+	if msg.ChannelID != "" {
+		var yodel *database.Yodel
+		var ok bool
+		channel, yodel, ok = getChannel(m.hub, msg.ChannelID, c)
+		if !ok {
+			return
+		}
+
+		if !checkNotBanned(m.hub, yodel.YodelID, c, database.BanKindBan) {
+			return
+		}
+		if _, ok := requirePermission(m.hub, yodel, c, database.PermissionSend); !ok {
+			return
+		}
+		if !checkNotBanned(m.hub, yodel.YodelID, c, database.BanKindMute) {
+			return
+		}
+	}
 
 	msg_broadcast := websocket_models.MsgBroadcast{
 		Time: time.Now().UnixNano(),
 		Author: websocket_models.Author{
 			ID:       c.User.UserID.Hex(),
 			Username: c.User.Username,
+			Bot:      c.User.Bot,
 		},
 		Message: msg.Message,
 	}
@@ -50,7 +91,10 @@ func (m *MessageHandler) HandleSendMessage(b []byte, c *server.Client) {
 		Author:    database.User{
 			UserID:   c.User.UserID,
 			Username: c.User.Username,
+			Bot:      c.User.Bot,
 		},
+		YodelID:   channel.YodelID,
+		ChannelID: channel.ChannelID,
 	}
 
 	err = m.hub.Database.InsertMessage(&db_msg)
@@ -61,7 +105,17 @@ func (m *MessageHandler) HandleSendMessage(b []byte, c *server.Client) {
 	}
 
 	msg_broadcast.MessageID = db_msg.MessageID.Hex()
-	m.hub.Broadcast_payload <- msg_broadcast
+	if channel.ChannelID == primitive.NilObjectID {
+		m.hub.Broadcast_payload <- msg_broadcast
+		return
+	}
+
+	msg_broadcast.YodelID = channel.YodelID.Hex()
+	msg_broadcast.ChannelID = channel.ChannelID.Hex()
+	err = m.hub.BroadcastToYodel(channel.YodelID, msg_broadcast)
+	if err != nil {
+		utils.ErrorLogger.Printf("Error broadcasting message to channel %v: %q", channel.ChannelID.Hex(), err)
+	}
 }
 
 func (m *MessageHandler) HandleMessageHistory(b []byte, c *server.Client) {
@@ -73,7 +127,42 @@ func (m *MessageHandler) HandleMessageHistory(b []byte, c *server.Client) {
 		return
 	}
 
-	msgs, err := m.hub.Database.GetMessagesBetween(hist.From, hist.To, 50)
+	limit := hist.Limit
+	max := m.hub.Sizes.HistoryPage
+	if limit < 0 {
+		c.OutgoingPayloadQueue <- websocket_models.GenericError{
+			Error:   "InvalidHistoryLimit",
+			Message: "Can't get a negative number of messages!",
+		}
+		return
+	}
+	if max > 0 && limit > max {
+		c.OutgoingPayloadQueue <- websocket_models.GenericError{
+			Error:   "HistoryLimitTooLarge",
+			Message: fmt.Sprintf("Can't get more than %v messages at once!", max),
+		}
+		return
+	}
+	if limit == 0 {
+		limit = defaultHistoryPage
+		if max > 0 && limit > max {
+			limit = max
+		}
+	}
+
+	var msgs []*database.Message
+	if hist.ChannelID != "" {
+		channel, yodel, ok := getChannel(m.hub, hist.ChannelID, c)
+		if !ok {
+			return
+		}
+		if _, ok := requirePermission(m.hub, yodel, c, 0); !ok {
+			return
+		}
+		msgs, err = m.hub.Database.GetChannelMessagesBetween(channel.ChannelID, hist.From, hist.To, int64(limit))
+	} else {
+		msgs, err = m.hub.Database.GetMessagesBetween(hist.From, hist.To, int64(limit))
+	}
 	if err != nil {
 		utils.ErrorLogger.Printf("Error handling message history request: %q", err)
 		c.OutgoingPayloadQueue <- websocket_models.GenericError{Error: "DatabaseError"}
@@ -85,6 +174,241 @@ func (m *MessageHandler) HandleMessageHistory(b []byte, c *server.Client) {
 	c.OutgoingPayloadQueue <- hist
 }
 
+func (m *MessageHandler) HandleMessageSearch(b []byte, c *server.Client) {
+	search := &websocket_models.MsgSearch{}
+	err := json.Unmarshal(b, search)
+	if err != nil {
+		utils.InfoLogger.Printf("error decoding message search json, %v", err)
+		c.OutgoingPayloadQueue <- websocket_models.GenericError{Error: "JSONDecodeError"}
+		return
+	}
+
+	if search.Query == "" {
+		c.OutgoingPayloadQueue <- websocket_models.GenericError{
+			Error:   "SearchQueryEmpty",
+			Message: "Cannot search for an empty query!",
+		}
+		return
+	}
+
+	q := &database.SearchQuery{
+		Query: search.Query,
+		From:  search.From,
+		To:    search.To,
+		Limit: search.Limit,
+	}
+	if q.Limit <= 0 || q.Limit > maxSearchResults {
+		q.Limit = maxSearchResults
+	}
+
+	if search.Author != "" {
+		q.AuthorID, err = primitive.ObjectIDFromHex(search.Author)
+		if err != nil {
+			c.OutgoingPayloadQueue <- websocket_models.GenericError{Error: "IDFormattingError", Message: "Author field is formatted incorrectly!"}
+			return
+		}
+	}
+	// Only messages the client could read with msg_history are searched
+	if search.YodelID != "" {
+		yodelID, ok := parseObjectID(search.YodelID, c)
+		if !ok {
+			return
+		}
+		yodel := &database.Yodel{YodelID: yodelID}
+		err = m.hub.Database.GetYodel(yodel)
+		if err != nil {
+			c.OutgoingPayloadQueue <- websocket_models.GenericError{Error: "YodelDoesntExistError"}
+			return
+		}
+		if _, ok := requirePermission(m.hub, yodel, c, 0); !ok {
+			return
+		}
+		q.YodelID = yodelID
+	}
+	if search.ChannelID != "" {
+		channel, yodel, ok := getChannel(m.hub, search.ChannelID, c)
+		if !ok {
+			return
+		}
+		if _, ok := requirePermission(m.hub, yodel, c, 0); !ok {
+			return
+		}
+		q.ChannelID = channel.ChannelID
+	}
+	if search.YodelID == "" && search.ChannelID == "" {
+		members, err := m.hub.Database.GetMembersOf(c.User.UserID)
+		if err != nil {
+			utils.ErrorLogger.Printf("Error handling message search request: %q", err)
+			c.OutgoingPayloadQueue <- websocket_models.GenericError{Error: "DatabaseError"}
+			return
+		}
+		q.YodelIDs = make([]primitive.ObjectID, 0, len(members))
+		for _, member := range members {
+			q.YodelIDs = append(q.YodelIDs, member.YodelID)
+		}
+	}
+
+	results, err := m.hub.Database.SearchMessages(q)
+	if err != nil {
+		utils.ErrorLogger.Printf("Error handling message search request: %q", err)
+		c.OutgoingPayloadQueue <- websocket_models.GenericError{Error: "DatabaseError"}
+		return
+	}
+
+	search.Results = results
+
+	c.OutgoingPayloadQueue <- search
+}
+
+func (m *MessageHandler) HandleMessagePin(b []byte, c *server.Client) {
+	var pin websocket_models.MsgPin
+	err := json.Unmarshal(b, &pin)
+	if err != nil {
+		utils.InfoLogger.Printf("error decoding message pin json, %v", err)
+		c.OutgoingPayloadQueue <- websocket_models.GenericError{Error: "JSONDecodeError"}
+		return
+	}
+
+	m.setPinned(pin.MessageID, true, c)
+}
+
+func (m *MessageHandler) HandleMessageUnpin(b []byte, c *server.Client) {
+	var unpin websocket_models.MsgUnpin
+	err := json.Unmarshal(b, &unpin)
+	if err != nil {
+		utils.InfoLogger.Printf("error decoding message unpin json, %v", err)
+		c.OutgoingPayloadQueue <- websocket_models.GenericError{Error: "JSONDecodeError"}
+		return
+	}
+
+	m.setPinned(unpin.MessageID, false, c)
+}
+
+// Pins or unpins a message in its yodel, then tells the yodel's members.
+func (m *MessageHandler) setPinned(messageHex string, pinned bool, c *server.Client) {
+	messageID, ok := parseObjectID(messageHex, c)
+	if !ok {
+		return
+	}
+
+	msg := database.Message{MessageID: messageID}
+	err := m.hub.Database.GetMessage(&msg)
+	if err != nil {
+		c.OutgoingPayloadQueue <- websocket_models.GenericError{Error: "MessageDoesntExistError"}
+		return
+	}
+
+	if msg.YodelID == primitive.NilObjectID {
+		c.OutgoingPayloadQueue <- websocket_models.GenericError{
+			Error:   "MessageNotInYodel",
+			Message: "Only messages sent to a yodel can be pinned!",
+		}
+		return
+	}
+
+	yodel := database.Yodel{YodelID: msg.YodelID}
+	err = m.hub.Database.GetYodel(&yodel)
+	if err != nil {
+		c.OutgoingPayloadQueue <- websocket_models.GenericError{Error: "YodelDoesntExistError"}
+		return
+	}
+
+	if _, ok := requirePermission(m.hub, &yodel, c, database.PermissionPin); !ok {
+		return
+	}
+
+	if pinned {
+		err = m.hub.Database.PinMessage(yodel.YodelID, messageID)
+	} else {
+		err = m.hub.Database.UnpinMessage(yodel.YodelID, messageID)
+	}
+	if err == nil {
+		err = m.hub.Database.GetYodel(&yodel)
+	}
+	if err != nil {
+		utils.ErrorLogger.Printf("Error updating pins of yodel %v: %q", yodel.YodelID.Hex(), err)
+		c.OutgoingPayloadQueue <- websocket_models.GenericError{Error: "DatabaseError"}
+		return
+	}
+
+	err = m.hub.BroadcastToYodel(yodel.YodelID, websocket_models.PinsUpdated{
+		YodelID: yodel.YodelID.Hex(),
+		Pins:    hexIDs(yodel.Pins),
+	})
+	if err != nil {
+		utils.ErrorLogger.Printf("Error broadcasting pins of yodel %v: %q", yodel.YodelID.Hex(), err)
+	}
+}
+
+func (m *MessageHandler) HandleMessageDelete(b []byte, c *server.Client) {
+	var del websocket_models.MsgDelete
+	err := json.Unmarshal(b, &del)
+	if err != nil {
+		utils.InfoLogger.Printf("error decoding message delete json, %v", err)
+		c.OutgoingPayloadQueue <- websocket_models.GenericError{Error: "JSONDecodeError"}
+		return
+	}
+
+	messageID, ok := parseObjectID(del.MessageID, c)
+	if !ok {
+		return
+	}
+
+	msg := database.Message{MessageID: messageID}
+	err = m.hub.Database.GetMessage(&msg)
+	if err != nil {
+		c.OutgoingPayloadQueue <- websocket_models.GenericError{Error: "MessageDoesntExistError"}
+		return
+	}
+
+	// Authors may delete their own messages, as long as they're still in the message's yodel
+	own := msg.Author.UserID == c.User.UserID
+	if msg.YodelID == primitive.NilObjectID {
+		if !own {
+			c.OutgoingPayloadQueue <- websocket_models.GenericError{
+				Error:   "NotMessageAuthor",
+				Message: "You can only delete your own messages!",
+			}
+			return
+		}
+	} else {
+		yodel := database.Yodel{YodelID: msg.YodelID}
+		err = m.hub.Database.GetYodel(&yodel)
+		if err != nil {
+			c.OutgoingPayloadQueue <- websocket_models.GenericError{Error: "YodelDoesntExistError"}
+			return
+		}
+
+		perm := database.PermissionDeleteOthers
+		if own {
+			perm = 0
+		}
+		if _, ok := requirePermission(m.hub, &yodel, c, perm); !ok {
+			return
+		}
+	}
+
+	err = m.hub.Database.DeleteMessage(messageID)
+	if err != nil {
+		utils.ErrorLogger.Printf("Error deleting message %v: %q", messageID.Hex(), err)
+		c.OutgoingPayloadQueue <- websocket_models.GenericError{Error: "DatabaseError"}
+		return
+	}
+
+	deleted := websocket_models.MsgDeleted{MessageID: messageID.Hex()}
+	if msg.YodelID == primitive.NilObjectID {
+		m.hub.Broadcast_payload <- deleted
+		return
+	}
+
+	deleted.YodelID = msg.YodelID.Hex()
+	deleted.ChannelID = msg.ChannelID.Hex()
+	err = m.hub.BroadcastToYodel(msg.YodelID, deleted)
+	if err != nil {
+		utils.ErrorLogger.Printf("Error broadcasting deletion of message %v: %q", messageID.Hex(), err)
+	}
+}
+
 func NewMessageHandler(hub *server.ServerHub) *MessageHandler {
 	m := MessageHandler{hub: hub}
 	m.init()
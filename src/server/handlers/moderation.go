@@ -0,0 +1,228 @@
+package handlers
+
+import (
+	"encoding/json"
+	"fenix/src/database"
+	"fenix/src/server"
+	"fenix/src/utils"
+	"fenix/src/websocket_models"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+type ModerationHandler struct {
+	hub *server.ServerHub
+}
+
+func (m *ModerationHandler) init() {
+	m.hub.RegisterHandler(websocket_models.MemberKick{}.Type(), m.HandleMemberKick)
+	m.hub.RegisterHandler(websocket_models.MemberBan{}.Type(), m.HandleMemberBan)
+	m.hub.RegisterHandler(websocket_models.MemberMute{}.Type(), m.HandleMemberMute)
+}
+
+func (m *ModerationHandler) HandleMemberKick(b []byte, c *server.Client) {
+	var kick websocket_models.MemberKick
+	err := json.Unmarshal(b, &kick)
+	if err != nil {
+		utils.InfoLogger.Printf("error in decoding memberkick json: %v", err)
+		c.OutgoingPayloadQueue <- websocket_models.GenericError{Error: "JSONDecodeError"}
+		return
+	}
+
+	yodel, _, target, ok := m.moderate(kick.YodelID, kick.UserID, database.PermissionKick, c)
+	if !ok {
+		return
+	}
+	if target == nil {
+		c.OutgoingPayloadQueue <- websocket_models.GenericError{Error: "UserNotYodelMember", Message: "That user isn't a member of this yodel!"}
+		return
+	}
+
+	err = m.hub.Database.DeleteMember(target)
+	if err != nil {
+		c.OutgoingPayloadQueue <- websocket_models.GenericError{Error: "DatabaseError"}
+		return
+	}
+
+	c.Audit(server.AuditMemberKick, target.UserID.Hex(), moderationDetails(yodel, kick.Reason))
+	m.notifyRemoved(yodel, target.UserID, websocket_models.MemberRemoved{
+		YodelID: yodel.YodelID.Hex(),
+		UserID:  target.UserID.Hex(),
+		Action:  "kick",
+		Reason:  kick.Reason,
+	})
+}
+
+func (m *ModerationHandler) HandleMemberBan(b []byte, c *server.Client) {
+	var ban websocket_models.MemberBan
+	err := json.Unmarshal(b, &ban)
+	if err != nil {
+		utils.InfoLogger.Printf("error in decoding memberban json: %v", err)
+		c.OutgoingPayloadQueue <- websocket_models.GenericError{Error: "JSONDecodeError"}
+		return
+	}
+
+	now := time.Now().UnixNano()
+	if ban.Expires != 0 && ban.Expires <= now {
+		c.OutgoingPayloadQueue <- websocket_models.GenericError{Error: "InvalidExpiry", Message: "Bans must expire in the future!"}
+		return
+	}
+
+	yodel, userID, target, ok := m.moderate(ban.YodelID, ban.UserID, database.PermissionBan, c)
+	if !ok {
+		return
+	}
+
+	err = m.hub.Database.InsertBan(&database.Ban{
+		YodelID: yodel.YodelID,
+		UserID:  userID,
+		Kind:    database.BanKindBan,
+		Issuer:  c.User.UserID,
+		Reason:  ban.Reason,
+		Created: now,
+		Expires: ban.Expires,
+	})
+	if err == nil && target != nil {
+		err = m.hub.Database.DeleteMember(target)
+	}
+	if err != nil {
+		c.OutgoingPayloadQueue <- websocket_models.GenericError{Error: "DatabaseError"}
+		return
+	}
+
+	c.Audit(server.AuditMemberBan, userID.Hex(), moderationDetails(yodel, ban.Reason))
+	m.notifyRemoved(yodel, userID, websocket_models.MemberRemoved{
+		YodelID: yodel.YodelID.Hex(),
+		UserID:  userID.Hex(),
+		Action:  "ban",
+		Reason:  ban.Reason,
+		Expires: ban.Expires,
+	})
+}
+
+func (m *ModerationHandler) HandleMemberMute(b []byte, c *server.Client) {
+	var mute websocket_models.MemberMute
+	err := json.Unmarshal(b, &mute)
+	if err != nil {
+		utils.InfoLogger.Printf("error in decoding membermute json: %v", err)
+		c.OutgoingPayloadQueue <- websocket_models.GenericError{Error: "JSONDecodeError"}
+		return
+	}
+
+	now := time.Now().UnixNano()
+	if mute.Expires <= now {
+		c.OutgoingPayloadQueue <- websocket_models.GenericError{Error: "InvalidExpiry", Message: "Mutes must expire in the future!"}
+		return
+	}
+
+	yodel, _, target, ok := m.moderate(mute.YodelID, mute.UserID, database.PermissionMute, c)
+	if !ok {
+		return
+	}
+	if target == nil {
+		c.OutgoingPayloadQueue <- websocket_models.GenericError{Error: "UserNotYodelMember", Message: "That user isn't a member of this yodel!"}
+		return
+	}
+
+	err = m.hub.Database.InsertBan(&database.Ban{
+		YodelID: yodel.YodelID,
+		UserID:  target.UserID,
+		Kind:    database.BanKindMute,
+		Issuer:  c.User.UserID,
+		Reason:  mute.Reason,
+		Created: now,
+		Expires: mute.Expires,
+	})
+	if err != nil {
+		c.OutgoingPayloadQueue <- websocket_models.GenericError{Error: "DatabaseError"}
+		return
+	}
+
+	c.Audit(server.AuditMemberMute, target.UserID.Hex(), moderationDetails(yodel, mute.Reason))
+	err = m.hub.BroadcastToYodel(yodel.YodelID, websocket_models.MemberMuted{
+		YodelID: yodel.YodelID.Hex(),
+		UserID:  target.UserID.Hex(),
+		Reason:  mute.Reason,
+		Expires: mute.Expires,
+	})
+	if err != nil {
+		utils.ErrorLogger.Printf("Error broadcasting mute in yodel %v: %q", yodel.YodelID.Hex(), err)
+	}
+}
+
+// Moderation is audited with the yodel it was in, and the moderator's reason.
+func moderationDetails(yodel *database.Yodel, reason string) string {
+	if reason == "" {
+		return "yodel " + yodel.YodelID.Hex()
+	}
+	return "yodel " + yodel.YodelID.Hex() + ": " + reason
+}
+
+// Checks the client has perm in the yodel, and outranks the target user.  Returns the yodel, the target's ID,
+// and the target's membership if they are a member.  Replies with an error and returns false otherwise.
+func (m *ModerationHandler) moderate(yodelHex, userHex string, perm database.Permission, c *server.Client) (*database.Yodel, primitive.ObjectID, *database.Member, bool) {
+	yodelID, ok := parseObjectID(yodelHex, c)
+	if !ok {
+		return nil, primitive.NilObjectID, nil, false
+	}
+	userID, ok := parseObjectID(userHex, c)
+	if !ok {
+		return nil, primitive.NilObjectID, nil, false
+	}
+
+	yodel := &database.Yodel{YodelID: yodelID}
+	err := m.hub.Database.GetYodel(yodel)
+	if err != nil {
+		c.OutgoingPayloadQueue <- websocket_models.GenericError{Error: "YodelDoesntExistError"}
+		return nil, primitive.NilObjectID, nil, false
+	}
+
+	actor, ok := requirePermission(m.hub, yodel, c, perm)
+	if !ok {
+		return nil, primitive.NilObjectID, nil, false
+	}
+
+	err = m.hub.Database.GetUser(&database.User{UserID: userID})
+	if err != nil {
+		c.OutgoingPayloadQueue <- websocket_models.GenericError{Error: "UserDoesntExistError"}
+		return nil, primitive.NilObjectID, nil, false
+	}
+
+	target := &database.Member{YodelID: yodelID, UserID: userID}
+	if m.hub.Database.GetMember(target) != nil {
+		target = nil
+	}
+
+	// Owners can't be moderated, and members can only moderate members they outrank, not their equals
+	if userID == actor.UserID || yodel.Owner == userID.Hex() || (target != nil && !outranks(yodel, actor, target)) {
+		c.OutgoingPayloadQueue <- websocket_models.GenericError{
+			Error:   "CantModerateMember",
+			Message: "You can't moderate that member!",
+		}
+		return nil, primitive.NilObjectID, nil, false
+	}
+	return yodel, userID, target, true
+}
+
+// Whether actor has every permission target has, and more.
+func outranks(yodel *database.Yodel, actor, target *database.Member) bool {
+	actorPerms, targetPerms := yodel.Permissions(actor), yodel.Permissions(target)
+	return actorPerms.Has(targetPerms) && actorPerms != targetPerms
+}
+
+// Tells the yodel's members, and the removed user, that they were removed.
+func (m *ModerationHandler) notifyRemoved(yodel *database.Yodel, userID primitive.ObjectID, removed websocket_models.MemberRemoved) {
+	m.hub.SendToUser(userID, removed)
+
+	err := m.hub.BroadcastToYodel(yodel.YodelID, removed)
+	if err != nil {
+		utils.ErrorLogger.Printf("Error broadcasting %v in yodel %v: %q", removed.Action, yodel.YodelID.Hex(), err)
+	}
+}
+
+func NewModerationHandler(hub *server.ServerHub) *ModerationHandler {
+	m := ModerationHandler{hub: hub}
+	m.init()
+	return &m
+}
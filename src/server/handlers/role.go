@@ -0,0 +1,201 @@
+package handlers
+
+import (
+	"encoding/json"
+	"fenix/src/database"
+	"fenix/src/server"
+	"fenix/src/utils"
+	"fenix/src/websocket_models"
+)
+
+type RoleHandler struct {
+	hub *server.ServerHub
+}
+
+func (r *RoleHandler) init() {
+	r.hub.RegisterHandler(websocket_models.RoleCreate{}.Type(), r.HandleRoleCreate)
+	r.hub.RegisterHandler(websocket_models.RoleDelete{}.Type(), r.HandleRoleDelete)
+	r.hub.RegisterHandler(websocket_models.RoleAssign{}.Type(), r.HandleRoleAssign)
+}
+
+func (r *RoleHandler) HandleRoleCreate(b []byte, c *server.Client) {
+	var create websocket_models.RoleCreate
+	err := json.Unmarshal(b, &create)
+	if err != nil {
+		utils.InfoLogger.Printf("error in decoding rolecreate json: %v", err)
+		c.OutgoingPayloadQueue <- websocket_models.GenericError{Error: "JSONDecodeError"}
+		return
+	}
+
+	if create.Name == "" {
+		c.OutgoingPayloadQueue <- websocket_models.GenericError{Error: "RoleNameEmpty", Message: "Cannot create a role with no name!"}
+		return
+	}
+
+	yodel, actor, ok := r.manageRoles(create.YodelID, c)
+	if !ok {
+		return
+	}
+
+	if !yodel.Permissions(actor).Has(create.Permissions) {
+		c.OutgoingPayloadQueue <- permissionEscalationError()
+		return
+	}
+
+	err = r.hub.Database.InsertRole(yodel.YodelID, database.Role{Name: create.Name, Permissions: create.Permissions})
+	if _, ok := err.(database.AlreadyExists); ok {
+		c.OutgoingPayloadQueue <- websocket_models.GenericError{Error: "RoleAlreadyExists"}
+		return
+	}
+	if err != nil {
+		c.OutgoingPayloadQueue <- websocket_models.GenericError{Error: "DatabaseError"}
+		return
+	}
+
+	r.broadcastRoles(yodel, c)
+}
+
+func (r *RoleHandler) HandleRoleDelete(b []byte, c *server.Client) {
+	var del websocket_models.RoleDelete
+	err := json.Unmarshal(b, &del)
+	if err != nil {
+		utils.InfoLogger.Printf("error in decoding roledelete json: %v", err)
+		c.OutgoingPayloadQueue <- websocket_models.GenericError{Error: "JSONDecodeError"}
+		return
+	}
+
+	yodel, actor, ok := r.manageRoles(del.YodelID, c)
+	if !ok {
+		return
+	}
+
+	if database.IsBuiltinRole(del.Name) {
+		c.OutgoingPayloadQueue <- websocket_models.GenericError{Error: "BuiltinRole", Message: "Builtin roles cannot be deleted!"}
+		return
+	}
+	role, exists := yodel.GetRole(del.Name)
+	if !exists {
+		c.OutgoingPayloadQueue <- websocket_models.GenericError{Error: "RoleDoesntExistError"}
+		return
+	}
+	if !yodel.Permissions(actor).Has(role.Permissions) {
+		c.OutgoingPayloadQueue <- permissionEscalationError()
+		return
+	}
+
+	err = r.hub.Database.DeleteRole(yodel.YodelID, del.Name)
+	if err != nil {
+		c.OutgoingPayloadQueue <- websocket_models.GenericError{Error: "DatabaseError"}
+		return
+	}
+
+	r.broadcastRoles(yodel, c)
+}
+
+func (r *RoleHandler) HandleRoleAssign(b []byte, c *server.Client) {
+	var assign websocket_models.RoleAssign
+	err := json.Unmarshal(b, &assign)
+	if err != nil {
+		utils.InfoLogger.Printf("error in decoding roleassign json: %v", err)
+		c.OutgoingPayloadQueue <- websocket_models.GenericError{Error: "JSONDecodeError"}
+		return
+	}
+
+	yodel, actor, ok := r.manageRoles(assign.YodelID, c)
+	if !ok {
+		return
+	}
+
+	userID, ok := parseObjectID(assign.UserID, c)
+	if !ok {
+		return
+	}
+	target := &database.Member{YodelID: yodel.YodelID, UserID: userID}
+	err = r.hub.Database.GetMember(target)
+	if err != nil {
+		c.OutgoingPayloadQueue <- websocket_models.GenericError{Error: "UserNotYodelMember", Message: "That user isn't a member of this yodel!"}
+		return
+	}
+
+	role, exists := yodel.GetRole(assign.Role)
+	if !exists {
+		c.OutgoingPayloadQueue <- websocket_models.GenericError{Error: "RoleDoesntExistError"}
+		return
+	}
+	if role.Name == database.RoleOwner || yodel.MemberRole(target) == database.RoleOwner {
+		c.OutgoingPayloadQueue <- websocket_models.GenericError{
+			Error:   "CantChangeOwner",
+			Message: "Ownership can only be changed by transferring the yodel!",
+		}
+		return
+	}
+
+	// Members can't grant more than they have themselves, or change the roles of members who rank as high as them
+	if !yodel.Permissions(actor).Has(role.Permissions) || !outranks(yodel, actor, target) {
+		c.OutgoingPayloadQueue <- permissionEscalationError()
+		return
+	}
+
+	target.Role = role.Name
+	err = r.hub.Database.UpdateMemberRole(target)
+	if err != nil {
+		c.OutgoingPayloadQueue <- websocket_models.GenericError{Error: "DatabaseError"}
+		return
+	}
+
+	err = r.hub.BroadcastToYodel(yodel.YodelID, websocket_models.MemberRoleUpdated{
+		YodelID: yodel.YodelID.Hex(),
+		UserID:  userID.Hex(),
+		Role:    role.Name,
+	})
+	if err != nil {
+		utils.ErrorLogger.Printf("Error broadcasting role of %v in yodel %v: %q", userID.Hex(), yodel.YodelID.Hex(), err)
+	}
+}
+
+// Gets a yodel, checking the client may manage its roles.  Replies with an error and returns false otherwise.
+func (r *RoleHandler) manageRoles(yodelHex string, c *server.Client) (*database.Yodel, *database.Member, bool) {
+	yodelID, ok := parseObjectID(yodelHex, c)
+	if !ok {
+		return nil, nil, false
+	}
+
+	yodel := &database.Yodel{YodelID: yodelID}
+	err := r.hub.Database.GetYodel(yodel)
+	if err != nil {
+		c.OutgoingPayloadQueue <- websocket_models.GenericError{Error: "YodelDoesntExistError"}
+		return nil, nil, false
+	}
+
+	actor, ok := requirePermission(r.hub, yodel, c, database.PermissionManageRoles)
+	return yodel, actor, ok
+}
+
+func (r *RoleHandler) broadcastRoles(yodel *database.Yodel, c *server.Client) {
+	err := r.hub.Database.GetYodel(yodel)
+	if err != nil {
+		c.OutgoingPayloadQueue <- websocket_models.GenericError{Error: "DatabaseError"}
+		return
+	}
+
+	err = r.hub.BroadcastToYodel(yodel.YodelID, websocket_models.RolesUpdated{
+		YodelID: yodel.YodelID.Hex(),
+		Roles:   yodel.AllRoles(),
+	})
+	if err != nil {
+		utils.ErrorLogger.Printf("Error broadcasting roles of yodel %v: %q", yodel.YodelID.Hex(), err)
+	}
+}
+
+func permissionEscalationError() websocket_models.GenericError {
+	return websocket_models.GenericError{
+		Error:   "PermissionEscalation",
+		Message: "You can't manage roles with permissions you don't have!",
+	}
+}
+
+func NewRoleHandler(hub *server.ServerHub) *RoleHandler {
+	r := RoleHandler{hub: hub}
+	r.init()
+	return &r
+}
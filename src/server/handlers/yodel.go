@@ -6,10 +6,14 @@ import (
 	"fenix/src/server"
 	"fenix/src/utils"
 	"fenix/src/websocket_models"
+	"fmt"
+	"time"
 
 	"go.mongodb.org/mongo-driver/bson/primitive"
 )
 
+const maxYodelListLimit = 50
+
 type YodelHandler struct {
 	hub *server.ServerHub
 }
@@ -17,6 +21,11 @@ type YodelHandler struct {
 func (y *YodelHandler) init() {
 	y.hub.RegisterHandler(websocket_models.YodelCreate{}.Type(), y.HandleYodelCreate)
 	y.hub.RegisterHandler(websocket_models.YodelGet{}.Type(), y.HandleYodelGet)
+	y.hub.RegisterHandler(websocket_models.YodelJoin{}.Type(), y.HandleYodelJoin)
+	y.hub.RegisterHandler(websocket_models.YodelList{}.Type(), y.HandleYodelList)
+	y.hub.RegisterHandler(websocket_models.YodelUpdate{}.Type(), y.HandleYodelUpdate)
+	y.hub.RegisterHandler(websocket_models.YodelDelete{}.Type(), y.HandleYodelDelete)
+	y.hub.RegisterHandler(websocket_models.YodelTransfer{}.Type(), y.HandleYodelTransfer)
 }
 
 func (y *YodelHandler) HandleYodelCreate(b []byte, c *server.Client) {
@@ -35,23 +44,64 @@ func (y *YodelHandler) HandleYodelCreate(b []byte, c *server.Client) {
 		}
 		return
 	}
+	if server.TooLong(yodel.Name, y.hub.Sizes.YodelNameLength) {
+		c.OutgoingPayloadQueue <- yodelNameTooLongError(y.hub)
+		return
+	}
+
+	visibility := database.Visibility(yodel.Visibility)
+	if visibility == "" {
+		visibility = database.VisibilityPublic
+	}
+	if !visibility.Valid() {
+		c.OutgoingPayloadQueue <- websocket_models.GenericError{
+			Error:   "InvalidVisibility",
+			Message: "Visibility must be public, private or invite!",
+		}
+		return
+	}
 
 	db_yodel := &database.Yodel{
-		Name:  yodel.Name,
-		Owner: c.User.UserID.Hex(),
+		Name:       yodel.Name,
+		Owner:      c.User.UserID.Hex(),
+		Visibility: visibility,
 	}
 
 	err = y.hub.Database.InsertYodel(db_yodel)
+	if err != nil {
+		c.OutgoingPayloadQueue <- websocket_models.GenericError{Error: "DatabaseError"}
+		return
+	}
 
+	err = y.hub.Database.InsertMember(&database.Member{
+		YodelID: db_yodel.YodelID,
+		UserID:  c.User.UserID,
+		Joined:  time.Now().UnixNano(),
+		Role:    database.RoleOwner,
+	})
+	if err == nil {
+		err = y.hub.Database.InsertChannel(&database.Channel{
+			YodelID: db_yodel.YodelID,
+			Name:    database.DefaultChannelName,
+			Created: time.Now().UnixNano(),
+		})
+	}
 	if err != nil {
+		// A yodel without its owner's membership or a channel can't be managed, so don't leave it behind
+		utils.ErrorLogger.Printf("Error creating yodel %v: %q", db_yodel.YodelID.Hex(), err)
+		delErr := y.hub.Database.DeleteYodel(db_yodel.YodelID)
+		if delErr != nil {
+			utils.ErrorLogger.Printf("Error deleting half created yodel %v: %q", db_yodel.YodelID.Hex(), delErr)
+		}
 		c.OutgoingPayloadQueue <- websocket_models.GenericError{Error: "DatabaseError"}
 		return
 	}
 
 	c.OutgoingPayloadQueue <- websocket_models.Yodel{
-		YodelID: db_yodel.YodelID.Hex(),
-		Name:    yodel.Name,
-		Owner:   c.User.UserID.Hex(),
+		YodelID:    db_yodel.YodelID.Hex(),
+		Name:       yodel.Name,
+		Owner:      c.User.UserID.Hex(),
+		Visibility: string(visibility),
 	}
 }
 
@@ -75,14 +125,293 @@ func (y *YodelHandler) HandleYodelGet(b []byte, c *server.Client) {
 
 	yodel := database.Yodel{YodelID: yodelID}
 	err = y.hub.Database.GetYodel(&yodel)
+	if err != nil || !y.canSee(&yodel, c) {
+		c.OutgoingPayloadQueue <- websocket_models.GenericError{Error: "YodelDoesntExistError"}
+		return
+	}
+
+	c.OutgoingPayloadQueue <- yodelModel(&yodel)
+}
+
+func (y *YodelHandler) HandleYodelJoin(b []byte, c *server.Client) {
+	var join websocket_models.YodelJoin
+	err := json.Unmarshal(b, &join)
 	if err != nil {
+		c.OutgoingPayloadQueue <- websocket_models.GenericError{Error: "JSONDecodeError"}
+		utils.InfoLogger.Printf("error in decoding yodeljoin json: %q\n", err)
+		return
+	}
+
+	yodelID, ok := parseObjectID(join.YodelID, c)
+	if !ok {
+		return
+	}
+
+	yodel := database.Yodel{YodelID: yodelID}
+	err = y.hub.Database.GetYodel(&yodel)
+	if err != nil || !y.canSee(&yodel, c) {
 		c.OutgoingPayloadQueue <- websocket_models.GenericError{Error: "YodelDoesntExistError"}
 		return
 	}
 
-	c.OutgoingPayloadQueue <- websocket_models.Yodel{
-		YodelID: yodel.YodelID.Hex(),
-		Name:    yodel.Name,
+	if !checkNotBanned(y.hub, yodelID, c, database.BanKindBan) {
+		return
+	}
+
+	if yodel.GetVisibility() != database.VisibilityPublic && !y.isMember(&yodel, c) {
+		c.OutgoingPayloadQueue <- websocket_models.GenericError{
+			Error:   "InviteRequired",
+			Message: "This yodel can only be joined with an invite!",
+		}
+		return
+	}
+
+	err = y.hub.Database.InsertMember(&database.Member{
+		YodelID: yodelID,
+		UserID:  c.User.UserID,
+		Joined:  time.Now().UnixNano(),
+		Role:    database.RoleMember,
+	})
+	if err != nil {
+		c.OutgoingPayloadQueue <- websocket_models.GenericError{Error: "DatabaseError"}
+		return
+	}
+
+	c.OutgoingPayloadQueue <- yodelModel(&yodel)
+}
+
+func (y *YodelHandler) HandleYodelList(b []byte, c *server.Client) {
+	list := &websocket_models.YodelList{}
+	err := json.Unmarshal(b, list)
+	if err != nil {
+		c.OutgoingPayloadQueue <- websocket_models.GenericError{Error: "JSONDecodeError"}
+		utils.InfoLogger.Printf("error in decoding yodellist json: %q\n", err)
+		return
+	}
+
+	if list.Offset < 0 {
+		list.Offset = 0
+	}
+	if list.Limit <= 0 || list.Limit > maxYodelListLimit {
+		list.Limit = maxYodelListLimit
+	}
+
+	yodels, err := y.hub.Database.ListYodels(&database.YodelQuery{
+		Name:   list.Name,
+		Viewer: c.User.UserID,
+		Offset: list.Offset,
+		Limit:  list.Limit,
+	})
+	if err != nil {
+		utils.ErrorLogger.Printf("Error listing yodels: %q", err)
+		c.OutgoingPayloadQueue <- websocket_models.GenericError{Error: "DatabaseError"}
+		return
+	}
+
+	list.Yodels = make([]websocket_models.Yodel, 0, len(yodels))
+	for _, yodel := range yodels {
+		list.Yodels = append(list.Yodels, yodelModel(yodel).SetType().(websocket_models.Yodel))
+	}
+
+	c.OutgoingPayloadQueue <- list
+}
+
+func (y *YodelHandler) HandleYodelUpdate(b []byte, c *server.Client) {
+	var update websocket_models.YodelUpdate
+	err := json.Unmarshal(b, &update)
+	if err != nil {
+		c.OutgoingPayloadQueue <- websocket_models.GenericError{Error: "JSONDecodeError"}
+		utils.InfoLogger.Printf("error in decoding yodelupdate json: %q\n", err)
+		return
+	}
+
+	yodel, ok := y.getYodel(update.YodelID, c)
+	if !ok {
+		return
+	}
+	if _, ok := requirePermission(y.hub, yodel, c, database.PermissionManageYodel); !ok {
+		return
+	}
+
+	if update.Name != nil {
+		if *update.Name == "" {
+			c.OutgoingPayloadQueue <- websocket_models.GenericError{
+				Error:   "YodelNameEmpty",
+				Message: "Cannot remove a yodel's name!",
+			}
+			return
+		}
+		if server.TooLong(*update.Name, y.hub.Sizes.YodelNameLength) {
+			c.OutgoingPayloadQueue <- yodelNameTooLongError(y.hub)
+			return
+		}
+		yodel.Name = *update.Name
+	}
+	if update.Description != nil {
+		yodel.Description = *update.Description
+	}
+	if update.Icon != nil {
+		yodel.Icon = *update.Icon
+	}
+	if update.Topic != nil {
+		yodel.Topic = *update.Topic
+	}
+
+	err = y.hub.Database.UpdateYodel(yodel)
+	if err != nil {
+		c.OutgoingPayloadQueue <- websocket_models.GenericError{Error: "DatabaseError"}
+		return
+	}
+
+	y.broadcastUpdated(yodel, c)
+}
+
+func (y *YodelHandler) HandleYodelDelete(b []byte, c *server.Client) {
+	var del websocket_models.YodelDelete
+	err := json.Unmarshal(b, &del)
+	if err != nil {
+		c.OutgoingPayloadQueue <- websocket_models.GenericError{Error: "JSONDecodeError"}
+		utils.InfoLogger.Printf("error in decoding yodeldelete json: %q\n", err)
+		return
+	}
+
+	yodel, ok := y.getYodel(del.YodelID, c)
+	if !ok || !y.requireOwner(yodel, c) {
+		return
+	}
+
+	// Members are deleted with the yodel, so find who to tell first
+	members, err := y.hub.Database.GetMembers(yodel.YodelID)
+	if err == nil {
+		err = y.hub.Database.DeleteYodel(yodel.YodelID)
+	}
+	if err != nil {
+		c.OutgoingPayloadQueue <- websocket_models.GenericError{Error: "DatabaseError"}
+		return
+	}
+
+	c.Audit(server.AuditYodelDelete, yodel.YodelID.Hex(), yodel.Name)
+	for _, m := range members {
+		y.hub.SendToUser(m.UserID, websocket_models.YodelDeleted{YodelID: yodel.YodelID.Hex()})
+	}
+}
+
+func (y *YodelHandler) HandleYodelTransfer(b []byte, c *server.Client) {
+	var transfer websocket_models.YodelTransfer
+	err := json.Unmarshal(b, &transfer)
+	if err != nil {
+		c.OutgoingPayloadQueue <- websocket_models.GenericError{Error: "JSONDecodeError"}
+		utils.InfoLogger.Printf("error in decoding yodeltransfer json: %q\n", err)
+		return
+	}
+
+	yodel, ok := y.getYodel(transfer.YodelID, c)
+	if !ok || !y.requireOwner(yodel, c) {
+		return
+	}
+
+	userID, ok := parseObjectID(transfer.UserID, c)
+	if !ok {
+		return
+	}
+	if userID == c.User.UserID {
+		c.OutgoingPayloadQueue <- websocket_models.GenericError{Error: "AlreadyYodelOwner", Message: "You already own this yodel!"}
+		return
+	}
+
+	newOwner := &database.Member{YodelID: yodel.YodelID, UserID: userID}
+	err = y.hub.Database.GetMember(newOwner)
+	if err != nil {
+		c.OutgoingPayloadQueue <- websocket_models.GenericError{Error: "UserNotYodelMember", Message: "That user isn't a member of this yodel!"}
+		return
+	}
+
+	err = y.hub.Database.TransferYodel(yodel.YodelID, c.User.UserID.Hex(), userID.Hex())
+	if _, ok := err.(database.DoesNotExist); ok {
+		c.OutgoingPayloadQueue <- websocket_models.GenericError{Error: "NotYodelOwner", Message: "Only the yodel's owner can do that!"}
+		return
+	}
+	if err == nil {
+		newOwner.Role = database.RoleOwner
+		err = y.hub.Database.UpdateMemberRole(newOwner)
+	}
+	if err == nil {
+		// The previous owner keeps every permission, but can be demoted by the new owner
+		err = y.hub.Database.UpdateMemberRole(&database.Member{YodelID: yodel.YodelID, UserID: c.User.UserID, Role: database.RoleAdmin})
+	}
+	if err == nil {
+		err = y.hub.Database.GetYodel(yodel)
+	}
+	if err != nil {
+		c.OutgoingPayloadQueue <- websocket_models.GenericError{Error: "DatabaseError"}
+		return
+	}
+
+	c.Audit(server.AuditYodelTransfer, yodel.YodelID.Hex(), "to "+userID.Hex())
+	y.broadcastUpdated(yodel, c)
+}
+
+// Gets a yodel the client can see.  Replies with an error and returns false otherwise.
+func (y *YodelHandler) getYodel(yodelHex string, c *server.Client) (*database.Yodel, bool) {
+	yodelID, ok := parseObjectID(yodelHex, c)
+	if !ok {
+		return nil, false
+	}
+
+	yodel := &database.Yodel{YodelID: yodelID}
+	err := y.hub.Database.GetYodel(yodel)
+	if err != nil || !y.canSee(yodel, c) {
+		c.OutgoingPayloadQueue <- websocket_models.GenericError{Error: "YodelDoesntExistError"}
+		return nil, false
+	}
+	return yodel, true
+}
+
+// Checks the client owns the yodel.  Replies with an error and returns false otherwise.
+func (y *YodelHandler) requireOwner(yodel *database.Yodel, c *server.Client) bool {
+	if yodel.Owner != c.User.UserID.Hex() {
+		c.OutgoingPayloadQueue <- websocket_models.GenericError{Error: "NotYodelOwner", Message: "Only the yodel's owner can do that!"}
+		return false
+	}
+	return true
+}
+
+func (y *YodelHandler) broadcastUpdated(yodel *database.Yodel, c *server.Client) {
+	err := y.hub.BroadcastToYodel(yodel.YodelID, websocket_models.YodelUpdated{
+		Yodel: yodelModel(yodel).SetType().(websocket_models.Yodel),
+	})
+	if err != nil {
+		utils.ErrorLogger.Printf("Error broadcasting update of yodel %v: %q", yodel.YodelID.Hex(), err)
+	}
+}
+
+func (y *YodelHandler) isMember(yodel *database.Yodel, c *server.Client) bool {
+	return y.hub.Database.GetMember(&database.Member{YodelID: yodel.YodelID, UserID: c.User.UserID}) == nil
+}
+
+// Private yodels are hidden from everyone but their members.
+func (y *YodelHandler) canSee(yodel *database.Yodel, c *server.Client) bool {
+	return yodel.GetVisibility() != database.VisibilityPrivate || y.isMember(yodel, c)
+}
+
+func yodelModel(yodel *database.Yodel) websocket_models.Yodel {
+	return websocket_models.Yodel{
+		YodelID:     yodel.YodelID.Hex(),
+		Name:        yodel.Name,
+		Owner:       yodel.Owner,
+		Description: yodel.Description,
+		Icon:        yodel.Icon,
+		Topic:       yodel.Topic,
+		Pins:        hexIDs(yodel.Pins),
+		Visibility:  string(yodel.GetVisibility()),
+		Roles:       yodel.AllRoles(),
+	}
+}
+
+func yodelNameTooLongError(hub *server.ServerHub) websocket_models.GenericError {
+	return websocket_models.GenericError{
+		Error:   "YodelNameTooLong",
+		Message: fmt.Sprintf("Yodel names can't be longer than %v characters!", hub.Sizes.YodelNameLength),
 	}
 }
 
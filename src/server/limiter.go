@@ -0,0 +1,267 @@
+package server
+
+import (
+	"fenix/src/database"
+	"fenix/src/utils"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+)
+
+// Failed attempts by one client or against one account.
+type LimitState struct {
+	Failures int
+	// No attempts are allowed until then.
+	Until time.Time
+}
+
+// Holds LoginLimiter state.  Shared stores let servers behind a load balancer limit clients together.
+type LimiterStore interface {
+	// Gets a key's state, returning false if it has none or it expired.
+	Get(key string) (LimitState, bool, error)
+	// Stores a key's state until ttl passes.
+	Put(key string, s LimitState, ttl time.Duration) error
+	Delete(key string) error
+}
+
+// How a LoginLimiter slows down one kind of key.
+type LimitPolicy struct {
+	// Failures allowed before backing off.
+	FreeFailures int
+	// Wait after the first failure past FreeFailures, doubling with each failure after it.
+	BaseDelay time.Duration
+	MaxDelay  time.Duration
+	// Failures after which the key is locked out for LockoutDuration.  0 never locks out.
+	LockoutFailures int
+	LockoutDuration time.Duration
+	// Failures are forgotten once this long passes without another.
+	Window time.Duration
+}
+
+// How long a key has to wait after its nth failure.
+func (p LimitPolicy) Delay(failures int) time.Duration {
+	if p.LockoutFailures > 0 && failures >= p.LockoutFailures {
+		return p.LockoutDuration
+	}
+	if failures <= p.FreeFailures {
+		return 0
+	}
+
+	d := p.BaseDelay
+	for i := p.FreeFailures + 1; i < failures && d < p.MaxDelay; i++ {
+		d *= 2
+	}
+	if d > p.MaxDelay {
+		d = p.MaxDelay
+	}
+	return d
+}
+
+// Slows down password guessing with exponential backoff per IP and per username,
+// and locks out usernames after repeated failures.  Registrations are limited per IP.
+type LoginLimiter struct {
+	Store    LimiterStore
+	IP       LimitPolicy
+	Username LimitPolicy
+	// Every registration counts as a failure, so one IP can't make unlimited accounts.
+	Register LimitPolicy
+}
+
+func NewLoginLimiter(store LimiterStore) *LoginLimiter {
+	return &LoginLimiter{
+		Store: store,
+		IP: LimitPolicy{
+			FreeFailures: 10,
+			BaseDelay:    time.Second,
+			MaxDelay:     5 * time.Minute,
+			Window:       time.Hour,
+		},
+		Username: LimitPolicy{
+			FreeFailures:    3,
+			BaseDelay:       time.Second,
+			MaxDelay:        time.Minute,
+			LockoutFailures: 10,
+			LockoutDuration: 15 * time.Minute,
+			Window:          time.Hour,
+		},
+		Register: LimitPolicy{
+			FreeFailures: 10,
+			BaseDelay:    time.Minute,
+			MaxDelay:     time.Hour,
+			Window:       24 * time.Hour,
+		},
+	}
+}
+
+// Returns how long a key has to wait before its next attempt, or 0 if it can try now.
+func (l *LoginLimiter) wait(key string) (time.Duration, error) {
+	s, ok, err := l.Store.Get(key)
+	if err != nil || !ok {
+		return 0, err
+	}
+
+	d := time.Until(s.Until)
+	if d < 0 {
+		return 0, nil
+	}
+	return d, nil
+}
+
+// Records a failed attempt by a key.
+func (l *LoginLimiter) fail(key string, p LimitPolicy) error {
+	s, _, err := l.Store.Get(key)
+	if err != nil {
+		return err
+	}
+
+	s.Failures++
+	delay := p.Delay(s.Failures)
+	s.Until = time.Now().Add(delay)
+
+	ttl := p.Window
+	if delay > ttl {
+		ttl = delay
+	}
+	return l.Store.Put(key, s, ttl)
+}
+
+// Keys are prefixed by their kind, so they can share a store.
+func ipLimitKey(r *http.Request) string {
+	return "ip:" + clientIP(r)
+}
+
+// Usernames are keyed by their canonical name, so spellings that reach the same account,
+// like "ｇopher123" for "gopher123", share its failures.
+func (hub *ServerHub) usernameLimitKey(username string) string {
+	return "user:" + hub.Accounts.canonicalName(normalizeUsername(username))
+}
+
+func registerLimitKey(r *http.Request) string {
+	return "register:" + ipLimitKey(r)
+}
+
+// Writes 429 with Retry-After and returns false if any of keys are waiting.
+// Fails open if the store errors, so logins keep working.
+func (hub *ServerHub) checkLimits(w http.ResponseWriter, keys ...string) bool {
+	var longest time.Duration
+	for _, key := range keys {
+		d, err := hub.Limiter.wait(key)
+		if err != nil {
+			utils.ErrorLogger.Printf("Error checking login limit of %q: %q", key, err)
+		}
+		if d > longest {
+			longest = d
+		}
+	}
+	if longest == 0 {
+		return true
+	}
+
+	// Round up, so clients that wait exactly Retry-After aren't refused again
+	seconds := (longest + time.Second - 1) / time.Second
+	setCORSHeaders(w)
+	w.Header().Set("Retry-After", strconv.FormatInt(int64(seconds), 10))
+	w.WriteHeader(http.StatusTooManyRequests)
+	return false
+}
+
+// Records a failed password or 2FA code for a username from r.
+func (hub *ServerHub) loginFailed(r *http.Request, username string) {
+	err := hub.Limiter.fail(ipLimitKey(r), hub.Limiter.IP)
+	if err == nil {
+		err = hub.Limiter.fail(hub.usernameLimitKey(username), hub.Limiter.Username)
+	}
+	if err != nil {
+		utils.ErrorLogger.Printf("Error recording failed login for %q: %q", username, err)
+	}
+}
+
+// Forgets a username's failures once they log in.  The IP's failures are kept,
+// or an attacker could reset them by logging into their own account.
+func (hub *ServerHub) loginSucceeded(username string) {
+	err := hub.Limiter.Store.Delete(hub.usernameLimitKey(username))
+	if err != nil {
+		utils.ErrorLogger.Printf("Error resetting login limit of %q: %q", username, err)
+	}
+}
+
+// Checks a username and password from r, with login limits.
+// Writes 429 or 403 and returns false if they can't log in.
+func (hub *ServerHub) authenticate(w http.ResponseWriter, r *http.Request, username, password string) (*database.User, bool) {
+	if !hub.checkLimits(w, ipLimitKey(r), hub.usernameLimitKey(username)) {
+		return nil, false
+	}
+
+	u, ok := hub.checkPassword(username, password)
+	if !ok {
+		target := username
+		if u != nil {
+			target = u.UserID.Hex()
+		}
+		hub.audit(r, AuditLoginFailure, nil, target, r.URL.Path)
+		hub.loginFailed(r, username)
+		w.WriteHeader(http.StatusForbidden)
+		return nil, false
+	}
+	if u.Disabled {
+		hub.audit(r, AuditLoginFailure, nil, u.UserID.Hex(), "disabled")
+		w.WriteHeader(http.StatusForbidden)
+		return nil, false
+	}
+	return u, true
+}
+
+type memoryLimit struct {
+	state   LimitState
+	expires time.Time
+}
+
+// Process-local LimiterStore.
+type MemoryLimiterStore struct {
+	lock   sync.Mutex
+	limits map[string]memoryLimit
+	swept  time.Time
+}
+
+func NewMemoryLimiterStore() *MemoryLimiterStore {
+	return &MemoryLimiterStore{limits: make(map[string]memoryLimit)}
+}
+
+func (s *MemoryLimiterStore) Get(key string) (LimitState, bool, error) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	l, ok := s.limits[key]
+	if !ok || !time.Now().Before(l.expires) {
+		return LimitState{}, false, nil
+	}
+	return l.state, true, nil
+}
+
+func (s *MemoryLimiterStore) Put(key string, state LimitState, ttl time.Duration) error {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	now := time.Now()
+	// Sweep forgotten keys every so often, so clients that stop trying don't pile up
+	if now.Sub(s.swept) > time.Minute {
+		s.swept = now
+		for k, l := range s.limits {
+			if !now.Before(l.expires) {
+				delete(s.limits, k)
+			}
+		}
+	}
+
+	s.limits[key] = memoryLimit{state: state, expires: now.Add(ttl)}
+	return nil
+}
+
+func (s *MemoryLimiterStore) Delete(key string) error {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	delete(s.limits, key)
+	return nil
+}
@@ -0,0 +1,184 @@
+package server
+
+import (
+	"fenix/src/websocket_models"
+	"strings"
+	"sync"
+	"time"
+)
+
+// A token bucket: Burst messages can be sent at once, and one more is allowed every Every.
+type RateLimit struct {
+	Burst int
+	Every time.Duration
+}
+
+// Whether the limit lets anything through unchecked.
+func (l RateLimit) unlimited() bool {
+	return l.Burst <= 0 || l.Every <= 0
+}
+
+// Rate limits for websocket messages, by message type.
+type MessageLimits struct {
+	Default RateLimit
+	// Overrides Default for some message types.  A zero RateLimit doesn't limit the type.
+	Types map[string]RateLimit
+}
+
+func (m MessageLimits) limit(messageType string) RateLimit {
+	if l, ok := m.Types[messageType]; ok {
+		return l
+	}
+	return m.Default
+}
+
+type tokenBucket struct {
+	tokens float64
+	last   time.Time
+}
+
+// Takes a token from b, returning 0 if there was one, or how long until there's one.
+func (b *tokenBucket) take(l RateLimit, now time.Time) time.Duration {
+	if b.last.IsZero() {
+		b.tokens = float64(l.Burst)
+	} else {
+		b.tokens += float64(now.Sub(b.last)) / float64(l.Every)
+		if b.tokens > float64(l.Burst) {
+			b.tokens = float64(l.Burst)
+		}
+	}
+	b.last = now
+
+	if b.tokens >= 1 {
+		b.tokens--
+		return 0
+	}
+	return time.Duration((1 - b.tokens) * float64(l.Every))
+}
+
+// Whether b would be full by now, so it can be forgotten.
+func (b *tokenBucket) full(l RateLimit, now time.Time) bool {
+	return now.Sub(b.last) >= time.Duration(l.Burst)*l.Every
+}
+
+// Limits how fast clients can send websocket messages, per session and per user.
+// Users' buckets outlive their sessions, so reconnecting doesn't refill them.
+type MessageLimiter struct {
+	Session MessageLimits
+	User    MessageLimits
+	// Over-limit messages allowed within StrikeWindow before a client is disconnected.  0 never disconnects.
+	Strikes      int
+	StrikeWindow time.Duration
+
+	lock  sync.Mutex
+	users map[string]*tokenBucket
+	swept time.Time
+}
+
+func NewMessageLimiter() *MessageLimiter {
+	sends := websocket_models.MsgSend{}.Type()
+	searches := websocket_models.MsgSearch{}.Type()
+	return &MessageLimiter{
+		Session: MessageLimits{
+			Default: RateLimit{Burst: 20, Every: 100 * time.Millisecond},
+			Types: map[string]RateLimit{
+				sends:    {Burst: 10, Every: 250 * time.Millisecond},
+				searches: {Burst: 5, Every: time.Second},
+			},
+		},
+		User: MessageLimits{
+			Default: RateLimit{Burst: 60, Every: 100 * time.Millisecond},
+			Types: map[string]RateLimit{
+				sends:    {Burst: 30, Every: 500 * time.Millisecond},
+				searches: {Burst: 10, Every: 2 * time.Second},
+			},
+		},
+		Strikes:      30,
+		StrikeWindow: time.Minute,
+	}
+}
+
+// Takes a token from a user's bucket for a message type, returning how long until there's one.
+func (l *MessageLimiter) takeUser(userID, messageType string, now time.Time) time.Duration {
+	limit := l.User.limit(messageType)
+	if limit.unlimited() {
+		return 0
+	}
+
+	l.lock.Lock()
+	defer l.lock.Unlock()
+
+	if l.users == nil {
+		l.users = make(map[string]*tokenBucket)
+	}
+	// Sweep full buckets every so often, so users who go quiet don't pile up
+	if now.Sub(l.swept) > time.Minute {
+		l.swept = now
+		for k, b := range l.users {
+			_, t, _ := strings.Cut(k, " ")
+			if b.full(l.User.limit(t), now) {
+				delete(l.users, k)
+			}
+		}
+	}
+
+	key := userID + " " + messageType
+	b, ok := l.users[key]
+	if !ok {
+		b = &tokenBucket{}
+		l.users[key] = b
+	}
+	return b.take(limit, now)
+}
+
+// A client's own buckets and strikes.  Only touched by the goroutine reading its websocket.
+type clientLimits struct {
+	buckets     map[string]*tokenBucket
+	strikes     int
+	strikeStart time.Time
+}
+
+// Checks a message from c against its session's and user's limits.
+// Returns 0 if it can be handled, or how long until it could be.
+func (c *Client) takeMessage(messageType string) time.Duration {
+	l := c.hub.RateLimits
+	if l == nil {
+		return 0
+	}
+	now := time.Now()
+
+	wait := time.Duration(0)
+	if limit := l.Session.limit(messageType); !limit.unlimited() {
+		if c.limits.buckets == nil {
+			c.limits.buckets = make(map[string]*tokenBucket)
+		}
+		b, ok := c.limits.buckets[messageType]
+		if !ok {
+			b = &tokenBucket{}
+			c.limits.buckets[messageType] = b
+		}
+		wait = b.take(limit, now)
+	}
+	// The user's bucket is only drawn from once the session's allows the message,
+	// so a session that's already over its limit can't drain the user's too
+	if wait == 0 {
+		wait = l.takeUser(c.User.UserID.Hex(), messageType, now)
+	}
+	return wait
+}
+
+// Counts an over-limit message, returning true once c has had too many and should be disconnected.
+func (c *Client) strike() bool {
+	l := c.hub.RateLimits
+	if l.Strikes <= 0 {
+		return false
+	}
+
+	now := time.Now()
+	if now.Sub(c.limits.strikeStart) > l.StrikeWindow {
+		c.limits.strikeStart = now
+		c.limits.strikes = 0
+	}
+	c.limits.strikes++
+	return c.limits.strikes > l.Strikes
+}
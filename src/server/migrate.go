@@ -0,0 +1,78 @@
+package server
+
+import (
+	"fenix/src/database"
+	"fenix/src/utils"
+	"fmt"
+)
+
+// Version Migrate brings databases up to.  Bump it when Migrate gets a new step.
+const SchemaVersion = 2
+
+// Brings db up to date: creates its collections and indexes, then gives users from before
+// canonical names one, by accounts' rules.  Every step is safe to run repeatedly.
+// Version 2 folds look-alike letters into canonical names, so older ones are worked out again.
+func Migrate(db database.Database, accounts *AccountPolicy) error {
+	version, err := db.GetSchemaVersion()
+	if err != nil {
+		return err
+	}
+	if version > SchemaVersion {
+		return fmt.Errorf("the database is at schema version %v, which is newer than this server's %v", version, SchemaVersion)
+	}
+
+	err = db.CreateSchema()
+	if err != nil {
+		return err
+	}
+	if version < 2 {
+		err = db.ClearCanonicalNames()
+		if err != nil {
+			return err
+		}
+	}
+	err = backfillCanonicalNames(db, accounts)
+	if err != nil {
+		return err
+	}
+
+	utils.InfoLogger.Printf("Migrated the database from schema version %v to %v", version, SchemaVersion)
+	return db.SetSchemaVersion(SchemaVersion)
+}
+
+// Users whose canonical name is already taken, like "Bob" after "bob", are left without one.
+// They can still log in with their username.
+func backfillCanonicalNames(db database.Database, accounts *AccountPolicy) error {
+	users, err := db.GetUsersWithoutCanonicalName()
+	if err != nil {
+		return err
+	}
+
+	for _, u := range users {
+		u.CanonicalName = accounts.canonicalName(normalizeUsername(u.Username))
+		err = db.SetCanonicalName(u)
+		if _, ok := err.(database.AlreadyExists); ok {
+			utils.WarningLogger.Printf("User %v (%q) looks like another user's name, so has no canonical name", u.UserID.Hex(), u.Username)
+			continue
+		}
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Returns an error if db needs migrating before it can be served.
+func CheckSchema(db database.Database) error {
+	version, err := db.GetSchemaVersion()
+	if err != nil {
+		return err
+	}
+	if version < SchemaVersion {
+		return fmt.Errorf("the database is at schema version %v, and needs migrating to %v with `fenix migrate`", version, SchemaVersion)
+	}
+	if version > SchemaVersion {
+		utils.WarningLogger.Printf("The database is at schema version %v, which is newer than this server's %v", version, SchemaVersion)
+	}
+	return nil
+}
@@ -0,0 +1,40 @@
+package server
+
+import (
+	"fenix/src/database"
+	"fenix/src/utils"
+	"fmt"
+	"io"
+	"sync"
+	"time"
+)
+
+// Delivers messages to users outside of their websocket, such as password reset tokens.
+type Notifier interface {
+	Notify(u *database.User, subject, body string) error
+}
+
+// Notifier that writes notifications to a file, for running Fenix locally.
+type LogNotifier struct {
+	lock sync.Mutex
+	out  io.Writer
+}
+
+// Notifications are written to out, or the info log if out is nil.
+func NewLogNotifier(out io.Writer) *LogNotifier {
+	return &LogNotifier{out: out}
+}
+
+func (n *LogNotifier) Notify(u *database.User, subject, body string) error {
+	if n.out == nil {
+		utils.InfoLogger.Printf("Notification for %v: %v: %v", u.Username, subject, body)
+		return nil
+	}
+
+	n.lock.Lock()
+	defer n.lock.Unlock()
+
+	_, err := fmt.Fprintf(n.out, "%v\nTo: %v (%v)\nSubject: %v\n\n%v\n\n",
+		time.Now().UTC().Format(time.RFC3339), u.Username, u.UserID.Hex(), subject, body)
+	return err
+}
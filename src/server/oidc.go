@@ -0,0 +1,481 @@
+package server
+
+import (
+	"crypto"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"fenix/src/database"
+	"fenix/src/utils"
+	"fmt"
+	"math/big"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	// How long a user has to sign in with the provider after /auth/oidc/start.
+	oidcLoginLifetime = 10 * time.Minute
+	// ID tokens that expired less than this long ago are still accepted, for clocks that have drifted.
+	oidcClockSkew = time.Minute
+	// How many usernames with a random suffix are tried when a user's preferred one is taken.
+	oidcUsernameAttempts = 5
+)
+
+// Ways of finding the account for an ID token.
+const (
+	// Only accounts made by signing in with the provider before.
+	OIDCMatchSubject = "subject"
+	// Also accounts with the same verified email, for providers whose subjects aren't stable.
+	OIDCMatchEmail = "email"
+)
+
+// An OpenID Connect provider users can sign in with, through /auth/oidc/start and /auth/oidc/callback.
+// Its endpoints and keys are discovered from the issuer when first needed.
+type OIDCProvider struct {
+	Issuer       string
+	ClientID     string
+	ClientSecret string
+	// Where the provider sends users back to.  It must pass the code and state on to /auth/oidc/callback.
+	RedirectURL string
+	// OIDCMatchSubject or OIDCMatchEmail.
+	Match  string
+	Client *http.Client
+
+	lock      *sync.Mutex
+	discovery *oidcDiscovery
+	keys      map[string]*rsa.PublicKey
+}
+
+type oidcDiscovery struct {
+	Issuer                string `json:"issuer"`
+	AuthorizationEndpoint string `json:"authorization_endpoint"`
+	TokenEndpoint         string `json:"token_endpoint"`
+	JWKSURI               string `json:"jwks_uri"`
+}
+
+type oidcClaims struct {
+	Issuer            string       `json:"iss"`
+	Subject           string       `json:"sub"`
+	Audience          oidcAudience `json:"aud"`
+	Expires           int64        `json:"exp"`
+	Nonce             string       `json:"nonce"`
+	Email             string       `json:"email"`
+	EmailVerified     bool         `json:"email_verified"`
+	PreferredUsername string       `json:"preferred_username"`
+}
+
+// The aud claim can be a string or a list of them.
+type oidcAudience []string
+
+func (a *oidcAudience) UnmarshalJSON(b []byte) error {
+	var s string
+	if json.Unmarshal(b, &s) == nil {
+		*a = []string{s}
+		return nil
+	}
+	return json.Unmarshal(b, (*[]string)(a))
+}
+
+func NewOIDCProvider(issuer, clientID, clientSecret, redirectURL string) *OIDCProvider {
+	return &OIDCProvider{
+		Issuer:       strings.TrimSuffix(issuer, "/"),
+		ClientID:     clientID,
+		ClientSecret: clientSecret,
+		RedirectURL:  redirectURL,
+		Match:        OIDCMatchSubject,
+		Client:       &http.Client{Timeout: 10 * time.Second},
+		lock:         &sync.Mutex{},
+	}
+}
+
+func (p *OIDCProvider) getJSON(u string, v interface{}) error {
+	res, err := p.Client.Get(u)
+	if err != nil {
+		return err
+	}
+	defer res.Body.Close()
+
+	if res.StatusCode != http.StatusOK {
+		return fmt.Errorf("GET %s: %s", u, res.Status)
+	}
+	return json.NewDecoder(res.Body).Decode(v)
+}
+
+func (p *OIDCProvider) discover() (*oidcDiscovery, error) {
+	p.lock.Lock()
+	defer p.lock.Unlock()
+
+	if p.discovery != nil {
+		return p.discovery, nil
+	}
+
+	d := &oidcDiscovery{}
+	err := p.getJSON(p.Issuer+"/.well-known/openid-configuration", d)
+	if err != nil {
+		return nil, err
+	}
+	if d.Issuer != p.Issuer {
+		return nil, fmt.Errorf("provider says its issuer is %q, not %q", d.Issuer, p.Issuer)
+	}
+	p.discovery = d
+	return d, nil
+}
+
+// Gets the key an ID token was signed with.  Keys are fetched again for kids that aren't known yet,
+// so the provider can rotate them.
+func (p *OIDCProvider) key(kid string) (*rsa.PublicKey, error) {
+	d, err := p.discover()
+	if err != nil {
+		return nil, err
+	}
+
+	p.lock.Lock()
+	defer p.lock.Unlock()
+
+	if k, ok := p.keys[kid]; ok {
+		return k, nil
+	}
+
+	var jwks struct {
+		Keys []struct {
+			Kty string `json:"kty"`
+			Kid string `json:"kid"`
+			N   string `json:"n"`
+			E   string `json:"e"`
+		} `json:"keys"`
+	}
+	err = p.getJSON(d.JWKSURI, &jwks)
+	if err != nil {
+		return nil, err
+	}
+
+	keys := map[string]*rsa.PublicKey{}
+	for _, k := range jwks.Keys {
+		if k.Kty != "RSA" {
+			continue
+		}
+		n, err := base64.RawURLEncoding.DecodeString(k.N)
+		if err != nil {
+			continue
+		}
+		e, err := base64.RawURLEncoding.DecodeString(k.E)
+		if err != nil || len(e) == 0 || len(e) > 8 {
+			continue
+		}
+		e = append(make([]byte, 8-len(e)), e...)
+		keys[k.Kid] = &rsa.PublicKey{N: new(big.Int).SetBytes(n), E: int(binary.BigEndian.Uint64(e))}
+	}
+	p.keys = keys
+
+	if k, ok := keys[kid]; ok {
+		return k, nil
+	}
+	return nil, fmt.Errorf("no key with kid %q", kid)
+}
+
+// Checks an ID token's RS256 signature, issuer, audience, expiry and nonce, returning its claims.
+func (p *OIDCProvider) verify(idToken, nonce string) (*oidcClaims, error) {
+	parts := strings.Split(idToken, ".")
+	if len(parts) != 3 {
+		return nil, errors.New("ID token isn't a JWT")
+	}
+
+	var header struct {
+		Alg string `json:"alg"`
+		Kid string `json:"kid"`
+	}
+	b, err := base64.RawURLEncoding.DecodeString(parts[0])
+	if err == nil {
+		err = json.Unmarshal(b, &header)
+	}
+	if err != nil {
+		return nil, err
+	}
+	if header.Alg != "RS256" {
+		return nil, fmt.Errorf("ID token is signed with %q, not RS256", header.Alg)
+	}
+
+	key, err := p.key(header.Kid)
+	if err != nil {
+		return nil, err
+	}
+	sig, err := base64.RawURLEncoding.DecodeString(parts[2])
+	if err != nil {
+		return nil, err
+	}
+	sum := sha256.Sum256([]byte(parts[0] + "." + parts[1]))
+	err = rsa.VerifyPKCS1v15(key, crypto.SHA256, sum[:], sig)
+	if err != nil {
+		return nil, err
+	}
+
+	claims := &oidcClaims{}
+	b, err = base64.RawURLEncoding.DecodeString(parts[1])
+	if err == nil {
+		err = json.Unmarshal(b, claims)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	if claims.Issuer != p.Issuer {
+		return nil, fmt.Errorf("ID token is from %q, not %q", claims.Issuer, p.Issuer)
+	}
+	audience := false
+	for _, a := range claims.Audience {
+		audience = audience || a == p.ClientID
+	}
+	if !audience {
+		return nil, errors.New("ID token isn't for this client")
+	}
+	if time.Now().Add(-oidcClockSkew).Unix() >= claims.Expires {
+		return nil, errors.New("ID token has expired")
+	}
+	if claims.Nonce != nonce {
+		return nil, errors.New("ID token has the wrong nonce")
+	}
+	if claims.Subject == "" {
+		return nil, errors.New("ID token has no subject")
+	}
+	return claims, nil
+}
+
+// Trades an authorization code for an ID token at the provider's token endpoint.
+func (p *OIDCProvider) exchange(code, verifier string) (string, error) {
+	d, err := p.discover()
+	if err != nil {
+		return "", err
+	}
+
+	form := url.Values{}
+	form.Set("grant_type", "authorization_code")
+	form.Set("code", code)
+	form.Set("redirect_uri", p.RedirectURL)
+	form.Set("client_id", p.ClientID)
+	form.Set("code_verifier", verifier)
+	req, err := http.NewRequest("POST", d.TokenEndpoint, strings.NewReader(form.Encode()))
+	if err != nil {
+		return "", err
+	}
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	if p.ClientSecret != "" {
+		req.SetBasicAuth(url.QueryEscape(p.ClientID), url.QueryEscape(p.ClientSecret))
+	}
+
+	res, err := p.Client.Do(req)
+	if err != nil {
+		return "", err
+	}
+	defer res.Body.Close()
+
+	var body struct {
+		IDToken string `json:"id_token"`
+	}
+	if res.StatusCode != http.StatusOK {
+		return "", fmt.Errorf("token endpoint: %s", res.Status)
+	}
+	err = json.NewDecoder(res.Body).Decode(&body)
+	if err != nil {
+		return "", err
+	}
+	if body.IDToken == "" {
+		return "", errors.New("token endpoint didn't return an ID token")
+	}
+	return body.IDToken, nil
+}
+
+// Logins in progress share the ticket store with upgrade tickets, under a different key.
+func oidcTicketKey(state string) string {
+	return "oidc:" + state
+}
+
+func randomString() (string, error) {
+	b := make([]byte, 32)
+	_, err := rand.Read(b)
+	if err != nil {
+		return "", err
+	}
+	return base64.RawURLEncoding.EncodeToString(b), nil
+}
+
+// HTTP method to start signing in with the OIDC provider.  Redirects to the provider's login page.
+func (hub *ServerHub) StartOIDC(w http.ResponseWriter, r *http.Request) {
+	if hub.OIDC == nil {
+		w.WriteHeader(http.StatusNotFound)
+		return
+	}
+
+	d, err := hub.OIDC.discover()
+	if err != nil {
+		utils.ErrorLogger.Printf("Error discovering OIDC provider %q: %q", hub.OIDC.Issuer, err)
+		w.WriteHeader(http.StatusBadGateway)
+		return
+	}
+
+	state, err := randomString()
+	var verifier, nonce string
+	if err == nil {
+		verifier, err = randomString()
+	}
+	if err == nil {
+		nonce, err = randomString()
+	}
+	if err == nil {
+		err = hub.Tickets.Put(oidcTicketKey(state), verifier+" "+nonce, oidcLoginLifetime)
+	}
+	if err != nil {
+		utils.ErrorLogger.Printf("Error starting OIDC login: %q", err)
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+
+	challenge := sha256.Sum256([]byte(verifier))
+	q := url.Values{}
+	q.Set("response_type", "code")
+	q.Set("client_id", hub.OIDC.ClientID)
+	q.Set("redirect_uri", hub.OIDC.RedirectURL)
+	q.Set("scope", "openid email profile")
+	q.Set("state", state)
+	q.Set("nonce", nonce)
+	q.Set("code_challenge", base64.RawURLEncoding.EncodeToString(challenge[:]))
+	q.Set("code_challenge_method", "S256")
+
+	sep := "?"
+	if strings.Contains(d.AuthorizationEndpoint, "?") {
+		sep = "&"
+	}
+	http.Redirect(w, r, d.AuthorizationEndpoint+sep+q.Encode(), http.StatusFound)
+}
+
+// HTTP method the provider's redirect is passed on to.  Finds or makes the user the ID token is for,
+// then responds with a ticket like /login.
+func (hub *ServerHub) OIDCCallback(w http.ResponseWriter, r *http.Request) {
+	if r.Method == "OPTIONS" {
+		writeCORSPreflight(w)
+		return
+	}
+	if hub.OIDC == nil {
+		w.WriteHeader(http.StatusNotFound)
+		return
+	}
+
+	q := r.URL.Query()
+	state, code := q.Get("state"), q.Get("code")
+	if state == "" {
+		w.WriteHeader(http.StatusBadRequest)
+		return
+	}
+
+	login, ok, err := hub.Tickets.Take(oidcTicketKey(state))
+	if err != nil {
+		utils.ErrorLogger.Printf("Error getting OIDC login: %q", err)
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+	// The provider sends an error instead of a code if the user didn't sign in
+	if !ok || code == "" {
+		w.WriteHeader(http.StatusForbidden)
+		return
+	}
+	verifier, nonce, _ := strings.Cut(login, " ")
+
+	idToken, err := hub.OIDC.exchange(code, verifier)
+	if err != nil {
+		utils.InfoLogger.Printf("Error exchanging OIDC code: %q", err)
+		hub.audit(r, AuditLoginFailure, nil, "", "oidc code")
+		w.WriteHeader(http.StatusForbidden)
+		return
+	}
+	claims, err := hub.OIDC.verify(idToken, nonce)
+	if err != nil {
+		utils.InfoLogger.Printf("Error verifying ID token: %q", err)
+		hub.audit(r, AuditLoginFailure, nil, "", "oidc ID token")
+		w.WriteHeader(http.StatusForbidden)
+		return
+	}
+
+	u, err := hub.oidcUser(claims)
+	if err != nil {
+		utils.ErrorLogger.Printf("Error getting user for OIDC subject %q: %q", claims.Subject, err)
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+	if u.Disabled {
+		hub.audit(r, AuditLoginFailure, nil, u.UserID.Hex(), "disabled")
+		w.WriteHeader(http.StatusForbidden)
+		return
+	}
+
+	hub.audit(r, AuditLoginSuccess, u, u.UserID.Hex(), "oidc")
+	hub.issueToken(w, u)
+}
+
+// Finds the user for an ID token, making them if they haven't signed in before.
+func (hub *ServerHub) oidcUser(c *oidcClaims) (*database.User, error) {
+	subject := hub.OIDC.Issuer + "|" + c.Subject
+	email := ""
+	if c.EmailVerified {
+		email = strings.ToLower(c.Email)
+	}
+
+	u := &database.User{OIDCSubject: subject}
+	err := hub.Database.GetUser(u)
+	if _, ok := err.(database.DoesNotExist); ok && hub.OIDC.Match == OIDCMatchEmail && email != "" {
+		u = &database.User{Email: email}
+		err = hub.Database.GetUser(u)
+	}
+	if _, ok := err.(database.DoesNotExist); !ok {
+		return u, err
+	}
+
+	name := c.PreferredUsername
+	if name == "" {
+		name, _, _ = strings.Cut(c.Email, "@")
+	}
+	name, _, verr := hub.Accounts.CheckUsername(name)
+	if verr != nil {
+		name = "user"
+	}
+
+	username, canonical := name, hub.Accounts.canonicalName(name)
+	for i := 0; i < oidcUsernameAttempts; i++ {
+		if i > 0 || hub.usernameTaken(username, canonical) {
+			username, canonical, err = hub.suffixedUsername(name)
+			if err != nil {
+				return nil, err
+			}
+		}
+
+		u = &database.User{Username: username, CanonicalName: canonical, OIDCSubject: subject, Email: email}
+		err = hub.Database.InsertUser(u)
+		if _, ok := err.(database.AlreadyExists); !ok {
+			return u, err
+		}
+	}
+	return nil, errors.New("couldn't find a free username")
+}
+
+// Adds a random number to a username, shortening it to fit if needed.
+func (hub *ServerHub) suffixedUsername(username string) (string, string, error) {
+	b := make([]byte, 2)
+	_, err := rand.Read(b)
+	if err != nil {
+		return "", "", err
+	}
+	suffix := fmt.Sprintf("_%d", binary.BigEndian.Uint16(b))
+
+	base := []rune(username)
+	if max := hub.Accounts.MaxUsernameLength - len(suffix); len(base) > max {
+		base = base[:max]
+	}
+	username = string(base) + suffix
+	return username, hub.Accounts.canonicalName(username), nil
+}
@@ -8,6 +8,7 @@ import (
 	"fenix/src/utils"
 	"fenix/src/websocket_models"
 	"sync"
+	"time"
 )
 
 func NewHub(wg *utils.WaitGroupCounter, database database.Database) *server.ServerHub {
@@ -17,12 +18,23 @@ func NewHub(wg *utils.WaitGroupCounter, database database.Database) *server.Serv
 		Handlers:          make(map[string]func([]byte, *server.Client)),
 		Wg:                wg,
 		Database:          database,
-		Tickets:  &sync.Map{},
+		Tickets:           server.NewMemoryTicketStore(),
+		Notifier:          server.NewLogNotifier(nil),
+		Limiter:           server.NewLoginLimiter(server.NewMemoryLimiterStore()),
+		Accounts:          server.NewAccountPolicy(),
+		Sizes:             server.NewSizeLimits(),
+		CORS:              server.NewCORSPolicy(),
+		RateLimits:        server.NewMessageLimiter(),
+		Started:           time.Now(),
 	}
 
 	handlers.NewMessageHandler(&hub)
 	handlers.NewIdentificationHandler(&hub)
 	handlers.NewYodelHandler(&hub)
+	handlers.NewInviteHandler(&hub)
+	handlers.NewRoleHandler(&hub)
+	handlers.NewModerationHandler(&hub)
+	handlers.NewChannelHandler(&hub)
 	hub.Ctx, hub.Shutdown = context.WithCancel(context.Background())
 
 	go hub.Run()
@@ -18,14 +18,6 @@ import (
 	"go.mongodb.org/mongo-driver/bson/primitive"
 )
 
-var upgrader = websocket.Upgrader{
-	ReadBufferSize:  1024,
-	WriteBufferSize: 1024,
-	CheckOrigin: func(r *http.Request) bool {
-		return true
-	},
-}
-
 var version = "0.1"
 
 // Main server class.  Should be initialized with NewHub()
@@ -37,7 +29,22 @@ type ServerHub struct {
 	Handlers          map[string]func([]byte, *Client)
 	Wg                *utils.WaitGroupCounter
 	Database          database.Database
-	Tickets           *sync.Map
+	Tickets           TicketStore
+	Notifier          Notifier
+	Limiter           *LoginLimiter
+	Accounts          *AccountPolicy
+	Sizes             *SizeLimits
+	// Origins browsers can make requests and open websockets from.
+	CORS *CORSPolicy
+	// Limits how fast clients send websocket messages.  Nil doesn't limit them.
+	RateLimits *MessageLimiter
+	// Provider for single sign-on.  Nil turns off /auth/oidc/*.
+	OIDC *OIDCProvider
+	// When the hub was made, for uptime.
+	Started time.Time
+	// 32 byte AES key for secrets kept in the database, such as TOTP secrets.
+	// Nil turns off 2FA enrolment, as secrets encrypted with a key that isn't kept couldn't be read after a restart.
+	SecretKey []byte
 }
 
 // Registers a message handler to be called when a type of message is recieved.
@@ -61,9 +68,9 @@ func (hub *ServerHub) broadcast() (context.Context, context.CancelFunc) {
 			case d := <-hub.Broadcast_payload:
 				hub.Clients.Range(
 					func(key, value interface{}) bool {
-					go func() {value.(*Client).OutgoingPayloadQueue <- d}()
-					return true
-				})
+						go func() { value.(*Client).OutgoingPayloadQueue <- d }()
+						return true
+					})
 
 			case <-ctx.Done():
 				hub.Wg.Done("BroadcastPayloadLoop")
@@ -75,6 +82,35 @@ func (hub *ServerHub) broadcast() (context.Context, context.CancelFunc) {
 	return ctx, cancel
 }
 
+// Sends payload to every connected member of a yodel.
+func (hub *ServerHub) BroadcastToYodel(yodelID primitive.ObjectID, payload websocket_models.JSONModel) error {
+	members, err := hub.Database.GetMembers(yodelID)
+	if err != nil {
+		return err
+	}
+
+	for _, m := range members {
+		hub.SendToUser(m.UserID, payload)
+	}
+	return nil
+}
+
+// Sends payload to a user, if they're connected.
+func (hub *ServerHub) SendToUser(userID primitive.ObjectID, payload websocket_models.JSONModel) {
+	if value, ok := hub.Clients.Load(userID.Hex()); ok {
+		go func(c *Client) { c.OutgoingPayloadQueue <- payload }(value.(*Client))
+	}
+}
+
+// Closes a user's connection, if they're connected.  Returns whether they were.
+func (hub *ServerHub) disconnect(userID primitive.ObjectID) bool {
+	if value, ok := hub.Clients.Load(userID.Hex()); ok {
+		value.(*Client).Close("")
+		return true
+	}
+	return false
+}
+
 // Starts all goroutines for server to run.
 // Will stop all goroutines when hub.Shutdown() is called.
 func (hub *ServerHub) Run() {
@@ -101,6 +137,18 @@ func (hub *ServerHub) Run() {
 // Function to upgrade http connection to websocket
 // Also makes new client.
 func (hub *ServerHub) upgrade(w http.ResponseWriter, r *http.Request) {
+	if token, ok := bearerToken(r); ok {
+		t, ok := hub.checkAccessToken(token)
+		if !ok {
+			hub.audit(r, AuditTicketReject, nil, "", "access token")
+			w.WriteHeader(http.StatusForbidden)
+			return
+		}
+		hub.audit(r, AuditTokenConnect, &database.User{UserID: t.UserID}, t.TokenID.Hex(), t.Name)
+		hub.connect(w, r, t.UserID, t)
+		return
+	}
+
 	ticket := r.URL.Query().Get("t")
 	userID := r.URL.Query().Get("id")
 
@@ -108,69 +156,82 @@ func (hub *ServerHub) upgrade(w http.ResponseWriter, r *http.Request) {
 		w.WriteHeader(http.StatusBadRequest)
 		return
 	}
-	vTicket, ok := hub.Tickets.LoadAndDelete(userID)
+	vTicket, ok, err := hub.Tickets.Take(userID)
+	if err != nil {
+		utils.ErrorLogger.Printf("Error taking ticket: %q", err)
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
 
 	if !ok {
+		hub.audit(r, AuditTicketReject, nil, userID, "no ticket")
 		w.WriteHeader(http.StatusForbidden)
 		return
 	}
 
-	res := subtle.ConstantTimeCompare([]byte(vTicket.(string)), []byte(ticket))
+	res := subtle.ConstantTimeCompare([]byte(vTicket), []byte(ticket))
 	if res == 0 {
+		hub.audit(r, AuditTicketReject, nil, userID, "wrong ticket")
 		w.WriteHeader(http.StatusForbidden)
 		return
 	}
 
-	conn, err := websocket.Upgrade(w, r, http.Header{}, 1024, 1024)
+	id, err := primitive.ObjectIDFromHex(userID)
 	if err != nil {
-		utils.InfoLogger.Printf("Error upgrading connection to websocket: %q", err)
+		utils.InfoLogger.Printf("Error parsing objectid: %q", err)
 		w.WriteHeader(http.StatusInternalServerError)
 		return
 	}
+	hub.audit(r, AuditTicketRedeem, &database.User{UserID: id}, userID, "")
+	hub.connect(w, r, id, nil)
+}
 
-	id, err := primitive.ObjectIDFromHex(userID)
+// Upgrades to a websocket for a user.  Connections made with an access token are limited to its scopes.
+func (hub *ServerHub) connect(w http.ResponseWriter, r *http.Request, userID primitive.ObjectID, token *database.AccessToken) {
+	u := database.User{UserID: userID}
+	err := hub.Database.GetUser(&u)
+	if err != nil || u.Disabled {
+		w.WriteHeader(http.StatusForbidden)
+		return
+	}
+
+	upgrader := websocket.Upgrader{
+		ReadBufferSize:  1024,
+		WriteBufferSize: 1024,
+		CheckOrigin:     hub.upgraderCheckOrigin,
+	}
+	// The upgrader writes its own error response
+	conn, err := upgrader.Upgrade(w, r, nil)
 	if err != nil {
-		utils.InfoLogger.Printf("Error parsing objectid: %q", err)
-		w.WriteHeader(http.StatusInternalServerError)
+		utils.InfoLogger.Printf("Error upgrading connection to websocket: %q", err)
 		return
 	}
 
-	u := database.User{UserID: id}
-	hub.Database.GetUser(&u)
-	client := &Client{hub: hub, conn: conn, User: database.User{Username: u.Username}}
+	client := &Client{hub: hub, conn: conn, User: database.User{Username: u.Username}, AccessToken: token, ip: clientIP(r)}
 	client.New()
 	hub.Clients.Store(client.User.UserID.Hex(), client)
 }
 
-func (hub *ServerHub) createToken(u *database.User) []byte {
+// Issues a one-shot upgrade ticket for u, returning it with the session token as JSON.
+func (hub *ServerHub) createToken(u *database.User, session string) ([]byte, error) {
 	ticket := make([]byte, 32)
 
 	rand.Read(ticket)
 	encodedTicket := base64.URLEncoding.EncodeToString(ticket)
 
-	hub.Tickets.Store(u.UserID.Hex(), encodedTicket)
-	go func() {
-		time.Sleep(5 * time.Second)
-		hub.Tickets.Delete(u.UserID)
-	}()
-
-	b, err := json.Marshal(map[string]interface{}{"userID": u.UserID.Hex(), "username": u.Username, "ticket": encodedTicket})
+	err := hub.Tickets.Put(u.UserID.Hex(), encodedTicket, ticketLifetime)
 	if err != nil {
-		utils.InfoLogger.Printf("Error marshalling JSON: %q", err)
-		return nil
+		return nil, err
 	}
-	return b
+
+	return json.Marshal(map[string]interface{}{"userID": u.UserID.Hex(), "username": u.Username, "ticket": encodedTicket, "token": session})
 }
 
 // HTTP method to log in and upgrade a user's connection.
 // Uses BasicAuth header
 func (hub *ServerHub) Login(w http.ResponseWriter, r *http.Request) {
 	if r.Method == "OPTIONS" {
-		w.Header().Set("Access-Control-Allow-Origin", "*")
-		w.Header().Set("Access-Control-Allow-Methods", "POST")
-		w.Header().Set("Access-Control-Max-Age", "86400")
-		w.Header().Set("Access-Control-Allow-Headers", "Content-Type")
-		w.WriteHeader(http.StatusNoContent)
+		writeCORSPreflight(w)
 		return
 	}
 
@@ -189,86 +250,85 @@ func (hub *ServerHub) Login(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	u := &database.User{Username: username}
-
-	err = hub.Database.GetUser(u)
-
-	if err != nil {
-		w.WriteHeader(http.StatusForbidden)
+	u, ok := hub.authenticate(w, r, username, password)
+	if !ok {
 		return
 	}
+	hub.upgradePassword(u, password)
 
-	p := &database.User{Password: []byte(password), Salt: u.Salt, Username: username}
-	p.HashPassword()
-
-	res := subtle.ConstantTimeCompare(p.Password, u.Password)
-	if res != 1 {
-		w.WriteHeader(http.StatusForbidden)
+	// Users with 2FA only get a ticket after sending a code to /login/2fa
+	if u.TOTPEnabled {
+		hub.startMFALogin(w, u)
 		return
 	}
 
-	b := hub.createToken(u)
-	w.Header().Set("Access-Control-Allow-Origin", "*")
-	w.Header().Set("Access-Control-Allow-Methods", "POST")
-	w.Header().Set("Access-Control-Max-Age", "86400")
-	w.Header().Set("Access-Control-Allow-Headers", "Content-Type")
-	w.Write(b)
+	hub.loginSucceeded(username)
+	hub.audit(r, AuditLoginSuccess, u, u.UserID.Hex(), "")
+	hub.issueToken(w, u)
 }
 
 func (hub *ServerHub) Register(w http.ResponseWriter, r *http.Request) {
 	if r.Method == "OPTIONS" {
-		w.Header().Set("Access-Control-Allow-Origin", "*")
-		w.Header().Set("Access-Control-Allow-Methods", "POST")
-		w.Header().Set("Access-Control-Max-Age", "86400")
-		w.Header().Set("Access-Control-Allow-Headers", "Content-Type")
-		w.WriteHeader(http.StatusNoContent)
+		writeCORSPreflight(w)
 		return
 	}
 	decoder := json.NewDecoder(r.Body)
 	var body map[string]string
 	err := decoder.Decode(&body)
 	if err != nil {
-		w.WriteHeader(http.StatusBadRequest)
+		writeJSONError(w, http.StatusBadRequest, &ValidationError{"JSONDecodeError", "The body must be a JSON object of strings!"})
 		return
 	}
 	username, uok := body["username"]
 	password, pok := body["password"]
 
 	if !uok || !pok {
-		w.WriteHeader(http.StatusBadRequest)
+		writeJSONError(w, http.StatusBadRequest, &ValidationError{"MissingField", "A username and password are needed!"})
 		return
 	}
 
-	u := &database.User{Username: username}
-	err = hub.Database.GetUser(u)
+	username, canonical, verr := hub.Accounts.CheckUsername(username)
+	if verr == nil {
+		verr = hub.Accounts.CheckPassword(username, password)
+	}
+	if verr != nil {
+		writeJSONError(w, http.StatusBadRequest, verr)
+		return
+	}
 
-	// If user exists, dont let the client re-register a user.
-	if err == nil {
-		w.WriteHeader(http.StatusConflict)
+	if !hub.checkLimits(w, registerLimitKey(r)) {
 		return
 	}
+	// Usernames that are taken count too, so they can't be probed for quickly
+	err = hub.Limiter.fail(registerLimitKey(r), hub.Limiter.Register)
+	if err != nil {
+		utils.ErrorLogger.Printf("Error recording registration: %q", err)
+	}
 
-	u.Salt = make([]byte, 16)
+	taken := &ValidationError{"UsernameTaken", "That username, or one that looks like it, is taken!"}
+	// If user exists, dont let the client re-register a user.
+	if hub.usernameTaken(username, canonical) {
+		writeJSONError(w, http.StatusConflict, taken)
+		return
+	}
 
-	rand.Read(u.Salt)
+	u := &database.User{Username: username, CanonicalName: canonical}
 	u.Password = []byte(password)
 	u.HashPassword()
 
 	err = hub.Database.InsertUser(u)
-
+	if _, ok := err.(database.AlreadyExists); ok {
+		writeJSONError(w, http.StatusConflict, taken)
+		return
+	}
 	if err != nil {
 		utils.ErrorLogger.Printf("Error inserting user (%q:%q): %q", u.UserID.Hex(), u.Username, err)
 		w.WriteHeader(http.StatusInternalServerError)
 		return
 	}
 
-	b := hub.createToken(u)
-	w.Header().Set("Access-Control-Allow-Origin", "*")
-	w.Header().Set("Access-Control-Allow-Methods", "POST")
-	w.Header().Set("Access-Control-Max-Age", "86400")
-	w.Header().Set("Access-Control-Allow-Headers", "Content-Type")
-	w.Write(b)
-
+	hub.audit(r, AuditRegister, u, u.UserID.Hex(), "")
+	hub.issueToken(w, u)
 }
 
 // Serves http server on addr.
@@ -277,15 +337,19 @@ func (hub *ServerHub) Serve(addr string) {
 		Handler: hub.HTTPRequestHandler(),
 		Addr:    addr,
 	}
+	hub.listen("ServerHub_ListenAndServe", addr, srv.ListenAndServe)
+}
 
-	defer hub.Wg.Done("ServerHub_ListenAndServe")
+// Runs serve in the waitgroup as name, until it stops.
+func (hub *ServerHub) listen(name, addr string, serve func() error) {
+	defer hub.Wg.Done(name)
 
-	err := hub.Wg.Add(1, "ServerHub_ListenAndServe")
+	err := hub.Wg.Add(1, name)
 	if err != nil {
 		utils.ErrorLogger.Fatalf("Error adding goroutine to waitgroup: %v", err)
 	}
 	utils.InfoLogger.Printf("Listening on %v", addr)
-	err = srv.ListenAndServe()
+	err = serve()
 	if err != nil && err != http.ErrServerClosed {
 		panic(err)
 	}
@@ -294,12 +358,70 @@ func (hub *ServerHub) Serve(addr string) {
 // Handler func for incoming requests.
 func (hub *ServerHub) HTTPRequestHandler() http.HandlerFunc {
 	return func(w http.ResponseWriter, r *http.Request) {
+		if !hub.checkOrigin(w, r) {
+			return
+		}
+
 		if r.URL.Path == "/login" {
 			hub.Login(w, r)
+		} else if r.URL.Path == "/login/2fa" {
+			hub.LoginMFA(w, r)
 		} else if r.URL.Path == "/register" {
 			hub.Register(w, r)
 		} else if r.URL.Path == "/upgrade" {
 			hub.upgrade(w, r)
+		} else if r.URL.Path == "/token/refresh" {
+			hub.RefreshToken(w, r)
+		} else if r.URL.Path == "/logout" {
+			hub.Logout(w, r)
+		} else if r.URL.Path == "/password/change" {
+			hub.ChangePassword(w, r)
+		} else if r.URL.Path == "/password/reset/request" {
+			hub.RequestPasswordReset(w, r)
+		} else if r.URL.Path == "/password/reset" {
+			hub.ResetPassword(w, r)
+		} else if r.URL.Path == "/account/delete" {
+			hub.DeleteAccount(w, r)
+		} else if r.URL.Path == "/bots/create" {
+			hub.CreateBot(w, r)
+		} else if r.URL.Path == "/bots/list" {
+			hub.ListBots(w, r)
+		} else if r.URL.Path == "/tokens/create" {
+			hub.CreateAccessToken(w, r)
+		} else if r.URL.Path == "/tokens/list" {
+			hub.ListAccessTokens(w, r)
+		} else if r.URL.Path == "/tokens/revoke" {
+			hub.RevokeAccessToken(w, r)
+		} else if r.URL.Path == "/auth/oidc/start" {
+			hub.StartOIDC(w, r)
+		} else if r.URL.Path == "/auth/oidc/callback" {
+			hub.OIDCCallback(w, r)
+		} else if r.URL.Path == "/admin/users/list" {
+			hub.AdminListUsers(w, r)
+		} else if r.URL.Path == "/admin/users/disable" {
+			hub.AdminDisableUser(w, r)
+		} else if r.URL.Path == "/admin/users/enable" {
+			hub.AdminEnableUser(w, r)
+		} else if r.URL.Path == "/admin/users/delete" {
+			hub.AdminDeleteUser(w, r)
+		} else if r.URL.Path == "/admin/sessions/list" {
+			hub.AdminListSessions(w, r)
+		} else if r.URL.Path == "/admin/sessions/disconnect" {
+			hub.AdminDisconnectSession(w, r)
+		} else if r.URL.Path == "/admin/yodels/list" {
+			hub.AdminListYodels(w, r)
+		} else if r.URL.Path == "/admin/yodels/delete" {
+			hub.AdminDeleteYodel(w, r)
+		} else if r.URL.Path == "/admin/stats" {
+			hub.AdminStats(w, r)
+		} else if r.URL.Path == "/admin/audit" {
+			hub.AdminQueryAudit(w, r)
+		} else if r.URL.Path == "/2fa/enroll" {
+			hub.EnrollTwoFactor(w, r)
+		} else if r.URL.Path == "/2fa/confirm" {
+			hub.ConfirmTwoFactor(w, r)
+		} else if r.URL.Path == "/2fa/disable" {
+			hub.DisableTwoFactor(w, r)
 		} else {
 			w.WriteHeader(http.StatusNotFound)
 		}
@@ -0,0 +1,176 @@
+package server
+
+import (
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"fenix/src/database"
+	"fenix/src/utils"
+	"net/http"
+	"time"
+)
+
+// How long a session token can be exchanged for tickets after logging in.
+const sessionLifetime = 30 * 24 * time.Hour
+
+// Makes a random token for sessions and password resets.
+func newToken() (string, error) {
+	b := make([]byte, 32)
+	_, err := rand.Read(b)
+	if err != nil {
+		return "", err
+	}
+	return base64.URLEncoding.EncodeToString(b), nil
+}
+
+// Tokens are stored hashed, so a leaked database can't be used to log in.
+func hashToken(token string) string {
+	sum := sha256.Sum256([]byte(token))
+	return hex.EncodeToString(sum[:])
+}
+
+// Starts a session for u, returning its token.
+func (hub *ServerHub) newSession(u *database.User) (string, error) {
+	token, err := newToken()
+	if err != nil {
+		return "", err
+	}
+
+	now := time.Now()
+	err = hub.Database.InsertSession(&database.Session{
+		TokenHash: hashToken(token),
+		UserID:    u.UserID,
+		Created:   now.UnixNano(),
+		Expires:   now.Add(sessionLifetime).UnixNano(),
+	})
+	if err != nil {
+		return "", err
+	}
+	return token, nil
+}
+
+// Starts a session for a user who has logged in, responding with it and an upgrade ticket.
+func (hub *ServerHub) issueToken(w http.ResponseWriter, u *database.User) {
+	token, err := hub.newSession(u)
+	if err != nil {
+		utils.ErrorLogger.Printf("Error starting session for %q: %q", u.Username, err)
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+
+	b, err := hub.createToken(u, token)
+	if err != nil {
+		utils.ErrorLogger.Printf("Error creating ticket for %q: %q", u.Username, err)
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+	setCORSHeaders(w)
+	w.Write(b)
+}
+
+// Writes status with a JSON body explaining the error, for clients to show.
+func writeJSONError(w http.ResponseWriter, status int, e *ValidationError) {
+	b, err := json.Marshal(e)
+	if err != nil {
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+	setCORSHeaders(w)
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	w.Write(b)
+}
+
+// Decodes a JSON request body.  Writes 400 and returns false if it's invalid or any of fields are missing.
+func readJSONBody(w http.ResponseWriter, r *http.Request, fields ...string) (map[string]string, bool) {
+	var body map[string]string
+	err := json.NewDecoder(r.Body).Decode(&body)
+	if err != nil {
+		w.WriteHeader(http.StatusBadRequest)
+		return nil, false
+	}
+	for _, field := range fields {
+		if body[field] == "" {
+			w.WriteHeader(http.StatusBadRequest)
+			return nil, false
+		}
+	}
+	return body, true
+}
+
+// Gets the user of an active session token.  Writes 403 and returns false if there isn't one.
+func (hub *ServerHub) sessionUser(w http.ResponseWriter, token string) (*database.User, bool) {
+	session := &database.Session{TokenHash: hashToken(token)}
+	err := hub.Database.GetSession(session)
+	if err != nil {
+		w.WriteHeader(http.StatusForbidden)
+		return nil, false
+	}
+	if !session.Active(time.Now().UnixNano()) {
+		hub.Database.DeleteSession(session.TokenHash)
+		w.WriteHeader(http.StatusForbidden)
+		return nil, false
+	}
+
+	u := &database.User{UserID: session.UserID}
+	err = hub.Database.GetUser(u)
+	if err != nil || u.Disabled {
+		w.WriteHeader(http.StatusForbidden)
+		return nil, false
+	}
+	return u, true
+}
+
+// HTTP method to exchange a session token for an upgrade ticket, without sending the password again.
+func (hub *ServerHub) RefreshToken(w http.ResponseWriter, r *http.Request) {
+	if r.Method == "OPTIONS" {
+		writeCORSPreflight(w)
+		return
+	}
+
+	body, ok := readJSONBody(w, r, "token")
+	if !ok {
+		return
+	}
+	token := body["token"]
+
+	u, ok := hub.sessionUser(w, token)
+	if !ok {
+		return
+	}
+
+	b, err := hub.createToken(u, token)
+	if err != nil {
+		utils.ErrorLogger.Printf("Error creating ticket for %q: %q", u.Username, err)
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+	setCORSHeaders(w)
+	w.Write(b)
+}
+
+// HTTP method to revoke a session token.
+func (hub *ServerHub) Logout(w http.ResponseWriter, r *http.Request) {
+	if r.Method == "OPTIONS" {
+		writeCORSPreflight(w)
+		return
+	}
+
+	body, ok := readJSONBody(w, r, "token")
+	if !ok {
+		return
+	}
+	token := body["token"]
+
+	err := hub.Database.DeleteSession(hashToken(token))
+	if _, ok := err.(database.DoesNotExist); err != nil && !ok {
+		utils.ErrorLogger.Printf("Error deleting session: %q", err)
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+
+	setCORSHeaders(w)
+	w.WriteHeader(http.StatusNoContent)
+}
@@ -0,0 +1,28 @@
+package server
+
+import "unicode/utf8"
+
+// Limits on how much clients can send, or ask for, at once.  0 doesn't limit.
+type SizeLimits struct {
+	// Largest websocket frame read, in bytes.  Bigger frames close the connection.
+	FrameBytes int64
+	// Lengths are counted in characters.
+	MessageLength   int
+	YodelNameLength int
+	// Most messages one msg_history request can ask for.
+	HistoryPage int
+}
+
+func NewSizeLimits() *SizeLimits {
+	return &SizeLimits{
+		FrameBytes:      64 * 1024,
+		MessageLength:   4000,
+		YodelNameLength: 100,
+		HistoryPage:     100,
+	}
+}
+
+// Whether s is longer than max characters.
+func TooLong(s string, max int) bool {
+	return max > 0 && utf8.RuneCountInString(s) > max
+}
@@ -0,0 +1,152 @@
+package server_test
+
+import (
+	"fenix/src/database"
+	"fenix/src/test_utils"
+	"fenix/src/test_utils/test_client"
+	"fenix/src/websocket_models"
+	"net/http"
+	"strings"
+	"testing"
+)
+
+func TestAccessTokens(t *testing.T) {
+	// Registers gopher123, returning their session token.
+	register := func(t *testing.T, srv *test_utils.ServerFields, username string) string {
+		t.Helper()
+		srv.Addr.Path = "/register"
+		status, body := test_utils.PostJSON(srv.Addr, map[string]string{"username": username, "password": "pass"})
+		test_utils.AssertEqual(t, status, http.StatusOK)
+		return body["token"]
+	}
+	createBot := func(t *testing.T, srv *test_utils.ServerFields, session, username string) string {
+		t.Helper()
+		srv.Addr.Path = "/bots/create"
+		status, body := test_utils.PostJSON(srv.Addr, map[string]string{"token": session, "username": username})
+		test_utils.AssertEqual(t, status, http.StatusOK)
+		return body["userID"]
+	}
+	createToken := func(srv *test_utils.ServerFields, session, userID, scopes string) (int, map[string]interface{}) {
+		srv.Addr.Path = "/tokens/create"
+		var body map[string]interface{}
+		status := test_utils.PostJSONInto(srv.Addr, map[string]string{
+			"token": session, "name": "my bot", "scopes": scopes, "user_id": userID}, &body)
+		return status, body
+	}
+
+	t.Run("bots connect with a bearer token and their messages are marked", func(t *testing.T) {
+		srv := test_utils.StartServer()
+		defer srv.Close()
+		session := register(t, srv, "gopher123")
+		botID := createBot(t, srv, session, "gopherbot")
+
+		status, body := createToken(srv, session, botID, "read write")
+		test_utils.AssertEqual(t, status, http.StatusOK)
+		token := body["token"].(string)
+		test_utils.AssertEqual(t, strings.HasPrefix(token, "fnx_"), true)
+
+		cli, err := test_utils.UpgradeWithToken(token, srv.Addr)
+		if err != nil {
+			t.Fatalf("%q\n", err)
+		}
+		defer cli.Close()
+
+		testClient := testclient.TestClient{}
+		test_utils.AssertEqual(t, testClient.UserID(t, cli), botID)
+
+		testClient.MsgSend(t, cli, "beep boop")
+		var msg websocket_models.MsgBroadcast
+		cli.Conn.ReadJSON(&msg)
+		test_utils.AssertEqual(t, msg.Author, websocket_models.Author{ID: botID, Username: "gopherbot", Bot: true})
+	})
+
+	t.Run("tokens are limited to their scopes", func(t *testing.T) {
+		srv := test_utils.StartServer()
+		defer srv.Close()
+		session := register(t, srv, "gopher123")
+
+		_, body := createToken(srv, session, "", "read")
+		cli, err := test_utils.UpgradeWithToken(body["token"].(string), srv.Addr)
+		if err != nil {
+			t.Fatalf("%q\n", err)
+		}
+		defer cli.Close()
+
+		testClient := testclient.TestClient{}
+		testClient.MsgSend(t, cli, "hello")
+		var res websocket_models.GenericError
+		cli.Conn.ReadJSON(&res)
+		test_utils.AssertEqual(t, res.Error, "MissingScope")
+
+		testClient.YodelCreate(t, cli, "Fenixland")
+		cli.Conn.ReadJSON(&res)
+		test_utils.AssertEqual(t, res.Error, "MissingScope")
+	})
+
+	t.Run("revoked tokens cant connect", func(t *testing.T) {
+		srv := test_utils.StartServer()
+		defer srv.Close()
+		session := register(t, srv, "gopher123")
+		botID := createBot(t, srv, session, "gopherbot")
+		_, created := createToken(srv, session, botID, "read")
+
+		var list struct {
+			Tokens []database.AccessToken `json:"tokens"`
+		}
+		srv.Addr.Path = "/tokens/list"
+		status := test_utils.PostJSONInto(srv.Addr, map[string]string{"token": session}, &list)
+		test_utils.AssertEqual(t, status, http.StatusOK)
+		test_utils.AssertEqual(t, len(list.Tokens), 1)
+		test_utils.AssertEqual(t, list.Tokens[0].TokenID.Hex(), created["id"])
+		test_utils.AssertEqual(t, list.Tokens[0].UserID.Hex(), botID)
+		test_utils.AssertEqual(t, list.Tokens[0].Scopes, []string{"read"})
+
+		srv.Addr.Path = "/tokens/revoke"
+		status, _ = test_utils.PostJSON(srv.Addr, map[string]string{"token": session, "id": created["id"].(string)})
+		test_utils.AssertEqual(t, status, http.StatusNoContent)
+
+		cli, err := test_utils.UpgradeWithToken(created["token"].(string), srv.Addr)
+		test_utils.AssertNotEqual(t, err, nil)
+		test_utils.AssertEqual(t, cli.Res.StatusCode, http.StatusForbidden)
+	})
+
+	t.Run("users cant make tokens for other users' bots", func(t *testing.T) {
+		srv := test_utils.StartServer()
+		defer srv.Close()
+		botID := createBot(t, srv, register(t, srv, "gopher123"), "gopherbot")
+
+		status, _ := createToken(srv, register(t, srv, "billy"), botID, "read")
+		test_utils.AssertEqual(t, status, http.StatusForbidden)
+	})
+
+	t.Run("unknown scopes are rejected", func(t *testing.T) {
+		srv := test_utils.StartServer()
+		defer srv.Close()
+
+		status, _ := createToken(srv, register(t, srv, "gopher123"), "", "read everything")
+		test_utils.AssertEqual(t, status, http.StatusBadRequest)
+	})
+
+	t.Run("bots cant log in with a password", func(t *testing.T) {
+		srv := test_utils.StartServer()
+		defer srv.Close()
+		createBot(t, srv, register(t, srv, "gopher123"), "gopherbot")
+
+		srv.Addr.Path = "/login"
+		status, _ := test_utils.PostJSON(srv.Addr, map[string]string{"username": "gopherbot", "password": ""})
+		test_utils.AssertEqual(t, status, http.StatusForbidden)
+	})
+
+	t.Run("deleting an account deletes its bots", func(t *testing.T) {
+		srv := test_utils.StartServer()
+		defer srv.Close()
+		createBot(t, srv, register(t, srv, "gopher123"), "gopherbot")
+
+		srv.Addr.Path = "/account/delete"
+		status, _ := test_utils.PostJSON(srv.Addr, map[string]string{"username": "gopher123", "password": "pass"})
+		test_utils.AssertEqual(t, status, http.StatusNoContent)
+
+		err := srv.Database.GetUser(&database.User{Username: "gopherbot"})
+		test_utils.AssertEqual(t, err, database.DoesNotExist{})
+	})
+}
@@ -0,0 +1,191 @@
+package server_test
+
+import (
+	"fenix/src/database"
+	"fenix/src/test_utils"
+	"fenix/src/test_utils/test_client"
+	"fenix/src/websocket_models"
+	"net/http"
+	"testing"
+)
+
+func TestAdmin(t *testing.T) {
+	// Registers username, returning the /register response.
+	register := func(t *testing.T, srv *test_utils.ServerFields, username string) map[string]string {
+		t.Helper()
+		srv.Addr.Path = "/register"
+		status, body := test_utils.PostJSON(srv.Addr, map[string]string{"username": username, "password": "pass"})
+		test_utils.AssertEqual(t, status, http.StatusOK)
+		return body
+	}
+	// Starts a server with gopher123 as an admin, returning their session token.
+	start := func(t *testing.T) (*test_utils.ServerFields, string) {
+		t.Helper()
+		srv := test_utils.StartServer()
+		admin := register(t, srv, "gopher123")
+		err := srv.Hub.MakeAdmin("gopher123")
+		test_utils.AssertEqual(t, err, nil)
+		return srv, admin["token"]
+	}
+	post := func(srv *test_utils.ServerFields, path string, body map[string]string) (int, map[string]interface{}) {
+		srv.Addr.Path = path
+		var res map[string]interface{}
+		status := test_utils.PostJSONInto(srv.Addr, body, &res)
+		return status, res
+	}
+	// Connects with a /register response, waiting until the hub has the client.
+	connect := func(t *testing.T, srv *test_utils.ServerFields, body map[string]string) *test_utils.ClientFields {
+		t.Helper()
+		cli := test_utils.Upgrade(body, srv.Addr)
+		testClient := testclient.TestClient{}
+		testClient.UserID(t, cli)
+		return cli
+	}
+	login := func(srv *test_utils.ServerFields, username string) int {
+		srv.Addr.Path = "/login"
+		status, _ := test_utils.PostJSON(srv.Addr, map[string]string{"username": username, "password": "pass"})
+		return status
+	}
+
+	t.Run("admins are made by normalized username", func(t *testing.T) {
+		srv := test_utils.StartServer()
+		defer srv.Close()
+		billy := register(t, srv, "billy")
+
+		// Fullwidth letters, which NFKC normalizes to billy
+		err := srv.Hub.MakeAdmin("ｂｉｌｌｙ")
+		test_utils.AssertEqual(t, err, nil)
+		status, _ := post(srv, "/admin/stats", map[string]string{"token": billy["token"]})
+		test_utils.AssertEqual(t, status, http.StatusOK)
+	})
+
+	t.Run("non-admins are forbidden", func(t *testing.T) {
+		srv, _ := start(t)
+		defer srv.Close()
+		billy := register(t, srv, "billy")
+
+		status, _ := post(srv, "/admin/stats", map[string]string{"token": billy["token"]})
+		test_utils.AssertEqual(t, status, http.StatusForbidden)
+		status, _ = post(srv, "/admin/stats", map[string]string{"token": "nope"})
+		test_utils.AssertEqual(t, status, http.StatusForbidden)
+	})
+
+	t.Run("disabled users are disconnected and can't log in until enabled", func(t *testing.T) {
+		srv, token := start(t)
+		defer srv.Close()
+		billy := register(t, srv, "billy")
+		cli := connect(t, srv, billy)
+		defer cli.Close()
+
+		status, _ := post(srv, "/admin/users/disable", map[string]string{"token": token, "user_id": billy["userID"]})
+		test_utils.AssertEqual(t, status, http.StatusNoContent)
+		_, _, err := cli.Conn.ReadMessage()
+		test_utils.AssertNotEqual(t, err, nil)
+
+		test_utils.AssertEqual(t, login(srv, "billy"), http.StatusForbidden)
+		srv.Addr.Path = "/token/refresh"
+		status, _ = test_utils.PostJSON(srv.Addr, map[string]string{"token": billy["token"]})
+		test_utils.AssertEqual(t, status, http.StatusForbidden)
+
+		status, _ = post(srv, "/admin/users/enable", map[string]string{"token": token, "user_id": billy["userID"]})
+		test_utils.AssertEqual(t, status, http.StatusNoContent)
+		test_utils.AssertEqual(t, login(srv, "billy"), http.StatusOK)
+	})
+
+	t.Run("users are listed and deleted", func(t *testing.T) {
+		srv, token := start(t)
+		defer srv.Close()
+		billy := register(t, srv, "billy")
+
+		status, body := post(srv, "/admin/users/list", map[string]string{"token": token, "username": "bil"})
+		test_utils.AssertEqual(t, status, http.StatusOK)
+		users := body["users"].([]interface{})
+		test_utils.AssertEqual(t, len(users), 1)
+		test_utils.AssertEqual(t, users[0].(map[string]interface{})["Username"], "billy")
+
+		status, _ = post(srv, "/admin/users/delete", map[string]string{"token": token, "user_id": billy["userID"]})
+		test_utils.AssertEqual(t, status, http.StatusNoContent)
+		test_utils.AssertEqual(t, login(srv, "billy"), http.StatusForbidden)
+
+		status, _ = post(srv, "/admin/users/delete", map[string]string{"token": token, "user_id": billy["userID"]})
+		test_utils.AssertEqual(t, status, http.StatusNotFound)
+		status, _ = post(srv, "/admin/users/list", map[string]string{"token": token, "limit": "1000"})
+		test_utils.AssertEqual(t, status, http.StatusBadRequest)
+	})
+
+	t.Run("admins can't disable or delete themselves", func(t *testing.T) {
+		srv, token := start(t)
+		defer srv.Close()
+		u := &database.User{Username: "gopher123"}
+		srv.Database.GetUser(u)
+
+		status, _ := post(srv, "/admin/users/disable", map[string]string{"token": token, "user_id": u.UserID.Hex()})
+		test_utils.AssertEqual(t, status, http.StatusConflict)
+		status, _ = post(srv, "/admin/users/delete", map[string]string{"token": token, "user_id": u.UserID.Hex()})
+		test_utils.AssertEqual(t, status, http.StatusConflict)
+	})
+
+	t.Run("connected sessions are listed and disconnected", func(t *testing.T) {
+		srv, token := start(t)
+		defer srv.Close()
+		billy := register(t, srv, "billy")
+		cli := connect(t, srv, billy)
+		defer cli.Close()
+
+		status, body := post(srv, "/admin/sessions/list", map[string]string{"token": token})
+		test_utils.AssertEqual(t, status, http.StatusOK)
+		sessions := body["sessions"].([]interface{})
+		test_utils.AssertEqual(t, len(sessions), 1)
+		test_utils.AssertEqual(t, sessions[0].(map[string]interface{})["user_id"], billy["userID"])
+
+		status, _ = post(srv, "/admin/sessions/disconnect", map[string]string{"token": token, "user_id": billy["userID"]})
+		test_utils.AssertEqual(t, status, http.StatusNoContent)
+		_, _, err := cli.Conn.ReadMessage()
+		test_utils.AssertNotEqual(t, err, nil)
+
+		status, _ = post(srv, "/admin/sessions/disconnect", map[string]string{"token": token, "user_id": billy["userID"]})
+		test_utils.AssertEqual(t, status, http.StatusNotFound)
+	})
+
+	t.Run("private yodels are listed and deleted yodels are announced", func(t *testing.T) {
+		srv, token := start(t)
+		defer srv.Close()
+		billy := register(t, srv, "billy")
+		cli := connect(t, srv, billy)
+		defer cli.Close()
+
+		u := &database.User{Username: "billy"}
+		srv.Database.GetUser(u)
+		yodel := &database.Yodel{Name: "secret", Owner: u.UserID.Hex(), Visibility: database.VisibilityPrivate}
+		srv.Database.InsertYodel(yodel)
+		srv.Database.InsertMember(&database.Member{YodelID: yodel.YodelID, UserID: u.UserID, Role: database.RoleOwner})
+
+		status, body := post(srv, "/admin/yodels/list", map[string]string{"token": token})
+		test_utils.AssertEqual(t, status, http.StatusOK)
+		yodels := body["yodels"].([]interface{})
+		test_utils.AssertEqual(t, len(yodels), 1)
+		test_utils.AssertEqual(t, yodels[0].(map[string]interface{})["y_id"], yodel.YodelID.Hex())
+
+		status, _ = post(srv, "/admin/yodels/delete", map[string]string{"token": token, "yodel_id": yodel.YodelID.Hex()})
+		test_utils.AssertEqual(t, status, http.StatusNoContent)
+		var deleted websocket_models.YodelDeleted
+		cli.Conn.ReadJSON(&deleted)
+		test_utils.AssertEqual(t, deleted.YodelID, yodel.YodelID.Hex())
+
+		status, _ = post(srv, "/admin/yodels/delete", map[string]string{"token": token, "yodel_id": yodel.YodelID.Hex()})
+		test_utils.AssertEqual(t, status, http.StatusNotFound)
+	})
+
+	t.Run("stats count what's stored and connected", func(t *testing.T) {
+		srv, token := start(t)
+		defer srv.Close()
+		billy := register(t, srv, "billy")
+		cli := connect(t, srv, billy)
+		defer cli.Close()
+
+		status, body := post(srv, "/admin/stats", map[string]string{"token": token})
+		test_utils.AssertEqual(t, status, http.StatusOK)
+		test_utils.AssertEqual(t, body["users"], float64(2))
+		test_utils.AssertEqual(t, body["connected"], float64(1))
+	})
+}
@@ -0,0 +1,120 @@
+package server_test
+
+import (
+	"fenix/src/database"
+	"fenix/src/server"
+	"fenix/src/test_utils"
+	"fenix/src/test_utils/test_client"
+	"fenix/src/websocket_models"
+	"net/http"
+	"strconv"
+	"testing"
+	"time"
+)
+
+func TestAuditLog(t *testing.T) {
+	// Starts a server with gopher123 as an admin, returning their session token and ID.
+	start := func(t *testing.T) (*test_utils.ServerFields, string, string) {
+		t.Helper()
+		srv := test_utils.StartServer()
+		srv.Addr.Path = "/register"
+		status, body := test_utils.PostJSON(srv.Addr, map[string]string{"username": "gopher123", "password": "pass"})
+		test_utils.AssertEqual(t, status, http.StatusOK)
+		err := srv.Hub.MakeAdmin("gopher123")
+		test_utils.AssertEqual(t, err, nil)
+		return srv, body["token"], body["userID"]
+	}
+	query := func(t *testing.T, srv *test_utils.ServerFields, q map[string]string) []database.AuditEntry {
+		t.Helper()
+		srv.Addr.Path = "/admin/audit"
+		var res struct {
+			Entries []database.AuditEntry `json:"entries"`
+		}
+		status := test_utils.PostJSONInto(srv.Addr, q, &res)
+		test_utils.AssertEqual(t, status, http.StatusOK)
+		return res.Entries
+	}
+	actions := func(entries []database.AuditEntry) []string {
+		res := []string{}
+		for _, e := range entries {
+			res = append(res, e.Action)
+		}
+		return res
+	}
+
+	t.Run("logins, registrations and upgrades are recorded", func(t *testing.T) {
+		srv, token, _ := start(t)
+		defer srv.Close()
+		from := time.Now().UnixNano()
+
+		srv.Addr.Path = "/register"
+		_, billy := test_utils.PostJSON(srv.Addr, map[string]string{"username": "billy", "password": "pass"})
+		srv.Addr.Path = "/login"
+		status, _ := test_utils.PostJSON(srv.Addr, map[string]string{"username": "billy", "password": "wrong"})
+		test_utils.AssertEqual(t, status, http.StatusForbidden)
+		status, body := test_utils.PostJSON(srv.Addr, map[string]string{"username": "billy", "password": "pass"})
+		test_utils.AssertEqual(t, status, http.StatusOK)
+		cli := test_utils.Upgrade(body, srv.Addr)
+		defer cli.Close()
+		testClient := testclient.TestClient{}
+		testClient.UserID(t, cli)
+
+		entries := query(t, srv, map[string]string{"token": token, "from": strconv.FormatInt(from, 10)})
+		test_utils.AssertEqual(t, actions(entries), []string{
+			server.AuditTicketRedeem, server.AuditLoginSuccess, server.AuditLoginFailure, server.AuditRegister})
+		test_utils.AssertEqual(t, entries[2].Target, billy["userID"])
+		test_utils.AssertEqual(t, entries[2].ActorID, "")
+		test_utils.AssertEqual(t, entries[2].IP, "127.0.0.1")
+
+		entries = query(t, srv, map[string]string{"token": token, "actor_id": billy["userID"]})
+		test_utils.AssertEqual(t, actions(entries), []string{
+			server.AuditTicketRedeem, server.AuditLoginSuccess, server.AuditRegister})
+	})
+
+	t.Run("yodel deletions are recorded with the client's address", func(t *testing.T) {
+		srv, token, _ := start(t)
+		defer srv.Close()
+		testClient := testclient.TestClient{}
+		cli := testClient.RegisterClient(t, srv, testclient.Credentials{Username: "billy", Password: "pass"})
+		defer cli.Close()
+
+		testClient.YodelCreate(t, cli, "gophers")
+		var yodel websocket_models.Yodel
+		cli.Conn.ReadJSON(&yodel)
+		testClient.YodelDelete(t, cli, yodel.YodelID)
+		cli.Conn.ReadJSON(&websocket_models.YodelDeleted{})
+
+		entries := query(t, srv, map[string]string{"token": token, "action": server.AuditYodelDelete})
+		test_utils.AssertEqual(t, len(entries), 1)
+		test_utils.AssertEqual(t, entries[0].Target, yodel.YodelID)
+		test_utils.AssertEqual(t, entries[0].ActorID, testClient.UserID(t, cli))
+		test_utils.AssertEqual(t, entries[0].IP, "127.0.0.1")
+	})
+
+	t.Run("admin actions are recorded, and only admins can query", func(t *testing.T) {
+		srv, token, adminID := start(t)
+		defer srv.Close()
+		srv.Addr.Path = "/register"
+		_, billy := test_utils.PostJSON(srv.Addr, map[string]string{"username": "billy", "password": "pass"})
+
+		srv.Addr.Path = "/admin/audit"
+		status, _ := test_utils.PostJSON(srv.Addr, map[string]string{"token": billy["token"]})
+		test_utils.AssertEqual(t, status, http.StatusForbidden)
+
+		srv.Addr.Path = "/admin/stats"
+		var stats map[string]interface{}
+		test_utils.PostJSONInto(srv.Addr, map[string]string{"token": token}, &stats)
+
+		entries := query(t, srv, map[string]string{"token": token, "limit": "2"})
+		test_utils.AssertEqual(t, actions(entries), []string{server.AuditAdminStats, server.AuditAdminDenied})
+		test_utils.AssertEqual(t, entries[0].ActorID, adminID)
+		test_utils.AssertEqual(t, entries[1].ActorID, billy["userID"])
+
+		entries = query(t, srv, map[string]string{"token": token, "action": server.AuditAdminQueryAudit})
+		test_utils.AssertEqual(t, len(entries), 1)
+
+		srv.Addr.Path = "/admin/audit"
+		status, _ = test_utils.PostJSON(srv.Addr, map[string]string{"token": token, "from": "2", "to": "1"})
+		test_utils.AssertEqual(t, status, http.StatusBadRequest)
+	})
+}
@@ -3,11 +3,20 @@ package server_test
 import (
 	"bytes"
 	"crypto/rand"
+	"crypto/sha512"
+	"encoding/base32"
 	"encoding/json"
 	"fenix/src/database"
 	"fenix/src/test_utils"
+	"fenix/src/test_utils/test_client"
+	"fenix/src/utils"
+	"fenix/src/websocket_models"
 	"net/http"
+	"strings"
 	"testing"
+	"time"
+
+	"github.com/xdg-go/pbkdf2"
 )
 
 func TestStatusCodes(t *testing.T) {
@@ -201,3 +210,363 @@ func TestStatusCodes(t *testing.T) {
 		test_utils.AssertEqual(t, got, expected)
 	})
 }
+
+func TestSessions(t *testing.T) {
+	register := func(t *testing.T, srv *test_utils.ServerFields) map[string]string {
+		t.Helper()
+		srv.Addr.Path = "/register"
+		status, body := test_utils.PostJSON(srv.Addr, map[string]string{"username": "gopher123", "password": "pass"})
+		test_utils.AssertEqual(t, status, http.StatusOK)
+		return body
+	}
+
+	t.Run("session token can be exchanged for a ticket", func(t *testing.T) {
+		srv := test_utils.StartServer()
+		defer srv.Close()
+
+		token := register(t, srv)["token"]
+
+		srv.Addr.Path = "/token/refresh"
+		status, body := test_utils.PostJSON(srv.Addr, map[string]string{"token": token})
+		test_utils.AssertEqual(t, status, http.StatusOK)
+		test_utils.AssertEqual(t, body["token"], token)
+
+		cli := test_utils.Upgrade(body, srv.Addr)
+		defer cli.Close()
+		test_utils.AssertEqual(t, cli.Res.StatusCode, http.StatusSwitchingProtocols)
+	})
+
+	t.Run("unknown session token is forbidden", func(t *testing.T) {
+		srv := test_utils.StartServer()
+		defer srv.Close()
+
+		srv.Addr.Path = "/token/refresh"
+		status, _ := test_utils.PostJSON(srv.Addr, map[string]string{"token": "notarealtoken"})
+		test_utils.AssertEqual(t, status, http.StatusForbidden)
+	})
+
+	t.Run("logout revokes the session token", func(t *testing.T) {
+		srv := test_utils.StartServer()
+		defer srv.Close()
+
+		token := register(t, srv)["token"]
+
+		srv.Addr.Path = "/logout"
+		status, _ := test_utils.PostJSON(srv.Addr, map[string]string{"token": token})
+		test_utils.AssertEqual(t, status, http.StatusNoContent)
+
+		srv.Addr.Path = "/token/refresh"
+		status, _ = test_utils.PostJSON(srv.Addr, map[string]string{"token": token})
+		test_utils.AssertEqual(t, status, http.StatusForbidden)
+	})
+
+	t.Run("expired session token is forbidden", func(t *testing.T) {
+		srv := test_utils.StartServer()
+		defer srv.Close()
+
+		register(t, srv)
+		user := &database.User{Username: "gopher123"}
+		srv.Database.GetUser(user)
+		srv.Database.InsertSession(&database.Session{
+			// sha256 of "hello"
+			TokenHash: "2cf24dba5fb0a30e26e83b2ac5b9e29e1b161e5c1fa7425e73043362938b9824",
+			UserID:    user.UserID,
+			Expires:   time.Now().Add(-time.Minute).UnixNano(),
+		})
+
+		srv.Addr.Path = "/token/refresh"
+		status, _ := test_utils.PostJSON(srv.Addr, map[string]string{"token": "hello"})
+		test_utils.AssertEqual(t, status, http.StatusForbidden)
+	})
+}
+
+type recordingNotifier struct {
+	bodies []string
+}
+
+func (n *recordingNotifier) Notify(u *database.User, subject, body string) error {
+	n.bodies = append(n.bodies, body)
+	return nil
+}
+
+func TestAccounts(t *testing.T) {
+	// Registers gopher123 with password "pass", returning their session token.
+	register := func(t *testing.T, srv *test_utils.ServerFields) string {
+		t.Helper()
+		srv.Addr.Path = "/register"
+		status, body := test_utils.PostJSON(srv.Addr, map[string]string{"username": "gopher123", "password": "pass"})
+		test_utils.AssertEqual(t, status, http.StatusOK)
+		return body["token"]
+	}
+	login := func(srv *test_utils.ServerFields, password string) int {
+		srv.Addr.Path = "/login"
+		status, _ := test_utils.PostJSON(srv.Addr, map[string]string{"username": "gopher123", "password": password})
+		return status
+	}
+
+	t.Run("changing password logs out sessions", func(t *testing.T) {
+		srv := test_utils.StartServer()
+		defer srv.Close()
+		token := register(t, srv)
+
+		srv.Addr.Path = "/password/change"
+		status, _ := test_utils.PostJSON(srv.Addr, map[string]string{
+			"username": "gopher123", "password": "pass", "new_password": "newpass"})
+		test_utils.AssertEqual(t, status, http.StatusNoContent)
+
+		test_utils.AssertEqual(t, login(srv, "pass"), http.StatusForbidden)
+		test_utils.AssertEqual(t, login(srv, "newpass"), http.StatusOK)
+
+		srv.Addr.Path = "/token/refresh"
+		status, _ = test_utils.PostJSON(srv.Addr, map[string]string{"token": token})
+		test_utils.AssertEqual(t, status, http.StatusForbidden)
+	})
+
+	t.Run("changing password needs the current password", func(t *testing.T) {
+		srv := test_utils.StartServer()
+		defer srv.Close()
+		register(t, srv)
+
+		srv.Addr.Path = "/password/change"
+		status, _ := test_utils.PostJSON(srv.Addr, map[string]string{
+			"username": "gopher123", "password": "wrong", "new_password": "newpass"})
+		test_utils.AssertEqual(t, status, http.StatusForbidden)
+	})
+
+	t.Run("reset tokens set a new password once", func(t *testing.T) {
+		srv := test_utils.StartServer()
+		defer srv.Close()
+		register(t, srv)
+		notifier := &recordingNotifier{}
+		srv.Hub.Notifier = notifier
+
+		srv.Addr.Path = "/password/reset/request"
+		status, _ := test_utils.PostJSON(srv.Addr, map[string]string{"username": "gopher123"})
+		test_utils.AssertEqual(t, status, http.StatusNoContent)
+		test_utils.AssertEqual(t, len(notifier.bodies), 1)
+
+		body := notifier.bodies[0]
+		token := body[strings.LastIndex(body, " ")+1:]
+
+		srv.Addr.Path = "/password/reset"
+		status, _ = test_utils.PostJSON(srv.Addr, map[string]string{"token": token, "new_password": "newpass"})
+		test_utils.AssertEqual(t, status, http.StatusNoContent)
+		test_utils.AssertEqual(t, login(srv, "newpass"), http.StatusOK)
+
+		srv.Addr.Path = "/password/reset"
+		status, _ = test_utils.PostJSON(srv.Addr, map[string]string{"token": token, "new_password": "again"})
+		test_utils.AssertEqual(t, status, http.StatusForbidden)
+	})
+
+	t.Run("reset requests normalize the username", func(t *testing.T) {
+		srv := test_utils.StartServer()
+		defer srv.Close()
+		register(t, srv)
+		notifier := &recordingNotifier{}
+		srv.Hub.Notifier = notifier
+
+		// Fullwidth letters, which NFKC normalizes to gopher123
+		srv.Addr.Path = "/password/reset/request"
+		status, _ := test_utils.PostJSON(srv.Addr, map[string]string{"username": "ｇｏｐｈｅｒ123"})
+		test_utils.AssertEqual(t, status, http.StatusNoContent)
+		test_utils.AssertEqual(t, len(notifier.bodies), 1)
+	})
+
+	t.Run("reset requests for unknown users look the same", func(t *testing.T) {
+		srv := test_utils.StartServer()
+		defer srv.Close()
+		notifier := &recordingNotifier{}
+		srv.Hub.Notifier = notifier
+
+		srv.Addr.Path = "/password/reset/request"
+		status, _ := test_utils.PostJSON(srv.Addr, map[string]string{"username": "nobody"})
+		test_utils.AssertEqual(t, status, http.StatusNoContent)
+		test_utils.AssertEqual(t, len(notifier.bodies), 0)
+	})
+
+	t.Run("deleted accounts cant log in", func(t *testing.T) {
+		srv := test_utils.StartServer()
+		defer srv.Close()
+		register(t, srv)
+
+		srv.Addr.Path = "/account/delete"
+		status, _ := test_utils.PostJSON(srv.Addr, map[string]string{"username": "gopher123", "password": "pass"})
+		test_utils.AssertEqual(t, status, http.StatusNoContent)
+
+		test_utils.AssertEqual(t, login(srv, "pass"), http.StatusForbidden)
+	})
+
+	t.Run("logging in rehashes legacy passwords", func(t *testing.T) {
+		srv := test_utils.StartServer()
+		defer srv.Close()
+
+		u := &database.User{Username: "gopher123", Salt: make([]byte, 16)}
+		rand.Read(u.Salt)
+		u.Password = pbkdf2.Key([]byte("pass"), u.Salt, 100000, 32, sha512.New512_256)
+		srv.Database.InsertUser(u)
+
+		test_utils.AssertEqual(t, login(srv, "pass"), http.StatusOK)
+
+		got := &database.User{Username: "gopher123"}
+		srv.Database.GetUser(got)
+		test_utils.AssertEqual(t, strings.HasPrefix(string(got.Password), "$argon2id$"), true)
+		test_utils.AssertEqual(t, login(srv, "pass"), http.StatusOK)
+	})
+
+	t.Run("yodel owners cant delete their account", func(t *testing.T) {
+		srv, cli, close := test_utils.StartServerAndConnect("gopher123", "pass", "/register")
+		defer close()
+
+		testClient := testclient.TestClient{}
+		testClient.YodelCreate(t, cli, "Fenixland")
+		cli.Conn.ReadJSON(&websocket_models.Yodel{})
+
+		srv.Addr.Path = "/account/delete"
+		status, _ := test_utils.PostJSON(srv.Addr, map[string]string{"username": "gopher123", "password": "pass"})
+		test_utils.AssertEqual(t, status, http.StatusConflict)
+	})
+}
+
+func TestTwoFactor(t *testing.T) {
+	// Registers gopher123 with password "pass" and enrols them in 2FA, returning their TOTP secret and recovery codes.
+	enable := func(t *testing.T, srv *test_utils.ServerFields, step int64) ([]byte, []string) {
+		t.Helper()
+		srv.Addr.Path = "/register"
+		status, _ := test_utils.PostJSON(srv.Addr, map[string]string{"username": "gopher123", "password": "pass"})
+		test_utils.AssertEqual(t, status, http.StatusOK)
+
+		srv.Addr.Path = "/2fa/enroll"
+		status, body := test_utils.PostJSON(srv.Addr, map[string]string{"username": "gopher123", "password": "pass"})
+		test_utils.AssertEqual(t, status, http.StatusOK)
+		test_utils.AssertEqual(t, strings.HasPrefix(body["uri"], "otpauth://totp/Fenix:gopher123?"), true)
+		secret, err := base32.StdEncoding.WithPadding(base32.NoPadding).DecodeString(body["secret"])
+		if err != nil {
+			t.Fatalf("%q\n", err)
+		}
+
+		var confirmed struct {
+			RecoveryCodes []string `json:"recovery_codes"`
+		}
+		srv.Addr.Path = "/2fa/confirm"
+		status = test_utils.PostJSONInto(srv.Addr, map[string]string{
+			"username": "gopher123", "password": "pass", "code": utils.TOTPCode(secret, step)}, &confirmed)
+		test_utils.AssertEqual(t, status, http.StatusOK)
+		test_utils.AssertEqual(t, len(confirmed.RecoveryCodes), 10)
+		return secret, confirmed.RecoveryCodes
+	}
+	// Does the password step of a login, returning its response.
+	login := func(t *testing.T, srv *test_utils.ServerFields) map[string]string {
+		t.Helper()
+		srv.Addr.Path = "/login"
+		status, body := test_utils.PostJSON(srv.Addr, map[string]string{"username": "gopher123", "password": "pass"})
+		test_utils.AssertEqual(t, status, http.StatusAccepted)
+		test_utils.AssertEqual(t, body["ticket"], "")
+		return body
+	}
+	loginMFA := func(srv *test_utils.ServerFields, challenge map[string]string, code string) (int, map[string]string) {
+		srv.Addr.Path = "/login/2fa"
+		return test_utils.PostJSON(srv.Addr, map[string]string{
+			"userID": challenge["userID"], "mfa_token": challenge["mfa_token"], "code": code})
+	}
+
+	t.Run("logins need a code once enrolment is confirmed", func(t *testing.T) {
+		srv := test_utils.StartServer()
+		defer srv.Close()
+		step := utils.TOTPStep(time.Now())
+		secret, _ := enable(t, srv, step)
+
+		status, body := loginMFA(srv, login(t, srv), utils.TOTPCode(secret, step+1))
+		test_utils.AssertEqual(t, status, http.StatusOK)
+
+		cli := test_utils.Upgrade(body, srv.Addr)
+		defer cli.Close()
+		test_utils.AssertEqual(t, cli.Res.StatusCode, http.StatusSwitchingProtocols)
+	})
+
+	t.Run("unconfirmed enrolments dont need a code", func(t *testing.T) {
+		srv := test_utils.StartServer()
+		defer srv.Close()
+		srv.Addr.Path = "/register"
+		test_utils.PostJSON(srv.Addr, map[string]string{"username": "gopher123", "password": "pass"})
+		srv.Addr.Path = "/2fa/enroll"
+		test_utils.PostJSON(srv.Addr, map[string]string{"username": "gopher123", "password": "pass"})
+
+		srv.Addr.Path = "/login"
+		status, body := test_utils.PostJSON(srv.Addr, map[string]string{"username": "gopher123", "password": "pass"})
+		test_utils.AssertEqual(t, status, http.StatusOK)
+		test_utils.AssertNotEqual(t, body["ticket"], "")
+	})
+
+	t.Run("codes cant be replayed", func(t *testing.T) {
+		srv := test_utils.StartServer()
+		defer srv.Close()
+		step := utils.TOTPStep(time.Now())
+		secret, _ := enable(t, srv, step)
+
+		status, _ := loginMFA(srv, login(t, srv), utils.TOTPCode(secret, step))
+		test_utils.AssertEqual(t, status, http.StatusForbidden)
+	})
+
+	t.Run("login challenges can only be tried once", func(t *testing.T) {
+		srv := test_utils.StartServer()
+		defer srv.Close()
+		step := utils.TOTPStep(time.Now())
+		secret, _ := enable(t, srv, step)
+
+		challenge := login(t, srv)
+		status, _ := loginMFA(srv, challenge, "000000")
+		test_utils.AssertEqual(t, status, http.StatusForbidden)
+		status, _ = loginMFA(srv, challenge, utils.TOTPCode(secret, step+1))
+		test_utils.AssertEqual(t, status, http.StatusForbidden)
+	})
+
+	t.Run("recovery codes work once", func(t *testing.T) {
+		srv := test_utils.StartServer()
+		defer srv.Close()
+		_, codes := enable(t, srv, utils.TOTPStep(time.Now()))
+
+		status, _ := loginMFA(srv, login(t, srv), strings.ToUpper(codes[0]))
+		test_utils.AssertEqual(t, status, http.StatusOK)
+		status, _ = loginMFA(srv, login(t, srv), codes[0])
+		test_utils.AssertEqual(t, status, http.StatusForbidden)
+	})
+
+	t.Run("disabling 2FA needs a code", func(t *testing.T) {
+		srv := test_utils.StartServer()
+		defer srv.Close()
+		_, codes := enable(t, srv, utils.TOTPStep(time.Now()))
+
+		srv.Addr.Path = "/2fa/disable"
+		status, _ := test_utils.PostJSON(srv.Addr, map[string]string{"username": "gopher123", "password": "pass", "code": "000000"})
+		test_utils.AssertEqual(t, status, http.StatusForbidden)
+		status, _ = test_utils.PostJSON(srv.Addr, map[string]string{"username": "gopher123", "password": "pass", "code": codes[1]})
+		test_utils.AssertEqual(t, status, http.StatusNoContent)
+
+		srv.Addr.Path = "/login"
+		status, _ = test_utils.PostJSON(srv.Addr, map[string]string{"username": "gopher123", "password": "pass"})
+		test_utils.AssertEqual(t, status, http.StatusOK)
+	})
+
+	t.Run("secrets are stored encrypted", func(t *testing.T) {
+		srv := test_utils.StartServer()
+		defer srv.Close()
+		secret, _ := enable(t, srv, utils.TOTPStep(time.Now()))
+
+		u := &database.User{Username: "gopher123"}
+		srv.Database.GetUser(u)
+		test_utils.AssertEqual(t, bytes.Contains(u.TOTPSecret, secret), false)
+	})
+
+	t.Run("enrolment needs a secret key", func(t *testing.T) {
+		srv := test_utils.StartServer()
+		defer srv.Close()
+		srv.Hub.SecretKey = nil
+		srv.Addr.Path = "/register"
+		test_utils.PostJSON(srv.Addr, map[string]string{"username": "gopher123", "password": "pass"})
+
+		srv.Addr.Path = "/2fa/enroll"
+		status, body := test_utils.PostJSON(srv.Addr, map[string]string{"username": "gopher123", "password": "pass"})
+		test_utils.AssertEqual(t, status, http.StatusServiceUnavailable)
+		test_utils.AssertEqual(t, body["error"], "TwoFactorUnavailable")
+	})
+}
@@ -0,0 +1,112 @@
+package server_test
+
+import (
+	"bytes"
+	"fenix/src/server"
+	"fenix/src/test_utils"
+	"net/http"
+	"net/url"
+	"testing"
+
+	"github.com/gorilla/websocket"
+)
+
+func TestCORSPolicy(t *testing.T) {
+	p := server.ParseCORSPolicy("https://fenix.example.com, https://*.gophers.dev")
+
+	for origin, allowed := range map[string]bool{
+		"https://fenix.example.com":     true,
+		"https://FENIX.example.com":     true,
+		"https://a.gophers.dev":         true,
+		"https://a.b.gophers.dev":       true,
+		"https://gophers.dev":           false,
+		"http://a.gophers.dev":          false,
+		"https://a.gophers.dev:8443":    false,
+		"https://evilgophers.dev":       false,
+		"https://a.gophers.dev.evil.io": false,
+		"https://example.com":           false,
+		"null":                          false,
+	} {
+		test_utils.AssertEqual(t, p.Allowed(origin), allowed)
+	}
+
+	test_utils.AssertEqual(t, server.NewCORSPolicy().Allowed("https://anywhere.io"), true)
+	test_utils.AssertEqual(t, server.ParseCORSPolicy("").Allowed("https://anywhere.io"), false)
+}
+
+func TestOrigins(t *testing.T) {
+	// Posts to path with an Origin header, returning the response.
+	post := func(t *testing.T, srv *test_utils.ServerFields, method, path, origin string) *http.Response {
+		t.Helper()
+		srv.Addr.Path = path
+		req, err := http.NewRequest(method, srv.Addr.String(), bytes.NewBufferString(`{"username": "billy", "password": "pass"}`))
+		if err != nil {
+			t.Fatalf("%q\n", err)
+		}
+		req.Header.Set("Origin", origin)
+		res, err := http.DefaultClient.Do(req)
+		if err != nil {
+			t.Fatalf("%q\n", err)
+		}
+		res.Body.Close()
+		return res
+	}
+	start := func() *test_utils.ServerFields {
+		srv := test_utils.StartServer()
+		srv.Hub.CORS = server.ParseCORSPolicy("https://*.example.com")
+		return srv
+	}
+
+	t.Run("allowed origins can read responses", func(t *testing.T) {
+		srv := start()
+		defer srv.Close()
+
+		res := post(t, srv, "OPTIONS", "/register", "https://fenix.example.com")
+		test_utils.AssertEqual(t, res.StatusCode, http.StatusNoContent)
+		test_utils.AssertEqual(t, res.Header.Get("Access-Control-Allow-Origin"), "https://fenix.example.com")
+		test_utils.AssertEqual(t, res.Header.Get("Vary"), "Origin")
+
+		res = post(t, srv, "POST", "/register", "https://fenix.example.com")
+		test_utils.AssertEqual(t, res.StatusCode, http.StatusOK)
+		test_utils.AssertEqual(t, res.Header.Get("Access-Control-Allow-Origin"), "https://fenix.example.com")
+	})
+
+	t.Run("other origins are forbidden", func(t *testing.T) {
+		srv := start()
+		defer srv.Close()
+
+		res := post(t, srv, "OPTIONS", "/register", "https://evil.io")
+		test_utils.AssertEqual(t, res.StatusCode, http.StatusForbidden)
+		test_utils.AssertEqual(t, res.Header.Get("Access-Control-Allow-Origin"), "")
+		res = post(t, srv, "POST", "/register", "https://evil.io")
+		test_utils.AssertEqual(t, res.StatusCode, http.StatusForbidden)
+	})
+
+	t.Run("requests without an origin are allowed", func(t *testing.T) {
+		srv := start()
+		defer srv.Close()
+
+		srv.Addr.Path = "/register"
+		status, _ := test_utils.PostJSON(srv.Addr, map[string]string{"username": "billy", "password": "pass"})
+		test_utils.AssertEqual(t, status, http.StatusOK)
+	})
+
+	t.Run("websockets can't be opened from other origins", func(t *testing.T) {
+		srv := start()
+		defer srv.Close()
+		srv.Addr.Path = "/register"
+		_, body := test_utils.PostJSON(srv.Addr, map[string]string{"username": "billy", "password": "pass"})
+
+		wsAddr := url.URL{Scheme: "ws", Host: srv.Addr.Host, Path: "/upgrade"}
+		wsAddr.RawQuery = url.Values{"t": {body["ticket"]}, "id": {body["userID"]}}.Encode()
+		_, res, err := websocket.DefaultDialer.Dial(wsAddr.String(), http.Header{"Origin": {"https://evil.io"}})
+		test_utils.AssertNotEqual(t, err, nil)
+		test_utils.AssertEqual(t, res.StatusCode, http.StatusForbidden)
+
+		// The ticket wasn't used up by the rejected upgrade
+		cli, res, err := websocket.DefaultDialer.Dial(wsAddr.String(), http.Header{"Origin": {"https://fenix.example.com"}})
+		test_utils.AssertEqual(t, err, nil)
+		defer cli.Close()
+		test_utils.AssertEqual(t, res.StatusCode, http.StatusSwitchingProtocols)
+	})
+}
@@ -0,0 +1,181 @@
+package server_test
+
+import (
+	"bytes"
+	"encoding/json"
+	"fenix/src/server"
+	"fenix/src/test_utils"
+	"net/http"
+	"net/url"
+	"testing"
+	"time"
+)
+
+// Posts credentials to an endpoint, returning the status code and Retry-After header.
+func postCredentials(t *testing.T, u url.URL, path, username, password string) (int, string) {
+	t.Helper()
+	u.Path = path
+	b, err := json.Marshal(map[string]string{"username": username, "password": password})
+	if err != nil {
+		t.Fatalf("%q\n", err)
+	}
+
+	res, err := http.Post(u.String(), "application/json", bytes.NewBuffer(b))
+	if err != nil {
+		t.Fatalf("%q\n", err)
+	}
+	res.Body.Close()
+	return res.StatusCode, res.Header.Get("Retry-After")
+}
+
+func TestLoginLimits(t *testing.T) {
+	// Starts a server with gopher123 registered, and policies that never limit unless a test sets them.
+	start := func(t *testing.T) *test_utils.ServerFields {
+		t.Helper()
+		srv := test_utils.StartServer()
+		unlimited := server.LimitPolicy{FreeFailures: 1000, Window: time.Hour}
+		srv.Hub.Limiter.IP = unlimited
+		srv.Hub.Limiter.Username = unlimited
+		srv.Hub.Limiter.Register = unlimited
+
+		status, _ := postCredentials(t, srv.Addr, "/register", "gopher123", "pass")
+		test_utils.AssertEqual(t, status, http.StatusOK)
+		return srv
+	}
+
+	t.Run("usernames back off after repeated failures", func(t *testing.T) {
+		srv := start(t)
+		defer srv.Close()
+		srv.Hub.Limiter.Username = server.LimitPolicy{FreeFailures: 2, BaseDelay: time.Hour, MaxDelay: time.Hour, Window: time.Hour}
+
+		for i := 0; i < 3; i++ {
+			status, _ := postCredentials(t, srv.Addr, "/login", "gopher123", "wrong")
+			test_utils.AssertEqual(t, status, http.StatusForbidden)
+		}
+
+		// Even the right password is refused while backing off
+		status, retry := postCredentials(t, srv.Addr, "/login", "GOPHER123", "pass")
+		test_utils.AssertEqual(t, status, http.StatusTooManyRequests)
+		test_utils.AssertEqual(t, retry, "3600")
+	})
+
+	t.Run("usernames are locked out", func(t *testing.T) {
+		srv := start(t)
+		defer srv.Close()
+		srv.Hub.Limiter.Username = server.LimitPolicy{
+			FreeFailures: 1000, LockoutFailures: 3, LockoutDuration: 15 * time.Minute, Window: time.Hour}
+
+		for i := 0; i < 3; i++ {
+			postCredentials(t, srv.Addr, "/login", "gopher123", "wrong")
+		}
+
+		status, retry := postCredentials(t, srv.Addr, "/2fa/enroll", "gopher123", "pass")
+		test_utils.AssertEqual(t, status, http.StatusTooManyRequests)
+		test_utils.AssertEqual(t, retry, "900")
+	})
+
+	t.Run("spellings of the same username share its lockout", func(t *testing.T) {
+		srv := start(t)
+		defer srv.Close()
+		srv.Hub.Limiter.Username = server.LimitPolicy{
+			FreeFailures: 1000, LockoutFailures: 3, LockoutDuration: 15 * time.Minute, Window: time.Hour}
+
+		// Fullwidth and Cyrillic letters, which reach gopher123 or look like it
+		for _, username := range []string{"ｇopher123", "GOPHER１２３", "gоpher123"} {
+			status, _ := postCredentials(t, srv.Addr, "/login", username, "wrong")
+			test_utils.AssertEqual(t, status, http.StatusForbidden)
+		}
+
+		status, retry := postCredentials(t, srv.Addr, "/login", "gopher123", "pass")
+		test_utils.AssertEqual(t, status, http.StatusTooManyRequests)
+		test_utils.AssertEqual(t, retry, "900")
+	})
+
+	t.Run("IPs back off across usernames", func(t *testing.T) {
+		srv := start(t)
+		defer srv.Close()
+		srv.Hub.Limiter.IP = server.LimitPolicy{FreeFailures: 1, BaseDelay: time.Minute, MaxDelay: time.Minute, Window: time.Hour}
+
+		postCredentials(t, srv.Addr, "/login", "alice", "wrong")
+		postCredentials(t, srv.Addr, "/login", "bob", "wrong")
+
+		status, retry := postCredentials(t, srv.Addr, "/login", "gopher123", "pass")
+		test_utils.AssertEqual(t, status, http.StatusTooManyRequests)
+		test_utils.AssertEqual(t, retry, "60")
+	})
+
+	t.Run("logging in forgets a username's failures", func(t *testing.T) {
+		srv := start(t)
+		defer srv.Close()
+		srv.Hub.Limiter.Username = server.LimitPolicy{FreeFailures: 2, BaseDelay: time.Hour, MaxDelay: time.Hour, Window: time.Hour}
+
+		for _, password := range []string{"wrong", "wrong", "pass", "wrong", "wrong"} {
+			postCredentials(t, srv.Addr, "/login", "gopher123", password)
+		}
+
+		status, _ := postCredentials(t, srv.Addr, "/login", "gopher123", "pass")
+		test_utils.AssertEqual(t, status, http.StatusOK)
+	})
+
+	t.Run("registrations are limited per IP", func(t *testing.T) {
+		srv := start(t)
+		defer srv.Close()
+		srv.Hub.Limiter.Register = server.LimitPolicy{FreeFailures: 2, BaseDelay: time.Hour, MaxDelay: time.Hour, Window: time.Hour}
+
+		for _, username := range []string{"alice", "bob", "gopher123"} {
+			postCredentials(t, srv.Addr, "/register", username, "pass")
+		}
+
+		status, _ := postCredentials(t, srv.Addr, "/register", "carol", "pass")
+		test_utils.AssertEqual(t, status, http.StatusTooManyRequests)
+	})
+}
+
+func TestLimitPolicy(t *testing.T) {
+	p := server.LimitPolicy{
+		FreeFailures:    2,
+		BaseDelay:       time.Second,
+		MaxDelay:        5 * time.Second,
+		LockoutFailures: 10,
+		LockoutDuration: time.Hour,
+	}
+	expected := map[int]time.Duration{
+		1:  0,
+		2:  0,
+		3:  time.Second,
+		4:  2 * time.Second,
+		5:  4 * time.Second,
+		6:  5 * time.Second,
+		9:  5 * time.Second,
+		10: time.Hour,
+	}
+
+	for failures, delay := range expected {
+		test_utils.AssertEqual(t, p.Delay(failures), delay)
+	}
+}
+
+func TestMemoryLimiterStore(t *testing.T) {
+	t.Run("states expire", func(t *testing.T) {
+		store := server.NewMemoryLimiterStore()
+		store.Put("ip:127.0.0.1", server.LimitState{Failures: 1}, time.Millisecond)
+		time.Sleep(5 * time.Millisecond)
+
+		_, ok, err := store.Get("ip:127.0.0.1")
+		test_utils.AssertEqual(t, err, nil)
+		test_utils.AssertEqual(t, ok, false)
+	})
+
+	t.Run("states can be deleted", func(t *testing.T) {
+		store := server.NewMemoryLimiterStore()
+		store.Put("user:gopher123", server.LimitState{Failures: 3}, time.Hour)
+
+		s, ok, _ := store.Get("user:gopher123")
+		test_utils.AssertEqual(t, ok, true)
+		test_utils.AssertEqual(t, s.Failures, 3)
+
+		store.Delete("user:gopher123")
+		_, ok, _ = store.Get("user:gopher123")
+		test_utils.AssertEqual(t, ok, false)
+	})
+}
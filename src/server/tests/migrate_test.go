@@ -0,0 +1,88 @@
+package server_test
+
+import (
+	"fenix/src/database"
+	"fenix/src/server"
+	"fenix/src/test_utils"
+	"testing"
+)
+
+func TestMigrate(t *testing.T) {
+	t.Run("new databases must be migrated before serving", func(t *testing.T) {
+		db := database.NewInMemoryDatabase()
+		test_utils.AssertNotEqual(t, server.CheckSchema(db), nil)
+
+		err := server.Migrate(db, server.NewAccountPolicy())
+		test_utils.AssertEqual(t, err, nil)
+		test_utils.AssertEqual(t, server.CheckSchema(db), nil)
+
+		version, _ := db.GetSchemaVersion()
+		test_utils.AssertEqual(t, version, server.SchemaVersion)
+	})
+
+	t.Run("users without canonical names get one", func(t *testing.T) {
+		db := database.NewInMemoryDatabase()
+		db.InsertUser(&database.User{Username: "Gopher.123"})
+
+		err := server.Migrate(db, server.NewAccountPolicy())
+		test_utils.AssertEqual(t, err, nil)
+
+		u := database.User{Username: "Gopher.123"}
+		db.GetUser(&u)
+		test_utils.AssertEqual(t, u.CanonicalName, "gopher_123")
+		users, _ := db.GetUsersWithoutCanonicalName()
+		test_utils.AssertEqual(t, len(users), 0)
+	})
+
+	t.Run("users whose canonical name is taken are skipped", func(t *testing.T) {
+		db := database.NewInMemoryDatabase()
+		db.InsertUser(&database.User{Username: "Gopher"})
+		db.InsertUser(&database.User{Username: "gopher"})
+
+		err := server.Migrate(db, server.NewAccountPolicy())
+		test_utils.AssertEqual(t, err, nil)
+
+		users, _ := db.GetUsersWithoutCanonicalName()
+		test_utils.AssertEqual(t, len(users), 1)
+		test_utils.AssertEqual(t, users[0].Username, "gopher")
+
+		// Running it again changes nothing
+		err = server.Migrate(db, server.NewAccountPolicy())
+		test_utils.AssertEqual(t, err, nil)
+		users, _ = db.GetUsersWithoutCanonicalName()
+		test_utils.AssertEqual(t, len(users), 1)
+	})
+
+	t.Run("canonical names from before look-alikes were folded are worked out again", func(t *testing.T) {
+		db := database.NewInMemoryDatabase()
+		db.SetSchemaVersion(1)
+		db.InsertUser(&database.User{Username: "paypal", CanonicalName: "paypal"})
+		// A Cyrillic а, which version 1 didn't fold
+		db.InsertUser(&database.User{Username: "p\u0430yp\u0430l", CanonicalName: "p\u0430yp\u0430l"})
+
+		err := server.Migrate(db, server.NewAccountPolicy())
+		test_utils.AssertEqual(t, err, nil)
+
+		users, _ := db.GetUsersWithoutCanonicalName()
+		test_utils.AssertEqual(t, len(users), 1)
+		test_utils.AssertEqual(t, users[0].Username, "p\u0430yp\u0430l")
+	})
+
+	t.Run("databases from newer servers aren't migrated", func(t *testing.T) {
+		db := database.NewInMemoryDatabase()
+		db.SetSchemaVersion(server.SchemaVersion + 1)
+
+		test_utils.AssertNotEqual(t, server.Migrate(db, server.NewAccountPolicy()), nil)
+		test_utils.AssertEqual(t, server.CheckSchema(db), nil)
+	})
+
+	t.Run("database errors stop migrations", func(t *testing.T) {
+		db := database.NewInMemoryDatabase()
+		db.ShouldErrorOnNext = true
+
+		test_utils.AssertNotEqual(t, server.Migrate(db, server.NewAccountPolicy()), nil)
+		db.ShouldErrorOnNext = false
+		version, _ := db.GetSchemaVersion()
+		test_utils.AssertEqual(t, version, 0)
+	})
+}
@@ -0,0 +1,128 @@
+package server_test
+
+import (
+	"fenix/src/database"
+	"fenix/src/server"
+	"fenix/src/test_utils"
+	"fenix/src/test_utils/test_client"
+	"net/http"
+	"strings"
+	"testing"
+)
+
+func TestOIDC(t *testing.T) {
+	// Starts a server that signs in with a stub issuer.
+	start := func(t *testing.T) (*test_utils.ServerFields, *test_utils.OIDCIssuer) {
+		t.Helper()
+		srv := test_utils.StartServer()
+		iss := test_utils.StartOIDCIssuer("fenix")
+		callback := srv.Addr
+		callback.Path = "/auth/oidc/callback"
+		srv.Hub.OIDC = server.NewOIDCProvider(iss.URL, "fenix", "shh", callback.String())
+		return srv, iss
+	}
+
+	t.Run("users are made on first sign in and can upgrade", func(t *testing.T) {
+		srv, iss := start(t)
+		defer srv.Close()
+		defer iss.Close()
+		iss.Claims["sub"] = "1234"
+		iss.Claims["preferred_username"] = "gopher123"
+
+		status, body := test_utils.OIDCLogin(srv.Addr)
+		test_utils.AssertEqual(t, status, http.StatusOK)
+		test_utils.AssertEqual(t, body["username"], "gopher123")
+		test_utils.AssertNotEqual(t, body["token"], "")
+
+		cli := test_utils.Upgrade(body, srv.Addr)
+		defer cli.Close()
+		testClient := testclient.TestClient{}
+		test_utils.AssertEqual(t, testClient.UserID(t, cli), body["userID"])
+
+		status, again := test_utils.OIDCLogin(srv.Addr)
+		test_utils.AssertEqual(t, status, http.StatusOK)
+		test_utils.AssertEqual(t, again["userID"], body["userID"])
+	})
+
+	t.Run("taken usernames get a suffix", func(t *testing.T) {
+		srv, iss := start(t)
+		defer srv.Close()
+		defer iss.Close()
+		srv.Addr.Path = "/register"
+		status, _ := test_utils.PostJSON(srv.Addr, map[string]string{"username": "gopher123", "password": "pass"})
+		test_utils.AssertEqual(t, status, http.StatusOK)
+
+		iss.Claims["sub"] = "1234"
+		iss.Claims["email"] = "Gopher123@example.com"
+		iss.Claims["email_verified"] = true
+
+		status, body := test_utils.OIDCLogin(srv.Addr)
+		test_utils.AssertEqual(t, status, http.StatusOK)
+		test_utils.AssertEqual(t, strings.HasPrefix(body["username"], "Gopher123_"), true)
+
+		u := &database.User{Username: body["username"]}
+		srv.Database.GetUser(u)
+		test_utils.AssertEqual(t, u.Email, "gopher123@example.com")
+		test_utils.AssertEqual(t, u.OIDCSubject, iss.URL+"|1234")
+	})
+
+	t.Run("emails only match when configured to", func(t *testing.T) {
+		srv, iss := start(t)
+		defer srv.Close()
+		defer iss.Close()
+		iss.Claims["sub"] = "1234"
+		iss.Claims["email"] = "gopher@example.com"
+		iss.Claims["email_verified"] = true
+		_, first := test_utils.OIDCLogin(srv.Addr)
+
+		srv.Hub.OIDC.Match = server.OIDCMatchEmail
+		iss.Claims["sub"] = "5678"
+		_, body := test_utils.OIDCLogin(srv.Addr)
+		test_utils.AssertEqual(t, body["userID"], first["userID"])
+
+		iss.Claims["sub"] = "9012"
+		iss.Claims["email_verified"] = false
+		_, body = test_utils.OIDCLogin(srv.Addr)
+		test_utils.AssertNotEqual(t, body["userID"], first["userID"])
+
+		srv.Hub.OIDC.Match = server.OIDCMatchSubject
+		iss.Claims["sub"] = "3456"
+		iss.Claims["email_verified"] = true
+		_, body = test_utils.OIDCLogin(srv.Addr)
+		test_utils.AssertNotEqual(t, body["userID"], first["userID"])
+	})
+
+	t.Run("ID tokens with bad signatures are rejected", func(t *testing.T) {
+		srv, iss := start(t)
+		defer srv.Close()
+		defer iss.Close()
+		iss.Claims["sub"] = "1234"
+		iss.WrongKey = true
+
+		status, _ := test_utils.OIDCLogin(srv.Addr)
+		test_utils.AssertEqual(t, status, http.StatusForbidden)
+	})
+
+	t.Run("unknown states are rejected", func(t *testing.T) {
+		srv, iss := start(t)
+		defer srv.Close()
+		defer iss.Close()
+
+		srv.Addr.Path = "/auth/oidc/callback"
+		srv.Addr.RawQuery = "state=nope&code=nope"
+		res, err := http.Get(srv.Addr.String())
+		if err != nil {
+			t.Fatalf("%q\n", err)
+		}
+		res.Body.Close()
+		test_utils.AssertEqual(t, res.StatusCode, http.StatusForbidden)
+	})
+
+	t.Run("sign in is off without a provider", func(t *testing.T) {
+		srv := test_utils.StartServer()
+		defer srv.Close()
+
+		status, _ := test_utils.OIDCLogin(srv.Addr)
+		test_utils.AssertEqual(t, status, http.StatusNotFound)
+	})
+}
@@ -0,0 +1,95 @@
+package server_test
+
+import (
+	"fenix/src/server"
+	"fenix/src/test_utils"
+	"fenix/src/test_utils/test_client"
+	"fenix/src/websocket_models"
+	"testing"
+	"time"
+)
+
+func TestMessageRateLimits(t *testing.T) {
+	// Sends whoami n times, returning the error sent back for the last one, if any.
+	whoami := func(t *testing.T, cli *test_utils.ClientFields, n int) websocket_models.GenericError {
+		t.Helper()
+		testClient := testclient.TestClient{}
+		var res websocket_models.GenericError
+		for i := 0; i < n; i++ {
+			testClient.WhoAmI(t, cli)
+			res = websocket_models.GenericError{}
+			err := cli.Conn.ReadJSON(&res)
+			if err != nil {
+				t.Fatalf("%q\n", err)
+			}
+		}
+		return res
+	}
+
+	t.Run("sessions over their limit are told when to retry", func(t *testing.T) {
+		srv := test_utils.StartServer()
+		defer srv.Close()
+		srv.Hub.RateLimits = &server.MessageLimiter{
+			Session: server.MessageLimits{Default: server.RateLimit{Burst: 2, Every: time.Hour}},
+		}
+		testClient := testclient.TestClient{}
+		cli := testClient.RegisterClient(t, srv, testclient.Credentials{Username: "billy", Password: "pass"})
+		defer cli.Close()
+
+		test_utils.AssertEqual(t, whoami(t, cli, 2).Error, "")
+		res := whoami(t, cli, 1)
+		test_utils.AssertEqual(t, res.Error, "RateLimited")
+		test_utils.AssertEqual(t, res.RetryAfter > 59*60*1000, true)
+	})
+
+	t.Run("message types have their own limits", func(t *testing.T) {
+		srv := test_utils.StartServer()
+		defer srv.Close()
+		srv.Hub.RateLimits = &server.MessageLimiter{
+			Session: server.MessageLimits{
+				Default: server.RateLimit{Burst: 1, Every: time.Hour},
+				Types:   map[string]server.RateLimit{websocket_models.WhoAmI{}.Type(): {}},
+			},
+		}
+		testClient := testclient.TestClient{}
+		cli := testClient.RegisterClient(t, srv, testclient.Credentials{Username: "billy", Password: "pass"})
+		defer cli.Close()
+
+		test_utils.AssertEqual(t, whoami(t, cli, 5).Error, "")
+	})
+
+	t.Run("users' limits last across sessions", func(t *testing.T) {
+		srv := test_utils.StartServer()
+		defer srv.Close()
+		srv.Hub.RateLimits = &server.MessageLimiter{
+			User: server.MessageLimits{Default: server.RateLimit{Burst: 2, Every: time.Hour}},
+		}
+		testClient := testclient.TestClient{}
+		cli := testClient.RegisterClient(t, srv, testclient.Credentials{Username: "billy", Password: "pass"})
+		test_utils.AssertEqual(t, whoami(t, cli, 2).Error, "")
+		cli.Close()
+
+		cli = testClient.LoginClient(t, srv, testclient.Credentials{Username: "billy", Password: "pass"})
+		defer cli.Close()
+		test_utils.AssertEqual(t, whoami(t, cli, 1).Error, "RateLimited")
+	})
+
+	t.Run("clients that keep going are disconnected", func(t *testing.T) {
+		srv := test_utils.StartServer()
+		defer srv.Close()
+		srv.Hub.RateLimits = &server.MessageLimiter{
+			Session:      server.MessageLimits{Default: server.RateLimit{Burst: 1, Every: time.Hour}},
+			Strikes:      2,
+			StrikeWindow: time.Minute,
+		}
+		testClient := testclient.TestClient{}
+		cli := testClient.RegisterClient(t, srv, testclient.Credentials{Username: "billy", Password: "pass"})
+		defer cli.Close()
+
+		res := whoami(t, cli, 4)
+		test_utils.AssertEqual(t, res.Error, "RateLimited")
+		test_utils.AssertEqual(t, res.RetryAfter, int64(0))
+		_, _, err := cli.Conn.ReadMessage()
+		test_utils.AssertNotEqual(t, err, nil)
+	})
+}
@@ -113,6 +113,38 @@ func TestMessageHandlers(t *testing.T) {
 		expected := 50
 		test_utils.AssertEqual(t, got, expected)
 	})
+
+	t.Run("message search finds populated messages", func(t *testing.T) {
+		srv, cli, closeConn := test_utils.StartServerAndConnect("gopher123", "pass", "/register")
+		defer closeConn()
+
+		test_utils.PopulateDB(srv, 2)
+		testClient := testclient.TestClient{}
+		testClient.MsgSearch(t, cli, "hello")
+
+		res := testClient.RecvMsgSearch(t, cli)
+		got := len(res.Results)
+		expected := 2
+		test_utils.AssertEqual(t, got, expected)
+	})
+
+	t.Run("message search doesnt allow empty queries", func(t *testing.T) {
+		_, cli, closeConn := test_utils.StartServerAndConnect("gopher123", "pass", "/register")
+		defer closeConn()
+
+		testClient := testclient.TestClient{}
+		testClient.MsgSearch(t, cli, "")
+
+		var resProto websocket_models.GenericError
+		err := cli.Conn.ReadJSON(&resProto)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		expected := "SearchQueryEmpty"
+		got := resProto.Error
+		test_utils.AssertEqual(t, got, expected)
+	})
 }
 
 func TestErrorHandling(t *testing.T) {
@@ -197,7 +229,8 @@ func TestYodelHandlers(t *testing.T) {
 
 		got := res
 		expected := websocket_models.Yodel{
-			YodelID: yodel.YodelID, Name: "Yodelyay"}.SetType()
+			YodelID: yodel.YodelID, Name: "Yodelyay", Owner: yodel.Owner,
+			Visibility: "public", Roles: database.BuiltinRoles}.SetType()
 
 		test_utils.AssertEqual(t, got, expected)
 	})
@@ -268,4 +301,1073 @@ then server responds with GenericError`, func(t *testing.T) {
 
 		test_utils.AssertEqual(t, got, expected)
 	})
+
+	t.Run("yodels are deleted if their channel cant be created", func(t *testing.T) {
+		srv, cli, close := test_utils.StartServerAndConnect("gopher123", "pass", "/register")
+		defer close()
+		db := srv.Hub.Database
+		srv.Hub.Database = failingChannelsDB{db}
+
+		testClient := testclient.TestClient{}
+		testClient.YodelCreate(t, cli, "Fenixland")
+
+		var res websocket_models.GenericError
+		err := cli.Conn.ReadJSON(&res)
+		if err != nil {
+			t.Fatalf("%v\n", err)
+		}
+		test_utils.AssertEqual(t, res.Error, "DatabaseError")
+
+		yodels, _ := db.ListYodels(&database.YodelQuery{All: true})
+		test_utils.AssertEqual(t, len(yodels), 0)
+		userID, _ := primitive.ObjectIDFromHex(testClient.UserID(t, cli))
+		members, _ := db.GetMembersOf(userID)
+		test_utils.AssertEqual(t, len(members), 0)
+	})
+}
+
+// Fails to insert channels, to test handlers that insert one after other documents.
+type failingChannelsDB struct {
+	database.Database
+}
+
+func (db failingChannelsDB) InsertChannel(*database.Channel) error {
+	return database.DatabaseError{}
+}
+
+func TestPinHandlers(t *testing.T) {
+	// Creates a yodel and sends a message to it, returning the yodel and message IDs.
+	yodelWithMessage := func(t *testing.T, cli *test_utils.ClientFields) (string, string) {
+		t.Helper()
+		testClient := testclient.TestClient{}
+		testClient.YodelCreate(t, cli, "Fenixland")
+
+		var yodel websocket_models.Yodel
+		err := cli.Conn.ReadJSON(&yodel)
+		if err != nil {
+			t.Fatalf("%v\n", err)
+		}
+
+		testClient.MsgSendToChannel(t, cli, "Read the rules!", testClient.DefaultChannel(t, cli, yodel.YodelID))
+		var msg websocket_models.MsgBroadcast
+		err = cli.Conn.ReadJSON(&msg)
+		if err != nil {
+			t.Fatalf("%v\n", err)
+		}
+		return yodel.YodelID, msg.MessageID
+	}
+
+	t.Run("pinning a message broadcasts pins_updated", func(t *testing.T) {
+		_, cli, close := test_utils.StartServerAndConnect("gopher123", "pass", "/register")
+		defer close()
+
+		yodelID, messageID := yodelWithMessage(t, cli)
+		testClient := testclient.TestClient{}
+		testClient.MsgPin(t, cli, messageID)
+
+		var res websocket_models.PinsUpdated
+		err := cli.Conn.ReadJSON(&res)
+		if err != nil {
+			t.Fatalf("%v\n", err)
+		}
+
+		got := res
+		expected := websocket_models.PinsUpdated{YodelID: yodelID, Pins: []string{messageID}}.SetType()
+		test_utils.AssertEqual(t, got, expected)
+	})
+
+	t.Run("yodel_get returns pins", func(t *testing.T) {
+		_, cli, close := test_utils.StartServerAndConnect("gopher123", "pass", "/register")
+		defer close()
+
+		yodelID, messageID := yodelWithMessage(t, cli)
+		testClient := testclient.TestClient{}
+		testClient.MsgPin(t, cli, messageID)
+		cli.Conn.ReadJSON(&websocket_models.PinsUpdated{})
+
+		testClient.YodelGet(t, cli, yodelID)
+		var res websocket_models.Yodel
+		err := cli.Conn.ReadJSON(&res)
+		if err != nil {
+			t.Fatalf("%v\n", err)
+		}
+
+		got := res.Pins
+		expected := []string{messageID}
+		test_utils.AssertEqual(t, got, expected)
+	})
+
+	t.Run("unpinning a message removes it", func(t *testing.T) {
+		_, cli, close := test_utils.StartServerAndConnect("gopher123", "pass", "/register")
+		defer close()
+
+		_, messageID := yodelWithMessage(t, cli)
+		testClient := testclient.TestClient{}
+		testClient.MsgPin(t, cli, messageID)
+		cli.Conn.ReadJSON(&websocket_models.PinsUpdated{})
+		testClient.MsgUnpin(t, cli, messageID)
+
+		var res websocket_models.PinsUpdated
+		err := cli.Conn.ReadJSON(&res)
+		if err != nil {
+			t.Fatalf("%v\n", err)
+		}
+
+		got := len(res.Pins)
+		expected := 0
+		test_utils.AssertEqual(t, got, expected)
+	})
+
+	t.Run("members cant pin messages", func(t *testing.T) {
+		srv, cli, close := test_utils.StartServerAndConnect("gopher123", "pass", "/register")
+		defer close()
+
+		yodelID, messageID := yodelWithMessage(t, cli)
+		other := test_utils.Connect("billy", "pass", srv.Addr)
+		defer other.Close()
+
+		testClient := testclient.TestClient{}
+		testClient.YodelJoin(t, other, yodelID)
+		other.Conn.ReadJSON(&websocket_models.Yodel{})
+		testClient.MsgPin(t, other, messageID)
+
+		var res websocket_models.GenericError
+		err := other.Conn.ReadJSON(&res)
+		if err != nil {
+			t.Fatalf("%v\n", err)
+		}
+
+		got := res.Error
+		expected := "MissingPermission"
+		test_utils.AssertEqual(t, got, expected)
+	})
+
+	t.Run("sending to a yodel requires membership", func(t *testing.T) {
+		srv, cli, close := test_utils.StartServerAndConnect("gopher123", "pass", "/register")
+		defer close()
+
+		yodelID, _ := yodelWithMessage(t, cli)
+		other := test_utils.Connect("billy", "pass", srv.Addr)
+		defer other.Close()
+
+		testClient := testclient.TestClient{}
+		testClient.MsgSendToChannel(t, other, "let me in", testClient.DefaultChannel(t, cli, yodelID))
+
+		var res websocket_models.GenericError
+		err := other.Conn.ReadJSON(&res)
+		if err != nil {
+			t.Fatalf("%v\n", err)
+		}
+
+		got := res.Error
+		expected := "NotYodelMember"
+		test_utils.AssertEqual(t, got, expected)
+	})
+}
+
+func TestYodelVisibility(t *testing.T) {
+	// Creates a yodel with the given visibility, returning its ID.
+	createYodel := func(t *testing.T, cli *test_utils.ClientFields, name, visibility string) string {
+		t.Helper()
+		err := cli.Conn.WriteJSON(websocket_models.YodelCreate{Name: name, Visibility: visibility}.SetType())
+		if err != nil {
+			t.Fatalf("%v\n", err)
+		}
+
+		var yodel websocket_models.Yodel
+		err = cli.Conn.ReadJSON(&yodel)
+		if err != nil {
+			t.Fatalf("%v\n", err)
+		}
+		return yodel.YodelID
+	}
+
+	listNames := func(t *testing.T, cli *test_utils.ClientFields, list websocket_models.YodelList) []string {
+		t.Helper()
+		err := cli.Conn.WriteJSON(list.SetType())
+		if err != nil {
+			t.Fatalf("%v\n", err)
+		}
+
+		var res websocket_models.YodelList
+		err = cli.Conn.ReadJSON(&res)
+		if err != nil {
+			t.Fatalf("%v\n", err)
+		}
+
+		names := []string{}
+		for _, y := range res.Yodels {
+			names = append(names, y.Name)
+		}
+		return names
+	}
+
+	t.Run("invalid visibility is rejected", func(t *testing.T) {
+		_, cli, close := test_utils.StartServerAndConnect("gopher123", "pass", "/register")
+		defer close()
+
+		cli.Conn.WriteJSON(websocket_models.YodelCreate{Name: "Fenixland", Visibility: "secret"}.SetType())
+
+		var res websocket_models.GenericError
+		err := cli.Conn.ReadJSON(&res)
+		if err != nil {
+			t.Fatalf("%v\n", err)
+		}
+
+		got := res.Error
+		expected := "InvalidVisibility"
+		test_utils.AssertEqual(t, got, expected)
+	})
+
+	t.Run("private yodels are only listed for members", func(t *testing.T) {
+		srv, cli, close := test_utils.StartServerAndConnect("gopher123", "pass", "/register")
+		defer close()
+
+		createYodel(t, cli, "Amazing", "public")
+		createYodel(t, cli, "Bunker", "private")
+		createYodel(t, cli, "Club", "invite")
+		other := test_utils.Connect("billy", "pass", srv.Addr)
+		defer other.Close()
+
+		test_utils.AssertEqual(t, listNames(t, cli, websocket_models.YodelList{}), []string{"Amazing", "Bunker", "Club"})
+		test_utils.AssertEqual(t, listNames(t, other, websocket_models.YodelList{}), []string{"Amazing", "Club"})
+	})
+
+	t.Run("list searches names and paginates", func(t *testing.T) {
+		_, cli, close := test_utils.StartServerAndConnect("gopher123", "pass", "/register")
+		defer close()
+
+		createYodel(t, cli, "Fenixland", "")
+		createYodel(t, cli, "Gophers", "")
+		createYodel(t, cli, "More fenix", "")
+
+		test_utils.AssertEqual(t, listNames(t, cli, websocket_models.YodelList{Name: "FENIX"}), []string{"Fenixland", "More fenix"})
+		test_utils.AssertEqual(t, listNames(t, cli, websocket_models.YodelList{Offset: 1, Limit: 1}), []string{"Gophers"})
+	})
+
+	t.Run("private yodels are hidden from non members", func(t *testing.T) {
+		srv, cli, close := test_utils.StartServerAndConnect("gopher123", "pass", "/register")
+		defer close()
+
+		yodelID := createYodel(t, cli, "Bunker", "private")
+		other := test_utils.Connect("billy", "pass", srv.Addr)
+		defer other.Close()
+
+		testClient := testclient.TestClient{}
+		testClient.YodelGet(t, other, yodelID)
+
+		var res websocket_models.GenericError
+		err := other.Conn.ReadJSON(&res)
+		if err != nil {
+			t.Fatalf("%v\n", err)
+		}
+
+		got := res.Error
+		expected := "YodelDoesntExistError"
+		test_utils.AssertEqual(t, got, expected)
+	})
+
+	t.Run("non members cant search private yodels or their channels", func(t *testing.T) {
+		srv, cli, close := test_utils.StartServerAndConnect("gopher123", "pass", "/register")
+		defer close()
+
+		yodelID := createYodel(t, cli, "Bunker", "private")
+		testClient := testclient.TestClient{}
+		channelID := testClient.DefaultChannel(t, cli, yodelID)
+		testClient.MsgSendToChannel(t, cli, "secret plans", channelID)
+		cli.Conn.ReadJSON(&websocket_models.MsgBroadcast{})
+
+		other := test_utils.Connect("billy", "pass", srv.Addr)
+		defer other.Close()
+		for _, search := range []websocket_models.MsgSearch{
+			{Query: "secret", YodelID: yodelID},
+			{Query: "secret", ChannelID: channelID},
+		} {
+			other.Conn.WriteJSON(search.SetType())
+			var res websocket_models.GenericError
+			err := other.Conn.ReadJSON(&res)
+			if err != nil {
+				t.Fatalf("%v\n", err)
+			}
+			test_utils.AssertEqual(t, res.Error, "NotYodelMember")
+		}
+
+		testClient.MsgSearch(t, other, "secret")
+		test_utils.AssertEqual(t, len(testClient.RecvMsgSearch(t, other).Results), 0)
+
+		testClient.MsgSearch(t, cli, "secret")
+		test_utils.AssertEqual(t, len(testClient.RecvMsgSearch(t, cli).Results), 1)
+	})
+
+	t.Run("invite only yodels cant be joined directly", func(t *testing.T) {
+		srv, cli, close := test_utils.StartServerAndConnect("gopher123", "pass", "/register")
+		defer close()
+
+		yodelID := createYodel(t, cli, "Club", "invite")
+		other := test_utils.Connect("billy", "pass", srv.Addr)
+		defer other.Close()
+
+		testClient := testclient.TestClient{}
+		testClient.YodelJoin(t, other, yodelID)
+
+		var res websocket_models.GenericError
+		err := other.Conn.ReadJSON(&res)
+		if err != nil {
+			t.Fatalf("%v\n", err)
+		}
+
+		got := res.Error
+		expected := "InviteRequired"
+		test_utils.AssertEqual(t, got, expected)
+	})
+}
+
+func TestInviteHandlers(t *testing.T) {
+	privateYodel := func(t *testing.T, cli *test_utils.ClientFields) string {
+		t.Helper()
+		cli.Conn.WriteJSON(websocket_models.YodelCreate{Name: "Bunker", Visibility: "private"}.SetType())
+
+		var yodel websocket_models.Yodel
+		err := cli.Conn.ReadJSON(&yodel)
+		if err != nil {
+			t.Fatalf("%v\n", err)
+		}
+		return yodel.YodelID
+	}
+
+	t.Run("redeeming an invite joins a private yodel", func(t *testing.T) {
+		srv, cli, close := test_utils.StartServerAndConnect("gopher123", "pass", "/register")
+		defer close()
+
+		yodelID := privateYodel(t, cli)
+		testClient := testclient.TestClient{}
+		invite := testClient.InviteCreate(t, cli, websocket_models.InviteCreate{YodelID: yodelID, MaxUses: 1})
+
+		other := test_utils.Connect("billy", "pass", srv.Addr)
+		defer other.Close()
+		testClient.InviteRedeem(t, other, invite.Code)
+
+		var res websocket_models.Yodel
+		err := other.Conn.ReadJSON(&res)
+		if err != nil {
+			t.Fatalf("%v\n", err)
+		}
+
+		got := res.YodelID
+		expected := yodelID
+		test_utils.AssertEqual(t, got, expected)
+	})
+
+	t.Run("invite max uses is enforced", func(t *testing.T) {
+		srv, cli, close := test_utils.StartServerAndConnect("gopher123", "pass", "/register")
+		defer close()
+
+		yodelID := privateYodel(t, cli)
+		testClient := testclient.TestClient{}
+		invite := testClient.InviteCreate(t, cli, websocket_models.InviteCreate{YodelID: yodelID, MaxUses: 1})
+
+		billy := test_utils.Connect("billy", "pass", srv.Addr)
+		defer billy.Close()
+		testClient.InviteRedeem(t, billy, invite.Code)
+		billy.Conn.ReadJSON(&websocket_models.Yodel{})
+
+		luk := test_utils.Connect("luk", "pass", srv.Addr)
+		defer luk.Close()
+		testClient.InviteRedeem(t, luk, invite.Code)
+
+		var res websocket_models.GenericError
+		err := luk.Conn.ReadJSON(&res)
+		if err != nil {
+			t.Fatalf("%v\n", err)
+		}
+
+		got := res.Error
+		expected := "InviteUsedUp"
+		test_utils.AssertEqual(t, got, expected)
+	})
+
+	t.Run("revoked invites cant be redeemed", func(t *testing.T) {
+		srv, cli, close := test_utils.StartServerAndConnect("gopher123", "pass", "/register")
+		defer close()
+
+		yodelID := privateYodel(t, cli)
+		testClient := testclient.TestClient{}
+		invite := testClient.InviteCreate(t, cli, websocket_models.InviteCreate{YodelID: yodelID})
+		testClient.InviteRevoke(t, cli, invite.Code)
+		cli.Conn.ReadJSON(&websocket_models.Invite{})
+
+		other := test_utils.Connect("billy", "pass", srv.Addr)
+		defer other.Close()
+		testClient.InviteRedeem(t, other, invite.Code)
+
+		var res websocket_models.GenericError
+		err := other.Conn.ReadJSON(&res)
+		if err != nil {
+			t.Fatalf("%v\n", err)
+		}
+
+		got := res.Error
+		expected := "InviteRevoked"
+		test_utils.AssertEqual(t, got, expected)
+	})
+
+	t.Run("non members cant create invites", func(t *testing.T) {
+		srv, cli, close := test_utils.StartServerAndConnect("gopher123", "pass", "/register")
+		defer close()
+
+		yodelID := privateYodel(t, cli)
+		other := test_utils.Connect("billy", "pass", srv.Addr)
+		defer other.Close()
+
+		other.Conn.WriteJSON(websocket_models.InviteCreate{YodelID: yodelID}.SetType())
+		var res websocket_models.GenericError
+		err := other.Conn.ReadJSON(&res)
+		if err != nil {
+			t.Fatalf("%v\n", err)
+		}
+
+		got := res.Error
+		expected := "NotYodelMember"
+		test_utils.AssertEqual(t, got, expected)
+	})
+
+	t.Run("kicked creators cant revoke their invites", func(t *testing.T) {
+		owner, billy, yodelID, close := startYodelWithMember(t)
+		defer close()
+
+		testClient := testclient.TestClient{}
+		billyID := testClient.UserID(t, billy)
+		testClient.RoleAssign(t, owner, yodelID, billyID, database.RoleModerator)
+		billy.Conn.ReadJSON(&websocket_models.MemberRoleUpdated{})
+		owner.Conn.ReadJSON(&websocket_models.MemberRoleUpdated{})
+		invite := testClient.InviteCreate(t, billy, websocket_models.InviteCreate{YodelID: yodelID})
+
+		owner.Conn.WriteJSON(websocket_models.MemberKick{YodelID: yodelID, UserID: billyID}.SetType())
+		billy.Conn.ReadJSON(&websocket_models.MemberRemoved{})
+		testClient.InviteRevoke(t, billy, invite.Code)
+
+		var res websocket_models.GenericError
+		err := billy.Conn.ReadJSON(&res)
+		if err != nil {
+			t.Fatalf("%v\n", err)
+		}
+
+		got := res.Error
+		expected := "NotYodelMember"
+		test_utils.AssertEqual(t, got, expected)
+	})
+}
+
+// Starts a server where gopher123 owns a yodel that billy has joined.
+func startYodelWithMember(t *testing.T) (*test_utils.ClientFields, *test_utils.ClientFields, string, func()) {
+	t.Helper()
+	srv, owner, close := test_utils.StartServerAndConnect("gopher123", "pass", "/register")
+	testClient := testclient.TestClient{}
+	testClient.YodelCreate(t, owner, "Fenixland")
+
+	var yodel websocket_models.Yodel
+	err := owner.Conn.ReadJSON(&yodel)
+	if err != nil {
+		t.Fatalf("%v\n", err)
+	}
+
+	billy := test_utils.Connect("billy", "pass", srv.Addr)
+	testClient.YodelJoin(t, billy, yodel.YodelID)
+	billy.Conn.ReadJSON(&websocket_models.Yodel{})
+
+	return owner, billy, yodel.YodelID, func() {
+		billy.Close()
+		close()
+	}
+}
+
+func TestRoleHandlers(t *testing.T) {
+	t.Run("creating a role broadcasts roles_updated", func(t *testing.T) {
+		owner, billy, yodelID, close := startYodelWithMember(t)
+		defer close()
+
+		testClient := testclient.TestClient{}
+		testClient.RoleCreate(t, owner, yodelID, "pinner", database.PermissionSend|database.PermissionPin)
+
+		var res websocket_models.RolesUpdated
+		err := billy.Conn.ReadJSON(&res)
+		if err != nil {
+			t.Fatalf("%v\n", err)
+		}
+
+		got := res.Roles[len(res.Roles)-1]
+		expected := database.Role{Name: "pinner", Permissions: database.PermissionSend | database.PermissionPin}
+		test_utils.AssertEqual(t, got, expected)
+	})
+
+	t.Run("assigned roles grant permissions", func(t *testing.T) {
+		owner, billy, yodelID, close := startYodelWithMember(t)
+		defer close()
+
+		testClient := testclient.TestClient{}
+		billyID := testClient.UserID(t, billy)
+		testClient.RoleAssign(t, owner, yodelID, billyID, database.RoleModerator)
+
+		var updated websocket_models.MemberRoleUpdated
+		err := billy.Conn.ReadJSON(&updated)
+		if err != nil {
+			t.Fatalf("%v\n", err)
+		}
+		test_utils.AssertEqual(t, updated.Role, database.RoleModerator)
+
+		testClient.MsgSendToChannel(t, billy, "Read the rules!", testClient.DefaultChannel(t, billy, yodelID))
+		var msg websocket_models.MsgBroadcast
+		err = billy.Conn.ReadJSON(&msg)
+		if err != nil {
+			t.Fatalf("%v\n", err)
+		}
+		testClient.MsgPin(t, billy, msg.MessageID)
+
+		var res websocket_models.PinsUpdated
+		err = billy.Conn.ReadJSON(&res)
+		if err != nil {
+			t.Fatalf("%v\n", err)
+		}
+
+		got := res.Pins
+		expected := []string{msg.MessageID}
+		test_utils.AssertEqual(t, got, expected)
+	})
+
+	t.Run("members cant manage roles", func(t *testing.T) {
+		_, billy, yodelID, close := startYodelWithMember(t)
+		defer close()
+
+		testClient := testclient.TestClient{}
+		testClient.RoleCreate(t, billy, yodelID, "pinner", database.PermissionPin)
+
+		var res websocket_models.GenericError
+		err := billy.Conn.ReadJSON(&res)
+		if err != nil {
+			t.Fatalf("%v\n", err)
+		}
+
+		got := res.Error
+		expected := "MissingPermission"
+		test_utils.AssertEqual(t, got, expected)
+	})
+
+	t.Run("roles cant grant more than their creator has", func(t *testing.T) {
+		owner, billy, yodelID, close := startYodelWithMember(t)
+		defer close()
+
+		testClient := testclient.TestClient{}
+		testClient.RoleCreate(t, owner, yodelID, "role-manager", database.PermissionManageRoles)
+		billy.Conn.ReadJSON(&websocket_models.RolesUpdated{})
+		testClient.RoleAssign(t, owner, yodelID, testClient.UserID(t, billy), "role-manager")
+		billy.Conn.ReadJSON(&websocket_models.MemberRoleUpdated{})
+
+		testClient.RoleCreate(t, billy, yodelID, "kicker", database.PermissionKick)
+
+		var res websocket_models.GenericError
+		err := billy.Conn.ReadJSON(&res)
+		if err != nil {
+			t.Fatalf("%v\n", err)
+		}
+
+		got := res.Error
+		expected := "PermissionEscalation"
+		test_utils.AssertEqual(t, got, expected)
+	})
+
+	t.Run("admins cant change the roles of other admins", func(t *testing.T) {
+		srv, owner, close := test_utils.StartServerAndConnect("gopher123", "pass", "/register")
+		defer close()
+
+		testClient := testclient.TestClient{}
+		testClient.YodelCreate(t, owner, "Fenixland")
+		var yodel websocket_models.Yodel
+		err := owner.Conn.ReadJSON(&yodel)
+		if err != nil {
+			t.Fatalf("%v\n", err)
+		}
+
+		admins := []*test_utils.ClientFields{}
+		for _, name := range []string{"billy", "luk"} {
+			admin := test_utils.Connect(name, "pass", srv.Addr)
+			defer admin.Close()
+			testClient.YodelJoin(t, admin, yodel.YodelID)
+			admin.Conn.ReadJSON(&websocket_models.Yodel{})
+			admins = append(admins, admin)
+		}
+		for _, admin := range admins {
+			testClient.RoleAssign(t, owner, yodel.YodelID, testClient.UserID(t, admin), database.RoleAdmin)
+			owner.Conn.ReadJSON(&websocket_models.MemberRoleUpdated{})
+			for _, a := range admins {
+				a.Conn.ReadJSON(&websocket_models.MemberRoleUpdated{})
+			}
+		}
+
+		testClient.RoleAssign(t, admins[0], yodel.YodelID, testClient.UserID(t, admins[1]), database.RoleMember)
+
+		var res websocket_models.GenericError
+		err = admins[0].Conn.ReadJSON(&res)
+		if err != nil {
+			t.Fatalf("%v\n", err)
+		}
+
+		got := res.Error
+		expected := "PermissionEscalation"
+		test_utils.AssertEqual(t, got, expected)
+	})
+}
+
+func TestMessageDeletion(t *testing.T) {
+	// Sends a message to the yodel's default channel, returning its broadcast once every client has recieved it.
+	send := func(t *testing.T, from *test_utils.ClientFields, yodelID string, clients ...*test_utils.ClientFields) websocket_models.MsgBroadcast {
+		t.Helper()
+		testClient := testclient.TestClient{}
+		testClient.MsgSendToChannel(t, from, "Read the rules!", testClient.DefaultChannel(t, from, yodelID))
+
+		var msg websocket_models.MsgBroadcast
+		for _, cli := range clients {
+			err := cli.Conn.ReadJSON(&msg)
+			if err != nil {
+				t.Fatalf("%v\n", err)
+			}
+		}
+		return msg
+	}
+
+	t.Run("authors can delete their own messages", func(t *testing.T) {
+		owner, billy, yodelID, close := startYodelWithMember(t)
+		defer close()
+
+		msg := send(t, billy, yodelID, billy, owner)
+		testClient := testclient.TestClient{}
+		testClient.MsgDelete(t, billy, msg.MessageID)
+
+		var res websocket_models.MsgDeleted
+		err := owner.Conn.ReadJSON(&res)
+		if err != nil {
+			t.Fatalf("%v\n", err)
+		}
+
+		got := res
+		expected := websocket_models.MsgDeleted{MessageID: msg.MessageID, YodelID: yodelID, ChannelID: msg.ChannelID}.SetType()
+		test_utils.AssertEqual(t, got, expected)
+	})
+
+	t.Run("members cant delete others messages", func(t *testing.T) {
+		owner, billy, yodelID, close := startYodelWithMember(t)
+		defer close()
+
+		msg := send(t, owner, yodelID, owner, billy)
+		testClient := testclient.TestClient{}
+		testClient.MsgDelete(t, billy, msg.MessageID)
+
+		var res websocket_models.GenericError
+		err := billy.Conn.ReadJSON(&res)
+		if err != nil {
+			t.Fatalf("%v\n", err)
+		}
+
+		got := res.Error
+		expected := "MissingPermission"
+		test_utils.AssertEqual(t, got, expected)
+	})
+
+	t.Run("moderators can delete others messages, unpinning them", func(t *testing.T) {
+		owner, billy, yodelID, close := startYodelWithMember(t)
+		defer close()
+
+		msg := send(t, owner, yodelID, owner, billy)
+		testClient := testclient.TestClient{}
+		testClient.MsgPin(t, owner, msg.MessageID)
+		owner.Conn.ReadJSON(&websocket_models.PinsUpdated{})
+		billy.Conn.ReadJSON(&websocket_models.PinsUpdated{})
+		testClient.RoleAssign(t, owner, yodelID, testClient.UserID(t, billy), database.RoleModerator)
+		owner.Conn.ReadJSON(&websocket_models.MemberRoleUpdated{})
+		billy.Conn.ReadJSON(&websocket_models.MemberRoleUpdated{})
+
+		testClient.MsgDelete(t, billy, msg.MessageID)
+		var deleted websocket_models.MsgDeleted
+		err := billy.Conn.ReadJSON(&deleted)
+		if err != nil {
+			t.Fatalf("%v\n", err)
+		}
+		test_utils.AssertEqual(t, deleted.MessageID, msg.MessageID)
+
+		testClient.YodelGet(t, billy, yodelID)
+		var res websocket_models.Yodel
+		err = billy.Conn.ReadJSON(&res)
+		if err != nil {
+			t.Fatalf("%v\n", err)
+		}
+
+		got := len(res.Pins)
+		expected := 0
+		test_utils.AssertEqual(t, got, expected)
+	})
+
+	t.Run("messages outside yodels can only be deleted by their author", func(t *testing.T) {
+		srv, cli, close := test_utils.StartServerAndConnect("gopher123", "pass", "/register")
+		defer close()
+		other := test_utils.Connect("billy", "pass", srv.Addr)
+		defer other.Close()
+
+		testClient := testclient.TestClient{}
+		testClient.MsgSend(t, cli, "hello")
+		var msg websocket_models.MsgBroadcast
+		cli.Conn.ReadJSON(&msg)
+		other.Conn.ReadJSON(&websocket_models.MsgBroadcast{})
+
+		testClient.MsgDelete(t, other, msg.MessageID)
+		var res websocket_models.GenericError
+		err := other.Conn.ReadJSON(&res)
+		if err != nil {
+			t.Fatalf("%v\n", err)
+		}
+
+		got := res.Error
+		expected := "NotMessageAuthor"
+		test_utils.AssertEqual(t, got, expected)
+	})
+}
+
+func TestModerationHandlers(t *testing.T) {
+	readError := func(t *testing.T, cli *test_utils.ClientFields) string {
+		t.Helper()
+		var res websocket_models.GenericError
+		err := cli.Conn.ReadJSON(&res)
+		if err != nil {
+			t.Fatalf("%v\n", err)
+		}
+		return res.Error
+	}
+
+	t.Run("kicked members are removed from the yodel", func(t *testing.T) {
+		owner, billy, yodelID, close := startYodelWithMember(t)
+		defer close()
+
+		testClient := testclient.TestClient{}
+		billyID := testClient.UserID(t, billy)
+		channelID := testClient.DefaultChannel(t, billy, yodelID)
+		owner.Conn.WriteJSON(websocket_models.MemberKick{YodelID: yodelID, UserID: billyID, Reason: "spam"}.SetType())
+
+		var removed websocket_models.MemberRemoved
+		err := billy.Conn.ReadJSON(&removed)
+		if err != nil {
+			t.Fatalf("%v\n", err)
+		}
+		test_utils.AssertEqual(t, removed, websocket_models.MemberRemoved{
+			YodelID: yodelID, UserID: billyID, Action: "kick", Reason: "spam"}.SetType())
+
+		testClient.MsgSendToChannel(t, billy, "hello?", channelID)
+		test_utils.AssertEqual(t, readError(t, billy), "NotYodelMember")
+	})
+
+	t.Run("banned users cant rejoin or send", func(t *testing.T) {
+		owner, billy, yodelID, close := startYodelWithMember(t)
+		defer close()
+
+		testClient := testclient.TestClient{}
+		channelID := testClient.DefaultChannel(t, billy, yodelID)
+		owner.Conn.WriteJSON(websocket_models.MemberBan{YodelID: yodelID, UserID: testClient.UserID(t, billy)}.SetType())
+		billy.Conn.ReadJSON(&websocket_models.MemberRemoved{})
+
+		testClient.YodelJoin(t, billy, yodelID)
+		test_utils.AssertEqual(t, readError(t, billy), "BannedFromYodel")
+
+		testClient.MsgSendToChannel(t, billy, "hello?", channelID)
+		test_utils.AssertEqual(t, readError(t, billy), "BannedFromYodel")
+	})
+
+	t.Run("muted members cant send", func(t *testing.T) {
+		owner, billy, yodelID, close := startYodelWithMember(t)
+		defer close()
+
+		testClient := testclient.TestClient{}
+		channelID := testClient.DefaultChannel(t, billy, yodelID)
+		expires := time.Now().Add(time.Hour).UnixNano()
+		owner.Conn.WriteJSON(websocket_models.MemberMute{YodelID: yodelID, UserID: testClient.UserID(t, billy), Expires: expires}.SetType())
+		billy.Conn.ReadJSON(&websocket_models.MemberMuted{})
+
+		testClient.MsgSendToChannel(t, billy, "hello?", channelID)
+		test_utils.AssertEqual(t, readError(t, billy), "MutedInYodel")
+	})
+
+	t.Run("members cant kick", func(t *testing.T) {
+		owner, billy, yodelID, close := startYodelWithMember(t)
+		defer close()
+
+		testClient := testclient.TestClient{}
+		billy.Conn.WriteJSON(websocket_models.MemberKick{YodelID: yodelID, UserID: testClient.UserID(t, owner)}.SetType())
+
+		test_utils.AssertEqual(t, readError(t, billy), "MissingPermission")
+	})
+
+	t.Run("moderators cant kick the owner", func(t *testing.T) {
+		owner, billy, yodelID, close := startYodelWithMember(t)
+		defer close()
+
+		testClient := testclient.TestClient{}
+		testClient.RoleAssign(t, owner, yodelID, testClient.UserID(t, billy), database.RoleModerator)
+		billy.Conn.ReadJSON(&websocket_models.MemberRoleUpdated{})
+		owner.Conn.ReadJSON(&websocket_models.MemberRoleUpdated{})
+
+		billy.Conn.WriteJSON(websocket_models.MemberKick{YodelID: yodelID, UserID: testClient.UserID(t, owner)}.SetType())
+
+		test_utils.AssertEqual(t, readError(t, billy), "CantModerateMember")
+	})
+
+	t.Run("moderators cant kick other moderators", func(t *testing.T) {
+		srv, owner, close := test_utils.StartServerAndConnect("gopher123", "pass", "/register")
+		defer close()
+
+		testClient := testclient.TestClient{}
+		testClient.YodelCreate(t, owner, "Fenixland")
+		var yodel websocket_models.Yodel
+		err := owner.Conn.ReadJSON(&yodel)
+		if err != nil {
+			t.Fatalf("%v\n", err)
+		}
+
+		mods := []*test_utils.ClientFields{}
+		for _, name := range []string{"billy", "luk"} {
+			mod := test_utils.Connect(name, "pass", srv.Addr)
+			defer mod.Close()
+			testClient.YodelJoin(t, mod, yodel.YodelID)
+			mod.Conn.ReadJSON(&websocket_models.Yodel{})
+			mods = append(mods, mod)
+		}
+		for _, mod := range mods {
+			testClient.RoleAssign(t, owner, yodel.YodelID, testClient.UserID(t, mod), database.RoleModerator)
+			owner.Conn.ReadJSON(&websocket_models.MemberRoleUpdated{})
+			for _, m := range mods {
+				m.Conn.ReadJSON(&websocket_models.MemberRoleUpdated{})
+			}
+		}
+
+		mods[0].Conn.WriteJSON(websocket_models.MemberKick{YodelID: yodel.YodelID, UserID: testClient.UserID(t, mods[1])}.SetType())
+
+		test_utils.AssertEqual(t, readError(t, mods[0]), "CantModerateMember")
+	})
+}
+
+func TestYodelManagement(t *testing.T) {
+	t.Run("updating a yodel broadcasts yodel_updated to members", func(t *testing.T) {
+		owner, billy, yodelID, close := startYodelWithMember(t)
+		defer close()
+
+		name, topic := "Fenixville", "birds"
+		testClient := testclient.TestClient{}
+		testClient.YodelUpdate(t, owner, websocket_models.YodelUpdate{YodelID: yodelID, Name: &name, Topic: &topic})
+
+		var res websocket_models.YodelUpdated
+		err := billy.Conn.ReadJSON(&res)
+		if err != nil {
+			t.Fatalf("%v\n", err)
+		}
+
+		test_utils.AssertEqual(t, res.Yodel.Name, name)
+		test_utils.AssertEqual(t, res.Yodel.Topic, topic)
+	})
+
+	t.Run("members cant update the yodel", func(t *testing.T) {
+		_, billy, yodelID, close := startYodelWithMember(t)
+		defer close()
+
+		name := "Billyland"
+		testClient := testclient.TestClient{}
+		testClient.YodelUpdate(t, billy, websocket_models.YodelUpdate{YodelID: yodelID, Name: &name})
+
+		var res websocket_models.GenericError
+		err := billy.Conn.ReadJSON(&res)
+		if err != nil {
+			t.Fatalf("%v\n", err)
+		}
+		test_utils.AssertEqual(t, res.Error, "MissingPermission")
+	})
+
+	t.Run("deleting a yodel tells members and removes it", func(t *testing.T) {
+		owner, billy, yodelID, close := startYodelWithMember(t)
+		defer close()
+
+		testClient := testclient.TestClient{}
+		testClient.YodelDelete(t, owner, yodelID)
+
+		var deleted websocket_models.YodelDeleted
+		err := billy.Conn.ReadJSON(&deleted)
+		if err != nil {
+			t.Fatalf("%v\n", err)
+		}
+		test_utils.AssertEqual(t, deleted, websocket_models.YodelDeleted{YodelID: yodelID}.SetType())
+
+		testClient.YodelGet(t, billy, yodelID)
+		var res websocket_models.GenericError
+		err = billy.Conn.ReadJSON(&res)
+		if err != nil {
+			t.Fatalf("%v\n", err)
+		}
+		test_utils.AssertEqual(t, res.Error, "YodelDoesntExistError")
+	})
+
+	t.Run("only the owner can delete a yodel", func(t *testing.T) {
+		_, billy, yodelID, close := startYodelWithMember(t)
+		defer close()
+
+		testClient := testclient.TestClient{}
+		testClient.YodelDelete(t, billy, yodelID)
+
+		var res websocket_models.GenericError
+		err := billy.Conn.ReadJSON(&res)
+		if err != nil {
+			t.Fatalf("%v\n", err)
+		}
+		test_utils.AssertEqual(t, res.Error, "NotYodelOwner")
+	})
+
+	t.Run("transferring a yodel changes its owner", func(t *testing.T) {
+		owner, billy, yodelID, close := startYodelWithMember(t)
+		defer close()
+
+		testClient := testclient.TestClient{}
+		billyID := testClient.UserID(t, billy)
+		testClient.YodelTransfer(t, owner, yodelID, billyID)
+
+		var res websocket_models.YodelUpdated
+		err := billy.Conn.ReadJSON(&res)
+		if err != nil {
+			t.Fatalf("%v\n", err)
+		}
+		test_utils.AssertEqual(t, res.Yodel.Owner, billyID)
+
+		// The previous owner can no longer delete it
+		owner.Conn.ReadJSON(&websocket_models.YodelUpdated{})
+		testClient.YodelDelete(t, owner, yodelID)
+		var errRes websocket_models.GenericError
+		err = owner.Conn.ReadJSON(&errRes)
+		if err != nil {
+			t.Fatalf("%v\n", err)
+		}
+		test_utils.AssertEqual(t, errRes.Error, "NotYodelOwner")
+	})
+}
+
+func TestChannelHandlers(t *testing.T) {
+	readError := func(t *testing.T, cli *test_utils.ClientFields) string {
+		t.Helper()
+		var res websocket_models.GenericError
+		err := cli.Conn.ReadJSON(&res)
+		if err != nil {
+			t.Fatalf("%v\n", err)
+		}
+		return res.Error
+	}
+
+	t.Run("yodels are created with a general channel", func(t *testing.T) {
+		owner, _, yodelID, close := startYodelWithMember(t)
+		defer close()
+
+		testClient := testclient.TestClient{}
+		channels := testClient.ChannelList(t, owner, yodelID)
+
+		test_utils.AssertEqual(t, len(channels), 1)
+		test_utils.AssertEqual(t, channels[0].Name, database.DefaultChannelName)
+	})
+
+	t.Run("creating a channel broadcasts channels_updated", func(t *testing.T) {
+		owner, billy, yodelID, close := startYodelWithMember(t)
+		defer close()
+
+		testClient := testclient.TestClient{}
+		testClient.ChannelCreate(t, owner, yodelID, "random")
+
+		var res websocket_models.ChannelsUpdated
+		err := billy.Conn.ReadJSON(&res)
+		if err != nil {
+			t.Fatalf("%v\n", err)
+		}
+
+		test_utils.AssertEqual(t, len(res.Channels), 2)
+		test_utils.AssertEqual(t, res.Channels[1].Name, "random")
+	})
+
+	t.Run("channel names are unique in a yodel", func(t *testing.T) {
+		owner, _, yodelID, close := startYodelWithMember(t)
+		defer close()
+
+		testClient := testclient.TestClient{}
+		testClient.ChannelCreate(t, owner, yodelID, database.DefaultChannelName)
+
+		test_utils.AssertEqual(t, readError(t, owner), "ChannelAlreadyExists")
+	})
+
+	t.Run("members cant create channels", func(t *testing.T) {
+		_, billy, yodelID, close := startYodelWithMember(t)
+		defer close()
+
+		testClient := testclient.TestClient{}
+		testClient.ChannelCreate(t, billy, yodelID, "billys")
+
+		test_utils.AssertEqual(t, readError(t, billy), "MissingPermission")
+	})
+
+	t.Run("messages and history are addressed to channels", func(t *testing.T) {
+		owner, billy, yodelID, close := startYodelWithMember(t)
+		defer close()
+
+		testClient := testclient.TestClient{}
+		general := testClient.DefaultChannel(t, billy, yodelID)
+		testClient.ChannelCreate(t, owner, yodelID, "random")
+		var updated websocket_models.ChannelsUpdated
+		err := billy.Conn.ReadJSON(&updated)
+		if err != nil {
+			t.Fatalf("%v\n", err)
+		}
+		random := updated.Channels[1].ChannelID
+
+		testClient.MsgSendToChannel(t, billy, "hello general", general)
+		var msg websocket_models.MsgBroadcast
+		err = billy.Conn.ReadJSON(&msg)
+		if err != nil {
+			t.Fatalf("%v\n", err)
+		}
+		test_utils.AssertEqual(t, msg.ChannelID, general)
+		test_utils.AssertEqual(t, msg.YodelID, yodelID)
+
+		testClient.MsgSendToChannel(t, billy, "hello random", random)
+		billy.Conn.ReadJSON(&websocket_models.MsgBroadcast{})
+
+		err = billy.Conn.WriteJSON(websocket_models.MsgHistory{
+			From: 0, To: time.Now().UnixNano(), ChannelID: random}.SetType())
+		if err != nil {
+			t.Fatalf("%v\n", err)
+		}
+		hist := testClient.RecvMsgHistory(t, billy)
+
+		test_utils.AssertEqual(t, len(hist.Messages), 1)
+		test_utils.AssertEqual(t, hist.Messages[0].Content, "hello random")
+	})
+
+	t.Run("the last channel cant be deleted", func(t *testing.T) {
+		owner, _, yodelID, close := startYodelWithMember(t)
+		defer close()
+
+		testClient := testclient.TestClient{}
+		testClient.ChannelDelete(t, owner, testClient.DefaultChannel(t, owner, yodelID))
+
+		test_utils.AssertEqual(t, readError(t, owner), "LastChannel")
+	})
+
+	t.Run("renaming a channel broadcasts channels_updated", func(t *testing.T) {
+		owner, billy, yodelID, close := startYodelWithMember(t)
+		defer close()
+
+		testClient := testclient.TestClient{}
+		testClient.ChannelRename(t, owner, testClient.DefaultChannel(t, owner, yodelID), "lobby")
+
+		var res websocket_models.ChannelsUpdated
+		err := billy.Conn.ReadJSON(&res)
+		if err != nil {
+			t.Fatalf("%v\n", err)
+		}
+		test_utils.AssertEqual(t, res.Channels[0].Name, "lobby")
+	})
 }
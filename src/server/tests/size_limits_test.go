@@ -0,0 +1,100 @@
+package server_test
+
+import (
+	"fenix/src/test_utils"
+	"fenix/src/test_utils/test_client"
+	"fenix/src/websocket_models"
+	"math"
+	"strings"
+	"testing"
+
+	"github.com/gorilla/websocket"
+)
+
+func TestSizeLimits(t *testing.T) {
+	start := func(t *testing.T) (*test_utils.ServerFields, *test_utils.ClientFields) {
+		t.Helper()
+		srv := test_utils.StartServer()
+		srv.Hub.Sizes.FrameBytes = 1024
+		srv.Hub.Sizes.MessageLength = 10
+		srv.Hub.Sizes.YodelNameLength = 5
+		srv.Hub.Sizes.HistoryPage = 3
+		testClient := testclient.TestClient{}
+		cli := testClient.RegisterClient(t, srv, testclient.Credentials{Username: "billy", Password: "pass"})
+		return srv, cli
+	}
+	readError := func(t *testing.T, cli *test_utils.ClientFields) string {
+		t.Helper()
+		var res websocket_models.GenericError
+		err := cli.Conn.ReadJSON(&res)
+		if err != nil {
+			t.Fatalf("%q\n", err)
+		}
+		return res.Error
+	}
+
+	t.Run("oversize frames close the connection", func(t *testing.T) {
+		srv, cli := start(t)
+		defer srv.Close()
+		defer cli.Close()
+
+		testClient := testclient.TestClient{}
+		testClient.MsgSend(t, cli, strings.Repeat("a", 2048))
+		_, _, err := cli.Conn.ReadMessage()
+		test_utils.AssertEqual(t, websocket.IsCloseError(err, websocket.CloseMessageTooBig), true)
+	})
+
+	t.Run("messages are limited in characters, not bytes", func(t *testing.T) {
+		srv, cli := start(t)
+		defer srv.Close()
+		defer cli.Close()
+
+		testClient := testclient.TestClient{}
+		testClient.MsgSend(t, cli, strings.Repeat("é", 11))
+		test_utils.AssertEqual(t, readError(t, cli), "MessageTooLong")
+
+		testClient.MsgSend(t, cli, strings.Repeat("é", 10))
+		var msg websocket_models.MsgBroadcast
+		cli.Conn.ReadJSON(&msg)
+		test_utils.AssertEqual(t, msg.Message, strings.Repeat("é", 10))
+	})
+
+	t.Run("yodel names are limited", func(t *testing.T) {
+		srv, cli := start(t)
+		defer srv.Close()
+		defer cli.Close()
+
+		testClient := testclient.TestClient{}
+		testClient.YodelCreate(t, cli, "gophers")
+		test_utils.AssertEqual(t, readError(t, cli), "YodelNameTooLong")
+
+		testClient.YodelCreate(t, cli, "gophs")
+		var yodel websocket_models.Yodel
+		cli.Conn.ReadJSON(&yodel)
+		name := "gophers"
+		testClient.YodelUpdate(t, cli, websocket_models.YodelUpdate{YodelID: yodel.YodelID, Name: &name})
+		test_utils.AssertEqual(t, readError(t, cli), "YodelNameTooLong")
+	})
+
+	t.Run("history pages are limited", func(t *testing.T) {
+		srv, cli := start(t)
+		defer srv.Close()
+		defer cli.Close()
+
+		testClient := testclient.TestClient{}
+		for i := 0; i < 5; i++ {
+			testClient.MsgSend(t, cli, "hi")
+			cli.Conn.ReadJSON(&websocket_models.MsgBroadcast{})
+		}
+
+		cli.Conn.WriteJSON(websocket_models.MsgHistory{To: math.MaxInt64, Limit: 4}.SetType())
+		test_utils.AssertEqual(t, readError(t, cli), "HistoryLimitTooLarge")
+		cli.Conn.WriteJSON(websocket_models.MsgHistory{To: math.MaxInt64, Limit: -1}.SetType())
+		test_utils.AssertEqual(t, readError(t, cli), "InvalidHistoryLimit")
+
+		cli.Conn.WriteJSON(websocket_models.MsgHistory{To: math.MaxInt64, Limit: 2}.SetType())
+		test_utils.AssertEqual(t, len(testClient.RecvMsgHistory(t, cli).Messages), 2)
+		testClient.MsgHistory(t, cli, 0, math.MaxInt64)
+		test_utils.AssertEqual(t, len(testClient.RecvMsgHistory(t, cli).Messages), 3)
+	})
+}
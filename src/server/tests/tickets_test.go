@@ -0,0 +1,79 @@
+package server_test
+
+import (
+	"fenix/src/database"
+	"fenix/src/server"
+	"fenix/src/test_utils"
+	"testing"
+	"time"
+)
+
+func TestTicketStores(t *testing.T) {
+	stores := map[string]func() server.TicketStore{
+		"memory":   func() server.TicketStore { return server.NewMemoryTicketStore() },
+		"database": func() server.TicketStore { return server.NewDatabaseTicketStore(database.NewInMemoryDatabase()) },
+	}
+
+	for name, newStore := range stores {
+		t.Run(name+" tickets can only be taken once", func(t *testing.T) {
+			store := newStore()
+			store.Put("gopher", "ticket", time.Minute)
+
+			ticket, ok, err := store.Take("gopher")
+			test_utils.AssertEqual(t, err, nil)
+			test_utils.AssertEqual(t, ok, true)
+			test_utils.AssertEqual(t, ticket, "ticket")
+
+			_, ok, _ = store.Take("gopher")
+			test_utils.AssertEqual(t, ok, false)
+		})
+
+		t.Run(name+" tickets expire", func(t *testing.T) {
+			store := newStore()
+			store.Put("gopher", "ticket", time.Millisecond)
+			time.Sleep(5 * time.Millisecond)
+
+			_, ok, err := store.Take("gopher")
+			test_utils.AssertEqual(t, err, nil)
+			test_utils.AssertEqual(t, ok, false)
+		})
+
+		t.Run(name+" new tickets replace old ones", func(t *testing.T) {
+			store := newStore()
+			store.Put("gopher", "old", time.Minute)
+			store.Put("gopher", "new", time.Minute)
+
+			ticket, _, _ := store.Take("gopher")
+			test_utils.AssertEqual(t, ticket, "new")
+		})
+	}
+
+	t.Run("memory store sweeps expired tickets", func(t *testing.T) {
+		store := server.NewMemoryTicketStore()
+		store.Put("gopher", "ticket", time.Millisecond)
+		time.Sleep(5 * time.Millisecond)
+		store.Put("billy", "ticket", time.Minute)
+
+		test_utils.AssertEqual(t, store.Len(), 1)
+	})
+
+	t.Run("tickets from one server upgrade on another", func(t *testing.T) {
+		login := test_utils.StartServer()
+		defer login.Close()
+		upgrade := test_utils.StartServer()
+		defer upgrade.Close()
+
+		shared := database.NewInMemoryDatabase()
+		for _, srv := range []*test_utils.ServerFields{login, upgrade} {
+			srv.Hub.Database = shared
+			srv.Hub.Tickets = server.NewDatabaseTicketStore(shared)
+		}
+
+		login.Addr.Path = "/register"
+		_, body := test_utils.PostJSON(login.Addr, map[string]string{"username": "gopher123", "password": "pass"})
+
+		cli := test_utils.Upgrade(body, upgrade.Addr)
+		defer cli.Close()
+		test_utils.AssertEqual(t, cli.Res.StatusCode, 101)
+	})
+}
@@ -0,0 +1,113 @@
+package server_test
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"fenix/src/server"
+	"fenix/src/test_utils"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestTLS(t *testing.T) {
+	// Serves srv's hub over TLS with certs, returning its address.
+	serveTLS := func(t *testing.T, srv *test_utils.ServerFields, certs *server.CertReloader) string {
+		t.Helper()
+		l, err := net.Listen("tcp", "127.0.0.1:0")
+		if err != nil {
+			t.Fatalf("%q\n", err)
+		}
+		https := &http.Server{Handler: srv.Hub.HTTPRequestHandler()}
+		go https.Serve(tls.NewListener(l, certs.TLSConfig()))
+		t.Cleanup(func() { https.Close() })
+		return l.Addr().String()
+	}
+	// Connects to addr, returning the serial of the certificate it served.
+	serial := func(t *testing.T, addr string) int64 {
+		t.Helper()
+		conn, err := tls.Dial("tcp", addr, &tls.Config{InsecureSkipVerify: true})
+		if err != nil {
+			t.Fatalf("%q\n", err)
+		}
+		defer conn.Close()
+		return conn.ConnectionState().PeerCertificates[0].SerialNumber.Int64()
+	}
+	// Writes a new certificate, marking it as changed even on filesystems with coarse timestamps.
+	rewrite := func(dir string, n int64, at time.Time) {
+		certFile, keyFile := test_utils.WriteSelfSignedCert(dir, n)
+		os.Chtimes(certFile, at, at)
+		os.Chtimes(keyFile, at, at)
+	}
+
+	t.Run("clients that trust the certificate are served", func(t *testing.T) {
+		srv := test_utils.StartServer()
+		defer srv.Close()
+		certFile, keyFile := test_utils.WriteSelfSignedCert(t.TempDir(), 1)
+		certs, err := server.NewCertReloader(certFile, keyFile)
+		test_utils.AssertEqual(t, err, nil)
+		addr := serveTLS(t, srv, certs)
+
+		pem, _ := os.ReadFile(certFile)
+		pool := x509.NewCertPool()
+		pool.AppendCertsFromPEM(pem)
+		client := &http.Client{Transport: &http.Transport{TLSClientConfig: &tls.Config{RootCAs: pool}}}
+		res, err := client.Post("https://"+addr+"/register", "application/json", strings.NewReader(`{"username": "billy", "password": "pass"}`))
+		if err != nil {
+			t.Fatalf("%q\n", err)
+		}
+		res.Body.Close()
+		test_utils.AssertEqual(t, res.StatusCode, http.StatusOK)
+	})
+
+	t.Run("changed certificates are reloaded", func(t *testing.T) {
+		srv := test_utils.StartServer()
+		defer srv.Close()
+		dir := t.TempDir()
+		certFile, keyFile := test_utils.WriteSelfSignedCert(dir, 1)
+		certs, _ := server.NewCertReloader(certFile, keyFile)
+		certs.CheckInterval = 0
+		addr := serveTLS(t, srv, certs)
+		test_utils.AssertEqual(t, serial(t, addr), int64(1))
+
+		rewrite(dir, 2, time.Now().Add(time.Minute))
+		test_utils.AssertEqual(t, serial(t, addr), int64(2))
+	})
+
+	t.Run("the last good certificate is kept if the new one is broken", func(t *testing.T) {
+		srv := test_utils.StartServer()
+		defer srv.Close()
+		dir := t.TempDir()
+		certFile, keyFile := test_utils.WriteSelfSignedCert(dir, 1)
+		certs, _ := server.NewCertReloader(certFile, keyFile)
+		certs.CheckInterval = 0
+		addr := serveTLS(t, srv, certs)
+
+		os.WriteFile(certFile, []byte("half written"), 0600)
+		test_utils.AssertEqual(t, serial(t, addr), int64(1))
+
+		rewrite(dir, 3, time.Now().Add(time.Minute))
+		test_utils.AssertEqual(t, serial(t, addr), int64(3))
+	})
+
+	t.Run("missing certificates fail to load", func(t *testing.T) {
+		_, err := server.NewCertReloader("nope.pem", "nope.key")
+		test_utils.AssertNotEqual(t, err, nil)
+	})
+
+	t.Run("plain HTTP is redirected to HTTPS", func(t *testing.T) {
+		for port, location := range map[string]string{
+			"8443": "https://fenix.example.com:8443/login?x=1",
+			"443":  "https://fenix.example.com/login?x=1",
+		} {
+			w := httptest.NewRecorder()
+			server.RedirectToHTTPS(port).ServeHTTP(w, httptest.NewRequest("POST", "http://fenix.example.com:8080/login?x=1", nil))
+			test_utils.AssertEqual(t, w.Code, http.StatusPermanentRedirect)
+			test_utils.AssertEqual(t, w.Header().Get("Location"), location)
+		}
+	})
+}
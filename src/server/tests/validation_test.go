@@ -0,0 +1,151 @@
+package server_test
+
+import (
+	"crypto/sha1"
+	"encoding/hex"
+	"fenix/src/server"
+	"fenix/src/test_utils"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestAccountPolicy(t *testing.T) {
+	policy := server.NewAccountPolicy()
+
+	t.Run("usernames are normalized", func(t *testing.T) {
+		username, canonical, verr := policy.CheckUsername("Ｇｏｐｈｅｒ.123")
+		test_utils.AssertEqual(t, verr, (*server.ValidationError)(nil))
+		test_utils.AssertEqual(t, username, "Gopher.123")
+		test_utils.AssertEqual(t, canonical, "gopher_123")
+	})
+
+	t.Run("look-alike letters from other scripts are folded", func(t *testing.T) {
+		// Cyrillic а and Greek α
+		_, canonical, verr := policy.CheckUsername("p\u0430yp\u03b1l")
+		test_utils.AssertEqual(t, verr, (*server.ValidationError)(nil))
+		test_utils.AssertEqual(t, canonical, "paypal")
+
+		_, _, verr = policy.CheckUsername("\u0430dmin")
+		test_utils.AssertEqual(t, verr.Code, "UsernameReserved")
+	})
+
+	t.Run("bad usernames are rejected", func(t *testing.T) {
+		cases := map[string]string{
+			"go":                    "UsernameTooShort",
+			strings.Repeat("a", 33): "UsernameTooLong",
+			"go pher":               "UsernameInvalidCharacters",
+			"_gopher":               "UsernameInvalidCharacters",
+			"gopher!":               "UsernameInvalidCharacters",
+			"ADMIN":                 "UsernameReserved",
+			"[deleted]":             "UsernameInvalidCharacters",
+		}
+		for username, code := range cases {
+			_, _, verr := policy.CheckUsername(username)
+			if verr == nil {
+				t.Fatalf("%q was accepted", username)
+			}
+			test_utils.AssertEqual(t, verr.Code, code)
+		}
+	})
+
+	t.Run("weak passwords are rejected", func(t *testing.T) {
+		cases := map[string]string{
+			"short":                     "PasswordTooShort",
+			strings.Repeat("ab12", 300): "PasswordTooLong",
+			"aaaaaaaaaaaa":              "PasswordTooSimple",
+			"mygopher123password":       "PasswordContainsUsername",
+		}
+		for password, code := range cases {
+			verr := policy.CheckPassword("gopher123", password)
+			if verr == nil {
+				t.Fatalf("%q was accepted", password)
+			}
+			test_utils.AssertEqual(t, verr.Code, code)
+		}
+
+		test_utils.AssertEqual(t, policy.CheckPassword("gopher123", "correct horse battery"), (*server.ValidationError)(nil))
+	})
+
+	t.Run("breached passwords are rejected", func(t *testing.T) {
+		sum := sha1.Sum([]byte("hashedbreach"))
+		list := "plainbreach\n" + strings.ToUpper(hex.EncodeToString(sum[:])) + ":42\n"
+		path := filepath.Join(t.TempDir(), "breached.txt")
+		err := os.WriteFile(path, []byte(list), 0600)
+		if err != nil {
+			t.Fatalf("%q\n", err)
+		}
+
+		policy := server.NewAccountPolicy()
+		err = policy.LoadBreachedPasswords(path)
+		test_utils.AssertEqual(t, err, nil)
+
+		for _, password := range []string{"plainbreach", "hashedbreach"} {
+			verr := policy.CheckPassword("gopher123", password)
+			if verr == nil {
+				t.Fatalf("%q was accepted", password)
+			}
+			test_utils.AssertEqual(t, verr.Code, "PasswordBreached")
+		}
+		test_utils.AssertEqual(t, policy.CheckPassword("gopher123", "notbreached"), (*server.ValidationError)(nil))
+	})
+}
+
+func TestRegistrationValidation(t *testing.T) {
+	register := func(srv *test_utils.ServerFields, username, password string) (int, map[string]string) {
+		srv.Addr.Path = "/register"
+		return test_utils.PostJSON(srv.Addr, map[string]string{"username": username, "password": password})
+	}
+
+	t.Run("rejections have a JSON body", func(t *testing.T) {
+		srv := test_utils.StartServer()
+		defer srv.Close()
+		srv.Hub.Accounts = server.NewAccountPolicy()
+
+		status, body := register(srv, "gopher123", "pass")
+		test_utils.AssertEqual(t, status, http.StatusBadRequest)
+		test_utils.AssertEqual(t, body["error"], "PasswordTooShort")
+		test_utils.AssertNotEqual(t, body["msg"], "")
+	})
+
+	t.Run("look-alike usernames are taken", func(t *testing.T) {
+		srv := test_utils.StartServer()
+		defer srv.Close()
+
+		status, _ := register(srv, "gopher_123", "pass")
+		test_utils.AssertEqual(t, status, http.StatusOK)
+
+		// The last two mix in Cyrillic о and р, and a Greek Ο
+		for _, username := range []string{"Gopher-123", "ｇｏｐｈｅｒ.123", "g\u043e\u0440her_123", "G\u039fpher_123"} {
+			status, body := register(srv, username, "pass")
+			test_utils.AssertEqual(t, status, http.StatusConflict)
+			test_utils.AssertEqual(t, body["error"], "UsernameTaken")
+		}
+	})
+
+	t.Run("users can log in with an unnormalized username", func(t *testing.T) {
+		srv := test_utils.StartServer()
+		defer srv.Close()
+		register(srv, "gopher", "pass")
+
+		srv.Addr.Path = "/login"
+		status, body := test_utils.PostJSON(srv.Addr, map[string]string{"username": "ｇｏｐｈｅｒ", "password": "pass"})
+		test_utils.AssertEqual(t, status, http.StatusOK)
+		test_utils.AssertEqual(t, body["username"], "gopher")
+	})
+
+	t.Run("new passwords follow the policy", func(t *testing.T) {
+		srv := test_utils.StartServer()
+		defer srv.Close()
+		register(srv, "gopher123", "pass")
+		srv.Hub.Accounts = server.NewAccountPolicy()
+
+		srv.Addr.Path = "/password/change"
+		status, body := test_utils.PostJSON(srv.Addr, map[string]string{
+			"username": "gopher123", "password": "pass", "new_password": "aaaaaaaaaaaa"})
+		test_utils.AssertEqual(t, status, http.StatusBadRequest)
+		test_utils.AssertEqual(t, body["error"], "PasswordTooSimple")
+	})
+}
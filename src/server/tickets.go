@@ -0,0 +1,101 @@
+package server
+
+import (
+	"fenix/src/database"
+	"sync"
+	"time"
+)
+
+// How long a ticket from createToken can be used to upgrade.
+const ticketLifetime = 5 * time.Second
+
+// Holds one-shot upgrade tickets.  Shared stores let users log in on one server and upgrade on another.
+type TicketStore interface {
+	// Stores a ticket for a user until ttl passes, replacing their previous ticket.
+	Put(userID, ticket string, ttl time.Duration) error
+	// Removes a user's ticket, returning it if it hasn't expired.
+	Take(userID string) (string, bool, error)
+}
+
+type memoryTicket struct {
+	ticket  string
+	expires time.Time
+}
+
+// Process-local TicketStore.
+type MemoryTicketStore struct {
+	lock    sync.Mutex
+	tickets map[string]memoryTicket
+}
+
+func NewMemoryTicketStore() *MemoryTicketStore {
+	return &MemoryTicketStore{tickets: make(map[string]memoryTicket)}
+}
+
+func (s *MemoryTicketStore) Put(userID, ticket string, ttl time.Duration) error {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	now := time.Now()
+	// Sweep tickets that were never redeemed, so they don't pile up
+	for id, t := range s.tickets {
+		if !now.Before(t.expires) {
+			delete(s.tickets, id)
+		}
+	}
+
+	s.tickets[userID] = memoryTicket{ticket: ticket, expires: now.Add(ttl)}
+	return nil
+}
+
+func (s *MemoryTicketStore) Take(userID string) (string, bool, error) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	t, ok := s.tickets[userID]
+	if !ok {
+		return "", false, nil
+	}
+	delete(s.tickets, userID)
+
+	if !time.Now().Before(t.expires) {
+		return "", false, nil
+	}
+	return t.ticket, true, nil
+}
+
+// Number of tickets held, including expired tickets that haven't been swept yet.
+func (s *MemoryTicketStore) Len() int {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	return len(s.tickets)
+}
+
+// TicketStore kept in the database, so every server using it sees the same tickets.
+type DatabaseTicketStore struct {
+	db database.Database
+}
+
+func NewDatabaseTicketStore(db database.Database) *DatabaseTicketStore {
+	return &DatabaseTicketStore{db: db}
+}
+
+func (s *DatabaseTicketStore) Put(userID, ticket string, ttl time.Duration) error {
+	return s.db.InsertTicket(&database.Ticket{
+		UserID:  userID,
+		Ticket:  ticket,
+		Expires: time.Now().Add(ttl),
+	})
+}
+
+func (s *DatabaseTicketStore) Take(userID string) (string, bool, error) {
+	t := &database.Ticket{UserID: userID}
+	err := s.db.TakeTicket(t)
+	if _, ok := err.(database.DoesNotExist); ok {
+		return "", false, nil
+	}
+	if err != nil {
+		return "", false, err
+	}
+	return t.Ticket, true, nil
+}
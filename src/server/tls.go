@@ -0,0 +1,135 @@
+package server
+
+import (
+	"crypto/tls"
+	"fenix/src/utils"
+	"fmt"
+	"net"
+	"net/http"
+	"os"
+	"sync"
+	"time"
+)
+
+// Serves a certificate and key from files, reloading them when either changes.
+// Renewed certificates, like those from certbot, are picked up without a restart.
+type CertReloader struct {
+	CertFile string
+	KeyFile  string
+	// How often the files are checked for changes, at most.  0 checks on every handshake.
+	CheckInterval time.Duration
+
+	lock    sync.Mutex
+	cert    *tls.Certificate
+	stamp   string
+	checked time.Time
+}
+
+// Loads a certificate and key, failing if they can't be.
+func NewCertReloader(certFile, keyFile string) (*CertReloader, error) {
+	c := &CertReloader{CertFile: certFile, KeyFile: keyFile, CheckInterval: 10 * time.Second}
+	err := c.reload()
+	if err != nil {
+		return nil, err
+	}
+	return c, nil
+}
+
+// Identifies the files' current versions by their sizes and modification times.
+func (c *CertReloader) fileStamp() (string, error) {
+	stamp := ""
+	for _, name := range []string{c.CertFile, c.KeyFile} {
+		info, err := os.Stat(name)
+		if err != nil {
+			return "", err
+		}
+		stamp += fmt.Sprintf("%v %v;", info.ModTime().UnixNano(), info.Size())
+	}
+	return stamp, nil
+}
+
+// Must be called with lock held, besides from NewCertReloader.
+func (c *CertReloader) reload() error {
+	stamp, err := c.fileStamp()
+	if err != nil {
+		return err
+	}
+	if stamp == c.stamp {
+		return nil
+	}
+
+	cert, err := tls.LoadX509KeyPair(c.CertFile, c.KeyFile)
+	if err != nil {
+		return err
+	}
+	if c.cert != nil {
+		utils.InfoLogger.Printf("Reloaded TLS certificate from %v", c.CertFile)
+	}
+	c.cert = &cert
+	c.stamp = stamp
+	return nil
+}
+
+// For tls.Config.GetCertificate.  Keeps serving the last good certificate
+// if the files can't be loaded, like while they're half written.
+func (c *CertReloader) GetCertificate(*tls.ClientHelloInfo) (*tls.Certificate, error) {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+
+	now := time.Now()
+	if now.Sub(c.checked) >= c.CheckInterval {
+		c.checked = now
+		err := c.reload()
+		if err != nil {
+			utils.ErrorLogger.Printf("Error reloading TLS certificate from %v: %q", c.CertFile, err)
+		}
+	}
+	return c.cert, nil
+}
+
+func (c *CertReloader) TLSConfig() *tls.Config {
+	return &tls.Config{
+		GetCertificate: c.GetCertificate,
+		MinVersion:     tls.VersionTLS12,
+	}
+}
+
+// Serves the hub over TLS with certs.
+func (hub *ServerHub) ServeTLS(addr string, certs *CertReloader) {
+	srv := &http.Server{
+		Handler:   hub.HTTPRequestHandler(),
+		Addr:      addr,
+		TLSConfig: certs.TLSConfig(),
+	}
+	hub.listen("ServerHub_ListenAndServeTLS", addr, func() error {
+		return srv.ListenAndServeTLS("", "")
+	})
+}
+
+// Serves redirects to HTTPS on httpsPort, for clients that try plain HTTP first.
+func (hub *ServerHub) ServeRedirect(addr, httpsPort string) {
+	srv := &http.Server{
+		Handler: RedirectToHTTPS(httpsPort),
+		Addr:    addr,
+	}
+	hub.listen("ServerHub_ListenAndServeRedirect", addr, srv.ListenAndServe)
+}
+
+// Redirects requests to the same host and path over HTTPS on port.
+// 308 keeps the method and body, so POSTs still work once redirected.
+func RedirectToHTTPS(port string) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		host, _, err := net.SplitHostPort(r.Host)
+		if err != nil {
+			host = r.Host
+		}
+		if port != "" && port != "443" {
+			host = net.JoinHostPort(host, port)
+		}
+
+		u := *r.URL
+		u.Scheme = "https"
+		u.Host = host
+		http.Redirect(w, r, u.String(), http.StatusPermanentRedirect)
+	})
+}
@@ -0,0 +1,368 @@
+package server
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"crypto/subtle"
+	"encoding/base32"
+	"encoding/json"
+	"errors"
+	"fenix/src/database"
+	"fenix/src/utils"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+const (
+	// How long after the password step of a login its second step can be done.
+	mfaLifetime = 5 * time.Minute
+	// Codes from this many periods either side of now are accepted, for clocks that have drifted.
+	totpSkew          = 1
+	recoveryCodeCount = 10
+	totpIssuer        = "Fenix"
+)
+
+var totpEncoding = base32.StdEncoding.WithPadding(base32.NoPadding)
+
+// Login challenges share the ticket store with upgrade tickets, under a different key.
+func mfaTicketKey(u *database.User) string {
+	return "mfa:" + u.UserID.Hex()
+}
+
+// Encrypts a secret for u with the hub's SecretKey.  The user's ID is authenticated too,
+// so secrets can't be copied between users.
+func (hub *ServerHub) encryptSecret(u *database.User, secret []byte) ([]byte, error) {
+	gcm, err := hub.secretCipher()
+	if err != nil {
+		return nil, err
+	}
+
+	nonce := make([]byte, gcm.NonceSize())
+	_, err = rand.Read(nonce)
+	if err != nil {
+		return nil, err
+	}
+	return gcm.Seal(nonce, nonce, secret, u.UserID[:]), nil
+}
+
+func (hub *ServerHub) decryptSecret(u *database.User, sealed []byte) ([]byte, error) {
+	gcm, err := hub.secretCipher()
+	if err != nil {
+		return nil, err
+	}
+	if len(sealed) < gcm.NonceSize() {
+		return nil, errors.New("encrypted secret is too short")
+	}
+
+	nonce, ciphertext := sealed[:gcm.NonceSize()], sealed[gcm.NonceSize():]
+	return gcm.Open(nil, nonce, ciphertext, u.UserID[:])
+}
+
+func (hub *ServerHub) secretCipher() (cipher.AEAD, error) {
+	block, err := aes.NewCipher(hub.SecretKey)
+	if err != nil {
+		return nil, err
+	}
+	return cipher.NewGCM(block)
+}
+
+// Checks a TOTP code against u's secret, returning the time step it was for.
+// Steps at or before u.TOTPLastStep are rejected, so each code can only be used once.
+func (hub *ServerHub) checkTOTP(u *database.User, code string) (int64, bool) {
+	secret, err := hub.decryptSecret(u, u.TOTPSecret)
+	if err != nil {
+		utils.ErrorLogger.Printf("Error decrypting TOTP secret of %q: %q", u.Username, err)
+		return 0, false
+	}
+
+	now := utils.TOTPStep(time.Now())
+	for step := now - totpSkew; step <= now+totpSkew; step++ {
+		if step <= u.TOTPLastStep {
+			continue
+		}
+		if subtle.ConstantTimeCompare([]byte(utils.TOTPCode(secret, step)), []byte(code)) == 1 {
+			return step, true
+		}
+	}
+	return 0, false
+}
+
+// Checks a TOTP or recovery code for a user with 2FA enabled, then saves it as used.
+func (hub *ServerHub) checkSecondFactor(u *database.User, code string) (bool, error) {
+	if step, ok := hub.checkTOTP(u, code); ok {
+		u.TOTPLastStep = step
+		return true, hub.Database.UpdateTwoFactor(u)
+	}
+
+	hash := hashToken(normalizeRecoveryCode(code))
+	for i, h := range u.RecoveryCodes {
+		if subtle.ConstantTimeCompare([]byte(h), []byte(hash)) == 1 {
+			u.RecoveryCodes = append(u.RecoveryCodes[:i:i], u.RecoveryCodes[i+1:]...)
+			return true, hub.Database.UpdateTwoFactor(u)
+		}
+	}
+	return false, nil
+}
+
+// Makes recovery codes, returning them and their hashes.
+func newRecoveryCodes() ([]string, []string, error) {
+	codes := make([]string, 0, recoveryCodeCount)
+	hashes := make([]string, 0, recoveryCodeCount)
+	for i := 0; i < recoveryCodeCount; i++ {
+		b := make([]byte, 5)
+		_, err := rand.Read(b)
+		if err != nil {
+			return nil, nil, err
+		}
+
+		code := strings.ToLower(totpEncoding.EncodeToString(b))
+		codes = append(codes, code[:4]+"-"+code[4:])
+		hashes = append(hashes, hashToken(code))
+	}
+	return codes, hashes, nil
+}
+
+// Recovery codes are accepted in any case, with or without their dash.
+func normalizeRecoveryCode(code string) string {
+	return strings.ToLower(strings.ReplaceAll(code, "-", ""))
+}
+
+func totpURI(u *database.User, secret []byte) string {
+	q := url.Values{}
+	q.Set("secret", totpEncoding.EncodeToString(secret))
+	q.Set("issuer", totpIssuer)
+	return "otpauth://totp/" + url.PathEscape(totpIssuer+":"+u.Username) + "?" + q.Encode()
+}
+
+// Starts the second step of a login for a user with 2FA enabled.
+// Responds with a token to send to /login/2fa with a code, instead of a ticket.
+func (hub *ServerHub) startMFALogin(w http.ResponseWriter, u *database.User) {
+	token, err := newToken()
+	if err == nil {
+		err = hub.Tickets.Put(mfaTicketKey(u), hashToken(token), mfaLifetime)
+	}
+	if err != nil {
+		utils.ErrorLogger.Printf("Error starting 2FA login for %q: %q", u.Username, err)
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+
+	b, err := json.Marshal(map[string]string{"userID": u.UserID.Hex(), "username": u.Username, "mfa_token": token})
+	if err != nil {
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+	setCORSHeaders(w)
+	w.WriteHeader(http.StatusAccepted)
+	w.Write(b)
+}
+
+// HTTP method for the second step of a login with 2FA.  Takes a TOTP or recovery code.
+// Each mfa_token can only be tried once.
+func (hub *ServerHub) LoginMFA(w http.ResponseWriter, r *http.Request) {
+	if r.Method == "OPTIONS" {
+		writeCORSPreflight(w)
+		return
+	}
+
+	body, ok := readJSONBody(w, r, "userID", "mfa_token", "code")
+	if !ok {
+		return
+	}
+
+	id, err := primitive.ObjectIDFromHex(body["userID"])
+	if err != nil {
+		w.WriteHeader(http.StatusBadRequest)
+		return
+	}
+	u := &database.User{UserID: id}
+	err = hub.Database.GetUser(u)
+	if err != nil || !u.TOTPEnabled {
+		w.WriteHeader(http.StatusForbidden)
+		return
+	}
+	if !hub.checkLimits(w, ipLimitKey(r), hub.usernameLimitKey(u.Username)) {
+		return
+	}
+
+	challenge, ok, err := hub.Tickets.Take(mfaTicketKey(u))
+	if err != nil {
+		utils.ErrorLogger.Printf("Error getting 2FA login for %q: %q", u.Username, err)
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+	if !ok || subtle.ConstantTimeCompare([]byte(challenge), []byte(hashToken(body["mfa_token"]))) != 1 {
+		hub.audit(r, AuditLoginFailure, nil, u.UserID.Hex(), "2fa token")
+		w.WriteHeader(http.StatusForbidden)
+		return
+	}
+
+	ok, err = hub.checkSecondFactor(u, body["code"])
+	if err != nil {
+		utils.ErrorLogger.Printf("Error using 2FA code of %q: %q", u.Username, err)
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+	if !ok {
+		hub.audit(r, AuditLoginFailure, nil, u.UserID.Hex(), "2fa code")
+		hub.loginFailed(r, u.Username)
+		w.WriteHeader(http.StatusForbidden)
+		return
+	}
+
+	hub.loginSucceeded(u.Username)
+	hub.audit(r, AuditLoginSuccess, u, u.UserID.Hex(), "2fa")
+	hub.issueToken(w, u)
+}
+
+// HTTP method to start enrolling in 2FA.  Responds with the secret to add to an authenticator app,
+// which takes effect once a code from it is sent to /2fa/confirm.
+func (hub *ServerHub) EnrollTwoFactor(w http.ResponseWriter, r *http.Request) {
+	if r.Method == "OPTIONS" {
+		writeCORSPreflight(w)
+		return
+	}
+
+	body, ok := readJSONBody(w, r, "username", "password")
+	if !ok {
+		return
+	}
+	if hub.SecretKey == nil {
+		writeJSONError(w, http.StatusServiceUnavailable, &ValidationError{
+			"TwoFactorUnavailable", "This server needs a secret_key before users can enable 2FA!"})
+		return
+	}
+
+	u, ok := hub.authenticate(w, r, body["username"], body["password"])
+	if !ok {
+		return
+	}
+	if u.TOTPEnabled {
+		w.WriteHeader(http.StatusConflict)
+		return
+	}
+
+	secret := make([]byte, 20)
+	_, err := rand.Read(secret)
+	if err == nil {
+		u.TOTPSecret, err = hub.encryptSecret(u, secret)
+	}
+	if err == nil {
+		err = hub.Database.UpdateTwoFactor(u)
+	}
+	if err != nil {
+		utils.ErrorLogger.Printf("Error enrolling %q in 2FA: %q", u.Username, err)
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+
+	b, err := json.Marshal(map[string]string{"secret": totpEncoding.EncodeToString(secret), "uri": totpURI(u, secret)})
+	if err != nil {
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+	setCORSHeaders(w)
+	w.Write(b)
+}
+
+// HTTP method to finish enrolling in 2FA with a code from the new secret.  Responds with recovery codes,
+// which can each be used once instead of a TOTP code.
+func (hub *ServerHub) ConfirmTwoFactor(w http.ResponseWriter, r *http.Request) {
+	if r.Method == "OPTIONS" {
+		writeCORSPreflight(w)
+		return
+	}
+
+	body, ok := readJSONBody(w, r, "username", "password", "code")
+	if !ok {
+		return
+	}
+
+	u, ok := hub.authenticate(w, r, body["username"], body["password"])
+	if !ok {
+		return
+	}
+	if u.TOTPEnabled || u.TOTPSecret == nil {
+		w.WriteHeader(http.StatusConflict)
+		return
+	}
+
+	step, ok := hub.checkTOTP(u, body["code"])
+	if !ok {
+		hub.loginFailed(r, u.Username)
+		w.WriteHeader(http.StatusForbidden)
+		return
+	}
+
+	codes, hashes, err := newRecoveryCodes()
+	if err == nil {
+		u.TOTPEnabled = true
+		u.TOTPLastStep = step
+		u.RecoveryCodes = hashes
+		err = hub.Database.UpdateTwoFactor(u)
+	}
+	if err != nil {
+		utils.ErrorLogger.Printf("Error confirming 2FA of %q: %q", u.Username, err)
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+
+	hub.audit(r, AuditEnable2FA, u, u.UserID.Hex(), "")
+	b, err := json.Marshal(map[string][]string{"recovery_codes": codes})
+	if err != nil {
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+	setCORSHeaders(w)
+	w.Write(b)
+}
+
+// HTTP method to turn off 2FA.  Needs the password and a TOTP or recovery code.
+func (hub *ServerHub) DisableTwoFactor(w http.ResponseWriter, r *http.Request) {
+	if r.Method == "OPTIONS" {
+		writeCORSPreflight(w)
+		return
+	}
+
+	body, ok := readJSONBody(w, r, "username", "password", "code")
+	if !ok {
+		return
+	}
+
+	u, ok := hub.authenticate(w, r, body["username"], body["password"])
+	if !ok {
+		return
+	}
+	if !u.TOTPEnabled {
+		w.WriteHeader(http.StatusConflict)
+		return
+	}
+
+	ok, err := hub.checkSecondFactor(u, body["code"])
+	if err == nil && ok {
+		u.TOTPSecret = nil
+		u.TOTPEnabled = false
+		u.TOTPLastStep = 0
+		u.RecoveryCodes = nil
+		err = hub.Database.UpdateTwoFactor(u)
+	}
+	if err != nil {
+		utils.ErrorLogger.Printf("Error disabling 2FA of %q: %q", u.Username, err)
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+	if !ok {
+		hub.loginFailed(r, u.Username)
+		w.WriteHeader(http.StatusForbidden)
+		return
+	}
+
+	hub.audit(r, AuditDisable2FA, u, u.UserID.Hex(), "")
+	setCORSHeaders(w)
+	w.WriteHeader(http.StatusNoContent)
+}
@@ -0,0 +1,168 @@
+package server
+
+import (
+	"bufio"
+	"crypto/sha1"
+	"encoding/hex"
+	"os"
+	"strconv"
+	"strings"
+	"unicode"
+	"unicode/utf8"
+
+	"golang.org/x/text/unicode/norm"
+)
+
+// A rejected username or password, sent to clients as a JSON body.
+type ValidationError struct {
+	Code    string `json:"error"`
+	Message string `json:"msg"`
+}
+
+func (e *ValidationError) Error() string {
+	return e.Message
+}
+
+// Rules for the usernames and passwords of new accounts.
+type AccountPolicy struct {
+	// Lengths are counted in characters, after normalization.
+	MinUsernameLength int
+	MaxUsernameLength int
+	// Allowed in usernames besides letters and digits, but not at the start or end.
+	UsernamePunctuation string
+	// Names nobody can register, compared by canonical name.
+	ReservedNames []string
+
+	MinPasswordLength int
+	// In bytes, so hashing stays cheap.
+	MaxPasswordLength int
+	// Passwords with fewer different characters, like "aaaaaaaa", are rejected.
+	MinPasswordUniqueChars int
+
+	// Uppercase hex SHA-1 hashes of passwords from breaches.
+	breached map[string]struct{}
+}
+
+func NewAccountPolicy() *AccountPolicy {
+	return &AccountPolicy{
+		MinUsernameLength:      3,
+		MaxUsernameLength:      32,
+		UsernamePunctuation:    "_-.",
+		ReservedNames:          []string{"admin", "administrator", "root", "system", "fenix", "moderator", "support", "deleted"},
+		MinPasswordLength:      8,
+		MaxPasswordLength:      1024,
+		MinPasswordUniqueChars: 4,
+	}
+}
+
+// Loads a breached password list, with a password or SHA-1 hash on each line.
+// Hashes can be followed by ":count", as in the Pwned Passwords downloads.
+func (p *AccountPolicy) LoadBreachedPasswords(path string) error {
+	f, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	breached := make(map[string]struct{})
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" {
+			continue
+		}
+
+		hash, _, _ := strings.Cut(line, ":")
+		if _, err := hex.DecodeString(hash); err != nil || len(hash) != 2*sha1.Size {
+			hash = sha1Hex(line)
+		}
+		breached[strings.ToUpper(hash)] = struct{}{}
+	}
+	if err := scanner.Err(); err != nil {
+		return err
+	}
+
+	p.breached = breached
+	return nil
+}
+
+func sha1Hex(s string) string {
+	sum := sha1.Sum([]byte(s))
+	return strings.ToUpper(hex.EncodeToString(sum[:]))
+}
+
+// Usernames are stored NFKC normalized, so different encodings of the same text are the same name.
+func normalizeUsername(username string) string {
+	return norm.NFKC.String(username)
+}
+
+// Folds look-alike letters, case and punctuation of a normalized username, so names that only differ by them collide.
+func (p *AccountPolicy) canonicalName(username string) string {
+	return strings.Map(func(r rune) rune {
+		if strings.ContainsRune(p.UsernamePunctuation, r) {
+			return '_'
+		}
+		return unicode.ToLower(r)
+	}, skeleton(username))
+}
+
+// Checks a username for a new account, returning it normalized with its canonical name.
+func (p *AccountPolicy) CheckUsername(username string) (string, string, *ValidationError) {
+	username = normalizeUsername(username)
+
+	length := utf8.RuneCountInString(username)
+	if length < p.MinUsernameLength {
+		return "", "", &ValidationError{"UsernameTooShort",
+			"Usernames must be at least " + strconv.Itoa(p.MinUsernameLength) + " characters!"}
+	}
+	if length > p.MaxUsernameLength {
+		return "", "", &ValidationError{"UsernameTooLong",
+			"Usernames can't be longer than " + strconv.Itoa(p.MaxUsernameLength) + " characters!"}
+	}
+
+	for i, r := range username {
+		punct := strings.ContainsRune(p.UsernamePunctuation, r)
+		edge := i == 0 || i+utf8.RuneLen(r) == len(username)
+		if !unicode.IsLetter(r) && !unicode.IsDigit(r) && !(punct && !edge) {
+			return "", "", &ValidationError{"UsernameInvalidCharacters",
+				"Usernames can only have letters, digits and " + p.UsernamePunctuation + " between them!"}
+		}
+	}
+
+	canonical := p.canonicalName(username)
+	for _, reserved := range p.ReservedNames {
+		if canonical == p.canonicalName(normalizeUsername(reserved)) {
+			return "", "", &ValidationError{"UsernameReserved", "That username is reserved!"}
+		}
+	}
+	return username, canonical, nil
+}
+
+// Checks a new password for a user.
+func (p *AccountPolicy) CheckPassword(username, password string) *ValidationError {
+	if utf8.RuneCountInString(password) < p.MinPasswordLength {
+		return &ValidationError{"PasswordTooShort",
+			"Passwords must be at least " + strconv.Itoa(p.MinPasswordLength) + " characters!"}
+	}
+	if len(password) > p.MaxPasswordLength {
+		return &ValidationError{"PasswordTooLong",
+			"Passwords can't be longer than " + strconv.Itoa(p.MaxPasswordLength) + " bytes!"}
+	}
+
+	unique := make(map[rune]struct{})
+	for _, r := range password {
+		unique[r] = struct{}{}
+	}
+	if len(unique) < p.MinPasswordUniqueChars {
+		return &ValidationError{"PasswordTooSimple", "Passwords need more different characters!"}
+	}
+
+	if username != "" && strings.Contains(strings.ToLower(password), strings.ToLower(username)) {
+		return &ValidationError{"PasswordContainsUsername", "Passwords can't contain the username!"}
+	}
+
+	if _, ok := p.breached[sha1Hex(password)]; ok {
+		return &ValidationError{"PasswordBreached", "That password has appeared in a data breach, choose another!"}
+	}
+	return nil
+}
@@ -0,0 +1,177 @@
+package test_utils
+
+import (
+	"crypto"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"sync"
+	"time"
+)
+
+// A stub OpenID Connect provider.  Every /authorize request signs in straight away,
+// with an ID token holding Claims.
+type OIDCIssuer struct {
+	Server   *httptest.Server
+	URL      string
+	ClientID string
+	Claims   map[string]interface{}
+	// Signs ID tokens with a key that isn't in the JWKS.
+	WrongKey bool
+
+	key      *rsa.PrivateKey
+	wrongKey *rsa.PrivateKey
+	lock     *sync.Mutex
+	codes    map[string]oidcCode
+}
+
+type oidcCode struct {
+	challenge   string
+	nonce       string
+	redirectURI string
+}
+
+func StartOIDCIssuer(clientID string) *OIDCIssuer {
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		panic(err)
+	}
+	wrongKey, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		panic(err)
+	}
+
+	iss := &OIDCIssuer{
+		ClientID: clientID,
+		Claims:   map[string]interface{}{},
+		key:      key,
+		wrongKey: wrongKey,
+		lock:     &sync.Mutex{},
+		codes:    map[string]oidcCode{},
+	}
+	iss.Server = httptest.NewServer(http.HandlerFunc(iss.serve))
+	iss.URL = iss.Server.URL
+	return iss
+}
+
+func (iss *OIDCIssuer) Close() {
+	iss.Server.Close()
+}
+
+func (iss *OIDCIssuer) serve(w http.ResponseWriter, r *http.Request) {
+	switch r.URL.Path {
+	case "/.well-known/openid-configuration":
+		json.NewEncoder(w).Encode(map[string]string{
+			"issuer":                 iss.URL,
+			"authorization_endpoint": iss.URL + "/authorize",
+			"token_endpoint":         iss.URL + "/token",
+			"jwks_uri":               iss.URL + "/jwks",
+		})
+	case "/jwks":
+		e := big.NewInt(int64(iss.key.E)).Bytes()
+		json.NewEncoder(w).Encode(map[string]interface{}{"keys": []map[string]string{{
+			"kty": "RSA",
+			"kid": "stub",
+			"n":   base64.RawURLEncoding.EncodeToString(iss.key.N.Bytes()),
+			"e":   base64.RawURLEncoding.EncodeToString(e),
+		}}})
+	case "/authorize":
+		iss.authorize(w, r)
+	case "/token":
+		iss.token(w, r)
+	default:
+		w.WriteHeader(http.StatusNotFound)
+	}
+}
+
+func (iss *OIDCIssuer) authorize(w http.ResponseWriter, r *http.Request) {
+	q := r.URL.Query()
+	if q.Get("client_id") != iss.ClientID || q.Get("code_challenge_method") != "S256" || q.Get("response_type") != "code" {
+		w.WriteHeader(http.StatusBadRequest)
+		return
+	}
+
+	code := randomB64()
+	iss.lock.Lock()
+	iss.codes[code] = oidcCode{q.Get("code_challenge"), q.Get("nonce"), q.Get("redirect_uri")}
+	iss.lock.Unlock()
+
+	redirect, err := url.Parse(q.Get("redirect_uri"))
+	if err != nil {
+		w.WriteHeader(http.StatusBadRequest)
+		return
+	}
+	values := redirect.Query()
+	values.Set("code", code)
+	values.Set("state", q.Get("state"))
+	redirect.RawQuery = values.Encode()
+	http.Redirect(w, r, redirect.String(), http.StatusFound)
+}
+
+func (iss *OIDCIssuer) token(w http.ResponseWriter, r *http.Request) {
+	iss.lock.Lock()
+	c, ok := iss.codes[r.PostFormValue("code")]
+	delete(iss.codes, r.PostFormValue("code"))
+	iss.lock.Unlock()
+
+	sum := sha256.Sum256([]byte(r.PostFormValue("code_verifier")))
+	if !ok || base64.RawURLEncoding.EncodeToString(sum[:]) != c.challenge || r.PostFormValue("redirect_uri") != c.redirectURI {
+		w.WriteHeader(http.StatusBadRequest)
+		return
+	}
+
+	claims := map[string]interface{}{
+		"iss":   iss.URL,
+		"aud":   iss.ClientID,
+		"exp":   time.Now().Add(time.Hour).Unix(),
+		"nonce": c.nonce,
+	}
+	for k, v := range iss.Claims {
+		claims[k] = v
+	}
+	json.NewEncoder(w).Encode(map[string]string{"id_token": iss.sign(claims), "token_type": "Bearer"})
+}
+
+func (iss *OIDCIssuer) sign(claims map[string]interface{}) string {
+	header, _ := json.Marshal(map[string]string{"alg": "RS256", "kid": "stub"})
+	payload, _ := json.Marshal(claims)
+	signed := base64.RawURLEncoding.EncodeToString(header) + "." + base64.RawURLEncoding.EncodeToString(payload)
+
+	key := iss.key
+	if iss.WrongKey {
+		key = iss.wrongKey
+	}
+	sum := sha256.Sum256([]byte(signed))
+	sig, err := rsa.SignPKCS1v15(rand.Reader, key, crypto.SHA256, sum[:])
+	if err != nil {
+		panic(err)
+	}
+	return signed + "." + base64.RawURLEncoding.EncodeToString(sig)
+}
+
+func randomB64() string {
+	b := make([]byte, 16)
+	rand.Read(b)
+	return base64.RawURLEncoding.EncodeToString(b)
+}
+
+// Signs in through /auth/oidc/start, following the redirects to the callback.
+// Returns the status code and decoded response body of the callback.
+func OIDCLogin(u url.URL) (int, map[string]string) {
+	u.Path = "/auth/oidc/start"
+	resp, err := http.Get(u.String())
+	if err != nil {
+		panic(err)
+	}
+	defer resp.Body.Close()
+
+	body := map[string]string{}
+	json.NewDecoder(resp.Body).Decode(&body)
+	return resp.StatusCode, body
+}
@@ -0,0 +1,60 @@
+package testclient
+
+import (
+	"fenix/src/database"
+	"fenix/src/test_utils"
+	"fenix/src/websocket_models"
+	"testing"
+)
+
+func (m *TestClient) ChannelCreate(t *testing.T, cli *test_utils.ClientFields, yodelID, name string) {
+	t.Helper()
+	err := cli.Conn.WriteJSON(websocket_models.ChannelCreate{YodelID: yodelID, Name: name}.SetType())
+	if err != nil {
+		t.Fatalf("%v", err)
+	}
+}
+
+func (m *TestClient) ChannelRename(t *testing.T, cli *test_utils.ClientFields, channelID, name string) {
+	t.Helper()
+	err := cli.Conn.WriteJSON(websocket_models.ChannelRename{ChannelID: channelID, Name: name}.SetType())
+	if err != nil {
+		t.Fatalf("%v", err)
+	}
+}
+
+func (m *TestClient) ChannelDelete(t *testing.T, cli *test_utils.ClientFields, channelID string) {
+	t.Helper()
+	err := cli.Conn.WriteJSON(websocket_models.ChannelDelete{ChannelID: channelID}.SetType())
+	if err != nil {
+		t.Fatalf("%v", err)
+	}
+}
+
+// Sends channel_list, returning the yodel's channels.
+func (m *TestClient) ChannelList(t *testing.T, cli *test_utils.ClientFields, yodelID string) []websocket_models.Channel {
+	t.Helper()
+	err := cli.Conn.WriteJSON(websocket_models.ChannelList{YodelID: yodelID}.SetType())
+	if err != nil {
+		t.Fatalf("%v", err)
+	}
+
+	var list websocket_models.ChannelList
+	err = cli.Conn.ReadJSON(&list)
+	if err != nil {
+		t.Fatalf("%v", err)
+	}
+	return list.Channels
+}
+
+// Returns the ID of the channel a yodel was created with.
+func (m *TestClient) DefaultChannel(t *testing.T, cli *test_utils.ClientFields, yodelID string) string {
+	t.Helper()
+	for _, channel := range m.ChannelList(t, cli, yodelID) {
+		if channel.Name == database.DefaultChannelName {
+			return channel.ChannelID
+		}
+	}
+	t.Fatalf("yodel %v has no %v channel", yodelID, database.DefaultChannelName)
+	return ""
+}
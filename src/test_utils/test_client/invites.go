@@ -0,0 +1,38 @@
+package testclient
+
+import (
+	"fenix/src/test_utils"
+	"fenix/src/websocket_models"
+	"testing"
+)
+
+func (m *TestClient) InviteCreate(t *testing.T, cli *test_utils.ClientFields, create websocket_models.InviteCreate) websocket_models.Invite {
+	t.Helper()
+	err := cli.Conn.WriteJSON(create.SetType())
+	if err != nil {
+		t.Fatalf("%v", err)
+	}
+
+	var invite websocket_models.Invite
+	err = cli.Conn.ReadJSON(&invite)
+	if err != nil {
+		t.Fatalf("%v", err)
+	}
+	return invite
+}
+
+func (m *TestClient) InviteRedeem(t *testing.T, cli *test_utils.ClientFields, code string) {
+	t.Helper()
+	err := cli.Conn.WriteJSON(websocket_models.InviteRedeem{Code: code}.SetType())
+	if err != nil {
+		t.Fatalf("%v", err)
+	}
+}
+
+func (m *TestClient) InviteRevoke(t *testing.T, cli *test_utils.ClientFields, code string) {
+	t.Helper()
+	err := cli.Conn.WriteJSON(websocket_models.InviteRevoke{Code: code}.SetType())
+	if err != nil {
+		t.Fatalf("%v", err)
+	}
+}
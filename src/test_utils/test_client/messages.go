@@ -37,3 +37,65 @@ func (m *TestClient) RecvMsgHistory(t *testing.T, cli *test_utils.ClientFields)
 
 	return resProto
 }
+
+func (m *TestClient) MsgSearch(t *testing.T, cli *test_utils.ClientFields, query string) {
+	t.Helper()
+
+	err := cli.Conn.WriteJSON(
+		websocket_models.MsgSearch{Query: query}.SetType())
+	if err != nil {
+		t.Fatal(err)
+	}
+}
+
+func (m *TestClient) RecvMsgSearch(t *testing.T, cli *test_utils.ClientFields) websocket_models.MsgSearch {
+	t.Helper()
+
+	var resProto websocket_models.MsgSearch
+	err := cli.Conn.ReadJSON(&resProto)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return resProto
+}
+
+func (m *TestClient) MsgSendToChannel(t *testing.T, cli *test_utils.ClientFields, content, channelID string) {
+	t.Helper()
+
+	err := cli.Conn.WriteJSON(
+		websocket_models.MsgSend{Message: content, ChannelID: channelID}.SetType())
+	if err != nil {
+		t.Fatal(err)
+	}
+}
+
+func (m *TestClient) MsgPin(t *testing.T, cli *test_utils.ClientFields, messageID string) {
+	t.Helper()
+
+	err := cli.Conn.WriteJSON(
+		websocket_models.MsgPin{MessageID: messageID}.SetType())
+	if err != nil {
+		t.Fatal(err)
+	}
+}
+
+func (m *TestClient) MsgUnpin(t *testing.T, cli *test_utils.ClientFields, messageID string) {
+	t.Helper()
+
+	err := cli.Conn.WriteJSON(
+		websocket_models.MsgUnpin{MessageID: messageID}.SetType())
+	if err != nil {
+		t.Fatal(err)
+	}
+}
+
+func (m *TestClient) MsgDelete(t *testing.T, cli *test_utils.ClientFields, messageID string) {
+	t.Helper()
+
+	err := cli.Conn.WriteJSON(
+		websocket_models.MsgDelete{MessageID: messageID}.SetType())
+	if err != nil {
+		t.Fatal(err)
+	}
+}
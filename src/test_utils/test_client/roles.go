@@ -0,0 +1,24 @@
+package testclient
+
+import (
+	"fenix/src/database"
+	"fenix/src/test_utils"
+	"fenix/src/websocket_models"
+	"testing"
+)
+
+func (m *TestClient) RoleCreate(t *testing.T, cli *test_utils.ClientFields, yodelID, name string, permissions database.Permission) {
+	t.Helper()
+	err := cli.Conn.WriteJSON(websocket_models.RoleCreate{YodelID: yodelID, Name: name, Permissions: permissions}.SetType())
+	if err != nil {
+		t.Fatalf("%v", err)
+	}
+}
+
+func (m *TestClient) RoleAssign(t *testing.T, cli *test_utils.ClientFields, yodelID, userID, role string) {
+	t.Helper()
+	err := cli.Conn.WriteJSON(websocket_models.RoleAssign{YodelID: yodelID, UserID: userID, Role: role}.SetType())
+	if err != nil {
+		t.Fatalf("%v", err)
+	}
+}
@@ -12,3 +12,16 @@ func (m *TestClient) WhoAmI(t *testing.T, cli *test_utils.ClientFields) {
 		t.Fatalf("%q\n", err)
 	}
 }
+
+// Sends whoami, returning the client's user ID.
+func (m *TestClient) UserID(t *testing.T, cli *test_utils.ClientFields) string {
+	t.Helper()
+	m.WhoAmI(t, cli)
+
+	var whoami websocket_models.WhoAmI
+	err := cli.Conn.ReadJSON(&whoami)
+	if err != nil {
+		t.Fatalf("%q\n", err)
+	}
+	return whoami.ID
+}
@@ -21,3 +21,35 @@ func (m *TestClient) YodelGet(t *testing.T, cli *test_utils.ClientFields, yodelI
 		t.Fatalf("%v", err)
 	}
 }
+
+func (m *TestClient) YodelJoin(t *testing.T, cli *test_utils.ClientFields, yodelID string) {
+	t.Helper()
+	err := cli.Conn.WriteJSON(websocket_models.YodelJoin{YodelID: yodelID}.SetType())
+	if err != nil {
+		t.Fatalf("%v", err)
+	}
+}
+
+func (m *TestClient) YodelUpdate(t *testing.T, cli *test_utils.ClientFields, update websocket_models.YodelUpdate) {
+	t.Helper()
+	err := cli.Conn.WriteJSON(update.SetType())
+	if err != nil {
+		t.Fatalf("%v", err)
+	}
+}
+
+func (m *TestClient) YodelDelete(t *testing.T, cli *test_utils.ClientFields, yodelID string) {
+	t.Helper()
+	err := cli.Conn.WriteJSON(websocket_models.YodelDelete{YodelID: yodelID}.SetType())
+	if err != nil {
+		t.Fatalf("%v", err)
+	}
+}
+
+func (m *TestClient) YodelTransfer(t *testing.T, cli *test_utils.ClientFields, yodelID string, userID string) {
+	t.Helper()
+	err := cli.Conn.WriteJSON(websocket_models.YodelTransfer{YodelID: yodelID, UserID: userID}.SetType())
+	if err != nil {
+		t.Fatalf("%v", err)
+	}
+}
@@ -3,6 +3,7 @@ package test_utils
 import (
 	"bytes"
 	"context"
+	"crypto/rand"
 	"encoding/json"
 	"fenix/src/database"
 	"fenix/src/server"
@@ -51,7 +52,8 @@ type ClientFields struct {
 	Close func()
 }
 
-func intTestDB() database.Database {
+// Connects to the empty integration testing database named by the integration_testing variable.
+func IntTestDB() database.Database {
 	var db database.Database
 	mongoAddr := os.Getenv("mongo_addr")
 	intTest := os.Getenv("integration_testing")
@@ -74,12 +76,17 @@ func StartServer(isIntTest ...bool) *ServerFields {
 	var db database.Database
 
 	if len(isIntTest) == 1 && isIntTest[0] {
-		db = intTestDB()
+		db = IntTestDB()
 	} else {
 		db = database.NewInMemoryDatabase()
 	}
 
 	hub := runner.NewHub(wg, db)
+	// Tests use short passwords like "pass"
+	hub.Accounts.MinPasswordLength = 0
+	hub.Accounts.MinPasswordUniqueChars = 0
+	hub.SecretKey = make([]byte, 32)
+	rand.Read(hub.SecretKey)
 
 	srv := httptest.NewServer(hub.HTTPRequestHandler())
 	u, err := url.ParseRequestURI(srv.URL)
@@ -101,36 +108,54 @@ func StartServer(isIntTest ...bool) *ServerFields {
 	}
 }
 
-func Connect(username, password string, u url.URL) *ClientFields {
-	b, err := json.Marshal(map[string]string{"username": username, "password": password})
+// Posts body as JSON to u, returning the status code and decoded response body.
+func PostJSON(u url.URL, body map[string]string) (int, map[string]string) {
+	var resJSON = make(map[string]string)
+	status := PostJSONInto(u, body, &resJSON)
+	return status, resJSON
+}
+
+// Posts body as JSON to u, decoding the response body into res.  Returns the status code.
+func PostJSONInto(u url.URL, body map[string]string, res interface{}) int {
+	b, err := json.Marshal(body)
 	if err != nil {
 		panic(err)
 	}
 
-	res, err := http.Post(u.String(), "application/json", bytes.NewBuffer(b))
+	resp, err := http.Post(u.String(), "application/json", bytes.NewBuffer(b))
 	if err != nil {
 		panic(err)
 	}
 
-	resBody, err := ioutil.ReadAll(res.Body)
+	resBody, err := ioutil.ReadAll(resp.Body)
 	if err != nil {
 		panic(err)
 	}
 
-	res.Body.Close()
-	var body = make(map[string]string)
-	err = json.Unmarshal(resBody, &body)
-	if err != nil {
-		panic(err)
+	resp.Body.Close()
+	if len(resBody) != 0 {
+		err = json.Unmarshal(resBody, res)
+		if err != nil {
+			panic(err)
+		}
 	}
+	return resp.StatusCode
+}
 
-	if res.StatusCode != 200 {
-		panic(res.Status)
+func Connect(username, password string, u url.URL) *ClientFields {
+	status, body := PostJSON(u, map[string]string{"username": username, "password": password})
+	if status != 200 {
+		panic(http.StatusText(status))
 	}
 
+	return Upgrade(body, u)
+}
+
+// Upgrades to a websocket with the ticket from a /login, /register or /token/refresh response.
+func Upgrade(body map[string]string, u url.URL) *ClientFields {
 	ctx, cancel := context.WithTimeout(context.Background(), time.Second*100)
 
-	ref, err := url.Parse("upgrade")
+	ref, err := url.Parse("/upgrade")
 	if err != nil {
 		panic(err)
 	}
@@ -157,6 +182,31 @@ func Connect(username, password string, u url.URL) *ClientFields {
 	}
 }
 
+// Upgrades to a websocket with an access token, instead of a ticket.
+// Returns the handshake's error, so tests can check rejected tokens.
+func UpgradeWithToken(token string, u url.URL) (*ClientFields, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second*100)
+
+	u.Scheme = "ws"
+	u.Path = "/upgrade"
+	u.RawQuery = ""
+	header := http.Header{"Authorization": []string{"Bearer " + token}}
+
+	conn, wres, err := websocket.DefaultDialer.DialContext(ctx, u.String(), header)
+	if err != nil {
+		cancel()
+		return &ClientFields{Res: wres, Close: func() {}}, err
+	}
+	return &ClientFields{
+		Conn: conn,
+		Res:  wres,
+		Close: func() {
+			conn.Close()
+			cancel()
+		},
+	}, nil
+}
+
 func StartServerAndConnect(username string, password string, endpoint string, isIntTest ...bool) (*ServerFields, *ClientFields, func()) {
 	utils.InitLogger(3)
 	srv := StartServer(isIntTest...)
@@ -0,0 +1,38 @@
+package mongo_interaction_test
+
+import (
+	"fenix/src/test_utils"
+	"net/http"
+	"testing"
+
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+func TestAdminNotFound(t *testing.T) {
+	backends := map[string]bool{"memory": false}
+	if !testing.Short() {
+		backends["mongo"] = true
+	}
+
+	for name, isIntTest := range backends {
+		t.Run(name+" unknown users and yodels are not found", func(t *testing.T) {
+			srv := test_utils.StartServer(isIntTest)
+			defer srv.Close()
+			srv.Addr.Path = "/register"
+			_, admin := test_utils.PostJSON(srv.Addr, map[string]string{"username": "gopher123", "password": "pass"})
+			err := srv.Hub.MakeAdmin("gopher123")
+			test_utils.AssertEqual(t, err, nil)
+
+			missing := primitive.NewObjectID().Hex()
+			for path, field := range map[string]string{
+				"/admin/users/disable": "user_id",
+				"/admin/users/delete":  "user_id",
+				"/admin/yodels/delete": "yodel_id",
+			} {
+				srv.Addr.Path = path
+				status, _ := test_utils.PostJSON(srv.Addr, map[string]string{"token": admin["token"], field: missing})
+				test_utils.AssertEqual(t, status, http.StatusNotFound)
+			}
+		})
+	}
+}
@@ -0,0 +1,42 @@
+package mongo_interaction_test
+
+import (
+	"fenix/src/database"
+	"fenix/src/test_utils"
+	"fenix/src/utils"
+	"testing"
+
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// Every backend should return DoesNotExist for missing documents, as handlers rely on it.
+func TestMissingDocuments(t *testing.T) {
+	backends := map[string]func() database.Database{
+		"memory": func() database.Database { return database.NewInMemoryDatabase() },
+	}
+	if !testing.Short() {
+		utils.InitLogger(3)
+		backends["mongo"] = test_utils.IntTestDB
+	}
+
+	for name, newDB := range backends {
+		db := newDB()
+		id := primitive.NewObjectID()
+		lookups := map[string]func() error{
+			"user":    func() error { return db.GetUser(&database.User{UserID: id}) },
+			"yodel":   func() error { return db.GetYodel(&database.Yodel{YodelID: id}) },
+			"member":  func() error { return db.GetMember(&database.Member{YodelID: id, UserID: id}) },
+			"channel": func() error { return db.GetChannel(&database.Channel{ChannelID: id}) },
+			"invite":  func() error { return db.GetInvite(&database.Invite{Code: "nope"}) },
+			"message": func() error { return db.GetMessage(&database.Message{MessageID: id}) },
+			"session": func() error { return db.GetSession(&database.Session{TokenHash: "nope"}) },
+		}
+
+		for what, lookup := range lookups {
+			t.Run(name+" "+what+"s that dont exist", func(t *testing.T) {
+				_, ok := lookup().(database.DoesNotExist)
+				test_utils.AssertEqual(t, ok, true)
+			})
+		}
+	}
+}
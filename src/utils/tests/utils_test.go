@@ -54,3 +54,21 @@ func TestWaitGroupCounterWait(t *testing.T) {
 		t.FailNow()
 	}
 }
+
+func TestTOTPCode(t *testing.T) {
+	// Test vectors from RFC 6238 appendix B, truncated to 6 digits
+	secret := []byte("12345678901234567890")
+	cases := map[int64]string{
+		59:         "287082",
+		1111111109: "081804",
+		1234567890: "005924",
+		2000000000: "279037",
+	}
+
+	for unix, expected := range cases {
+		got := utils.TOTPCode(secret, utils.TOTPStep(time.Unix(unix, 0)))
+		if got != expected {
+			t.Errorf("TOTPCode at %v: got %q, expected %q", unix, got, expected)
+		}
+	}
+}
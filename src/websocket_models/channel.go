@@ -0,0 +1,121 @@
+package websocket_models
+
+// Channel inside a yodel.
+type Channel struct {
+	T         string `json:"type"`
+	Nonce     string `json:"n"`
+	ChannelID string `json:"c_id"`
+	YodelID   string `json:"y_id"`
+	Name      string `json:"name"`
+}
+
+func (b Channel) Type() string {
+	b.T = "channel"
+	return b.T
+}
+func (b Channel) SetType() JSONModel {
+	b.T = b.Type()
+	return b
+}
+func (n Channel) GetNonce() string {
+	return n.Nonce
+}
+
+// Creates a channel in a yodel.
+type ChannelCreate struct {
+	T       string `json:"type"`
+	Nonce   string `json:"n"`
+	YodelID string `json:"y_id"`
+	Name    string `json:"name"`
+}
+
+func (b ChannelCreate) Type() string {
+	b.T = "channel_create"
+	return b.T
+}
+func (b ChannelCreate) SetType() JSONModel {
+	b.T = b.Type()
+	return b
+}
+func (n ChannelCreate) GetNonce() string {
+	return n.Nonce
+}
+
+// Lists a yodel's channels.  The server responds with the same model, with Channels filled in.
+type ChannelList struct {
+	T        string    `json:"type"`
+	Nonce    string    `json:"n"`
+	YodelID  string    `json:"y_id"`
+	Channels []Channel `json:"channels,omitempty"`
+}
+
+func (b ChannelList) Type() string {
+	b.T = "channel_list"
+	return b.T
+}
+func (b ChannelList) SetType() JSONModel {
+	b.T = b.Type()
+	return b
+}
+func (n ChannelList) GetNonce() string {
+	return n.Nonce
+}
+
+// Renames a channel.
+type ChannelRename struct {
+	T         string `json:"type"`
+	Nonce     string `json:"n"`
+	ChannelID string `json:"c_id"`
+	Name      string `json:"name"`
+}
+
+func (b ChannelRename) Type() string {
+	b.T = "channel_rename"
+	return b.T
+}
+func (b ChannelRename) SetType() JSONModel {
+	b.T = b.Type()
+	return b
+}
+func (n ChannelRename) GetNonce() string {
+	return n.Nonce
+}
+
+// Deletes a channel and its messages.
+type ChannelDelete struct {
+	T         string `json:"type"`
+	Nonce     string `json:"n"`
+	ChannelID string `json:"c_id"`
+}
+
+func (b ChannelDelete) Type() string {
+	b.T = "channel_delete"
+	return b.T
+}
+func (b ChannelDelete) SetType() JSONModel {
+	b.T = b.Type()
+	return b
+}
+func (n ChannelDelete) GetNonce() string {
+	return n.Nonce
+}
+
+// Sent to every member of a yodel when its channels change.
+type ChannelsUpdated struct {
+	T        string    `json:"type"`
+	Nonce    string    `json:"n"`
+	YodelID  string    `json:"y_id"`
+	Channels []Channel `json:"channels"`
+}
+
+func (b ChannelsUpdated) Type() string {
+	b.T = "channels_updated"
+	return b.T
+}
+func (b ChannelsUpdated) SetType() JSONModel {
+	b.T = b.Type()
+	return b
+}
+func (n ChannelsUpdated) GetNonce() string {
+	return n.Nonce
+}
@@ -33,6 +33,8 @@ type GenericError struct {
 	Nonce   string `json:"n"`
 	Error   string `json:"error"`
 	Message string `json:"msg"`
+	// Milliseconds to wait before trying again, for RateLimited errors.
+	RetryAfter int64 `json:"retry_ms,omitempty"`
 }
 
 func (e GenericError) Type() string {
@@ -0,0 +1,85 @@
+package websocket_models
+
+// Creates an invite code for a yodel.  Expiry, max uses and target user are optional.
+type InviteCreate struct {
+	T       string `json:"type"`
+	Nonce   string `json:"n"`
+	YodelID string `json:"y_id"`
+	Expires int64  `json:"expires,omitempty"`
+	MaxUses int64  `json:"max_uses,omitempty"`
+	Target  string `json:"target,omitempty"`
+}
+
+func (b InviteCreate) Type() string {
+	b.T = "invite_create"
+	return b.T
+}
+func (b InviteCreate) SetType() JSONModel {
+	b.T = b.Type()
+	return b
+}
+func (n InviteCreate) GetNonce() string {
+	return n.Nonce
+}
+
+// Joins the yodel an invite code belongs to.
+type InviteRedeem struct {
+	T     string `json:"type"`
+	Nonce string `json:"n"`
+	Code  string `json:"code"`
+}
+
+func (b InviteRedeem) Type() string {
+	b.T = "invite_redeem"
+	return b.T
+}
+func (b InviteRedeem) SetType() JSONModel {
+	b.T = b.Type()
+	return b
+}
+func (n InviteRedeem) GetNonce() string {
+	return n.Nonce
+}
+
+type InviteRevoke struct {
+	T     string `json:"type"`
+	Nonce string `json:"n"`
+	Code  string `json:"code"`
+}
+
+func (b InviteRevoke) Type() string {
+	b.T = "invite_revoke"
+	return b.T
+}
+func (b InviteRevoke) SetType() JSONModel {
+	b.T = b.Type()
+	return b
+}
+func (n InviteRevoke) GetNonce() string {
+	return n.Nonce
+}
+
+type Invite struct {
+	T       string `json:"type"`
+	Nonce   string `json:"n"`
+	Code    string `json:"code"`
+	YodelID string `json:"y_id"`
+	Creator string `json:"creator"`
+	Target  string `json:"target,omitempty"`
+	Expires int64  `json:"expires,omitempty"`
+	MaxUses int64  `json:"max_uses,omitempty"`
+	Uses    int64  `json:"uses"`
+	Revoked bool   `json:"revoked"`
+}
+
+func (b Invite) Type() string {
+	b.T = "invite"
+	return b.T
+}
+func (b Invite) SetType() JSONModel {
+	b.T = b.Type()
+	return b
+}
+func (n Invite) GetNonce() string {
+	return n.Nonce
+}
@@ -3,10 +3,12 @@ package websocket_models
 import "fenix/src/database"
 
 // Sends a message to the server.  Clients will recieve this message back, when it broadcasts.
+// Messages without a channel go to every connected client.
 type MsgSend struct {
-	T       string `json:"type"`
-	Message string `json:"msg"`
-	Nonce   string `json:"n"`
+	T         string `json:"type"`
+	Message   string `json:"msg"`
+	ChannelID string `json:"c_id,omitempty"`
+	Nonce     string `json:"n"`
 }
 
 func (b MsgSend) Type() string {
@@ -30,6 +32,8 @@ type MsgBroadcast struct {
 	MessageID string `json:"m_id"`
 	Author    Author `json:"author"`
 	Message   string `json:"msg"`
+	YodelID   string `json:"y_id,omitempty"`
+	ChannelID string `json:"c_id,omitempty"`
 	Time      int64  `json:"time"`
 }
 
@@ -48,15 +52,18 @@ func (n MsgBroadcast) GetNonce() string {
 type Author struct {
 	ID       string
 	Username string
+	Bot      bool `json:",omitempty"`
 }
 
 type MsgHistory struct {
 	T     string `json:"type"`
 	Nonce string `json:"n"`
 
-	From     int64               `json:"from,omitempty"`
-	To       int64               `json:"to,omitempty"`
-	Messages []*database.Message `json:"messages,omitempty"`
+	From      int64               `json:"from,omitempty"`
+	To        int64               `json:"to,omitempty"`
+	ChannelID string              `json:"c_id,omitempty"`
+	Limit     int                 `json:"limit,omitempty"`
+	Messages  []*database.Message `json:"messages,omitempty"`
 }
 
 func (m MsgHistory) Type() string {
@@ -71,3 +78,114 @@ func (b MsgHistory) SetType() JSONModel {
 func (n MsgHistory) GetNonce() string {
 	return n.Nonce
 }
+
+// Searches message content.  The server responds with the same model, with Results filled in.
+type MsgSearch struct {
+	T     string `json:"type"`
+	Nonce string `json:"n"`
+
+	Query     string                   `json:"q"`
+	Author    string                   `json:"author,omitempty"`
+	YodelID   string                   `json:"y_id,omitempty"`
+	ChannelID string                   `json:"c_id,omitempty"`
+	From      int64                    `json:"from,omitempty"`
+	To        int64                    `json:"to,omitempty"`
+	Limit     int64                    `json:"limit,omitempty"`
+	Results   []*database.SearchResult `json:"results,omitempty"`
+}
+
+func (m MsgSearch) Type() string {
+	m.T = "msg_search"
+	return m.T
+}
+
+func (b MsgSearch) SetType() JSONModel {
+	b.T = b.Type()
+	return b
+}
+func (n MsgSearch) GetNonce() string {
+	return n.Nonce
+}
+
+// Pins a message in the yodel it was sent to.  Only the yodel's owner may pin messages.
+type MsgPin struct {
+	T         string `json:"type"`
+	Nonce     string `json:"n"`
+	MessageID string `json:"m_id"`
+}
+
+func (m MsgPin) Type() string {
+	m.T = "msg_pin"
+	return m.T
+}
+
+func (b MsgPin) SetType() JSONModel {
+	b.T = b.Type()
+	return b
+}
+func (n MsgPin) GetNonce() string {
+	return n.Nonce
+}
+
+// Unpins a message from the yodel it was sent to.
+type MsgUnpin struct {
+	T         string `json:"type"`
+	Nonce     string `json:"n"`
+	MessageID string `json:"m_id"`
+}
+
+func (m MsgUnpin) Type() string {
+	m.T = "msg_unpin"
+	return m.T
+}
+
+func (b MsgUnpin) SetType() JSONModel {
+	b.T = b.Type()
+	return b
+}
+func (n MsgUnpin) GetNonce() string {
+	return n.Nonce
+}
+
+// Deletes a message.  Authors may delete their own messages, and members with the delete-others permission
+// may delete anyone's in their yodel.
+type MsgDelete struct {
+	T         string `json:"type"`
+	Nonce     string `json:"n"`
+	MessageID string `json:"m_id"`
+}
+
+func (m MsgDelete) Type() string {
+	m.T = "msg_delete"
+	return m.T
+}
+
+func (b MsgDelete) SetType() JSONModel {
+	b.T = b.Type()
+	return b
+}
+func (n MsgDelete) GetNonce() string {
+	return n.Nonce
+}
+
+// Sent to the clients that could see a message when it is deleted.
+type MsgDeleted struct {
+	T         string `json:"type"`
+	Nonce     string `json:"n"`
+	MessageID string `json:"m_id"`
+	YodelID   string `json:"y_id,omitempty"`
+	ChannelID string `json:"c_id,omitempty"`
+}
+
+func (m MsgDeleted) Type() string {
+	m.T = "msg_deleted"
+	return m.T
+}
+
+func (b MsgDeleted) SetType() JSONModel {
+	b.T = b.Type()
+	return b
+}
+func (n MsgDeleted) GetNonce() string {
+	return n.Nonce
+}
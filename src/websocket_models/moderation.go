@@ -0,0 +1,112 @@
+package websocket_models
+
+// Removes a member from a yodel.  They can rejoin.
+type MemberKick struct {
+	T       string `json:"type"`
+	Nonce   string `json:"n"`
+	YodelID string `json:"y_id"`
+	UserID  string `json:"u_id"`
+	Reason  string `json:"reason,omitempty"`
+}
+
+func (b MemberKick) Type() string {
+	b.T = "member_kick"
+	return b.T
+}
+func (b MemberKick) SetType() JSONModel {
+	b.T = b.Type()
+	return b
+}
+func (n MemberKick) GetNonce() string {
+	return n.Nonce
+}
+
+// Removes a user from a yodel, and stops them rejoining until Expires.  Expires is optional.
+type MemberBan struct {
+	T       string `json:"type"`
+	Nonce   string `json:"n"`
+	YodelID string `json:"y_id"`
+	UserID  string `json:"u_id"`
+	Reason  string `json:"reason,omitempty"`
+	Expires int64  `json:"expires,omitempty"`
+}
+
+func (b MemberBan) Type() string {
+	b.T = "member_ban"
+	return b.T
+}
+func (b MemberBan) SetType() JSONModel {
+	b.T = b.Type()
+	return b
+}
+func (n MemberBan) GetNonce() string {
+	return n.Nonce
+}
+
+// Stops a member sending messages to a yodel until Expires.
+type MemberMute struct {
+	T       string `json:"type"`
+	Nonce   string `json:"n"`
+	YodelID string `json:"y_id"`
+	UserID  string `json:"u_id"`
+	Reason  string `json:"reason,omitempty"`
+	Expires int64  `json:"expires"`
+}
+
+func (b MemberMute) Type() string {
+	b.T = "member_mute"
+	return b.T
+}
+func (b MemberMute) SetType() JSONModel {
+	b.T = b.Type()
+	return b
+}
+func (n MemberMute) GetNonce() string {
+	return n.Nonce
+}
+
+// Sent to a yodel's members, and the removed user, when a member is kicked or banned.
+type MemberRemoved struct {
+	T       string `json:"type"`
+	Nonce   string `json:"n"`
+	YodelID string `json:"y_id"`
+	UserID  string `json:"u_id"`
+	// "kick" or "ban"
+	Action  string `json:"action"`
+	Reason  string `json:"reason,omitempty"`
+	Expires int64  `json:"expires,omitempty"`
+}
+
+func (b MemberRemoved) Type() string {
+	b.T = "member_removed"
+	return b.T
+}
+func (b MemberRemoved) SetType() JSONModel {
+	b.T = b.Type()
+	return b
+}
+func (n MemberRemoved) GetNonce() string {
+	return n.Nonce
+}
+
+// Sent to a yodel's members when a member is muted.
+type MemberMuted struct {
+	T       string `json:"type"`
+	Nonce   string `json:"n"`
+	YodelID string `json:"y_id"`
+	UserID  string `json:"u_id"`
+	Reason  string `json:"reason,omitempty"`
+	Expires int64  `json:"expires"`
+}
+
+func (b MemberMuted) Type() string {
+	b.T = "member_muted"
+	return b.T
+}
+func (b MemberMuted) SetType() JSONModel {
+	b.T = b.Type()
+	return b
+}
+func (n MemberMuted) GetNonce() string {
+	return n.Nonce
+}
@@ -0,0 +1,106 @@
+package websocket_models
+
+import "fenix/src/database"
+
+// Creates a custom role in a yodel.  Permissions is a bitmask of database.Permission.
+type RoleCreate struct {
+	T           string              `json:"type"`
+	Nonce       string              `json:"n"`
+	YodelID     string              `json:"y_id"`
+	Name        string              `json:"name"`
+	Permissions database.Permission `json:"permissions"`
+}
+
+func (b RoleCreate) Type() string {
+	b.T = "role_create"
+	return b.T
+}
+func (b RoleCreate) SetType() JSONModel {
+	b.T = b.Type()
+	return b
+}
+func (n RoleCreate) GetNonce() string {
+	return n.Nonce
+}
+
+// Deletes a custom role.  Members with the role become members.
+type RoleDelete struct {
+	T       string `json:"type"`
+	Nonce   string `json:"n"`
+	YodelID string `json:"y_id"`
+	Name    string `json:"name"`
+}
+
+func (b RoleDelete) Type() string {
+	b.T = "role_delete"
+	return b.T
+}
+func (b RoleDelete) SetType() JSONModel {
+	b.T = b.Type()
+	return b
+}
+func (n RoleDelete) GetNonce() string {
+	return n.Nonce
+}
+
+// Gives a member of a yodel a role.
+type RoleAssign struct {
+	T       string `json:"type"`
+	Nonce   string `json:"n"`
+	YodelID string `json:"y_id"`
+	UserID  string `json:"u_id"`
+	Role    string `json:"role"`
+}
+
+func (b RoleAssign) Type() string {
+	b.T = "role_assign"
+	return b.T
+}
+func (b RoleAssign) SetType() JSONModel {
+	b.T = b.Type()
+	return b
+}
+func (n RoleAssign) GetNonce() string {
+	return n.Nonce
+}
+
+// Sent to a yodel's members when its roles change.
+type RolesUpdated struct {
+	T       string          `json:"type"`
+	Nonce   string          `json:"n"`
+	YodelID string          `json:"y_id"`
+	Roles   []database.Role `json:"roles"`
+}
+
+func (b RolesUpdated) Type() string {
+	b.T = "roles_updated"
+	return b.T
+}
+func (b RolesUpdated) SetType() JSONModel {
+	b.T = b.Type()
+	return b
+}
+func (n RolesUpdated) GetNonce() string {
+	return n.Nonce
+}
+
+// Sent to a yodel's members when a member's role changes.
+type MemberRoleUpdated struct {
+	T       string `json:"type"`
+	Nonce   string `json:"n"`
+	YodelID string `json:"y_id"`
+	UserID  string `json:"u_id"`
+	Role    string `json:"role"`
+}
+
+func (b MemberRoleUpdated) Type() string {
+	b.T = "member_role_updated"
+	return b.T
+}
+func (b MemberRoleUpdated) SetType() JSONModel {
+	b.T = b.Type()
+	return b
+}
+func (n MemberRoleUpdated) GetNonce() string {
+	return n.Nonce
+}
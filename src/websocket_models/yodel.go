@@ -1,9 +1,12 @@
 package websocket_models
 
+import "fenix/src/database"
+
 type YodelCreate struct {
-	T    string `json:"type"`
-	Nonce string `json:"n"`
-	Name string `json:"name"`
+	T          string `json:"type"`
+	Nonce      string `json:"n"`
+	Name       string `json:"name"`
+	Visibility string `json:"visibility,omitempty"`
 }
 
 func (b YodelCreate) Type() string {
@@ -19,11 +22,17 @@ func (n YodelCreate) GetNonce() string {
 }
 
 type Yodel struct {
-	T       string `json:"type"`
-	YodelID string `json:"y_id"`
-	Name    string `json:"name"`
-	Owner   string `json:"o_id"`
-	Nonce string `json:"n"`
+	T           string          `json:"type"`
+	YodelID     string          `json:"y_id"`
+	Name        string          `json:"name"`
+	Owner       string          `json:"o_id"`
+	Description string          `json:"description,omitempty"`
+	Icon        string          `json:"icon,omitempty"`
+	Topic       string          `json:"topic,omitempty"`
+	Pins        []string        `json:"pins,omitempty"`
+	Visibility  string          `json:"visibility,omitempty"`
+	Roles       []database.Role `json:"roles,omitempty"`
+	Nonce       string          `json:"n"`
 }
 
 func (b Yodel) Type() string {
@@ -40,7 +49,7 @@ func (n Yodel) GetNonce() string {
 
 type YodelGet struct {
 	T       string `json:"type"`
-	Nonce string `json:"n"`
+	Nonce   string `json:"n"`
 	YodelID string `json:"y_id"`
 }
 
@@ -54,4 +63,165 @@ func (b YodelGet) SetType() JSONModel {
 }
 func (n YodelGet) GetNonce() string {
 	return n.Nonce
-}
\ No newline at end of file
+}
+
+type YodelJoin struct {
+	T       string `json:"type"`
+	Nonce   string `json:"n"`
+	YodelID string `json:"y_id"`
+}
+
+func (b YodelJoin) Type() string {
+	b.T = "yodel_join"
+	return b.T
+}
+func (b YodelJoin) SetType() JSONModel {
+	b.T = b.Type()
+	return b
+}
+func (n YodelJoin) GetNonce() string {
+	return n.Nonce
+}
+
+// Sent to a yodel's members when its pinned messages change.
+type PinsUpdated struct {
+	T       string   `json:"type"`
+	Nonce   string   `json:"n"`
+	YodelID string   `json:"y_id"`
+	Pins    []string `json:"pins"`
+}
+
+func (b PinsUpdated) Type() string {
+	b.T = "pins_updated"
+	return b.T
+}
+func (b PinsUpdated) SetType() JSONModel {
+	b.T = b.Type()
+	return b
+}
+func (n PinsUpdated) GetNonce() string {
+	return n.Nonce
+}
+
+// Lists yodels the client can see, optionally filtered by name.
+// The server responds with the same model, with Yodels filled in.
+type YodelList struct {
+	T      string  `json:"type"`
+	Nonce  string  `json:"n"`
+	Name   string  `json:"q,omitempty"`
+	Offset int64   `json:"offset,omitempty"`
+	Limit  int64   `json:"limit,omitempty"`
+	Yodels []Yodel `json:"yodels"`
+}
+
+func (b YodelList) Type() string {
+	b.T = "yodel_list"
+	return b.T
+}
+func (b YodelList) SetType() JSONModel {
+	b.T = b.Type()
+	return b
+}
+func (n YodelList) GetNonce() string {
+	return n.Nonce
+}
+
+// Changes a yodel's details.  Fields that are left out aren't changed.
+type YodelUpdate struct {
+	T           string  `json:"type"`
+	Nonce       string  `json:"n"`
+	YodelID     string  `json:"y_id"`
+	Name        *string `json:"name,omitempty"`
+	Description *string `json:"description,omitempty"`
+	Icon        *string `json:"icon,omitempty"`
+	Topic       *string `json:"topic,omitempty"`
+}
+
+func (b YodelUpdate) Type() string {
+	b.T = "yodel_update"
+	return b.T
+}
+func (b YodelUpdate) SetType() JSONModel {
+	b.T = b.Type()
+	return b
+}
+func (n YodelUpdate) GetNonce() string {
+	return n.Nonce
+}
+
+// Deletes a yodel and all of its messages.
+type YodelDelete struct {
+	T       string `json:"type"`
+	Nonce   string `json:"n"`
+	YodelID string `json:"y_id"`
+}
+
+func (b YodelDelete) Type() string {
+	b.T = "yodel_delete"
+	return b.T
+}
+func (b YodelDelete) SetType() JSONModel {
+	b.T = b.Type()
+	return b
+}
+func (n YodelDelete) GetNonce() string {
+	return n.Nonce
+}
+
+// Makes another member the owner of a yodel.
+type YodelTransfer struct {
+	T       string `json:"type"`
+	Nonce   string `json:"n"`
+	YodelID string `json:"y_id"`
+	UserID  string `json:"u_id"`
+}
+
+func (b YodelTransfer) Type() string {
+	b.T = "yodel_transfer"
+	return b.T
+}
+func (b YodelTransfer) SetType() JSONModel {
+	b.T = b.Type()
+	return b
+}
+func (n YodelTransfer) GetNonce() string {
+	return n.Nonce
+}
+
+// Sent to a yodel's members when its details or owner change.
+type YodelUpdated struct {
+	T     string `json:"type"`
+	Nonce string `json:"n"`
+	Yodel Yodel  `json:"yodel"`
+}
+
+func (b YodelUpdated) Type() string {
+	b.T = "yodel_updated"
+	return b.T
+}
+func (b YodelUpdated) SetType() JSONModel {
+	b.T = b.Type()
+	return b
+}
+func (n YodelUpdated) GetNonce() string {
+	return n.Nonce
+}
+
+// Sent to a yodel's members when it is deleted.
+type YodelDeleted struct {
+	T       string `json:"type"`
+	Nonce   string `json:"n"`
+	YodelID string `json:"y_id"`
+}
+
+func (b YodelDeleted) Type() string {
+	b.T = "yodel_deleted"
+	return b.T
+}
+func (b YodelDeleted) SetType() JSONModel {
+	b.T = b.Type()
+	return b
+}
+func (n YodelDeleted) GetNonce() string {
+	return n.Nonce
+}